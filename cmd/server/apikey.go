@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/config"
+	"sweng-task/internal/db"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/repository/postgres"
+	"sweng-task/internal/repository/sqlite"
+	"sweng-task/internal/service"
+	"sweng-task/internal/validator"
+)
+
+const apiKeyUsage = "usage: adserver apikey create -name NAME -role admin|advertiser|publisher [-advertiser ID] | list | revoke ID"
+
+// runAPIKey implements the apikey subcommand: create issues a key and prints it, list shows
+// every key and revoke stops a key from authenticating
+func runAPIKey(cfg *config.Config, log *zap.SugaredLogger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	database, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	if err := db.CheckMigrations(database, cfg.Database.Driver, log); err != nil {
+		return err
+	}
+
+	var repo repository.APIKeyRepository
+	if cfg.Database.Driver == "postgres" {
+		repo = postgres.NewAPIKeyPostgresRepository(database, log)
+	} else {
+		repo = sqlite.NewAPIKeySQLiteRepository(database, log)
+	}
+	keys := service.NewAPIKeyService(repo, log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "create":
+		var input model.APIKeyCreate
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		flags.StringVar(&input.Name, "name", "", "name describing who the key is for")
+		flags.StringVar((*string)(&input.Role), "role", "", "admin, advertiser or publisher")
+		flags.StringVar(&input.AdvertiserID, "advertiser", "", "advertiser ID of advertiser keys")
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("%w\n%s", err, apiKeyUsage)
+		}
+		if fieldErr, err := validator.ValidateStruct(&input); err != nil {
+			return err
+		} else if fieldErr != nil {
+			return fmt.Errorf("%s %s\n%s", fieldErr.Field, fieldErr.Reason, apiKeyUsage)
+		}
+
+		created, err := keys.Create(ctx, input)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %s; store it now, it can't be shown again:\n%s\n", created.ID, created.Key)
+
+	case "list":
+		all, err := keys.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPREFIX\tNAME\tROLE\tADVERTISER\tCREATED AT\tREVOKED AT")
+		for _, key := range all {
+			revokedAt := "-"
+			if key.RevokedAt != nil {
+				revokedAt = key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.Prefix, key.Name, key.Role, key.AdvertiserID, key.CreatedAt.Format(time.RFC3339), revokedAt)
+		}
+		return w.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+		if err := keys.Revoke(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %s\n", args[1])
+
+	default:
+		return errors.New(apiKeyUsage)
+	}
+	return nil
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"sweng-task/internal/app"
 	"syscall"
 
-	"go.uber.org/zap"
 	"sweng-task/internal/config"
+	"sweng-task/internal/logging"
 )
 
 func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize logger
-	logger, err := zap.NewProduction()
+	logger, err := logging.New(cfg.App.LogLevel)
 	if err != nil {
 		fmt.Printf("Error initializing logger: %v\n", err)
 		os.Exit(1)
@@ -21,36 +29,57 @@ func main() {
 	defer logger.Sync()
 	log := logger.Sugar()
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, log, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKey(cfg, log, os.Args[2:]); err != nil {
+			log.Fatalf("API key command failed: %v", err)
+		}
+		return
 	}
 
 	log.Infow("Configuration loaded",
 		"environment", cfg.App.Environment,
 		"log_level", cfg.App.LogLevel,
 		"server_port", cfg.Server.Port,
+		"internal_port", cfg.Server.InternalPort,
 	)
 
-	fiberApp := app.SetupApp(cfg, log)
+	application := app.SetupApp(cfg, log)
 
 	// Start server
 	go func() {
 		address := fmt.Sprintf(":%d", cfg.Server.Port)
 		log.Infof("Starting server on %s", address)
-		if err := fiberApp.Listen(address); err != nil {
+		if err := application.Listen(address); err != nil {
 			log.Fatalf("Error starting server: %v", err)
 		}
 	}()
 
+	go func() {
+		address := fmt.Sprintf(":%d", cfg.Server.InternalPort)
+		log.Infof("Serving metrics on %s", address)
+		if err := application.ListenInternal(address); err != nil {
+			log.Fatalf("Error starting internal server: %v", err)
+		}
+	}()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	log.Info("Shutting down server...")
 
-	if err := fiberApp.Shutdown(); err != nil {
+	// Stop accepting requests, then drain queued tracking events before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := application.Shutdown(ctx); err != nil {
 		log.Fatalf("Error shutting down server: %v", err)
 	}
 
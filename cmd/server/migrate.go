@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sweng-task/internal/config"
+	"sweng-task/internal/db"
+)
+
+const migrateUsage = "usage: adserver migrate up | down [steps] | status"
+
+// runMigrate implements the migrate subcommand: up applies pending migrations, down reverts
+// the given number of migrations (one by default) and status lists them
+func runMigrate(cfg *config.Config, log *zap.SugaredLogger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	database, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := db.NewMigrator(database, cfg.Database.Driver, log)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
+
+// openDatabase connects to the database of the postgres or sqlite driver without migrating it
+func openDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	switch cfg.Driver {
+	case "postgres":
+		return db.ConnectPostgres(cfg)
+	case "sqlite":
+		return db.ConnectSQLite(cfg)
+	default:
+		return nil, fmt.Errorf("database driver %q keeps no data between runs", cfg.Driver)
+	}
+}
@@ -1,62 +1,452 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/changefeed"
+	"sweng-task/internal/health"
+	"sweng-task/internal/ingest"
+	"sweng-task/internal/ivt"
+	"sweng-task/internal/kafka"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/ratelimit"
+	"sweng-task/internal/repository"
 	"sweng-task/internal/scheduler"
+	"sweng-task/internal/sink"
+	"sweng-task/internal/tracing"
 	"sweng-task/internal/utils"
+	"sweng-task/internal/wal"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"sweng-task/internal/config"
 	"sweng-task/internal/db"
 	"sweng-task/internal/handler"
+	"sweng-task/internal/repository/memory"
 	"sweng-task/internal/repository/postgres"
+	"sweng-task/internal/repository/sqlite"
 	"sweng-task/internal/service"
 )
 
-func SetupApp(cfg *config.Config, log *zap.SugaredLogger) *fiber.App {
-	// Database
-	database := db.InitDatabase(cfg.Database, log)
+// App is the HTTP server together with the background components that must be
+// stopped after it on shutdown
+type App struct {
+	Server *fiber.App
+	// Internal serves operational endpoints such as /metrics, which must not be exposed
+	// publicly, on a listener of its own
+	Internal *fiber.App
+	log      *zap.SugaredLogger
+	closers  []func(ctx context.Context) error
+}
+
+// Listen serves HTTP requests on address
+func (a *App) Listen(address string) error {
+	return a.Server.Listen(address)
+}
+
+// ListenInternal serves the internal endpoints on address
+func (a *App) ListenInternal(address string) error {
+	return a.Internal.Listen(address)
+}
+
+// Shutdown stops accepting requests, then stops background components in the order they were
+// started. Components are stopped even after an earlier one failed or timed out, so that the
+// write-ahead log is still checkpointed when the ingestion pipeline could not drain.
+func (a *App) Shutdown(ctx context.Context) error {
+	errs := []error{a.Server.ShutdownWithContext(ctx), a.Internal.ShutdownWithContext(ctx)}
+	for _, closeFn := range a.closers {
+		if err := closeFn(ctx); err != nil {
+			a.log.Errorw("Failed to stop component", "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *App) onShutdown(closeFn func(ctx context.Context) error) {
+	a.closers = append(a.closers, closeFn)
+}
+
+func SetupApp(cfg *config.Config, log *zap.SugaredLogger) *App {
+	shutdownTracing, err := tracing.Setup(cfg.Tracing, cfg.App.Name, cfg.App.Version)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	appMetrics := metrics.New()
+	checks := health.NewChecker(cfg.App.Version, cfg.Health.CheckTimeout)
 
 	// Repositories
-	lineItemRepo := postgres.NewLineItemPostgresRepository(database, log)
-	trackingRepo := postgres.NewTrackingPostgresRepository(database, log)
+	repos, err := newRepositories(cfg.Database, appMetrics, checks, log)
+	if err != nil {
+		log.Fatalf("Failed to set up repositories: %v", err)
+	}
 
-	// Services
-	lineItemService := service.NewLineItemService(lineItemRepo, log)
-	trackingService := service.NewTrackingService(trackingRepo, lineItemService, log)
-	adService := service.NewAdService(lineItemService, trackingService, log)
+	// Event sinks
+	eventSink, err := newEventSink(cfg.Sink, appMetrics, log)
+	if err != nil {
+		log.Fatalf("Failed to set up event sinks: %v", err)
+	}
 
-	// Handlers
-	lineItemHandler := handler.NewLineItemHandler(lineItemService, log)
-	adSelectionHandler := handler.NewAdSelectionHandler(adService, log)
-	trackingHandler := handler.NewTrackingHandler(trackingService, log)
+	// Event counters
+	eventCounters := service.NewEventCounterCache(repos.tracking, cfg.Tracking.CounterRefreshInterval, cfg.Tracking.StatsRetention, log)
+	if err := eventCounters.Refresh(context.Background()); err != nil {
+		log.Errorw("Failed to load event counters", "error", err)
+	}
+
+	// Line item index
+	lineItemIndex := service.NewLineItemIndex(repos.lineItems, cfg.Bidding.IndexRefreshInterval, log)
+	if err := lineItemIndex.Refresh(context.Background()); err != nil {
+		log.Errorw("Failed to build line item index", "error", err)
+	}
+
+	// Services
+	lineItemService := service.NewLineItemService(repos.lineItems, log,
+		service.WithLineItemIndex(lineItemIndex),
+		service.WithLineItemMetrics(appMetrics),
+	)
+	trackingOpts := []service.TrackingOption{
+		service.WithTrackingMetrics(appMetrics),
+		service.WithEventCounterCache(eventCounters),
+		service.WithStatsRetention(cfg.Tracking.StatsRetention),
+		service.WithDedupeWindow(cfg.Tracking.DedupeWindow, cfg.Tracking.DedupeCapacity),
+		service.WithMaxBatchSize(cfg.Tracking.MaxBatchSize),
+		service.WithEventSink(eventSink),
+	}
+	if cfg.IVT.Enabled {
+		trackingOpts = append(trackingOpts, service.WithIVTFilter(newIVTFilter(cfg.IVT, repos.tracking)))
+	}
+	trackingService := service.NewTrackingService(repos.tracking, lineItemService, log, trackingOpts...)
+	adService := service.NewAdService(lineItemService, trackingService, log,
+		service.WithBidStrategy(utils.AvgConversionRateStrategy{
+			Stats: utils.StatsWindow{Window: cfg.Bidding.StatsWindow, HalfLife: cfg.Bidding.StatsHalfLife},
+		}),
+		service.WithStatsTimeout(cfg.Bidding.StatsTimeout),
+		service.WithAdMetrics(appMetrics),
+	)
+	anomalyService := service.NewAnomalyService(repos.alerts, repos.tracking, lineItemService, service.AnomalyConfig{
+		Baseline:       cfg.Anomaly.Baseline,
+		MinSamples:     cfg.Anomaly.MinSamples,
+		Alpha:          cfg.Anomaly.Alpha,
+		Threshold:      cfg.Anomaly.Threshold,
+		MinImpressions: cfg.Anomaly.MinImpressions,
+		MinClicks:      cfg.Anomaly.MinClicks,
+		AutoPause:      cfg.Anomaly.AutoPause,
+	}, log, service.WithAnomalyMetrics(appMetrics))
+	apiKeyService := service.NewAPIKeyService(repos.apiKeys, log,
+		service.WithAPIKeyCacheTTL(cfg.Auth.CacheTTL),
+		service.WithAPIKeyNegativeCacheTTL(cfg.Auth.NegativeCacheTTL),
+		service.WithBootstrapAdminKey(cfg.Auth.AdminKey),
+	)
 
 	// Fiber instance
-	app := fiber.New(fiber.Config{
+	server := fiber.New(fiber.Config{
 		AppName:      "Ad Bidding Service",
 		ReadTimeout:  time.Second * 10,
 		WriteTimeout: time.Second * 10,
 		IdleTimeout:  time.Second * 10,
 		ErrorHandler: utils.ErrorHandler(log),
+		// The client IP keys the pre-authentication rate limit and invalid traffic detection
+		ProxyHeader:             cfg.Server.ProxyHeader,
+		EnableTrustedProxyCheck: len(cfg.Server.TrustedProxies) > 0,
+		TrustedProxies:          cfg.Server.TrustedProxies,
+		// Takes the first valid IP of headers listing several, such as X-Forwarded-For
+		EnableIPValidation: cfg.Server.ProxyHeader != "",
+	})
+	internal := fiber.New(fiber.Config{
+		AppName:               "Ad Bidding Service (internal)",
+		ReadTimeout:           time.Second * 10,
+		WriteTimeout:          time.Second * 10,
+		IdleTimeout:           time.Second * 10,
+		ErrorHandler:          utils.ErrorHandler(log),
+		DisableStartupMessage: true,
 	})
+	application := &App{Server: server, Internal: internal, log: log}
+
+	eventCounters.Start()
+	application.onShutdown(eventCounters.Stop)
+
+	// Change feed
+	if cfg.Database.ChangeFeed && cfg.Database.Driver == "postgres" {
+		feed, err := changefeed.NewPostgresFeed(db.PostgresDSN(cfg.Database), log)
+		if err != nil {
+			log.Errorw("Failed to listen for line item changes, falling back to polling", "error", err)
+		} else {
+			feed.Subscribe(lineItemIndex.HandleChange)
+			application.onShutdown(func(ctx context.Context) error {
+				return feed.Close()
+			})
+		}
+	}
+	lineItemIndex.Start()
+	application.onShutdown(lineItemIndex.Stop)
+
+	// Ingestion
+	var trackingHandlerOpts []handler.TrackingHandlerOption
+	if cfg.Tracking.Async {
+		var eventLog *wal.Log
+		if cfg.Tracking.WALDir != "" {
+			eventLog, err = wal.Open(cfg.Tracking.WALDir, wal.Options{
+				Sync:         wal.SyncPolicy(cfg.Tracking.WALSync),
+				SyncInterval: cfg.Tracking.WALSyncInterval,
+				SegmentSize:  cfg.Tracking.WALSegmentSize,
+			}, log)
+			if err != nil {
+				log.Fatalf("Failed to open tracking write-ahead log: %v", err)
+			}
+		}
+
+		pipeline := ingest.NewPipeline(ingest.Config{
+			QueueSize:      cfg.Tracking.QueueSize,
+			Workers:        cfg.Tracking.Workers,
+			BatchSize:      min(cfg.Tracking.BatchSize, cfg.Tracking.MaxBatchSize),
+			FlushInterval:  cfg.Tracking.FlushInterval,
+			ProcessTimeout: cfg.Server.TrackingTimeout,
+			WAL:            eventLog,
+		}, trackingService, log)
+		// Events left in the write-ahead log are replayed in the background
+		pipeline.Start()
+		application.onShutdown(pipeline.Shutdown)
+		checks.Register("ingestion", func(ctx context.Context) error {
+			return pipeline.CheckBacklog(cfg.Health.MaxQueueUsage)
+		})
+
+		if eventLog != nil {
+			application.onShutdown(func(ctx context.Context) error {
+				return eventLog.Close()
+			})
+		}
+
+		trackingHandlerOpts = append(trackingHandlerOpts, handler.WithIngestPipeline(pipeline))
+	}
+	application.onShutdown(func(ctx context.Context) error {
+		return eventSink.Close()
+	})
+
+	// Handlers
+	lineItemHandler := handler.NewLineItemHandler(lineItemService, log)
+	adSelectionHandler := handler.NewAdSelectionHandler(adService, log)
+	trackingHandler := handler.NewTrackingHandler(trackingService, log, trackingHandlerOpts...)
+	healthHandler := handler.NewHealthHandler(checks)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService, log)
+	anomalyHandler := handler.NewAnomalyHandler(anomalyService, log)
 
 	// Middleware
-	app.Use(recover.New())
-	app.Use(logger.New())
-	app.Use(cors.New())
+	server.Use(tracing.Middleware())
+	server.Use(logging.Middleware(log))
+	server.Use(appMetrics.Middleware())
+	server.Use(recover.New())
+	server.Use(cors.New())
+
+	// Rate limits
+	limits, err := newRouteLimits(cfg.RateLimit, log)
+	if err != nil {
+		log.Fatalf("Failed to set up rate limits: %v", err)
+	}
+	// Clients are limited before they are authenticated, so that requests with invalid
+	// credentials are throttled too
+	server.Use("/api", limits.Clients)
+
+	if cfg.Auth.Enabled {
+		var authOpts []auth.MiddlewareOption
+		if keys := newJWKS(cfg.Auth, log); keys != nil {
+			if err := keys.Refresh(context.Background()); err != nil {
+				log.Errorw("Failed to load JWKS", "error", err)
+			}
+			keys.Start()
+			application.onShutdown(keys.Stop)
+			if cfg.Auth.Audience == "" {
+				log.Warn("No bearer token audience configured; tokens issued for other clients are accepted")
+			}
+			authOpts = append(authOpts, auth.WithBearerAuthenticator(auth.NewJWTAuthenticator(keys, auth.JWTConfig{
+				Issuer:          cfg.Auth.Issuer,
+				Audience:        cfg.Auth.Audience,
+				RoleClaim:       cfg.Auth.RoleClaim,
+				AdvertiserClaim: cfg.Auth.AdvertiserClaim,
+			})))
+		}
+		server.Use("/api", auth.Middleware(apiKeyService, log, authOpts...))
+	} else {
+		log.Warn("Authentication is disabled; every API request is treated as coming from an admin")
+		server.Use("/api", auth.Anonymous(log))
+	}
 
 	// Routes
-	RegisterRoutes(app, lineItemHandler, adSelectionHandler, trackingHandler)
+	RegisterRoutes(server, cfg.Server, limits, healthHandler, lineItemHandler, adSelectionHandler, trackingHandler, apiKeyHandler, anomalyHandler)
+	internal.Get("/metrics", appMetrics.Handler())
 
 	// Schedulers
-	schedule := scheduler.NewScheduler(lineItemService, log)
+	scheduleOpts := []scheduler.Option{scheduler.WithMetrics(appMetrics)}
+	if cfg.Anomaly.Enabled {
+		scheduleOpts = append(scheduleOpts, scheduler.WithAnomalyDetection(anomalyService))
+	}
+	schedule := scheduler.NewScheduler(lineItemService, trackingService, log, scheduleOpts...)
 	schedule.Start()
+	application.onShutdown(schedule.Stop)
+	checks.Register("scheduler", schedule.Check)
+
+	// Tracing stops last, so that spans recorded while other components stop are exported
+	application.onShutdown(shutdownTracing)
+
+	return application
+}
+
+// repositories are the repositories of one storage backend
+type repositories struct {
+	lineItems repository.LineItemRepository
+	tracking  repository.TrackingRepository
+	apiKeys   repository.APIKeyRepository
+	alerts    repository.AnomalyAlertRepository
+}
+
+// newRepositories builds the repositories of the storage backend selected by cfg.Driver
+func newRepositories(cfg config.DatabaseConfig, m *metrics.Metrics, checks *health.Checker, log *zap.SugaredLogger) (repositories, error) {
+	switch cfg.Driver {
+	case "postgres":
+		database := db.InitDatabase(cfg, log)
+		registerDB(m, checks, database, cfg.Driver, log)
+		return repositories{
+			lineItems: postgres.NewLineItemPostgresRepository(database, log),
+			tracking:  postgres.NewTrackingPostgresRepository(database, log),
+			apiKeys:   postgres.NewAPIKeyPostgresRepository(database, log),
+			alerts:    postgres.NewAnomalyAlertPostgresRepository(database, log),
+		}, nil
+	case "sqlite":
+		database := db.InitSQLite(cfg, log)
+		registerDB(m, checks, database, cfg.Driver, log)
+		return repositories{
+			lineItems: sqlite.NewLineItemSQLiteRepository(database, log),
+			tracking:  sqlite.NewTrackingSQLiteRepository(database, log),
+			apiKeys:   sqlite.NewAPIKeySQLiteRepository(database, log),
+			alerts:    sqlite.NewAnomalyAlertSQLiteRepository(database, log),
+		}, nil
+	case "memory":
+		log.Warn("Using in-memory storage; line items, tracking events, API keys and anomaly alerts are lost on restart")
+		lineItems := memory.NewLineItemRepository()
+		return repositories{
+			lineItems: lineItems,
+			tracking:  memory.NewTrackingRepository(lineItems),
+			apiKeys:   memory.NewAPIKeyRepository(),
+			alerts:    memory.NewAnomalyAlertRepository(),
+		}, nil
+	default:
+		return repositories{}, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// registerDB exposes the connection pool stats of database and checks it is reachable
+// before reporting the service ready
+func registerDB(m *metrics.Metrics, checks *health.Checker, database *gorm.DB, name string, log *zap.SugaredLogger) {
+	sqlDB, err := database.DB()
+	if err != nil {
+		log.Errorw("Failed to expose database pool metrics", "error", err)
+		return
+	}
+	m.RegisterDB(sqlDB, name)
+	checks.Register("database", sqlDB.PingContext)
+}
+
+// newJWKS builds the key set bearer tokens are verified with, or returns nil when bearer
+// tokens are not enabled
+func newJWKS(cfg config.AuthConfig, log *zap.SugaredLogger) *auth.JWKS {
+	switch {
+	case cfg.JWKSURL != "" && cfg.JWKSFile != "":
+		log.Fatal("Only one of APP_AUTH_JWKS_URL and APP_AUTH_JWKS_FILE may be set")
+		return nil
+	case cfg.JWKSURL != "":
+		return auth.NewRemoteJWKS(cfg.JWKSURL, &http.Client{Timeout: 10 * time.Second}, cfg.JWKSRefreshInterval, log)
+	case cfg.JWKSFile != "":
+		return auth.NewFileJWKS(cfg.JWKSFile, cfg.JWKSRefreshInterval, log)
+	default:
+		return nil
+	}
+}
+
+// newRouteLimits builds the rate limiting middleware of each limited route, all keeping
+// their buckets in one in-memory store
+func newRouteLimits(cfg config.RateLimitConfig, log *zap.SugaredLogger) (RouteLimits, error) {
+	store := ratelimit.NewMemoryStore()
+	middleware := func(name string, limit config.RateLimit) (fiber.Handler, error) {
+		rule := ratelimit.Rule{Name: name}
+		if cfg.Enabled && limit.Rate > 0 {
+			key, err := ratelimit.KeyFuncByName(limit.Key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			rule.Limit = ratelimit.Limit{Rate: limit.Rate, Burst: limit.Burst}
+			rule.Key = key
+		}
+		return ratelimit.Middleware(store, rule, log), nil
+	}
+
+	var (
+		limits RouteLimits
+		err    error
+	)
+	if limits.Clients, err = middleware("clients", cfg.Clients); err != nil {
+		return RouteLimits{}, err
+	}
+	if limits.Ads, err = middleware("ads", cfg.Ads); err != nil {
+		return RouteLimits{}, err
+	}
+	if limits.Tracking, err = middleware("tracking", cfg.Tracking); err != nil {
+		return RouteLimits{}, err
+	}
+	if limits.TrackingBatch, err = middleware("tracking_batch", cfg.TrackingBatch); err != nil {
+		return RouteLimits{}, err
+	}
+	if limits.LineItems, err = middleware("lineitems", cfg.LineItems); err != nil {
+		return RouteLimits{}, err
+	}
+	return limits, nil
+}
+
+// newIVTFilter builds the invalid traffic filter, looking up the impressions clicks follow in
+// trackingRepo and keeping per user and per IP event rates in memory
+func newIVTFilter(cfg config.IVTConfig, trackingRepo repository.TrackingRepository) *ivt.Filter {
+	return ivt.NewFilter(ivt.Config{
+		ClickWithoutImpression: cfg.RequireImpression,
+		ImpressionWindow:       cfg.ImpressionWindow,
+		MinClickDelay:          cfg.MinClickDelay,
+		MaxEventsPerUser:       cfg.MaxEventsPerUser,
+		MaxEventsPerIP:         cfg.MaxEventsPerIP,
+		EventWindow:            cfg.EventWindow,
+		BotUserAgents:          cfg.BotUserAgents,
+		MaxCTR:                 cfg.MaxCTR,
+		MinClicks:              cfg.MinClicks,
+		CTRWindow:              cfg.CTRWindow,
+	}, trackingRepo, ratelimit.NewMemoryStore())
+}
 
-	return app
+// newEventSink builds the sinks listed in cfg.Types
+func newEventSink(cfg config.SinkConfig, m *metrics.Metrics, log *zap.SugaredLogger) (sink.Multi, error) {
+	var sinks sink.Multi
+	for _, sinkType := range cfg.Types {
+		switch sinkType {
+		case "file":
+			fileSink, err := sink.NewFileSink(cfg.FilePath)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+		case "kafka":
+			sinks = append(sinks, sink.NewKafkaSink(kafka.ProducerConfig{
+				Brokers:  cfg.KafkaBrokers,
+				ClientID: "ad-bidding-service",
+				Acks:     cfg.KafkaAcks,
+			}, cfg.KafkaTopic, log, sink.WithKafkaBuffer(cfg.KafkaBuffer), sink.WithKafkaMetrics(m)))
+		default:
+			return nil, fmt.Errorf("unknown event sink %q", sinkType)
+		}
+	}
+	return sinks, nil
 }
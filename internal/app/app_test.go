@@ -0,0 +1,342 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/config"
+	"sweng-task/internal/health"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/testutil"
+	"sweng-task/internal/utils"
+)
+
+func TestSetupApp_MemoryDriver(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	testServesLineItems(t)
+}
+
+func TestSetupApp_SQLiteDriver(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "sqlite")
+	t.Setenv("APP_DATABASE_SQLITE_PATH", filepath.Join(t.TempDir(), "app.db"))
+	testServesLineItems(t)
+}
+
+const testAdminKey = "test-admin-key"
+
+// newTestApp sets up the application from the environment, accepting testAdminKey as an
+// admin key
+func newTestApp(t *testing.T) (*App, *config.Config) {
+	t.Setenv("APP_AUTH_ADMIN_KEY", testAdminKey)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	application := SetupApp(cfg, testutil.GetTestLogger())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, application.Shutdown(ctx))
+	})
+	return application, cfg
+}
+
+// newRequest builds a request authenticated with apiKey
+func newRequest(method, target string, body io.Reader, apiKey string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(auth.APIKeyHeader, apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func testServesLineItems(t *testing.T) {
+	application, cfg := newTestApp(t)
+
+	body, err := json.Marshal(testutil.CreateTestLineItemCreate())
+	require.NoError(t, err)
+	req := newRequest(http.MethodPost, "/api/v1/lineitems", bytes.NewReader(body), testAdminKey)
+	resp, err := application.Server.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created model.LineItem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	resp, err = application.Server.Test(newRequest(http.MethodGet, "/api/v1/lineitems/"+created.ID, nil, testAdminKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(logging.RequestIDHeader))
+
+	req = newRequest(http.MethodGet, "/api/v1/lineitems/missing", nil, testAdminKey)
+	req.Header.Set(logging.RequestIDHeader, "req-missing")
+	resp, err = application.Server.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "req-missing", resp.Header.Get(logging.RequestIDHeader))
+	var errResp utils.ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Equal(t, "req-missing", errResp.RequestID)
+
+	// The line item index picks up new line items asynchronously
+	assert.Eventually(t, func() bool {
+		resp, err := application.Server.Test(newRequest(http.MethodGet, "/api/v1/ads?placement="+created.Placement, nil, testAdminKey))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false
+		}
+		var ads []model.Ad
+		if err := json.NewDecoder(resp.Body).Decode(&ads); err != nil {
+			return false
+		}
+		return len(ads) == 1 && ads[0].ID == created.ID
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err = application.Server.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var report health.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, cfg.App.Version, report.Version)
+	assert.Contains(t, report.Checks, "scheduler")
+	assert.Contains(t, report.Checks, "ingestion")
+	if cfg.Database.Driver != "memory" {
+		assert.Equal(t, health.StatusOK, report.Checks["database"].Status)
+	}
+
+	// Metrics are only served on the internal listener
+	resp, err = application.Server.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = application.Internal.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	exposition, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(exposition), `adserver_http_requests_total{method="GET",route="/api/v1/lineitems/:id",status="200"} 1`)
+	assert.Contains(t, string(exposition), `adserver_ad_requests_total{outcome="filled"}`)
+}
+
+func TestSetupApp_APIKeys(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	application, _ := newTestApp(t)
+
+	resp, err := application.Server.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = application.Server.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "probes need no key")
+
+	body, err := json.Marshal(model.APIKeyCreate{Name: "dashboard", Role: model.RoleAdvertiser, AdvertiserID: "adv_123"})
+	require.NoError(t, err)
+	resp, err = application.Server.Test(newRequest(http.MethodPost, "/api/v1/apikeys", bytes.NewReader(body), testAdminKey))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var advertiserKey model.APIKeyCreated
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&advertiserKey))
+
+	body, err = json.Marshal(testutil.CreateTestLineItemCreate())
+	require.NoError(t, err)
+	resp, err = application.Server.Test(newRequest(http.MethodPost, "/api/v1/lineitems", bytes.NewReader(body), advertiserKey.Key))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = application.Server.Test(newRequest(http.MethodGet, "/api/v1/apikeys", nil, advertiserKey.Key))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only admins manage keys")
+
+	resp, err = application.Server.Test(newRequest(http.MethodDelete, "/api/v1/apikeys/"+advertiserKey.ID, nil, testAdminKey))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestSetupApp_BearerTokens(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC",
+		"kid": "dashboard",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	require.NoError(t, err)
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_AUTH_JWKS_FILE", jwksPath)
+	t.Setenv("APP_AUTH_ISSUER", "https://login.example.com")
+	t.Setenv("APP_AUTH_AUDIENCE", "dashboard")
+	application, _ := newTestApp(t)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss":           "https://login.example.com",
+		"aud":           "dashboard",
+		"sub":           "user-1",
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"role":          "advertiser",
+		"advertiser_id": testutil.CreateTestLineItemCreate().AdvertiserID,
+	})
+	token.Header["kid"] = "dashboard"
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	withToken := func(req *http.Request) *http.Request {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+signed)
+		return req
+	}
+
+	body, err := json.Marshal(testutil.CreateTestLineItemCreate())
+	require.NoError(t, err)
+	req := withToken(httptest.NewRequest(http.MethodPost, "/api/v1/lineitems", bytes.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := application.Server.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = application.Server.Test(withToken(httptest.NewRequest(http.MethodGet, "/api/v1/ads?placement=homepage_top", nil)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "publisher routes only take API keys")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/lineitems", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+signed+"x")
+	resp, err = application.Server.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestSetupApp_RateLimits(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_RATE_LIMIT_ADS", "1/m")
+	application, _ := newTestApp(t)
+
+	resp, err := application.Server.Test(newRequest(http.MethodGet, "/api/v1/ads?placement=homepage_top", nil, testAdminKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = application.Server.Test(newRequest(http.MethodGet, "/api/v1/ads?placement=homepage_top", nil, testAdminKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	resp, err = application.Server.Test(newRequest(http.MethodGet, "/api/v1/lineitems", nil, testAdminKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "routes are limited separately")
+}
+
+func TestSetupApp_TrackingBatchRateLimit(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_RATE_LIMIT_TRACKING_BATCH", "1/m,burst=1")
+	application, _ := newTestApp(t)
+
+	track := func(path, body string) int {
+		t.Helper()
+		resp, err := application.Server.Test(newRequest(http.MethodPost, path, strings.NewReader(body), testAdminKey))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	batch := `{"events":[{"event_type":"impression","line_item_id":"li_unknown"}]}`
+	assert.NotEqual(t, http.StatusTooManyRequests, track("/api/v1/tracking/batch", batch))
+	assert.Equal(t, http.StatusTooManyRequests, track("/api/v1/tracking/batch", batch))
+
+	event := `{"event_type":"impression","line_item_id":"li_unknown"}`
+	assert.NotEqual(t, http.StatusTooManyRequests, track("/api/v1/tracking", event), "single events have their own limit")
+}
+
+func TestSetupApp_ClientRateLimitBeforeAuth(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_RATE_LIMIT_CLIENTS", "2/m,burst=2,key=ip")
+	application, _ := newTestApp(t)
+
+	for _, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		resp, err := application.Server.Test(newRequest(http.MethodGet, "/api/v1/lineitems", nil, "ak_guess"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+
+	resp, err := application.Server.Test(newRequest(http.MethodGet, "/api/v1/lineitems", nil, testAdminKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "valid keys from the same IP share the limit")
+
+	resp, err = application.Server.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "probes are not limited")
+}
+
+func TestSetupApp_ClientRateLimitBehindProxy(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_RATE_LIMIT_CLIENTS", "1/m,burst=1,key=ip")
+	t.Setenv("APP_SERVER_PROXY_HEADER", "X-Forwarded-For")
+	// Requests made with Test come from 0.0.0.0
+	t.Setenv("APP_SERVER_TRUSTED_PROXIES", "0.0.0.0/32")
+	application, _ := newTestApp(t)
+
+	get := func(forwardedFor string) int {
+		t.Helper()
+		req := newRequest(http.MethodGet, "/api/v1/lineitems", nil, testAdminKey)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := application.Server.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, get("203.0.113.1"))
+	assert.Equal(t, http.StatusOK, get("203.0.113.2, 10.0.0.1"), "clients behind the balancer have their own buckets")
+}
+
+func TestSetupApp_ProxyHeaderFromUntrustedPeer(t *testing.T) {
+	t.Setenv("APP_DATABASE_DRIVER", "memory")
+	t.Setenv("APP_RATE_LIMIT_CLIENTS", "1/m,burst=1,key=ip")
+	t.Setenv("APP_SERVER_PROXY_HEADER", "X-Forwarded-For")
+	t.Setenv("APP_SERVER_TRUSTED_PROXIES", "10.0.0.0/8")
+	application, _ := newTestApp(t)
+
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := newRequest(http.MethodGet, "/api/v1/lineitems", nil, testAdminKey)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+		resp, err := application.Server.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, expected, resp.StatusCode, "the header is ignored from peers other than the trusted proxies")
+	}
+}
+
+func TestApp_ShutdownStopsEveryComponent(t *testing.T) {
+	application := &App{Server: fiber.New(), Internal: fiber.New(), log: testutil.GetTestLogger()}
+	var stopped []string
+	application.onShutdown(func(ctx context.Context) error {
+		stopped = append(stopped, "pipeline")
+		return context.DeadlineExceeded
+	})
+	application.onShutdown(func(ctx context.Context) error {
+		stopped = append(stopped, "wal")
+		return nil
+	})
+
+	// A component that times out does not keep the ones after it running
+	err := application.Shutdown(t.Context())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"pipeline", "wal"}, stopped)
+}
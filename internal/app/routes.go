@@ -3,26 +3,68 @@ package app
 import (
 	"github.com/gofiber/fiber/v2"
 
+	"sweng-task/internal/auth"
+	"sweng-task/internal/config"
 	"sweng-task/internal/handler"
+	"sweng-task/internal/model"
 )
 
+// RouteLimits are the rate limiting middleware of the routes clients can flood
+type RouteLimits struct {
+	// Clients limits every API request before it is authenticated
+	Clients  fiber.Handler
+	Ads      fiber.Handler
+	Tracking fiber.Handler
+	// TrackingBatch limits batches separately, since one batch carries many events
+	TrackingBatch fiber.Handler
+	LineItems     fiber.Handler
+}
+
 func RegisterRoutes(app *fiber.App,
+	cfg config.ServerConfig,
+	limits RouteLimits,
+	healthHandler *handler.HealthHandler,
 	lineItemHandler *handler.LineItemHandler,
 	adSelectionHandler *handler.AdSelectionHandler,
 	trackingHandler *handler.TrackingHandler,
+	apiKeyHandler *handler.APIKeyHandler,
+	anomalyHandler *handler.AnomalyHandler,
 ) {
-	app.Get("/health", handler.HealthCheck)
+	// Probes
+	app.Get("/livez", healthHandler.Livez)
+	app.Get("/readyz", healthHandler.Readyz)
+	// Kept for probes configured before /livez and /readyz were split out
+	app.Get("/health", healthHandler.Livez)
 
-	api := app.Group("/api/v1")
+	// Every API request is authenticated by the middleware installed in SetupApp; each route
+	// lists the roles allowed besides admin. Dashboard bearer tokens are accepted on the line
+	// item and reporting routes, everything else takes API keys only.
+	api := app.Group("/api/v1", handler.Timeout(cfg.Timeout))
+	advertiser := auth.Require(model.RoleAdvertiser)
+	reporting := auth.Require()
+	publisher := auth.RequireAPIKey(model.RolePublisher)
+	adminOnly := auth.RequireAPIKey()
 
 	// Line items
-	api.Post("/lineitems", lineItemHandler.Create)
-	api.Get("/lineitems", lineItemHandler.GetAll)
-	api.Get("/lineitems/:id", lineItemHandler.GetByID)
+	api.Post("/lineitems", advertiser, limits.LineItems, lineItemHandler.Create)
+	api.Get("/lineitems", advertiser, limits.LineItems, lineItemHandler.GetAll)
+	api.Get("/lineitems/:id", advertiser, limits.LineItems, lineItemHandler.GetByID)
+	api.Put("/lineitems/:id/status", advertiser, limits.LineItems, lineItemHandler.UpdateStatus)
+	api.Get("/lineitems/:id/invalid-traffic", advertiser, limits.LineItems, trackingHandler.InvalidTrafficReport)
+
+	// Anomaly alerts
+	api.Get("/alerts", advertiser, limits.LineItems, anomalyHandler.GetAll)
 
 	// Ad selection
-	api.Get("/ads", adSelectionHandler.GetWinningAds)
+	api.Get("/ads", publisher, limits.Ads, handler.Timeout(cfg.AdTimeout), adSelectionHandler.GetWinningAds)
 
 	// Tracking
-	api.Post("/tracking", trackingHandler.TrackEvent)
+	api.Post("/tracking", publisher, limits.Tracking, handler.Timeout(cfg.TrackingTimeout), trackingHandler.TrackEvent)
+	api.Post("/tracking/batch", publisher, limits.TrackingBatch, handler.Timeout(cfg.TrackingTimeout), trackingHandler.TrackBatch)
+	api.Get("/tracking/queue", reporting, trackingHandler.QueueStats)
+
+	// API keys
+	api.Post("/apikeys", adminOnly, apiKeyHandler.Create)
+	api.Get("/apikeys", adminOnly, apiKeyHandler.GetAll)
+	api.Delete("/apikeys/:id", adminOnly, apiKeyHandler.Revoke)
 }
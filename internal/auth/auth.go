@@ -0,0 +1,186 @@
+// Package auth authenticates API requests and authorizes them by role. Callers identify
+// themselves with an API key in the X-API-Key header or, on the management routes, with an
+// OIDC bearer token; the principal it maps to is passed to handlers in the request context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/utils"
+)
+
+// APIKeyHeader carries the caller's API key
+const APIKeyHeader = "X-API-Key"
+
+const bearerPrefix = "Bearer "
+
+// ErrInvalidCredentials is returned by an Authenticator for unknown or revoked credentials
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID identifies the credential, e.g. the API key ID
+	ID           string
+	Role         model.Role
+	AdvertiserID string
+	// Bearer is set for principals authenticated by a bearer token rather than an API key
+	Bearer bool
+}
+
+// CanAccessAdvertiser reports whether the principal may see and modify the line items of
+// advertiserID. Only admins and the advertiser's own keys may.
+func (p *Principal) CanAccessAdvertiser(advertiserID string) bool {
+	switch p.Role {
+	case model.RoleAdmin:
+		return true
+	case model.RoleAdvertiser:
+		return p.AdvertiserID == advertiserID
+	default:
+		return false
+	}
+}
+
+// Authenticator maps a credential, an API key or a bearer token, to the principal it was
+// issued to
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (*Principal, error)
+}
+
+// MiddlewareOption configures optional Middleware behaviour
+type MiddlewareOption func(*middleware)
+
+// WithBearerAuthenticator accepts requests carrying an Authorization: Bearer token instead of
+// an API key, authenticated by authenticator
+func WithBearerAuthenticator(authenticator Authenticator) MiddlewareOption {
+	return func(m *middleware) {
+		m.bearer = authenticator
+	}
+}
+
+type middleware struct {
+	apiKeys Authenticator
+	bearer  Authenticator
+	log     *zap.SugaredLogger
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request ctx belongs to, if it was authenticated
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Middleware rejects requests without a valid API key, or bearer token when a bearer
+// authenticator is configured, with 401 and passes the principal of valid credentials on in
+// the request's user context. A request carrying both is authenticated by its API key.
+func Middleware(authenticator Authenticator, log *zap.SugaredLogger, opts ...MiddlewareOption) fiber.Handler {
+	m := &middleware{apiKeys: authenticator, log: log}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(c *fiber.Ctx) error {
+		if apiKey := c.Get(APIKeyHeader); apiKey != "" {
+			return m.authenticate(c, m.apiKeys, apiKey, "Invalid API key")
+		}
+		if token, ok := bearerToken(c); ok && m.bearer != nil {
+			return m.authenticate(c, m.bearer, token, "Invalid bearer token")
+		}
+		return errorResponse(c, fiber.StatusUnauthorized, "Missing API key")
+	}
+}
+
+func (m *middleware) authenticate(c *fiber.Ctx, authenticator Authenticator, credential, invalidMessage string) error {
+	principal, err := authenticator.Authenticate(c.UserContext(), credential)
+	if errors.Is(err, ErrInvalidCredentials) {
+		logging.FromContext(c.UserContext(), m.log).Debugw("Rejected credentials", "error", err)
+		return errorResponse(c, fiber.StatusUnauthorized, invalidMessage)
+	}
+	if err != nil {
+		logging.FromContext(c.UserContext(), m.log).Errorw("Failed to authenticate request", "error", err)
+		return errorResponse(c, fiber.StatusServiceUnavailable, "Authentication unavailable")
+	}
+
+	return next(c, principal, m.log)
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):]), true
+}
+
+// Anonymous treats every request as coming from an admin, for deployments that run with
+// authentication disabled
+func Anonymous(log *zap.SugaredLogger) fiber.Handler {
+	principal := &Principal{ID: "anonymous", Role: model.RoleAdmin}
+	return func(c *fiber.Ctx) error {
+		return next(c, principal, log)
+	}
+}
+
+// Require rejects requests whose principal has none of roles with 403. Admins are always
+// allowed.
+func Require(roles ...model.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := FromContext(c.UserContext())
+		if !ok {
+			return errorResponse(c, fiber.StatusUnauthorized, "Missing API key")
+		}
+		return authorize(c, principal, roles)
+	}
+}
+
+// RequireAPIKey is Require for routes that only accept API keys, such as the publisher
+// integration and key management; bearer token principals are rejected with 403
+func RequireAPIKey(roles ...model.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := FromContext(c.UserContext())
+		if !ok {
+			return errorResponse(c, fiber.StatusUnauthorized, "Missing API key")
+		}
+		if principal.Bearer {
+			return errorResponse(c, fiber.StatusForbidden, "Bearer tokens are not accepted on this route")
+		}
+		return authorize(c, principal, roles)
+	}
+}
+
+func authorize(c *fiber.Ctx, principal *Principal, roles []model.Role) error {
+	if principal.Role != model.RoleAdmin && !slices.Contains(roles, principal.Role) {
+		return errorResponse(c, fiber.StatusForbidden, "Forbidden")
+	}
+	return c.Next()
+}
+
+// next attaches principal to the request context and its logger, then continues the chain
+func next(c *fiber.Ctx, principal *Principal, log *zap.SugaredLogger) error {
+	ctx := WithPrincipal(c.UserContext(), principal)
+	requestLog := logging.FromContext(ctx, log).With("principal_id", principal.ID, "role", principal.Role)
+	c.SetUserContext(logging.WithLogger(ctx, requestLog))
+	return c.Next()
+}
+
+func errorResponse(c *fiber.Ctx, code int, message string) error {
+	return c.Status(code).JSON(utils.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		RequestID: logging.RequestID(c),
+	})
+}
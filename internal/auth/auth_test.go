@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+)
+
+type staticAuthenticator map[string]*Principal
+
+func (a staticAuthenticator) Authenticate(ctx context.Context, apiKey string) (*Principal, error) {
+	if apiKey == "unavailable" {
+		return nil, errors.New("database unavailable")
+	}
+	if p, ok := a[apiKey]; ok {
+		return p, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func TestMiddleware(t *testing.T) {
+	authenticator := staticAuthenticator{
+		"admin-key":      {ID: "key_admin", Role: model.RoleAdmin},
+		"advertiser-key": {ID: "key_adv", Role: model.RoleAdvertiser, AdvertiserID: "adv_1"},
+		"publisher-key":  {ID: "key_pub", Role: model.RolePublisher},
+	}
+
+	app := fiber.New()
+	app.Use(Middleware(authenticator, zap.NewNop().Sugar()))
+	app.Get("/lineitems", Require(model.RoleAdvertiser), func(c *fiber.Ctx) error {
+		principal, ok := FromContext(c.UserContext())
+		require.True(t, ok)
+		return c.SendString(principal.ID)
+	})
+
+	tests := []struct {
+		name     string
+		key      string
+		expected int
+	}{
+		{name: "missing key", expected: http.StatusUnauthorized},
+		{name: "invalid key", key: "wrong", expected: http.StatusUnauthorized},
+		{name: "authenticator failure", key: "unavailable", expected: http.StatusServiceUnavailable},
+		{name: "role not allowed", key: "publisher-key", expected: http.StatusForbidden},
+		{name: "role allowed", key: "advertiser-key", expected: http.StatusOK},
+		{name: "admin always allowed", key: "admin-key", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/lineitems", nil)
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestMiddleware_BearerTokens(t *testing.T) {
+	apiKeys := staticAuthenticator{
+		"publisher-key": {ID: "key_pub", Role: model.RolePublisher},
+	}
+	tokens := staticAuthenticator{
+		"admin-token":      {ID: "user-1", Role: model.RoleAdmin, Bearer: true},
+		"advertiser-token": {ID: "user-2", Role: model.RoleAdvertiser, AdvertiserID: "adv_1", Bearer: true},
+	}
+
+	app := fiber.New()
+	app.Use(Middleware(apiKeys, zap.NewNop().Sugar(), WithBearerAuthenticator(tokens)))
+	app.Get("/lineitems", Require(model.RoleAdvertiser), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/apikeys", RequireAPIKey(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		key           string
+		expected      int
+	}{
+		{name: "valid token", path: "/lineitems", authorization: "Bearer advertiser-token", expected: http.StatusOK},
+		{name: "scheme is case insensitive", path: "/lineitems", authorization: "bearer advertiser-token", expected: http.StatusOK},
+		{name: "invalid token", path: "/lineitems", authorization: "Bearer wrong", expected: http.StatusUnauthorized},
+		{name: "other scheme", path: "/lineitems", authorization: "Basic YWRtaW46YWRtaW4=", expected: http.StatusUnauthorized},
+		{name: "API key takes precedence", path: "/lineitems", authorization: "Bearer advertiser-token", key: "publisher-key", expected: http.StatusForbidden},
+		{name: "token on API key route", path: "/apikeys", authorization: "Bearer admin-token", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestMiddleware_BearerTokensDisabled(t *testing.T) {
+	app := fiber.New()
+	app.Use(Middleware(staticAuthenticator{}, zap.NewNop().Sugar()))
+	app.Get("/lineitems", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/lineitems", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer advertiser-token")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRequire_WithoutPrincipal(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", Require(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAnonymous(t *testing.T) {
+	app := fiber.New()
+	app.Use(Anonymous(zap.NewNop().Sugar()))
+	app.Get("/apikeys", Require(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/apikeys", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "without authentication every caller is an admin")
+}
+
+func TestPrincipal_CanAccessAdvertiser(t *testing.T) {
+	admin := &Principal{Role: model.RoleAdmin}
+	advertiser := &Principal{Role: model.RoleAdvertiser, AdvertiserID: "adv_1"}
+	publisher := &Principal{Role: model.RolePublisher}
+
+	assert.True(t, admin.CanAccessAdvertiser("adv_2"))
+	assert.True(t, advertiser.CanAccessAdvertiser("adv_1"))
+	assert.False(t, advertiser.CanAccessAdvertiser("adv_2"))
+	assert.False(t, publisher.CanAccessAdvertiser("adv_1"))
+}
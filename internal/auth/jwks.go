@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxJWKSSize bounds the key sets read from files and URLs
+	maxJWKSSize = 1 << 20
+	// minJWKSRefreshInterval rate limits the refreshes triggered by tokens signed with unknown
+	// keys, so that forged key IDs can't make every request fetch the key set
+	minJWKSRefreshInterval = time.Minute
+)
+
+// ErrUnknownKey is returned by JWKS.Key when the key set has no key with the requested ID
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWKS is a JSON Web Key Set holding the public keys tokens are verified with, loaded from a
+// file or URL. It is reloaded every refresh interval and when a token names a key it doesn't
+// hold, so that keys rotated by the identity provider are picked up.
+type JWKS struct {
+	load     func(ctx context.Context) ([]byte, error)
+	interval time.Duration
+	log      *zap.SugaredLogger
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+	// refreshedAt is when the key set was last loaded or a lookup last tried to reload it
+	refreshedAt time.Time
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFileJWKS creates a JWKS read from the file at path
+func NewFileJWKS(path string, interval time.Duration, log *zap.SugaredLogger) *JWKS {
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxJWKSSize))
+	}, interval, log)
+}
+
+// NewRemoteJWKS creates a JWKS fetched from url, usually the jwks_uri of an OIDC provider
+func NewRemoteJWKS(url string, client *http.Client, interval time.Duration, log *zap.SugaredLogger) *JWKS {
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: unexpected status %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	}, interval, log)
+}
+
+func newJWKS(load func(ctx context.Context) ([]byte, error), interval time.Duration, log *zap.SugaredLogger) *JWKS {
+	return &JWKS{
+		load:     load,
+		interval: interval,
+		log:      log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Refresh replaces the keys with those currently published
+func (s *JWKS) Refresh(ctx context.Context) error {
+	data, err := s.load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.refreshedAt = time.Now()
+	return nil
+}
+
+// Key returns the public key with ID kid. An empty kid selects the only key of a set
+// holding one. Unknown IDs reload the key set at most once per minute before failing with
+// ErrUnknownKey.
+func (s *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	if !s.claimRefresh() {
+		return nil, ErrUnknownKey
+	}
+
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (s *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// claimRefresh reports whether a lookup may reload the key set, recording the attempt so
+// that failed reloads are rate limited as well
+func (s *JWKS) claimRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.refreshedAt) < minJWKSRefreshInterval {
+		return false
+	}
+	s.refreshedAt = time.Now()
+	return true
+}
+
+// Start reloads the key set every refresh interval until Stop is called
+func (s *JWKS) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+				if err := s.Refresh(ctx); err != nil {
+					s.log.Errorw("Failed to refresh JWKS", "error", err)
+				}
+				cancel()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background refreshes started by Start
+func (s *JWKS) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jwk is a JSON Web Key as defined by RFC 7517, limited to the members of RSA and EC keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the RSA and EC signing keys of a key set. Encryption keys and keys of
+// other types are skipped, as identity providers publish them alongside signing keys.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("exponent: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent out of range")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("x coordinate: %w", err)
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("y coordinate: %w", err)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
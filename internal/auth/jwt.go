@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"sweng-task/internal/model"
+)
+
+// jwtLeeway tolerates clock skew between the identity provider and the service
+const jwtLeeway = 30 * time.Second
+
+// JWTConfig selects which tokens a JWTAuthenticator accepts and how their claims map to
+// principals
+type JWTConfig struct {
+	// Issuer and Audience must match the iss and aud claims when set
+	Issuer   string
+	Audience string
+	// RoleClaim and AdvertiserClaim name the claims holding the caller's role and advertiser
+	// ID; dots select nested claims, e.g. realm_access.roles
+	RoleClaim       string
+	AdvertiserClaim string
+}
+
+// JWTAuthenticator authenticates bearer tokens issued by an OIDC provider for the internal
+// dashboard. Tokens must be signed with RS256 or ES256 by a key of the JWKS and grant the
+// admin or advertiser role; publishers integrate with API keys only.
+type JWTAuthenticator struct {
+	keys   *JWKS
+	cfg    JWTConfig
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator creates a JWTAuthenticator verifying tokens with keys
+func NewJWTAuthenticator(keys *JWKS, cfg JWTConfig) *JWTAuthenticator {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &JWTAuthenticator{
+		keys:   keys,
+		cfg:    cfg,
+		parser: jwt.NewParser(opts...),
+	}
+}
+
+// Authenticate verifies token and maps its claims to a principal. Tokens that are malformed,
+// expired, signed by an unknown key or grant no role fail with ErrInvalidCredentials; an
+// error loading the key set is returned as is.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	var keyErr error
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := a.keys.Key(ctx, kid)
+		if err != nil && !errors.Is(err, ErrUnknownKey) {
+			keyErr = err
+		}
+		return key, err
+	})
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidCredentials)
+	}
+
+	principal := &Principal{ID: subject, Bearer: true}
+	roles := claimStrings(claims, a.cfg.RoleClaim)
+	switch {
+	case containsRole(roles, model.RoleAdmin):
+		principal.Role = model.RoleAdmin
+	case containsRole(roles, model.RoleAdvertiser):
+		advertiserID, _ := claimValue(claims, a.cfg.AdvertiserClaim).(string)
+		if advertiserID == "" {
+			return nil, fmt.Errorf("%w: advertiser token without %s claim", ErrInvalidCredentials, a.cfg.AdvertiserClaim)
+		}
+		principal.Role = model.RoleAdvertiser
+		principal.AdvertiserID = advertiserID
+	default:
+		return nil, fmt.Errorf("%w: token grants no role", ErrInvalidCredentials)
+	}
+	return principal, nil
+}
+
+// claimValue returns the claim at path, descending into nested objects at each dot
+func claimValue(claims jwt.MapClaims, path string) interface{} {
+	var value interface{} = map[string]interface{}(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// claimStrings reads a claim holding either a single string or a list of strings, as
+// providers differ in how they encode roles
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func containsRole(values []string, role model.Role) bool {
+	for _, v := range values {
+		if model.Role(v) == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+)
+
+const (
+	testIssuer   = "https://login.example.com"
+	testAudience = "ad-bidding-dashboard"
+)
+
+// signingKey is a locally generated key together with its JWK
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return signingKey{kid: kid, method: jwt.SigningMethodRS256, key: key}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return signingKey{kid: kid, method: jwt.SigningMethodES256, key: key}
+}
+
+func (k signingKey) jwk() map[string]string {
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch pub := k.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig", "n": encode(pub.N), "e": encode(big.NewInt(int64(pub.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256", "x": encode(pub.X), "y": encode(pub.Y)}
+	default:
+		panic("unsupported key type")
+	}
+}
+
+func (k signingKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func jwksJSON(t *testing.T, keys ...signingKey) []byte {
+	t.Helper()
+	set := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func writeJWKS(t *testing.T, keys ...signingKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksJSON(t, keys...), 0o600))
+	return path
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":           testIssuer,
+		"aud":           testAudience,
+		"sub":           "user-1",
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"role":          "advertiser",
+		"advertiser_id": "adv_1",
+	}
+}
+
+func newTestJWTAuthenticator(t *testing.T, keys ...signingKey) *JWTAuthenticator {
+	t.Helper()
+	jwks := NewFileJWKS(writeJWKS(t, keys...), time.Hour, zap.NewNop().Sugar())
+	require.NoError(t, jwks.Refresh(t.Context()))
+	return NewJWTAuthenticator(jwks, JWTConfig{
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+		RoleClaim:       "role",
+		AdvertiserClaim: "advertiser_id",
+	})
+}
+
+func TestJWTAuthenticator_Authenticate(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	ecKey := newECKey(t, "ec-1")
+	authenticator := newTestJWTAuthenticator(t, rsaKey, ecKey)
+
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := validClaims()
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected *Principal
+	}{
+		{
+			name:     "RS256 advertiser",
+			token:    rsaKey.sign(t, validClaims()),
+			expected: &Principal{ID: "user-1", Role: model.RoleAdvertiser, AdvertiserID: "adv_1", Bearer: true},
+		},
+		{
+			name:     "ES256 advertiser",
+			token:    ecKey.sign(t, validClaims()),
+			expected: &Principal{ID: "user-1", Role: model.RoleAdvertiser, AdvertiserID: "adv_1", Bearer: true},
+		},
+		{
+			name:     "admin from a list of roles",
+			token:    rsaKey.sign(t, with(jwt.MapClaims{"role": []string{"viewer", "admin"}, "advertiser_id": nil})),
+			expected: &Principal{ID: "user-1", Role: model.RoleAdmin, Bearer: true},
+		},
+		{name: "expired", token: rsaKey.sign(t, with(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}))},
+		{name: "without expiry", token: rsaKey.sign(t, with(jwt.MapClaims{"exp": nil}))},
+		{name: "wrong issuer", token: rsaKey.sign(t, with(jwt.MapClaims{"iss": "https://evil.example.com"}))},
+		{name: "wrong audience", token: rsaKey.sign(t, with(jwt.MapClaims{"aud": "another-client"}))},
+		{name: "without subject", token: rsaKey.sign(t, with(jwt.MapClaims{"sub": nil}))},
+		{name: "publisher role", token: rsaKey.sign(t, with(jwt.MapClaims{"role": "publisher"}))},
+		{name: "advertiser without advertiser ID", token: rsaKey.sign(t, with(jwt.MapClaims{"advertiser_id": nil}))},
+		{name: "unknown key", token: newRSAKey(t, "rsa-2").sign(t, validClaims())},
+		{name: "key ID of another key", token: newRSAKey(t, "rsa-1").sign(t, validClaims())},
+		{name: "malformed", token: "not-a-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(t.Context(), tt.token)
+			if tt.expected == nil {
+				assert.ErrorIs(t, err, ErrInvalidCredentials)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, principal)
+		})
+	}
+}
+
+func TestJWTAuthenticator_RejectsOtherAlgorithms(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	authenticator := newTestJWTAuthenticator(t, rsaKey)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, validClaims())
+	token.Header["kid"] = rsaKey.kid
+	signed, err := token.SignedString(rsaKey.key)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(t.Context(), signed)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(t.Context(), unsigned)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestJWTAuthenticator_NestedClaims(t *testing.T) {
+	ecKey := newECKey(t, "ec-1")
+	jwks := NewFileJWKS(writeJWKS(t, ecKey), time.Hour, zap.NewNop().Sugar())
+	require.NoError(t, jwks.Refresh(t.Context()))
+	authenticator := NewJWTAuthenticator(jwks, JWTConfig{
+		RoleClaim:       "realm_access.roles",
+		AdvertiserClaim: "ads.advertiser",
+	})
+
+	claims := jwt.MapClaims{
+		"sub":          "user-2",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"realm_access": map[string]interface{}{"roles": []string{"advertiser"}},
+		"ads":          map[string]interface{}{"advertiser": "adv_9"},
+	}
+	principal, err := authenticator.Authenticate(t.Context(), ecKey.sign(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{ID: "user-2", Role: model.RoleAdvertiser, AdvertiserID: "adv_9", Bearer: true}, principal)
+}
+
+func TestRemoteJWKS_RotatedKeys(t *testing.T) {
+	oldKey := newRSAKey(t, "old")
+	newKey := newECKey(t, "new")
+
+	var (
+		published atomic.Value
+		fetches   atomic.Int32
+	)
+	published.Store(jwksJSON(t, oldKey))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(published.Load().([]byte))
+	}))
+	defer server.Close()
+
+	jwks := NewRemoteJWKS(server.URL, server.Client(), time.Hour, zap.NewNop().Sugar())
+	authenticator := NewJWTAuthenticator(jwks, JWTConfig{RoleClaim: "role", AdvertiserClaim: "advertiser_id"})
+
+	// The first token loads the key set
+	_, err := authenticator.Authenticate(t.Context(), oldKey.sign(t, validClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// A key published after the last load is only picked up once the rate limit allows
+	published.Store(jwksJSON(t, oldKey, newKey))
+	_, err = authenticator.Authenticate(t.Context(), newKey.sign(t, validClaims()))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, int32(1), fetches.Load(), "unknown keys must not refetch within the rate limit")
+
+	require.NoError(t, jwks.Refresh(t.Context()))
+	_, err = authenticator.Authenticate(t.Context(), newKey.sign(t, validClaims()))
+	require.NoError(t, err)
+}
+
+func TestRemoteJWKS_Unavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	jwks := NewRemoteJWKS(server.URL, server.Client(), time.Hour, zap.NewNop().Sugar())
+	authenticator := NewJWTAuthenticator(jwks, JWTConfig{RoleClaim: "role", AdvertiserClaim: "advertiser_id"})
+
+	_, err := authenticator.Authenticate(t.Context(), newRSAKey(t, "rsa-1").sign(t, validClaims()))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidCredentials, "an unreachable key set must not be reported as bad credentials")
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys, err := parseJWKS([]byte(`{"keys": [
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"}
+	]}`))
+	require.NoError(t, err)
+	assert.Empty(t, keys, "symmetric and encryption keys are skipped")
+
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "EC", "kid": "bad", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`))
+	assert.Error(t, err, "points off the curve are rejected")
+
+	keys, err = parseJWKS(jwksJSON(t, newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")))
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
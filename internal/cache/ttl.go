@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TTLCache is a bounded, concurrency-safe cache whose entries expire after a fixed TTL.
+// Because every entry shares the same TTL, insertion order is also expiry order, so the
+// oldest entry is always evicted first when the cache is full.
+type TTLCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	items    map[K]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+type ttlEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// NewTTLCache creates a cache holding at most capacity entries for ttl each
+func NewTTLCache[K comparable, V any](ttl time.Duration, capacity int) *TTLCache[K, V] {
+	return &TTLCache[K, V]{
+		ttl:      ttl,
+		capacity: capacity,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value stored for key if it has not expired
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := el.Value.(*ttlEntry[K, V])
+	if c.now().After(entry.expiresAt) {
+		c.remove(el)
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set stores value for key, replacing any previous value and resetting its TTL
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	c.evictExpired()
+	for c.capacity > 0 && c.order.Len() >= c.capacity {
+		c.remove(c.order.Front())
+	}
+
+	el := c.order.PushBack(&ttlEntry[K, V]{
+		key:       key,
+		value:     value,
+		expiresAt: c.now().Add(c.ttl),
+	})
+	c.items[key] = el
+}
+
+// Delete removes key from the cache
+func (c *TTLCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of entries currently held, including not yet evicted expired ones
+func (c *TTLCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *TTLCache[K, V]) evictExpired() {
+	now := c.now()
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		if !now.After(el.Value.(*ttlEntry[K, V]).expiresAt) {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *TTLCache[K, V]) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*ttlEntry[K, V])
+	delete(c.items, entry.key)
+}
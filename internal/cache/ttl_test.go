@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache_GetSet(t *testing.T) {
+	c := NewTTLCache[string, int](time.Minute, 10)
+
+	c.Set("a", 1)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	_, ok = c.Get("missing")
+	assert.False(t, ok)
+}
+
+func TestTTLCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := NewTTLCache[string, int](time.Minute, 10)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(2 * time.Minute)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestTTLCache_EvictsOldestWhenFull(t *testing.T) {
+	c := NewTTLCache[string, int](time.Minute, 2)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Len())
+}
@@ -0,0 +1,70 @@
+// Package changefeed delivers notifications about changed line items to in-process
+// subscribers such as caches and indexes.
+package changefeed
+
+import (
+	"sync"
+)
+
+// LineItemChannel is the Postgres notification channel line item changes are sent on
+const LineItemChannel = "line_item_changes"
+
+// EventType is the kind of change a line item went through
+type EventType string
+
+const (
+	LineItemCreated EventType = "created"
+	LineItemUpdated EventType = "updated"
+	LineItemDeleted EventType = "deleted"
+	// LineItemSpending means only the daily spending changed
+	LineItemSpending EventType = "spending"
+	// Resync means notifications may have been missed, so subscribers must reload their state
+	Resync EventType = "resync"
+)
+
+// Event describes a change to a line item. LineItemID and DailySpending are empty for Resync.
+type Event struct {
+	Type          EventType `json:"type"`
+	LineItemID    string    `json:"id"`
+	DailySpending float64   `json:"daily_spending"`
+}
+
+// Feed delivers change events to subscribers. Handlers run on the feed's delivery goroutine
+// and must return quickly.
+type Feed interface {
+	Subscribe(handler func(Event)) (unsubscribe func())
+	Close() error
+}
+
+type hub struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	nextID      int
+}
+
+func (h *hub) Subscribe(handler func(Event)) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = make(map[int]func(Event))
+	}
+	id := h.nextID
+	h.nextID++
+	h.subscribers[id] = handler
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, id)
+	}
+}
+
+func (h *hub) dispatch(event Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, handler := range h.subscribers {
+		handler(event)
+	}
+}
@@ -0,0 +1,33 @@
+package changefeed
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_FansOutToSubscribers(t *testing.T) {
+	feed := NewMemory()
+
+	var first, second []Event
+	unsubscribe := feed.Subscribe(func(e Event) { first = append(first, e) })
+	feed.Subscribe(func(e Event) { second = append(second, e) })
+
+	created := Event{Type: LineItemCreated, LineItemID: "li_1"}
+	feed.Publish(created)
+	unsubscribe()
+	feed.Publish(Event{Type: Resync})
+
+	assert.Equal(t, []Event{created}, first)
+	assert.Equal(t, []Event{created, {Type: Resync}}, second)
+}
+
+func TestDecodeNotification(t *testing.T) {
+	event, err := decodeNotification(`{"type" : "spending", "id" : "li_1", "daily_spending" : 12.5}`)
+	require.NoError(t, err)
+	assert.Equal(t, Event{Type: LineItemSpending, LineItemID: "li_1", DailySpending: 12.5}, event)
+
+	_, err = decodeNotification("not json")
+	assert.Error(t, err)
+}
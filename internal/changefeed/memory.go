@@ -0,0 +1,20 @@
+package changefeed
+
+// Memory is a Feed whose events are published in-process, for tests and for storage
+// backends that cannot notify on their own
+type Memory struct {
+	hub
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish delivers event to every subscriber before returning
+func (m *Memory) Publish(event Event) {
+	m.dispatch(event)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package changefeed
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const listenerPingInterval = 90 * time.Second
+
+// PostgresFeed listens for the notifications sent by the line_items trigger installed by the
+// database migrations. It reconnects on its own and publishes Resync once reconnected.
+type PostgresFeed struct {
+	hub
+	listener *pq.Listener
+	log      *zap.SugaredLogger
+	done     chan struct{}
+}
+
+func NewPostgresFeed(dsn string, log *zap.SugaredLogger) (*PostgresFeed, error) {
+	f := &PostgresFeed{log: log, done: make(chan struct{})}
+	f.listener = pq.NewListener(dsn, time.Second, time.Minute, f.onListenerEvent)
+	if err := f.listener.Listen(LineItemChannel); err != nil {
+		f.listener.Close()
+		return nil, err
+	}
+
+	go f.run()
+	log.Infow("Listening for line item changes", "channel", LineItemChannel)
+	return f, nil
+}
+
+// Close stops listening and waits for in-flight deliveries to finish
+func (f *PostgresFeed) Close() error {
+	err := f.listener.Close()
+	<-f.done
+	return err
+}
+
+func (f *PostgresFeed) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification, ok := <-f.listener.Notify:
+			if !ok {
+				return
+			}
+			// pq sends nil after re-establishing a lost connection
+			if notification == nil {
+				f.dispatch(Event{Type: Resync})
+				continue
+			}
+
+			event, err := decodeNotification(notification.Extra)
+			if err != nil {
+				f.log.Errorw("Failed to decode line item change", "payload", notification.Extra, "error", err)
+				continue
+			}
+			f.dispatch(event)
+		case <-ticker.C:
+			// Detects connections that died without an error
+			go f.listener.Ping()
+		}
+	}
+}
+
+func (f *PostgresFeed) onListenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		f.log.Warnw("Line item change listener disconnected", "error", err)
+	case pq.ListenerEventReconnected:
+		f.log.Infow("Line item change listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		f.log.Errorw("Line item change listener failed to connect", "error", err)
+	}
+}
+
+func decodeNotification(payload string) (Event, error) {
+	var event Event
+	err := json.Unmarshal([]byte(payload), &event)
+	return event, err
+}
@@ -0,0 +1,49 @@
+package changefeed_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/changefeed"
+	"sweng-task/internal/db"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/repotest"
+	"sweng-task/internal/testutil"
+)
+
+// TestPostgresFeed_NotifiesChanges runs against the database configured through the
+// APP_DATABASE_* variables, or a locally started Postgres, and is skipped otherwise
+func TestPostgresFeed_NotifiesChanges(t *testing.T) {
+	cfg := repotest.PostgresConfig(t)
+	database, err := db.ConnectPostgres(cfg)
+	require.NoError(t, err)
+	require.NoError(t, db.RunMigrations(database, "postgres", testutil.GetTestLogger()))
+
+	feed, err := changefeed.NewPostgresFeed(db.PostgresDSN(cfg), testutil.GetTestLogger())
+	require.NoError(t, err)
+	defer feed.Close()
+
+	events := make(chan changefeed.Event, 10)
+	feed.Subscribe(func(e changefeed.Event) { events <- e })
+
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, database.Create(item).Error)
+	defer database.Delete(&model.LineItemEntity{}, "id = ?", item.ID)
+	require.NoError(t, database.Model(item).Update("daily_spending", 1.5).Error)
+	require.NoError(t, database.Model(item).Update("name", "Renamed").Error)
+
+	for _, expected := range []changefeed.Event{
+		{Type: changefeed.LineItemCreated, LineItemID: item.ID},
+		{Type: changefeed.LineItemSpending, LineItemID: item.ID, DailySpending: 1.5},
+		{Type: changefeed.LineItemUpdated, LineItemID: item.ID, DailySpending: 1.5},
+	} {
+		select {
+		case event := <-events:
+			assert.Equal(t, expected, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s event", expected.Type)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -8,9 +12,18 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	App      AppConfig      `split_words:"true"`
-	Server   ServerConfig   `split_words:"true"`
-	Database DatabaseConfig `split_words:"true"`
+	App       AppConfig      `split_words:"true"`
+	Server    ServerConfig   `split_words:"true"`
+	Database  DatabaseConfig `split_words:"true"`
+	Tracking  TrackingConfig `split_words:"true"`
+	Sink      SinkConfig     `split_words:"true"`
+	Bidding   BiddingConfig
+	Tracing   TracingConfig
+	Health    HealthConfig
+	Auth      AuthConfig
+	RateLimit RateLimitConfig `split_words:"true"`
+	IVT       IVTConfig
+	Anomaly   AnomalyConfig
 }
 
 // AppConfig contains application-specific configuration
@@ -23,16 +36,256 @@ type AppConfig struct {
 
 // ServerConfig contains HTTP server configuration
 type ServerConfig struct {
-	Port    int           `default:"8080"`
-	Timeout time.Duration `default:"30s"`
+	Port int `default:"8080"`
+	// InternalPort serves /metrics, separately from the API so that it can be kept off
+	// the public network
+	InternalPort int `default:"9090" split_words:"true"`
+	// Timeout is the deadline of every API request; the per-endpoint timeouts below can only shorten it
+	Timeout         time.Duration `default:"30s"`
+	ShutdownTimeout time.Duration `default:"30s" split_words:"true"`
+	// AdTimeout is the latency budget of ad selection
+	AdTimeout time.Duration `default:"200ms" split_words:"true"`
+	// TrackingTimeout bounds tracking writes, both within requests and in the ingestion pipeline
+	TrackingTimeout time.Duration `default:"5s" split_words:"true"`
+	// ProxyHeader is the header in which the load balancer in front of the service passes
+	// the client IP, e.g. X-Forwarded-For. Without it the client IP is the peer address,
+	// which behind a load balancer is the balancer's for every client.
+	ProxyHeader string `split_words:"true"`
+	// TrustedProxies are the IPs and CIDR ranges of the load balancers whose ProxyHeader is
+	// believed; when empty it is believed from any peer
+	TrustedProxies []string `split_words:"true"`
 }
 
 type DatabaseConfig struct {
-	Host     string `split_words:"true"`
-	Port     int    `split_words:"true"`
-	User     string `split_words:"true"`
-	Password string `split_words:"true"`
-	Database string `split_words:"true"`
+	// Driver selects the storage backend: postgres, sqlite, or memory to run without a database
+	Driver string `default:"postgres"`
+	// SQLitePath is the database file used by the sqlite driver
+	SQLitePath string `default:"data/ad-bidding.db" envconfig:"sqlite_path"`
+	Host       string `split_words:"true"`
+	Port       int    `split_words:"true"`
+	User       string `split_words:"true"`
+	Password   string `split_words:"true"`
+	Database   string `split_words:"true"`
+	// MigrateOnStart applies pending schema migrations on startup; when false the server
+	// refuses to start until they are applied with the migrate command
+	MigrateOnStart bool `default:"true" split_words:"true"`
+	// ChangeFeed listens for line item change notifications so caches refresh without polling
+	ChangeFeed bool `default:"true" split_words:"true"`
+}
+
+// TrackingConfig contains tracking event ingestion configuration
+type TrackingConfig struct {
+	DedupeWindow   time.Duration `default:"10m" split_words:"true"`
+	DedupeCapacity int           `default:"100000" split_words:"true"`
+	MaxBatchSize   int           `default:"500" split_words:"true"`
+	Async          bool          `default:"true"`
+	QueueSize      int           `default:"10000" split_words:"true"`
+	Workers        int           `default:"4"`
+	BatchSize      int           `default:"200" split_words:"true"`
+	FlushInterval  time.Duration `default:"100ms" split_words:"true"`
+	// WALDir enables the write-ahead log for queued events when set
+	WALDir          string        `split_words:"true"`
+	WALSync         string        `default:"interval" split_words:"true"`
+	WALSyncInterval time.Duration `default:"1s" split_words:"true"`
+	WALSegmentSize  int64         `default:"67108864" split_words:"true"`
+	// CounterRefreshInterval is how often cached event counts used for bid estimation are reloaded
+	CounterRefreshInterval time.Duration `default:"5s" split_words:"true"`
+	// StatsRetention is how long hourly event counts are kept for windowed bid estimation
+	StatsRetention time.Duration `default:"720h" split_words:"true"`
+}
+
+// IVTConfig sets the rules tracking events are checked against for invalid traffic. Zero
+// values disable a rule.
+type IVTConfig struct {
+	Enabled bool `default:"true"`
+	// RequireImpression flags clicks without an impression of the line item by the same user
+	// within ImpressionWindow
+	RequireImpression bool          `default:"true" split_words:"true"`
+	ImpressionWindow  time.Duration `default:"24h" split_words:"true"`
+	// MinClickDelay is the shortest time between an impression and a valid click on it
+	MinClickDelay time.Duration `default:"1s" split_words:"true"`
+	// MaxEventsPerUser and MaxEventsPerIP are the events a user or client IP may produce
+	// within EventWindow
+	MaxEventsPerUser int           `default:"120" split_words:"true"`
+	MaxEventsPerIP   int           `default:"1200" envconfig:"max_events_per_ip"`
+	EventWindow      time.Duration `default:"1m" split_words:"true"`
+	// BotUserAgents is a comma-separated list of user agent substrings of known bots
+	BotUserAgents []string `default:"bot,crawler,spider,slurp,headless,phantomjs,lighthouse" split_words:"true"`
+	// MaxCTR is the highest click-through rate of a user within CTRWindow once the user has
+	// made MinClicks clicks
+	MaxCTR    float64       `default:"0.5" envconfig:"max_ctr"`
+	MinClicks int           `default:"5" split_words:"true"`
+	CTRWindow time.Duration `default:"1h" envconfig:"ctr_window"`
+}
+
+// AnomalyConfig tunes the hourly detection of anomalies in the CTR, CVR and spend of line
+// items
+type AnomalyConfig struct {
+	Enabled bool `default:"true"`
+	// Baseline is how far back the hours forming the baseline of a metric go
+	Baseline time.Duration `default:"168h"`
+	// MinSamples is the number of hours a baseline needs before the metric is checked
+	MinSamples int `default:"24" split_words:"true"`
+	// Alpha is the weight of each hour in the exponentially weighted baseline
+	Alpha float64 `default:"0.1"`
+	// Threshold is the z-score from which a deviation from the baseline is an anomaly
+	Threshold float64 `default:"3"`
+	// MinImpressions and MinClicks are the events an hour needs for its CTR, respectively
+	// CVR, to be sampled
+	MinImpressions int `default:"200" split_words:"true"`
+	MinClicks      int `default:"20" split_words:"true"`
+	// AutoPause pauses active line items whose CTR, CVR or spend rises anomalously
+	AutoPause bool `default:"false" split_words:"true"`
+}
+
+// BiddingConfig contains ad selection and bid estimation configuration
+type BiddingConfig struct {
+	// IndexRefreshInterval is how often the in-memory line item index is rebuilt in addition
+	// to rebuilds after line items are created or budgets reset
+	IndexRefreshInterval time.Duration `default:"30s" split_words:"true"`
+	// StatsWindow and StatsHalfLife weight events by age; both zero prices on all events ever tracked
+	StatsWindow   time.Duration `default:"0s" split_words:"true"`
+	StatsHalfLife time.Duration `default:"0s" split_words:"true"`
+	// StatsTimeout bounds the event count lookup of an ad request; slower lookups fall back to
+	// cached or default counts so that ads are still served within the request's budget
+	StatsTimeout time.Duration `default:"50ms" split_words:"true"`
+}
+
+// SinkConfig selects where accepted tracking events are published in addition to the
+// tracking repository, which always stores them
+type SinkConfig struct {
+	// Types is a comma-separated list of file and kafka
+	Types        []string `split_words:"true"`
+	FilePath     string   `default:"data/tracking-events.ndjson" split_words:"true"`
+	KafkaBrokers []string `split_words:"true"`
+	KafkaTopic   string   `default:"tracking-events" split_words:"true"`
+	KafkaAcks    int16    `default:"1" split_words:"true"`
+	// KafkaBuffer is the number of events buffered for the kafka producer; events
+	// published while it is full are dropped
+	KafkaBuffer int `default:"10000" split_words:"true"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported
+type TracingConfig struct {
+	// Exporter is otlp, stdout or file; tracing is disabled when empty
+	Exporter string
+	// Endpoint is the OTLP/HTTP endpoint URL; when empty the standard OTEL_EXPORTER_OTLP_*
+	// variables apply
+	Endpoint string
+	FilePath string `default:"data/traces.ndjson" split_words:"true"`
+	// SampleRatio is the share of new traces recorded; requests continuing a trace follow the
+	// caller's sampling decision
+	SampleRatio float64 `default:"1" split_words:"true"`
+}
+
+// HealthConfig controls the readiness checks behind /readyz
+type HealthConfig struct {
+	// CheckTimeout bounds each dependency check
+	CheckTimeout time.Duration `default:"2s" split_words:"true"`
+	// MaxQueueUsage is the share of the ingestion queue capacity above which the service
+	// reports itself not ready, so that load balancers stop sending it tracking events
+	MaxQueueUsage float64 `default:"0.9" split_words:"true"`
+}
+
+// AuthConfig controls API key and bearer token authentication of the /api endpoints
+type AuthConfig struct {
+	// Enabled requires an API key on every API request; when false every caller is an admin
+	Enabled bool `default:"true"`
+	// AdminKey is accepted as an admin key without being stored, to create the first keys
+	AdminKey string `split_words:"true"`
+	// CacheTTL is how long looked up keys are cached, and so how long a revoked key may still
+	// be accepted
+	CacheTTL time.Duration `default:"30s" split_words:"true"`
+	// NegativeCacheTTL is how long unknown keys are remembered, so that requests retried with
+	// an invalid key don't each query the database
+	NegativeCacheTTL time.Duration `default:"5s" split_words:"true"`
+	// JWKSURL or JWKSFile enable OIDC bearer tokens on the line item and reporting routes,
+	// verified with the keys of the JSON Web Key Set they point to
+	JWKSURL             string        `envconfig:"jwks_url"`
+	JWKSFile            string        `envconfig:"jwks_file"`
+	JWKSRefreshInterval time.Duration `default:"15m" envconfig:"jwks_refresh_interval"`
+	// Issuer and Audience are required of the iss and aud claims of bearer tokens when set
+	Issuer   string
+	Audience string
+	// RoleClaim and AdvertiserClaim name the token claims mapped to the principal's role and
+	// advertiser ID; dots select nested claims
+	RoleClaim       string `default:"role" split_words:"true"`
+	AdvertiserClaim string `default:"advertiser_id" split_words:"true"`
+}
+
+// RateLimitConfig sets the per-client limits of the API routes. Each limit is written as
+// RATE/PERIOD[,burst=N][,key=api_key|ip|advertiser], e.g. 100/s,burst=200,key=api_key, or off.
+type RateLimitConfig struct {
+	Enabled bool `default:"true"`
+	// Clients limits every API request per client IP before it is authenticated, so that
+	// floods of requests with invalid credentials are throttled before the key lookup
+	Clients RateLimit `default:"2000/s,burst=4000,key=ip"`
+	// Ads limits ad requests
+	Ads RateLimit `default:"100/s,burst=200,key=api_key"`
+	// Tracking limits single event tracking requests
+	Tracking RateLimit `default:"1000/s,burst=2000,key=api_key"`
+	// TrackingBatch limits batch tracking requests, each carrying up to
+	// Tracking.MaxBatchSize events, so its rate is far lower than Tracking's
+	TrackingBatch RateLimit `default:"10/s,burst=20,key=api_key" split_words:"true"`
+	// LineItems limits line item management, shared by all keys of an advertiser
+	LineItems RateLimit `default:"10/s,burst=20,key=advertiser" split_words:"true"`
+}
+
+// RateLimit is a token bucket limit of a route
+type RateLimit struct {
+	// Rate is the sustained number of requests per second; zero disables the limit
+	Rate float64
+	// Burst is the number of requests allowed at once; it defaults to one second of Rate
+	Burst int
+	// Key is what buckets are kept per: api_key, ip or advertiser
+	Key string
+}
+
+// Decode parses a limit written as RATE/PERIOD[,burst=N][,key=NAME], where PERIOD is s, m,
+// h or a duration such as 10s
+func (l *RateLimit) Decode(value string) error {
+	*l = RateLimit{Key: "api_key"}
+	value = strings.TrimSpace(value)
+	if value == "" || value == "off" {
+		*l = RateLimit{}
+		return nil
+	}
+
+	parts := strings.Split(value, ",")
+	count, period, ok := strings.Cut(parts[0], "/")
+	if !ok {
+		return fmt.Errorf("rate limit %q: expected RATE/PERIOD", value)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("rate limit %q: invalid rate %q", value, count)
+	}
+	if period == "s" || period == "m" || period == "h" {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("rate limit %q: invalid period %q", value, period)
+	}
+	l.Rate = n / d.Seconds()
+	l.Burst = max(1, int(math.Ceil(l.Rate)))
+
+	for _, option := range parts[1:] {
+		name, optionValue, _ := strings.Cut(option, "=")
+		switch name {
+		case "burst":
+			burst, err := strconv.Atoi(optionValue)
+			if err != nil || burst < 1 {
+				return fmt.Errorf("rate limit %q: invalid burst %q", value, optionValue)
+			}
+			l.Burst = burst
+		case "key":
+			l.Key = optionValue
+		default:
+			return fmt.Errorf("rate limit %q: unknown option %q", value, name)
+		}
+	}
+	return nil
 }
 
 // Load loads the configuration from environment variables
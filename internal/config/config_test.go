@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit_Decode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected RateLimit
+	}{
+		{value: "100/s,burst=200,key=ip", expected: RateLimit{Rate: 100, Burst: 200, Key: "ip"}},
+		{value: "50/s", expected: RateLimit{Rate: 50, Burst: 50, Key: "api_key"}},
+		{value: "600/m,key=advertiser", expected: RateLimit{Rate: 10, Burst: 10, Key: "advertiser"}},
+		{value: "1/10s", expected: RateLimit{Rate: 0.1, Burst: 1, Key: "api_key"}},
+		{value: "off", expected: RateLimit{}},
+		{value: "", expected: RateLimit{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var limit RateLimit
+			require.NoError(t, limit.Decode(tt.value))
+			assert.Equal(t, tt.expected, limit)
+		})
+	}
+
+	for _, value := range []string{"100", "0/s", "abc/s", "10/fortnight", "10/s,burst=0", "10/s,window=1m"} {
+		var limit RateLimit
+		assert.Error(t, limit.Decode(value), value)
+	}
+}
+
+func TestLoad_RateLimits(t *testing.T) {
+	t.Setenv("APP_RATE_LIMIT_ADS", "5/s,key=ip")
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, RateLimit{Rate: 5, Burst: 5, Key: "ip"}, cfg.RateLimit.Ads)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 20, Key: "advertiser"}, cfg.RateLimit.LineItems, "defaults apply to unset limits")
+}
+
+func TestLoad_IVT(t *testing.T) {
+	t.Setenv("APP_IVT_MAX_EVENTS_PER_IP", "50")
+	t.Setenv("APP_IVT_MAX_CTR", "0.25")
+	t.Setenv("APP_IVT_BOT_USER_AGENTS", "curl,wget")
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	assert.True(t, cfg.IVT.Enabled)
+	assert.Equal(t, 50, cfg.IVT.MaxEventsPerIP)
+	assert.Equal(t, 0.25, cfg.IVT.MaxCTR)
+	assert.Equal(t, []string{"curl", "wget"}, cfg.IVT.BotUserAgents)
+	assert.Equal(t, 120, cfg.IVT.MaxEventsPerUser, "defaults apply to unset rules")
+	assert.Equal(t, time.Hour, cfg.IVT.CTRWindow)
+}
+
+func TestLoad_Anomaly(t *testing.T) {
+	t.Setenv("APP_ANOMALY_MIN_SAMPLES", "48")
+	t.Setenv("APP_ANOMALY_AUTO_PAUSE", "true")
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	assert.True(t, cfg.Anomaly.Enabled)
+	assert.Equal(t, 48, cfg.Anomaly.MinSamples)
+	assert.True(t, cfg.Anomaly.AutoPause)
+	assert.Equal(t, 168*time.Hour, cfg.Anomaly.Baseline)
+	assert.Equal(t, 3.0, cfg.Anomaly.Threshold)
+}
@@ -2,21 +2,59 @@ package db
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"sweng-task/internal/config"
 )
 
-func ConnectPostgres(cfg config.DatabaseConfig) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
+// PostgresDSN returns the connection string for cfg
+func PostgresDSN(cfg config.DatabaseConfig) string {
+	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database,
 	)
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+func ConnectPostgres(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(PostgresDSN(cfg)), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Use(tracingPlugin{system: "postgresql"}); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// ConnectSQLite opens the database file at cfg.SQLitePath, creating it if needed
+func ConnectSQLite(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	if dir := filepath.Dir(cfg.SQLitePath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
 
+	dsn := cfg.SQLitePath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer; one connection queues writes in the pool instead of
+	// failing them with "database is locked"
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.Use(tracingPlugin{system: "sqlite"}); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
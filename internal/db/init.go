@@ -22,12 +22,32 @@ func InitDatabase(cfg config.DatabaseConfig, log *zap.SugaredLogger) *gorm.DB {
 	}
 	log.Info("Connected to PostgreSQL")
 
-	if err := RunMigrations(db, log); err != nil {
-
-		log.Fatalf("Migration error: %v", err)
+	prepareSchema(db, cfg, log)
+	return db
+}
 
+func InitSQLite(cfg config.DatabaseConfig, log *zap.SugaredLogger) *gorm.DB {
+	db, err := ConnectSQLite(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open SQLite database: %v", err)
 	}
-	log.Info("Migrations applied successfully")
+	log.Infow("Opened SQLite database", "path", cfg.SQLitePath)
 
+	prepareSchema(db, cfg, log)
 	return db
 }
+
+// prepareSchema applies pending migrations, or only checks that there are none when
+// migrations are run separately with the migrate command
+func prepareSchema(db *gorm.DB, cfg config.DatabaseConfig, log *zap.SugaredLogger) {
+	if !cfg.MigrateOnStart {
+		if err := CheckMigrations(db, cfg.Driver, log); err != nil {
+			log.Fatalf("Database schema is not up to date: %v", err)
+		}
+		return
+	}
+
+	if err := RunMigrations(db, cfg.Driver, log); err != nil {
+		log.Fatalf("Migration error: %v", err)
+	}
+}
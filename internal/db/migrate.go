@@ -1,17 +1,67 @@
 package db
 
 import (
+	"embed"
+	"fmt"
+	"io/fs"
+
 	"go.uber.org/zap"
 	"gorm.io/gorm"
-	"sweng-task/internal/model"
+	"sweng-task/internal/migrate"
 )
 
-func RunMigrations(db *gorm.DB, log *zap.SugaredLogger) error {
-	log.Info("Running GORM migrations")
+//go:embed migrations
+var migrations embed.FS
+
+// migrationLockKey is the Postgres advisory lock held while migrating
+const migrationLockKey int64 = 0x6164736d6967 // "adsmig"
+
+// NewMigrator returns the migrator for the schema of driver, postgres or sqlite
+func NewMigrator(db *gorm.DB, driver string, log *zap.SugaredLogger) (*migrate.Migrator, error) {
+	var opts []migrate.Option
+	switch driver {
+	case "postgres":
+		opts = append(opts, migrate.WithAdvisoryLock(migrationLockKey))
+	case "sqlite":
+	default:
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+
+	fsys, err := fs.Sub(migrations, "migrations/"+driver)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, fsys, log, opts...)
+}
+
+// RunMigrations applies the pending migrations of driver
+func RunMigrations(db *gorm.DB, driver string, log *zap.SugaredLogger) error {
+	migrator, err := NewMigrator(db, driver, log)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	log.Infow("Database schema is up to date", "applied", applied)
+	return nil
+}
 
-	return db.AutoMigrate(
-		&model.LineItemEntity{},
-		&model.TrackingEventEntity{},
-	)
+// CheckMigrations fails if driver has migrations that have not been applied
+func CheckMigrations(db *gorm.DB, driver string, log *zap.SugaredLogger) error {
+	migrator, err := NewMigrator(db, driver, log)
+	if err != nil {
+		return err
+	}
 
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d pending migrations; run the migrate up command", pending)
+	}
+	return nil
 }
@@ -0,0 +1,315 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/changefeed"
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/repotest"
+	"sweng-task/internal/repository/sqlite"
+	"sweng-task/internal/testutil"
+)
+
+func TestSQLiteMigrations_UpAndDown(t *testing.T) {
+	database, err := ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+
+	assert.Error(t, CheckMigrations(database, "sqlite", log))
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+	assert.NoError(t, CheckMigrations(database, "sqlite", log))
+	for _, table := range []string{"line_items", "tracking_events", "event_counters", "event_counter_buckets", "api_keys"} {
+		assert.True(t, database.Migrator().HasTable(table), table)
+	}
+
+	migrator, err := NewMigrator(database, "sqlite", log)
+	require.NoError(t, err)
+	_, err = migrator.Down(100)
+	require.NoError(t, err)
+	assert.False(t, database.Migrator().HasTable("line_items"))
+
+	require.NoError(t, RunMigrations(database, "sqlite", log), "migrations can be applied again after reverting")
+}
+
+func TestSQLiteMigrations_BackfillBucketSpend(t *testing.T) {
+	database, err := ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, sqlite.NewLineItemSQLiteRepository(database, log).Create(t.Context(), item))
+	tracking := sqlite.NewTrackingSQLiteRepository(database, log)
+	// Stored in a zone other than UTC, with two events in the same UTC hour
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	hour := time.Date(2026, 1, 10, 13, 0, 0, 0, zone)
+	for _, event := range []*model.TrackingEventEntity{
+		{EventID: "evt_1", EventType: model.TrackingEventTypeImpression, Timestamp: hour.Add(5 * time.Minute), Placement: "homepage_top", Cost: 0.5},
+		{EventID: "evt_2", EventType: model.TrackingEventTypeClick, Timestamp: hour.Add(55 * time.Minute), Placement: "homepage_top", Cost: 0.25},
+		{EventID: "evt_3", EventType: model.TrackingEventTypeImpression, Timestamp: hour.Add(time.Hour), Placement: "sidebar", Cost: 1},
+		{EventID: "evt_4", EventType: model.TrackingEventTypeClick, Timestamp: hour.Add(time.Hour), Placement: "sidebar", InvalidReason: "duplicate_click"},
+	} {
+		event.LineItemID = item.ID
+		require.NoError(t, tracking.Store(t.Context(), event))
+	}
+	stored, err := tracking.ListEventCounterBuckets(t.Context(), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, stored, 4)
+
+	// Reverting and reapplying the migration, and the one summing totals on read after it, fills
+	// in the spend the buckets were given on insert
+	migrator, err := NewMigrator(database, "sqlite", log)
+	require.NoError(t, err)
+	_, err = migrator.Down(2)
+	require.NoError(t, err)
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+
+	backfilled, err := tracking.ListEventCounterBuckets(t.Context(), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, backfilled, len(stored))
+	spend := make(map[string]float64)
+	for _, b := range stored {
+		spend[b.LineItemID+"/"+b.Placement+"/"+b.BucketStart.UTC().String()] = b.Spend
+	}
+	for _, b := range backfilled {
+		key := b.LineItemID + "/" + b.Placement + "/" + b.BucketStart.UTC().String()
+		assert.InDelta(t, spend[key], b.Spend, 1e-9, key)
+		assert.NotZero(t, b.Spend, key)
+	}
+}
+
+func TestSQLiteMigrations_SumEventCounterTotals(t *testing.T) {
+	database, err := ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+
+	lineItems := sqlite.NewLineItemSQLiteRepository(database, log)
+	tracking := sqlite.NewTrackingSQLiteRepository(database, log)
+	var events []*model.TrackingEventEntity
+	for i, placement := range []string{"homepage_top", "homepage_top", "sidebar"} {
+		item := testutil.CreateTestLineItemEntity()
+		require.NoError(t, lineItems.Create(t.Context(), item))
+		event := testutil.CreateTestTrackingEventEntity(item.ID)
+		event.EventID = fmt.Sprintf("evt_%d", i)
+		event.Placement = placement
+		events = append(events, event)
+	}
+	_, err = tracking.StoreBatch(t.Context(), events)
+	require.NoError(t, err)
+	want, err := tracking.CountEventsBatch(t.Context(), []string{""}, "homepage_top")
+	require.NoError(t, err)
+
+	// Reverting the migration stores the totals in rows of their own again
+	migrator, err := NewMigrator(database, "sqlite", log)
+	require.NoError(t, err)
+	_, err = migrator.Down(1)
+	require.NoError(t, err)
+	var totals model.EventCounterEntity
+	require.NoError(t, database.Where("line_item_id = '' AND placement = ''").Take(&totals).Error)
+	assert.Equal(t, want[""].Total, model.ToEventCounts(totals))
+	var stored int64
+	require.NoError(t, database.Raw("SELECT COUNT(*) FROM event_counter_buckets WHERE line_item_id = ''").Scan(&stored).Error)
+	assert.Equal(t, int64(3), stored, "one bucket per placement and the all-placements one")
+
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+	counters, err := tracking.ListEventCounters(t.Context())
+	require.NoError(t, err)
+	for _, counter := range counters {
+		assert.NotEmpty(t, counter.LineItemID)
+	}
+	got, err := tracking.CountEventsBatch(t.Context(), []string{""}, "homepage_top")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+// TestPostgresMigrations_Up runs against the database configured through the APP_DATABASE_*
+// variables, or a locally started Postgres, and is skipped otherwise
+func TestPostgresMigrations_Up(t *testing.T) {
+	database, err := ConnectPostgres(repotest.PostgresConfig(t))
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+
+	require.NoError(t, RunMigrations(database, "postgres", log))
+	require.NoError(t, RunMigrations(database, "postgres", log))
+	assert.NoError(t, CheckMigrations(database, "postgres", log))
+
+	var triggers int64
+	require.NoError(t, database.Raw("SELECT COUNT(*) FROM pg_trigger WHERE tgname = 'line_item_changes'").Scan(&triggers).Error)
+	assert.Equal(t, int64(1), triggers)
+}
+
+// postgresAutoMigrateLineItem and postgresAutoMigrateTrackingEvent are the first tables
+// GORM AutoMigrate created, before tracking events had IDs and costs
+type postgresAutoMigrateLineItem struct {
+	ID            string         `gorm:"primaryKey"`
+	Name          string         `gorm:"not null"`
+	AdvertiserID  string         `gorm:"not null;index:idx_advertiser_id"`
+	Bid           float64        `gorm:"not null;check:bid >= 0"`
+	Budget        float64        `gorm:"not null;check:budget >= 0"`
+	DailySpending float64        `gorm:"not null;default:0;check:daily_spending >= 0"`
+	Placement     string         `gorm:"not null;index:idx_placement"`
+	Categories    pq.StringArray `gorm:"type:text[]"`
+	Keywords      pq.StringArray `gorm:"type:text[]"`
+	Status        string         `gorm:"type:text;not null;index:idx_status"`
+	CreatedAt     time.Time      `gorm:"index:idx_created_at"`
+	UpdatedAt     time.Time
+}
+
+func (postgresAutoMigrateLineItem) TableName() string {
+	return "line_items"
+}
+
+type postgresAutoMigrateTrackingEvent struct {
+	ID         uint64                      `gorm:"primaryKey"`
+	EventType  string                      `gorm:"type:text;index:idx_event_type"`
+	LineItemID string                      `gorm:"not null;index:idx_line_item_id"`
+	LineItem   postgresAutoMigrateLineItem `gorm:"foreignKey:LineItemID;references:ID;constraint:OnDelete:CASCADE"`
+	Timestamp  time.Time                   `gorm:"index:idx_timestamp"`
+	Placement  string                      `gorm:"index:idx_placement"`
+	UserID     string
+	Metadata   string `gorm:"type:jsonb"`
+}
+
+func (postgresAutoMigrateTrackingEvent) TableName() string {
+	return "tracking_events"
+}
+
+// TestPostgresMigrations_AdoptAutoMigrateSchema migrates a database created by the first
+// AutoMigrate schema, in a database of its own created next to the configured one
+func TestPostgresMigrations_AdoptAutoMigrateSchema(t *testing.T) {
+	cfg := repotest.PostgresConfig(t)
+	admin, err := ConnectPostgres(cfg)
+	require.NoError(t, err)
+	name := fmt.Sprintf("adopt_automigrate_%d", time.Now().UnixNano())
+	require.NoError(t, admin.Exec("CREATE DATABASE "+name).Error)
+
+	cfg.Database = name
+	database, err := ConnectPostgres(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		assert.NoError(t, admin.Exec("DROP DATABASE IF EXISTS "+name).Error)
+	})
+	log := testutil.GetTestLogger()
+
+	require.NoError(t, database.AutoMigrate(&postgresAutoMigrateLineItem{}, &postgresAutoMigrateTrackingEvent{}))
+	require.NoError(t, database.Exec(`INSERT INTO line_items (id, name, advertiser_id, bid, budget, placement, status, created_at, updated_at)
+		VALUES ('li_old', 'Old', 'adv_1', 2.5, 100, 'homepage_top', 'active', now(), now())`).Error)
+	require.NoError(t, database.Exec(`INSERT INTO tracking_events (event_type, line_item_id, timestamp, placement, user_id, metadata)
+		VALUES ('impression', 'li_old', now(), 'homepage_top', 'user_1', '{}')`).Error)
+
+	require.NoError(t, RunMigrations(database, "postgres", log))
+	assert.NoError(t, CheckMigrations(database, "postgres", log))
+	for _, column := range []string{"event_id", "cost", "invalid_reason"} {
+		assert.True(t, database.Migrator().HasColumn("tracking_events", column), column)
+	}
+
+	var events []model.TrackingEventEntity
+	require.NoError(t, database.Find(&events).Error)
+	require.Len(t, events, 1, "stored events are kept")
+	assert.Zero(t, events[0].Cost)
+	var impressions int64
+	require.NoError(t, database.Raw("SELECT impressions FROM event_counters WHERE line_item_id = 'li_old' AND placement = 'homepage_top'").Scan(&impressions).Error)
+	assert.Equal(t, int64(1), impressions, "stored events are counted")
+}
+
+// sqliteAutoMigrateLineItem, sqliteAutoMigrateTrackingEvent and
+// sqliteAutoMigrateCounterBucket are the tables GORM AutoMigrate created for SQLite before
+// versioned migrations
+type sqliteAutoMigrateLineItem struct {
+	ID            string    `gorm:"primaryKey"`
+	Name          string    `gorm:"not null"`
+	AdvertiserID  string    `gorm:"not null;index:idx_line_items_advertiser_id"`
+	Bid           float64   `gorm:"not null;check:bid >= 0"`
+	Budget        float64   `gorm:"not null;check:budget >= 0"`
+	DailySpending float64   `gorm:"not null;default:0;check:daily_spending >= 0"`
+	Placement     string    `gorm:"not null;index:idx_line_items_placement"`
+	Categories    []string  `gorm:"serializer:json"`
+	Keywords      []string  `gorm:"serializer:json"`
+	Status        string    `gorm:"type:text;not null;index:idx_line_items_status"`
+	CreatedAt     time.Time `gorm:"index:idx_line_items_created_at"`
+	UpdatedAt     time.Time
+}
+
+func (sqliteAutoMigrateLineItem) TableName() string {
+	return "line_items"
+}
+
+type sqliteAutoMigrateTrackingEvent struct {
+	ID         uint64    `gorm:"primaryKey"`
+	EventID    string    `gorm:"type:text;uniqueIndex:idx_tracking_events_event_id"`
+	EventType  string    `gorm:"type:text;index:idx_tracking_events_event_type"`
+	LineItemID string    `gorm:"not null;index:idx_tracking_events_line_item_id"`
+	Timestamp  time.Time `gorm:"index:idx_tracking_events_timestamp"`
+	Placement  string    `gorm:"index:idx_tracking_events_placement"`
+	UserID     string
+	Metadata   map[string]string `gorm:"serializer:json"`
+	Cost       float64           `gorm:"not null;default:0"`
+}
+
+func (sqliteAutoMigrateTrackingEvent) TableName() string {
+	return "tracking_events"
+}
+
+type sqliteAutoMigrateCounterBucket struct {
+	LineItemID  string    `gorm:"primaryKey;type:text"`
+	Placement   string    `gorm:"primaryKey;type:text"`
+	BucketStart time.Time `gorm:"primaryKey;index:idx_event_counter_buckets_bucket_start"`
+	Impressions int       `gorm:"not null;default:0"`
+	Clicks      int       `gorm:"not null;default:0"`
+	Conversions int       `gorm:"not null;default:0"`
+	UpdatedAt   time.Time `gorm:"not null;index:idx_event_counter_buckets_updated_at"`
+}
+
+func (sqliteAutoMigrateCounterBucket) TableName() string {
+	return "event_counter_buckets"
+}
+
+func TestSQLiteMigrations_AdoptAutoMigrateSchema(t *testing.T) {
+	database, err := ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+
+	require.NoError(t, database.AutoMigrate(&sqliteAutoMigrateLineItem{}, &sqliteAutoMigrateTrackingEvent{},
+		&model.EventCounterEntity{}, &sqliteAutoMigrateCounterBucket{}))
+	now := time.Now().UTC()
+	require.NoError(t, database.Create(&sqliteAutoMigrateLineItem{
+		ID: "li_old", Name: "Old", AdvertiserID: "adv_1", Bid: 2.5, Budget: 100, Placement: "homepage_top", Status: "active",
+	}).Error)
+	require.NoError(t, database.Create(&sqliteAutoMigrateTrackingEvent{
+		EventID: "evt_old", EventType: "impression", LineItemID: "li_old", Timestamp: now, Placement: "homepage_top", Cost: 0.0025,
+	}).Error)
+
+	require.NoError(t, RunMigrations(database, "sqlite", log))
+	assert.NoError(t, CheckMigrations(database, "sqlite", log))
+
+	event, err := sqlite.NewTrackingSQLiteRepository(database, log).FindByEventID(t.Context(), "evt_old")
+	require.NoError(t, err, "stored events are kept")
+	assert.Equal(t, 0.0025, event.Cost)
+	item, err := sqlite.NewLineItemSQLiteRepository(database, log).GetByID(t.Context(), "li_old")
+	require.NoError(t, err)
+	assert.Equal(t, "Old", item.Name)
+}
+
+func TestPostgresMigrations_NotifyChangeFeedChannel(t *testing.T) {
+	sql, err := fs.ReadFile(migrations, "migrations/postgres/0002_line_item_change_trigger.up.sql")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(sql), "pg_notify('"+changefeed.LineItemChannel+"'"))
+}
+
+func TestNewMigrator_UnknownDriver(t *testing.T) {
+	_, err := NewMigrator(nil, "memory", testutil.GetTestLogger())
+	assert.Error(t, err)
+}
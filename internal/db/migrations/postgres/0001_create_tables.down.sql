@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS event_counter_buckets;
+DROP TABLE IF EXISTS event_counters;
+DROP TABLE IF EXISTS tracking_events;
+DROP TABLE IF EXISTS line_items;
@@ -0,0 +1,67 @@
+-- Databases created before versioned migrations already have these tables from GORM
+-- AutoMigrate, so every object is created only if it is missing, and columns added to the
+-- tables since the first AutoMigrate schema are added to them.
+
+CREATE TABLE IF NOT EXISTS line_items (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    advertiser_id TEXT NOT NULL,
+    bid DOUBLE PRECISION NOT NULL CONSTRAINT chk_line_items_bid CHECK (bid >= 0),
+    budget DOUBLE PRECISION NOT NULL CONSTRAINT chk_line_items_budget CHECK (budget >= 0),
+    daily_spending DOUBLE PRECISION NOT NULL DEFAULT 0 CONSTRAINT chk_line_items_daily_spending CHECK (daily_spending >= 0),
+    placement TEXT NOT NULL,
+    categories TEXT[],
+    keywords TEXT[],
+    status TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_advertiser_id ON line_items (advertiser_id);
+CREATE INDEX IF NOT EXISTS idx_placement ON line_items (placement);
+CREATE INDEX IF NOT EXISTS idx_status ON line_items (status);
+CREATE INDEX IF NOT EXISTS idx_created_at ON line_items (created_at);
+
+CREATE TABLE IF NOT EXISTS tracking_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_id TEXT,
+    event_type TEXT,
+    line_item_id TEXT NOT NULL CONSTRAINT fk_tracking_events_line_item REFERENCES line_items (id) ON DELETE CASCADE,
+    timestamp TIMESTAMPTZ,
+    placement TEXT,
+    user_id TEXT,
+    metadata JSONB,
+    cost DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+ALTER TABLE tracking_events ADD COLUMN IF NOT EXISTS event_id TEXT;
+ALTER TABLE tracking_events ADD COLUMN IF NOT EXISTS cost DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_events_event_id ON tracking_events (event_id);
+CREATE INDEX IF NOT EXISTS idx_event_type ON tracking_events (event_type);
+CREATE INDEX IF NOT EXISTS idx_line_item_id ON tracking_events (line_item_id);
+CREATE INDEX IF NOT EXISTS idx_timestamp ON tracking_events (timestamp);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_placement ON tracking_events (placement);
+
+CREATE TABLE IF NOT EXISTS event_counters (
+    line_item_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    conversions BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (line_item_id, placement)
+);
+
+CREATE TABLE IF NOT EXISTS event_counter_buckets (
+    line_item_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    bucket_start TIMESTAMPTZ NOT NULL,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    conversions BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (line_item_id, placement, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_counter_buckets_bucket_start ON event_counter_buckets (bucket_start);
+CREATE INDEX IF NOT EXISTS idx_event_counter_buckets_updated_at ON event_counter_buckets (updated_at);
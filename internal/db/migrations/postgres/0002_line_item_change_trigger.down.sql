@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS line_item_changes ON line_items;
+DROP FUNCTION IF EXISTS notify_line_item_change();
@@ -0,0 +1,36 @@
+-- Notifies the line_item_changes channel (changefeed.LineItemChannel) of every change to
+-- line_items. Updates that only touch the daily spending are sent as spending changes.
+
+CREATE OR REPLACE FUNCTION notify_line_item_change() RETURNS trigger AS $$
+DECLARE
+    change_type text;
+    changed line_items;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        change_type := 'deleted';
+        changed := OLD;
+    ELSIF TG_OP = 'INSERT' THEN
+        change_type := 'created';
+        changed := NEW;
+    ELSIF to_jsonb(NEW) - 'daily_spending' - 'updated_at' = to_jsonb(OLD) - 'daily_spending' - 'updated_at' THEN
+        change_type := 'spending';
+        changed := NEW;
+    ELSE
+        change_type := 'updated';
+        changed := NEW;
+    END IF;
+
+    PERFORM pg_notify('line_item_changes', json_build_object(
+        'type', change_type,
+        'id', changed.id,
+        'daily_spending', changed.daily_spending
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS line_item_changes ON line_items;
+
+CREATE TRIGGER line_item_changes
+AFTER INSERT OR UPDATE OR DELETE ON line_items
+FOR EACH ROW EXECUTE FUNCTION notify_line_item_change();
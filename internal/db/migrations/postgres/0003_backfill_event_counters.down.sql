@@ -0,0 +1 @@
+-- The counters are kept: they have been maintained on every insert since the backfill
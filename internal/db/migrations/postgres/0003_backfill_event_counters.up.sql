@@ -0,0 +1,36 @@
+-- Rolls up events stored before event counters and hourly buckets existed. Tables that
+-- already hold counters are left alone, as they have been maintained on every insert.
+
+INSERT INTO event_counters (line_item_id, placement, impressions, clicks, conversions)
+SELECT
+    CASE WHEN GROUPING(line_item_id) = 1 THEN '' ELSE line_item_id END,
+    CASE WHEN GROUPING(placement) = 1 THEN '' ELSE COALESCE(placement, '') END,
+    COUNT(*) FILTER (WHERE event_type = 'impression'),
+    COUNT(*) FILTER (WHERE event_type = 'click'),
+    COUNT(*) FILTER (WHERE event_type = 'conversion')
+FROM tracking_events
+WHERE NOT EXISTS (SELECT 1 FROM event_counters)
+GROUP BY GROUPING SETS ((), (placement), (line_item_id), (line_item_id, placement))
+-- Events without a placement are already counted in the all-placements rows
+HAVING COUNT(*) > 0 AND (GROUPING(placement) = 1 OR COALESCE(placement, '') <> '')
+ON CONFLICT (line_item_id, placement) DO NOTHING;
+
+INSERT INTO event_counter_buckets (line_item_id, placement, bucket_start, impressions, clicks, conversions, updated_at)
+SELECT
+    CASE WHEN GROUPING(line_item_id) = 1 THEN '' ELSE line_item_id END,
+    CASE WHEN GROUPING(placement) = 1 THEN '' ELSE COALESCE(placement, '') END,
+    bucket_start,
+    COUNT(*) FILTER (WHERE event_type = 'impression'),
+    COUNT(*) FILTER (WHERE event_type = 'click'),
+    COUNT(*) FILTER (WHERE event_type = 'conversion'),
+    now()
+FROM (
+    SELECT *, date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket_start
+    FROM tracking_events
+    WHERE NOT EXISTS (SELECT 1 FROM event_counter_buckets)
+) events
+GROUP BY GROUPING SETS (
+    (bucket_start), (bucket_start, placement), (bucket_start, line_item_id), (bucket_start, line_item_id, placement)
+)
+HAVING GROUPING(placement) = 1 OR COALESCE(placement, '') <> ''
+ON CONFLICT (line_item_id, placement, bucket_start) DO NOTHING;
@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    role TEXT NOT NULL CONSTRAINT chk_api_keys_role CHECK (role IN ('admin', 'advertiser', 'publisher')),
+    advertiser_id TEXT,
+    prefix TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
@@ -0,0 +1,7 @@
+DROP INDEX idx_tracking_events_invalid;
+DROP INDEX idx_tracking_events_impressions;
+
+ALTER TABLE tracking_events
+    DROP COLUMN invalid_reason,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip;
@@ -0,0 +1,15 @@
+-- Client details of tracking events and the reason events were marked as invalid traffic.
+-- Invalid events are not counted in event_counters or event_counter_buckets.
+
+ALTER TABLE tracking_events
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN invalid_reason TEXT NOT NULL DEFAULT '';
+
+-- Finds the impression a click follows
+CREATE INDEX idx_tracking_events_impressions ON tracking_events (line_item_id, user_id, timestamp)
+    WHERE event_type = 'impression' AND invalid_reason = '';
+
+-- Invalid traffic reports
+CREATE INDEX idx_tracking_events_invalid ON tracking_events (line_item_id, invalid_reason)
+    WHERE invalid_reason <> '';
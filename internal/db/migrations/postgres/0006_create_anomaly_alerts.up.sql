@@ -0,0 +1,21 @@
+-- Deviations of a line item's hourly CTR, CVR or spend from its rolling baseline, flagged by
+-- the anomaly detection job. An alert is raised at most once per line item, placement,
+-- metric and hour.
+
+CREATE TABLE anomaly_alerts (
+    id BIGSERIAL PRIMARY KEY,
+    line_item_id TEXT NOT NULL CONSTRAINT fk_anomaly_alerts_line_item REFERENCES line_items (id) ON DELETE CASCADE,
+    advertiser_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    metric TEXT NOT NULL CONSTRAINT chk_anomaly_alerts_metric CHECK (metric IN ('ctr', 'cvr', 'spend')),
+    window_start TIMESTAMPTZ NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    baseline DOUBLE PRECISION NOT NULL,
+    std_dev DOUBLE PRECISION NOT NULL,
+    z_score DOUBLE PRECISION NOT NULL,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_anomaly_alerts_window ON anomaly_alerts (line_item_id, placement, metric, window_start);
+CREATE INDEX idx_anomaly_alerts_advertiser ON anomaly_alerts (advertiser_id, window_start);
@@ -0,0 +1 @@
+ALTER TABLE event_counter_buckets DROP COLUMN spend;
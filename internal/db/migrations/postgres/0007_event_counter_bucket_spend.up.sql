@@ -0,0 +1,26 @@
+-- The cost charged for the events counted in each hourly bucket, as the baseline of spend
+-- anomaly detection. Existing buckets are filled in from the events they count.
+
+ALTER TABLE event_counter_buckets ADD COLUMN spend DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE event_counter_buckets b
+SET spend = rolled.spend
+FROM (
+    SELECT
+        CASE WHEN GROUPING(line_item_id) = 1 THEN '' ELSE line_item_id END AS line_item_id,
+        CASE WHEN GROUPING(placement) = 1 THEN '' ELSE COALESCE(placement, '') END AS placement,
+        bucket_start,
+        SUM(cost) AS spend
+    FROM (
+        SELECT *, date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket_start
+        FROM tracking_events
+        WHERE invalid_reason = ''
+    ) events
+    GROUP BY GROUPING SETS (
+        (bucket_start), (bucket_start, placement), (bucket_start, line_item_id), (bucket_start, line_item_id, placement)
+    )
+    HAVING GROUPING(placement) = 1 OR COALESCE(placement, '') <> ''
+) rolled
+WHERE b.line_item_id = rolled.line_item_id
+  AND b.placement = rolled.placement
+  AND b.bucket_start = rolled.bucket_start;
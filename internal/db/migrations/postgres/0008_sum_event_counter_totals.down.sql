@@ -0,0 +1,11 @@
+INSERT INTO event_counters (line_item_id, placement, impressions, clicks, conversions)
+SELECT '', placement, SUM(impressions), SUM(clicks), SUM(conversions)
+FROM event_counters
+WHERE line_item_id <> ''
+GROUP BY placement;
+
+INSERT INTO event_counter_buckets (line_item_id, placement, bucket_start, impressions, clicks, conversions, spend, updated_at)
+SELECT '', placement, bucket_start, SUM(impressions), SUM(clicks), SUM(conversions), SUM(spend), MAX(updated_at)
+FROM event_counter_buckets
+WHERE line_item_id <> ''
+GROUP BY placement, bucket_start;
@@ -0,0 +1,4 @@
+DROP TABLE event_counter_buckets;
+DROP TABLE event_counters;
+DROP TABLE tracking_events;
+DROP TABLE line_items;
@@ -0,0 +1,65 @@
+-- SQLite has no array or jsonb types: categories, keywords and metadata hold JSON text.
+-- Times are stored as UTC text. Databases created before versioned migrations already have
+-- these tables, with the same columns, from GORM AutoMigrate, so every object is created
+-- only if it is missing.
+
+CREATE TABLE IF NOT EXISTS line_items (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    advertiser_id TEXT NOT NULL,
+    bid REAL NOT NULL CHECK (bid >= 0),
+    budget REAL NOT NULL CHECK (budget >= 0),
+    daily_spending REAL NOT NULL DEFAULT 0 CHECK (daily_spending >= 0),
+    placement TEXT NOT NULL,
+    categories TEXT,
+    keywords TEXT,
+    status TEXT NOT NULL,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_line_items_advertiser_id ON line_items (advertiser_id);
+CREATE INDEX IF NOT EXISTS idx_line_items_placement ON line_items (placement);
+CREATE INDEX IF NOT EXISTS idx_line_items_status ON line_items (status);
+CREATE INDEX IF NOT EXISTS idx_line_items_created_at ON line_items (created_at);
+
+CREATE TABLE IF NOT EXISTS tracking_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id TEXT,
+    event_type TEXT,
+    line_item_id TEXT NOT NULL,
+    timestamp DATETIME,
+    placement TEXT,
+    user_id TEXT,
+    metadata TEXT,
+    cost REAL NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_events_event_id ON tracking_events (event_id);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_event_type ON tracking_events (event_type);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_line_item_id ON tracking_events (line_item_id);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_timestamp ON tracking_events (timestamp);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_placement ON tracking_events (placement);
+
+CREATE TABLE IF NOT EXISTS event_counters (
+    line_item_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    conversions INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (line_item_id, placement)
+);
+
+CREATE TABLE IF NOT EXISTS event_counter_buckets (
+    line_item_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    bucket_start DATETIME NOT NULL,
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    conversions INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (line_item_id, placement, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_counter_buckets_bucket_start ON event_counter_buckets (bucket_start);
+CREATE INDEX IF NOT EXISTS idx_event_counter_buckets_updated_at ON event_counter_buckets (updated_at);
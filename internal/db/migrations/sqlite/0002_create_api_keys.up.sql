@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'advertiser', 'publisher')),
+    advertiser_id TEXT,
+    prefix TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    revoked_at DATETIME
+);
+
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
@@ -0,0 +1,6 @@
+DROP INDEX idx_tracking_events_invalid;
+DROP INDEX idx_tracking_events_impressions;
+
+ALTER TABLE tracking_events DROP COLUMN invalid_reason;
+ALTER TABLE tracking_events DROP COLUMN user_agent;
+ALTER TABLE tracking_events DROP COLUMN ip;
@@ -0,0 +1,21 @@
+-- Deviations of a line item's hourly CTR, CVR or spend from its rolling baseline, flagged by
+-- the anomaly detection job. An alert is raised at most once per line item, placement,
+-- metric and hour.
+
+CREATE TABLE anomaly_alerts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    line_item_id TEXT NOT NULL,
+    advertiser_id TEXT NOT NULL,
+    placement TEXT NOT NULL,
+    metric TEXT NOT NULL CHECK (metric IN ('ctr', 'cvr', 'spend')),
+    window_start DATETIME NOT NULL,
+    value REAL NOT NULL,
+    baseline REAL NOT NULL,
+    std_dev REAL NOT NULL,
+    z_score REAL NOT NULL,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_anomaly_alerts_window ON anomaly_alerts (line_item_id, placement, metric, window_start);
+CREATE INDEX idx_anomaly_alerts_advertiser ON anomaly_alerts (advertiser_id, window_start);
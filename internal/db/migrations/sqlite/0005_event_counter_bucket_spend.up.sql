@@ -0,0 +1,14 @@
+-- The cost charged for the events counted in each hourly bucket, as the baseline of spend
+-- anomaly detection. Existing buckets are filled in from the events they count.
+
+ALTER TABLE event_counter_buckets ADD COLUMN spend REAL NOT NULL DEFAULT 0;
+
+UPDATE event_counter_buckets
+SET spend = (
+    SELECT COALESCE(SUM(e.cost), 0)
+    FROM tracking_events e
+    WHERE e.invalid_reason = ''
+      AND strftime('%Y-%m-%d %H', e.timestamp) = strftime('%Y-%m-%d %H', event_counter_buckets.bucket_start)
+      AND (event_counter_buckets.line_item_id = '' OR e.line_item_id = event_counter_buckets.line_item_id)
+      AND (event_counter_buckets.placement = '' OR e.placement = event_counter_buckets.placement)
+);
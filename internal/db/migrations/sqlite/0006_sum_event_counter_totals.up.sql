@@ -0,0 +1,5 @@
+-- Counts across all line items are summed from the rows of each line item when read, rather
+-- than kept in rows with an empty line item ID that every stored event would lock.
+
+DELETE FROM event_counters WHERE line_item_id = '';
+DELETE FROM event_counter_buckets WHERE line_item_id = '';
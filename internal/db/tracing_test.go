@@ -0,0 +1,51 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"sweng-task/internal/config"
+	"sweng-task/internal/testutil"
+)
+
+func TestTracingPlugin_QueriesInTracedRequests(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	database, err := ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, RunMigrations(database, "sqlite", testutil.GetTestLogger()))
+
+	var count int64
+	require.NoError(t, database.WithContext(t.Context()).Table("line_items").Count(&count).Error)
+	assert.Empty(t, recorder.Ended(), "queries outside a trace are not recorded")
+
+	ctx, parent := otel.Tracer("test").Start(t.Context(), "request")
+	require.NoError(t, database.WithContext(ctx).Table("line_items").Count(&count).Error)
+	parent.End()
+
+	var query sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "gorm.query" {
+			query = span
+		}
+	}
+	require.NotNil(t, query)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent().SpanID())
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range query.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.Equal(t, "sqlite", attrs["db.system.name"].AsString())
+	assert.Equal(t, "line_items", attrs["db.collection.name"].AsString())
+	assert.Contains(t, attrs["db.query.text"].AsString(), "SELECT count(*)")
+}
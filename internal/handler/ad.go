@@ -3,11 +3,15 @@ package handler
 import (
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
+	"sweng-task/internal/logging"
 	"sweng-task/internal/service"
 	"sweng-task/internal/utils"
 	"sweng-task/internal/validator"
 )
 
+// DegradedHeader marks ad responses whose bids were estimated on fallback event counts
+const DegradedHeader = "X-Ads-Degraded"
+
 type AdSelectionHandler struct {
 	adService *service.AdService
 	log       *zap.SugaredLogger
@@ -22,15 +26,17 @@ func NewAdSelectionHandler(adService *service.AdService, logger *zap.SugaredLogg
 
 // GetWinningAds handles GET /ads requests
 func (h *AdSelectionHandler) GetWinningAds(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
 	const defaultLimit = 1
 
 	var q validator.AdQueryParams
 	if err := c.QueryParser(&q); err != nil {
-		h.log.Warnw("Failed to parse query parameters", "error", err)
+		log.Warnw("Failed to parse query parameters", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid query parameters",
-			Details: err.Error(),
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
@@ -40,36 +46,46 @@ func (h *AdSelectionHandler) GetWinningAds(c *fiber.Ctx) error {
 	}
 
 	if fieldErr, err := validator.ValidateStruct(&q); err != nil {
-		h.log.Warnw("Validation failed", "error", err)
+		log.Warnw("Validation failed", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Validation failed",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
 		})
 	} else if fieldErr != nil {
-		h.log.Warnw("Query parameter validation error", "field", fieldErr.Field, "reason", fieldErr.Reason)
+		log.Warnw("Query parameter validation error", "field", fieldErr.Field, "reason", fieldErr.Reason)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request parameters",
-			Details: fieldErr,
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	h.log.Infow("Received ad request",
+	log.Infow("Received ad request",
 		"placement", q.Placement,
 		"category", q.Category,
 		"keyword", q.Keyword,
 		"limit", q.Limit,
 	)
 
-	ads, err := h.adService.GetWinningAds(q.Placement, q.Category, q.Keyword, q.Limit)
+	selection, err := h.adService.GetWinningAds(c.UserContext(), q.Placement, q.Category, q.Keyword, q.Limit)
 	if err != nil {
-		h.log.Errorw("Failed to get winning ads", "error", err)
+		log.Errorw("Failed to get winning ads", "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusInternalServerError,
-			Message: "Failed to retrieve ads",
-			Details: err.Error(),
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve ads",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(ads)
+	// Degraded responses still carry ads, priced without fresh event counts
+	if selection.Degraded {
+		c.Set(DegradedHeader, "true")
+	}
+	return c.Status(fiber.StatusOK).JSON(selection.Ads)
 }
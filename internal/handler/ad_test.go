@@ -1,24 +1,28 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"sweng-task/internal/repository/mocks"
+	"sweng-task/internal/repository/memory"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 	"sweng-task/internal/testutil"
+	"sweng-task/internal/utils"
 )
 
 func setupAdHandlerTest(t *testing.T) (*fiber.App, *service.AdService) {
 	app := testutil.SetupTestApp(t)
 
-	mockLineItemRepo := mocks.NewInMemoryLineItemRepository()
-	mockTrackingRepo := mocks.NewInMemoryTrackingRepository()
+	mockLineItemRepo := memory.NewLineItemRepository()
+	mockTrackingRepo := memory.NewTrackingRepository(mockLineItemRepo)
 	logger := testutil.GetTestLogger()
 
 	lineItemService := service.NewLineItemService(mockLineItemRepo, logger)
@@ -34,7 +38,7 @@ func setupAdHandlerTest(t *testing.T) (*fiber.App, *service.AdService) {
 func TestAdSelectionHandler_GetWinningAds_Success(t *testing.T) {
 	app, _ := setupAdHandlerTest(t)
 	item := testutil.CreateTestLineItemEntity()
-	_ = mocks.NewInMemoryLineItemRepository().Create(item)
+	_ = memory.NewLineItemRepository().Create(t.Context(), item)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/ads?placement="+item.Placement+"&limit=1", nil)
 	resp, err := app.Test(req)
@@ -60,3 +64,71 @@ func TestAdSelectionHandler_GetWinningAds_MissingPlacement(t *testing.T) {
 	assert.Equal(t, float64(400), body["code"])
 	assert.Contains(t, body["message"], "Invalid request")
 }
+
+// slowLineItemRepository blocks line item queries until their context is done, like a
+// database query cancelled by its deadline
+type slowLineItemRepository struct {
+	*memory.LineItemRepository
+}
+
+func (r slowLineItemRepository) GetByID(ctx context.Context, id string) (*model.LineItemEntity, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (r slowLineItemRepository) FindMatchingLineItems(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAdSelectionHandler_GetWinningAds_Timeout(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(slowLineItemRepository{memory.NewLineItemRepository()}, logger)
+	trackingService := service.NewTrackingService(memory.NewTrackingRepository(nil), lineItemService, logger)
+	h := NewAdSelectionHandler(service.NewAdService(lineItemService, trackingService, logger), logger)
+	app.Get("/api/v1/ads", Timeout(20*time.Millisecond), h.GetWinningAds)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/ads?placement=homepage_top", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	var body utils.ErrorResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, fiber.StatusGatewayTimeout, body.Code)
+}
+
+// failingCountsRepository fails every event count lookup
+type failingCountsRepository struct {
+	*memory.TrackingRepository
+}
+
+func (r failingCountsRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAdSelectionHandler_GetWinningAds_Degraded(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	logger := testutil.GetTestLogger()
+
+	lineItemRepo := memory.NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), item))
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(failingCountsRepository{memory.NewTrackingRepository(nil)}, lineItemService, logger)
+	h := NewAdSelectionHandler(service.NewAdService(lineItemService, trackingService, logger), logger)
+	app.Get("/api/v1/ads", h.GetWinningAds)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/ads?placement="+item.Placement, nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(DegradedHeader))
+
+	var ads []model.Ad
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&ads))
+	assert.Len(t, ads, 1)
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/service"
+	"sweng-task/internal/utils"
+	"sweng-task/internal/validator"
+)
+
+// defaultAlertLimit is the number of alerts returned when the request sets no limit
+const defaultAlertLimit = 100
+
+// AnomalyHandler handles HTTP requests related to anomaly alerts
+type AnomalyHandler struct {
+	service *service.AnomalyService
+	log     *zap.SugaredLogger
+}
+
+// NewAnomalyHandler creates a new AnomalyHandler
+func NewAnomalyHandler(service *service.AnomalyService, log *zap.SugaredLogger) *AnomalyHandler {
+	return &AnomalyHandler{
+		service: service,
+		log:     log,
+	}
+}
+
+// GetAll handles GET /alerts requests, listing anomaly alerts latest hour first, optionally
+// filtered by advertiser, line item, metric and the hour they start from
+func (h *AnomalyHandler) GetAll(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
+	var query validator.AnomalyAlertQueryParams
+	if err := c.QueryParser(&query); err != nil {
+		log.Warnw("Failed to parse query", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	if fieldErr, err := validator.ValidateStruct(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
+		})
+	} else if fieldErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid query parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	// Advertiser keys only see the alerts of their own line items
+	if principal, ok := auth.FromContext(c.UserContext()); ok && principal.Role == model.RoleAdvertiser {
+		if query.AdvertiserID != "" && query.AdvertiserID != principal.AdvertiserID {
+			return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusForbidden,
+				Message:   "Forbidden",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		query.AdvertiserID = principal.AdvertiserID
+	}
+
+	filter := repository.AnomalyAlertFilter{
+		AdvertiserID: query.AdvertiserID,
+		LineItemID:   query.LineItemID,
+		Metric:       model.AnomalyMetric(query.Metric),
+		Limit:        query.Limit,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAlertLimit
+	}
+	if query.Since != "" {
+		// Validated as RFC 3339 above
+		filter.Since, _ = time.Parse(time.RFC3339, query.Since)
+	}
+
+	alerts, err := h.service.ListAlerts(c.UserContext(), filter)
+	if err != nil {
+		log.Errorw("Failed to retrieve anomaly alerts", "query", query, "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve anomaly alerts",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(alerts)
+}
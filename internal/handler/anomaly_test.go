@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/service"
+	"sweng-task/internal/testutil"
+)
+
+func TestAnomalyHandler_GetAll(t *testing.T) {
+	alerts := memory.NewAnomalyAlertRepository()
+	hour := time.Date(2026, 1, 10, 11, 0, 0, 0, time.UTC)
+	for _, alert := range []*model.AnomalyAlertEntity{
+		{LineItemID: "li_1", AdvertiserID: "adv_123", Metric: model.AnomalyMetricCTR, WindowStart: hour.Add(-2 * time.Hour), ZScore: 4},
+		{LineItemID: "li_1", AdvertiserID: "adv_123", Metric: model.AnomalyMetricSpend, WindowStart: hour, ZScore: 5, Paused: true},
+		{LineItemID: "li_2", AdvertiserID: "adv_other", Metric: model.AnomalyMetricCTR, WindowStart: hour, ZScore: -6},
+	} {
+		require.NoError(t, alerts.Create(t.Context(), alert))
+	}
+
+	logger := testutil.GetTestLogger()
+	lineItemService := service.NewLineItemService(memory.NewLineItemRepository(), logger)
+	anomalyService := service.NewAnomalyService(alerts, memory.NewTrackingRepository(nil), lineItemService, service.AnomalyConfig{}, logger)
+	handler := NewAnomalyHandler(anomalyService, logger)
+
+	app := testutil.SetupTestApp(t)
+	principal := &auth.Principal{ID: "key_admin", Role: model.RoleAdmin}
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.WithPrincipal(c.UserContext(), principal))
+		return c.Next()
+	})
+	app.Get("/api/v1/alerts", handler.GetAll)
+
+	list := func(query string) (int, []model.AnomalyAlert) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/alerts"+query, nil))
+		require.NoError(t, err)
+		var got []model.AnomalyAlert
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+		}
+		return resp.StatusCode, got
+	}
+
+	status, got := list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, got, 3, "admins see every advertiser's alerts")
+
+	status, got = list("?metric=ctr&since=2026-01-10T10:00:00Z")
+	assert.Equal(t, http.StatusOK, status)
+	require.Len(t, got, 1)
+	assert.Equal(t, "li_2", got[0].LineItemID)
+
+	status, got = list("?line_item_id=li_1&limit=1")
+	assert.Equal(t, http.StatusOK, status)
+	require.Len(t, got, 1)
+	assert.Equal(t, model.AnomalyMetricSpend, got[0].Metric, "latest hour first")
+	assert.True(t, got[0].Paused)
+
+	for _, query := range []string{"?metric=impressions", "?since=yesterday", "?limit=-1", "?limit=5000"} {
+		status, _ = list(query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+
+	// Advertisers only see their own alerts
+	principal = &auth.Principal{ID: "key_adv", Role: model.RoleAdvertiser, AdvertiserID: "adv_123"}
+	status, got = list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, got, 2)
+	for _, alert := range got {
+		assert.Equal(t, "adv_123", alert.AdvertiserID)
+	}
+
+	status, _ = list("?advertiser_id=adv_other")
+	assert.Equal(t, http.StatusForbidden, status)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+	"sweng-task/internal/utils"
+	"sweng-task/internal/validator"
+)
+
+// APIKeyHandler handles HTTP requests managing API keys
+type APIKeyHandler struct {
+	service *service.APIKeyService
+	log     *zap.SugaredLogger
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(service *service.APIKeyService, log *zap.SugaredLogger) *APIKeyHandler {
+	return &APIKeyHandler{
+		service: service,
+		log:     log,
+	}
+}
+
+// Create handles issuing a new API key; the response is the only time the key is returned
+func (h *APIKeyHandler) Create(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
+	var input model.APIKeyCreate
+
+	if err := c.BodyParser(&input); err != nil {
+		log.Warnw("Invalid API key payload", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	if fieldErr, err := validator.ValidateStruct(&input); err != nil {
+		log.Warnw("API key validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
+		})
+	} else if fieldErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	key, err := h.service.Create(c.UserContext(), input)
+	if err != nil {
+		log.Errorw("Failed to create API key", "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to create API key",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(key)
+}
+
+// GetAll handles listing API keys, without the keys themselves
+func (h *APIKeyHandler) GetAll(c *fiber.Ctx) error {
+	keys, err := h.service.GetAll(c.UserContext())
+	if err != nil {
+		logging.FromContext(c.UserContext(), h.log).Errorw("Failed to retrieve API keys", "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve API keys",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// Revoke handles revoking an API key by ID
+func (h *APIKeyHandler) Revoke(c *fiber.Ctx) error {
+	var param validator.IDParam
+	if err := c.ParamsParser(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid path parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	err := h.service.Revoke(c.UserContext(), param.ID)
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusNotFound,
+			Message:   "API key not found",
+			RequestID: logging.RequestID(c),
+		})
+	}
+	if err != nil {
+		logging.FromContext(c.UserContext(), h.log).Errorw("Failed to revoke API key", "api_key_id", param.ID, "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to revoke API key",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
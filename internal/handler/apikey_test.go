@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/service"
+	"sweng-task/internal/testutil"
+)
+
+func setupAPIKeyTest(t *testing.T) (*fiber.App, *service.APIKeyService) {
+	app := testutil.SetupTestApp(t)
+	svc := service.NewAPIKeyService(memory.NewAPIKeyRepository(), testutil.GetTestLogger())
+	handler := NewAPIKeyHandler(svc, testutil.GetTestLogger())
+
+	app.Post("/api/v1/apikeys", handler.Create)
+	app.Get("/api/v1/apikeys", handler.GetAll)
+	app.Delete("/api/v1/apikeys/:id", handler.Revoke)
+	return app, svc
+}
+
+func TestAPIKeyHandler_Lifecycle(t *testing.T) {
+	app, svc := setupAPIKeyTest(t)
+
+	body, _ := json.Marshal(model.APIKeyCreate{Name: "dashboard", Role: model.RoleAdvertiser, AdvertiserID: "adv_123"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/apikeys", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created model.APIKeyCreated
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.NotEmpty(t, created.Key)
+	_, err = svc.Authenticate(t.Context(), created.Key)
+	require.NoError(t, err)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/apikeys", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0]["id"])
+	assert.NotContains(t, listed[0], "key", "keys are only returned on creation")
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, "/api/v1/apikeys/"+created.ID, nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, err = svc.Authenticate(t.Context(), created.Key)
+	assert.Error(t, err)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, "/api/v1/apikeys/key_unknown", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPIKeyHandler_Create_InvalidInput(t *testing.T) {
+	app, _ := setupAPIKeyTest(t)
+
+	tests := []struct {
+		name  string
+		input model.APIKeyCreate
+	}{
+		{name: "unknown role", input: model.APIKeyCreate{Name: "x", Role: "owner"}},
+		{name: "advertiser key without advertiser", input: model.APIKeyCreate{Name: "x", Role: model.RoleAdvertiser}},
+		{name: "publisher key with advertiser", input: model.APIKeyCreate{Name: "x", Role: model.RolePublisher, AdvertiserID: "adv_123"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.input)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/apikeys", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -2,12 +2,31 @@ package handler
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"sweng-task/internal/health"
 )
 
-// HealthCheck handles health check requests
-func HealthCheck(c *fiber.Ctx) error {
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":  "ok",
-		"version": "1.0.0",
-	})
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+// NewHealthHandler creates a new HealthHandler
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{checker: checker}
+}
+
+// Livez handles liveness probes; it answers as long as the process can serve requests and
+// never checks dependencies, so that an outage of a dependency doesn't restart every pod
+func (h *HealthHandler) Livez(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.checker.Live())
+}
+
+// Readyz handles readiness probes, answering 503 with the failing dependencies while the
+// service can't serve traffic
+func (h *HealthHandler) Readyz(c *fiber.Ctx) error {
+	report := h.checker.Ready(c.UserContext())
+	if report.Status != health.StatusOK {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+	}
+	return c.Status(fiber.StatusOK).JSON(report)
 }
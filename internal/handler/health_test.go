@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/health"
+	"sweng-task/internal/testutil"
+)
+
+func TestHealthHandler(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	checker := health.NewChecker("1.2.3", time.Second)
+	h := NewHealthHandler(checker)
+	app.Get("/livez", h.Livez)
+	app.Get("/readyz", h.Readyz)
+
+	var dbErr error
+	checker.Register("database", func(ctx context.Context) error { return dbErr })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	dbErr = errors.New("connection refused")
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	var report health.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, health.StatusUnavailable, report.Status)
+	assert.Equal(t, "1.2.3", report.Version)
+	assert.Equal(t, "connection refused", report.Checks["database"].Error)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/livez", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "a failing dependency doesn't fail liveness")
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"sweng-task/internal/auth"
+	"sweng-task/internal/logging"
 	"sweng-task/internal/model"
 	"sweng-task/internal/utils"
 	"sweng-task/internal/validator"
@@ -27,39 +29,57 @@ func NewLineItemHandler(service *service.LineItemService, log *zap.SugaredLogger
 
 // Create handles the creation of a new line item
 func (h *LineItemHandler) Create(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
 	var input model.LineItemCreate
 
 	if err := c.BodyParser(&input); err != nil {
-		h.log.Warnw("Invalid line item payload", "error", err)
+		log.Warnw("Invalid line item payload", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request body",
-			Details: err.Error(),
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	if fieldErr, err := validator.ValidateStruct(&input); err != nil {
-		h.log.Warnw("Line item validation failed", "error", err)
+		log.Warnw("Line item validation failed", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Validation failed",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
 		})
 	} else if fieldErr != nil {
-		h.log.Warnw("Line item field validation error", "field", fieldErr.Field, "reason", fieldErr.Reason)
+		log.Warnw("Line item field validation error", "field", fieldErr.Field, "reason", fieldErr.Reason)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request",
-			Details: fieldErr,
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	lineItem, err := h.service.Create(input)
+	// Advertiser keys may only create line items for their own advertiser
+	if principal, ok := auth.FromContext(c.UserContext()); ok && !principal.CanAccessAdvertiser(input.AdvertiserID) {
+		log.Warnw("Line item creation for another advertiser denied", "advertiser_id", input.AdvertiserID)
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusForbidden,
+			Message:   "Forbidden",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	lineItem, err := h.service.Create(c.UserContext(), input)
 	if err != nil {
-		h.log.Errorw("Failed to create line item", "error", err)
+		log.Errorw("Failed to create line item", "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusInternalServerError,
-			Message: "Failed to create line item",
-			Details: err.Error(),
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to create line item",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
@@ -68,41 +88,56 @@ func (h *LineItemHandler) Create(c *fiber.Ctx) error {
 
 // GetByID handles retrieving a line item by ID
 func (h *LineItemHandler) GetByID(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
 	var param validator.IDParam
 	if err := c.ParamsParser(&param); err != nil {
-		h.log.Warnw("Failed to parse path parameters", "error", err)
+		log.Warnw("Failed to parse path parameters", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid path parameters",
-			Details: err.Error(),
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid path parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	if fieldErr, err := validator.ValidateStruct(&param); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Validation failed",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
 		})
 	} else if fieldErr != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request parameters",
-			Details: fieldErr,
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	lineItem, err := h.service.GetByID(param.ID)
+	lineItem, err := h.service.GetByID(c.UserContext(), param.ID)
+	if err == nil {
+		// Line items of other advertisers are reported missing, so their IDs can't be probed
+		if principal, ok := auth.FromContext(c.UserContext()); ok && !principal.CanAccessAdvertiser(lineItem.AdvertiserID) {
+			err = service.ErrLineItemNotFound
+		}
+	}
 	if err != nil {
 		if err == service.ErrLineItemNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
-				Code:    fiber.StatusNotFound,
-				Message: "Line item not found",
+				Code:      fiber.StatusNotFound,
+				Message:   "Line item not found",
+				RequestID: logging.RequestID(c),
 			})
 		}
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusInternalServerError,
-			Message: "Failed to retrieve line item",
-			Details: err.Error(),
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve line item",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
@@ -111,38 +146,136 @@ func (h *LineItemHandler) GetByID(c *fiber.Ctx) error {
 
 // GetAll handles retrieving all line items with optional filtering
 func (h *LineItemHandler) GetAll(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
 	var query validator.LineItemQueryParams
 	if err := c.QueryParser(&query); err != nil {
-		h.log.Warnw("Failed to parse query", "error", err)
+		log.Warnw("Failed to parse query", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid query parameters",
-			Details: err.Error(),
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid query parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	if fieldErr, err := validator.ValidateStruct(&query); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Validation failed",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
 		})
 	} else if fieldErr != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid query parameters",
-			Details: fieldErr,
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid query parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	lineItems, err := h.service.GetAll(query.AdvertiserID, query.Placement)
+	// Advertiser keys only see their own line items
+	if principal, ok := auth.FromContext(c.UserContext()); ok && principal.Role == model.RoleAdvertiser {
+		if query.AdvertiserID != "" && query.AdvertiserID != principal.AdvertiserID {
+			return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusForbidden,
+				Message:   "Forbidden",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		query.AdvertiserID = principal.AdvertiserID
+	}
+
+	lineItems, err := h.service.GetAll(c.UserContext(), query.AdvertiserID, query.Placement)
 	if err != nil {
-		h.log.Errorw("Failed to retrieve line items", "query", query, "error", err)
+		log.Errorw("Failed to retrieve line items", "query", query, "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusInternalServerError,
-			Message: "Failed to retrieve line items",
-			Details: err.Error(),
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve line items",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lineItems)
 }
+
+// UpdateStatus handles PUT /lineitems/:id/status requests, pausing or resuming a line item
+func (h *LineItemHandler) UpdateStatus(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.log)
+	var param validator.IDParam
+	if err := c.ParamsParser(&param); err != nil {
+		log.Warnw("Failed to parse path parameters", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid path parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	var input model.LineItemStatusUpdate
+	if err := c.BodyParser(&input); err != nil {
+		log.Warnw("Invalid line item status payload", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request body",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	for _, s := range []interface{}{&param, &input} {
+		if fieldErr, err := validator.ValidateStruct(s); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusBadRequest,
+				Message:   "Validation failed",
+				RequestID: logging.RequestID(c),
+			})
+		} else if fieldErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusBadRequest,
+				Message:   "Invalid request",
+				Details:   fieldErr,
+				RequestID: logging.RequestID(c),
+			})
+		}
+	}
+
+	lineItem, err := h.service.GetByID(c.UserContext(), param.ID)
+	if err == nil {
+		// Line items of other advertisers are reported missing, so their IDs can't be probed
+		if principal, ok := auth.FromContext(c.UserContext()); ok && !principal.CanAccessAdvertiser(lineItem.AdvertiserID) {
+			err = service.ErrLineItemNotFound
+		}
+	}
+	if err == nil {
+		err = h.service.UpdateStatus(c.UserContext(), param.ID, input.Status)
+	}
+	if err == nil {
+		lineItem, err = h.service.GetByID(c.UserContext(), param.ID)
+	}
+	if err != nil {
+		if err == service.ErrLineItemNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusNotFound,
+				Message:   "Line item not found",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		log.Errorw("Failed to update line item status", "id", param.ID, "status", input.Status, "error", err)
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to update line item status",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lineItem)
+}
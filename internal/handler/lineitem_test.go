@@ -9,15 +9,17 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/auth"
 	"sweng-task/internal/model"
-	"sweng-task/internal/repository/mocks"
+	"sweng-task/internal/repository/memory"
 	"sweng-task/internal/service"
 	"sweng-task/internal/testutil"
 )
 
-func setupLineItemTest(t *testing.T) (*fiber.App, *mocks.LineItemRepository) {
+func setupLineItemTest(t *testing.T) (*fiber.App, *memory.LineItemRepository) {
 	app := testutil.SetupTestApp(t)
-	mockRepo := mocks.NewInMemoryLineItemRepository()
+	mockRepo := memory.NewLineItemRepository()
 	logger := testutil.GetTestLogger()
 
 	svc := service.NewLineItemService(
@@ -29,6 +31,7 @@ func setupLineItemTest(t *testing.T) (*fiber.App, *mocks.LineItemRepository) {
 
 	app.Post("/api/v1/lineitems", handler.Create)
 	app.Get("/api/v1/lineitems/:id", handler.GetByID)
+	app.Put("/api/v1/lineitems/:id/status", handler.UpdateStatus)
 	app.Get("/api/v1/lineitems", handler.GetAll)
 
 	return app, mockRepo
@@ -39,7 +42,7 @@ func TestLineItemHandler_Create(t *testing.T) {
 
 	input := testutil.CreateTestLineItemCreate()
 	expected := testutil.CreateTestLineItemEntity()
-	_ = mockRepo.Create(expected)
+	_ = mockRepo.Create(t.Context(), expected)
 
 	body, _ := json.Marshal(input)
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/lineitems", bytes.NewReader(body))
@@ -82,7 +85,7 @@ func TestLineItemHandler_Create_InvalidInput(t *testing.T) {
 func TestLineItemHandler_GetByID(t *testing.T) {
 	app, mockRepo := setupLineItemTest(t)
 	expected := testutil.CreateTestLineItemEntity()
-	_ = mockRepo.Create(expected)
+	_ = mockRepo.Create(t.Context(), expected)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/lineitems/"+expected.ID, nil)
 	resp, err := app.Test(req)
@@ -115,8 +118,8 @@ func TestLineItemHandler_GetAll(t *testing.T) {
 
 	li1 := testutil.CreateTestLineItemEntity()
 	li2 := testutil.CreateTestLineItemEntity()
-	_ = mockRepo.Create(li1)
-	_ = mockRepo.Create(li2)
+	_ = mockRepo.Create(t.Context(), li1)
+	_ = mockRepo.Create(t.Context(), li2)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/lineitems?advertiser_id="+li1.AdvertiserID+"&placement="+li1.Placement, nil)
 	resp, err := app.Test(req)
@@ -128,3 +131,107 @@ func TestLineItemHandler_GetAll(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, result)
 }
+
+func TestLineItemHandler_UpdateStatus(t *testing.T) {
+	app, mockRepo := setupLineItemTest(t)
+	item := testutil.CreateTestLineItemEntity()
+	item.Status = model.LineItemStatusPaused
+	require.NoError(t, mockRepo.Create(t.Context(), item))
+
+	update := func(id, body string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/lineitems/"+id+"/status", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := update(item.ID, `{"status":"active"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result model.LineItem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, model.LineItemStatusActive, result.Status)
+	stored, err := mockRepo.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemStatusActive, stored.Status)
+
+	for _, body := range []string{`{"status":"completed"}`, `{}`, `not json`} {
+		assert.Equal(t, http.StatusBadRequest, update(item.ID, body).StatusCode, body)
+	}
+	assert.Equal(t, http.StatusNotFound, update("li_missing", `{"status":"paused"}`).StatusCode)
+}
+
+func TestLineItemHandler_AdvertiserScope(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.WithPrincipal(c.UserContext(), &auth.Principal{
+			ID:           "key_adv",
+			Role:         model.RoleAdvertiser,
+			AdvertiserID: "adv_123",
+		}))
+		return c.Next()
+	})
+	mockRepo := memory.NewLineItemRepository()
+	handler := NewLineItemHandler(service.NewLineItemService(mockRepo, testutil.GetTestLogger()), testutil.GetTestLogger())
+	app.Post("/api/v1/lineitems", handler.Create)
+	app.Get("/api/v1/lineitems/:id", handler.GetByID)
+	app.Put("/api/v1/lineitems/:id/status", handler.UpdateStatus)
+	app.Get("/api/v1/lineitems", handler.GetAll)
+
+	own := testutil.CreateTestLineItemEntity()
+	other := testutil.CreateTestLineItemEntity()
+	other.AdvertiserID = "adv_other"
+	require.NoError(t, mockRepo.Create(t.Context(), own))
+	require.NoError(t, mockRepo.Create(t.Context(), other))
+
+	t.Run("lists own line items only", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []model.LineItem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Len(t, result, 1)
+		assert.Equal(t, own.ID, result[0].ID)
+	})
+
+	t.Run("can't filter by another advertiser", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems?advertiser_id=adv_other", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("other advertisers' line items are not found", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems/"+other.ID, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems/"+own.ID, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("can't update the status of another advertiser's line items", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/lineitems/"+other.ID+"/status", bytes.NewReader([]byte(`{"status":"paused"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		stored, err := mockRepo.GetByID(t.Context(), other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.LineItemStatusActive, stored.Status)
+	})
+
+	t.Run("can't create line items for another advertiser", func(t *testing.T) {
+		input := testutil.CreateTestLineItemCreate()
+		input.AdvertiserID = "adv_other"
+		body, _ := json.Marshal(input)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/lineitems", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/utils"
+)
+
+// Timeout sets a deadline of d on the request context passed to the services, so that
+// database queries are cancelled once it passes. Nested timeouts keep the earliest deadline.
+func Timeout(d time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if d <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), d)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// timedOut reports whether err was caused by the request deadline passing
+func timedOut(c *fiber.Ctx, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(c.UserContext().Err(), context.DeadlineExceeded)
+}
+
+func timeoutResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusGatewayTimeout).JSON(utils.ErrorResponse{
+		Code:      fiber.StatusGatewayTimeout,
+		Message:   "Request timed out",
+		RequestID: logging.RequestID(c),
+	})
+}
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"sweng-task/internal/auth"
+	"sweng-task/internal/ingest"
+	"sweng-task/internal/logging"
 	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 	"sweng-task/internal/utils"
@@ -12,58 +15,460 @@ import (
 )
 
 type TrackingHandler struct {
-	service *service.TrackingService
-	logger  *zap.SugaredLogger
+	service  *service.TrackingService
+	pipeline *ingest.Pipeline
+	logger   *zap.SugaredLogger
 }
 
-func NewTrackingHandler(service *service.TrackingService, logger *zap.SugaredLogger) *TrackingHandler {
-	return &TrackingHandler{service: service, logger: logger}
+// TrackingHandlerOption configures optional TrackingHandler behaviour
+type TrackingHandlerOption func(*TrackingHandler)
+
+// WithIngestPipeline makes the handler enqueue events for asynchronous processing
+// instead of persisting them within the request
+func WithIngestPipeline(pipeline *ingest.Pipeline) TrackingHandlerOption {
+	return func(h *TrackingHandler) {
+		h.pipeline = pipeline
+	}
+}
+
+const (
+	batchStatusAccepted = "accepted"
+	batchStatusRejected = "rejected"
+)
+
+// trackingBatchItemResponse is the per-event outcome returned by TrackBatch
+type trackingBatchItemResponse struct {
+	Index     int               `json:"index"`
+	Status    string            `json:"status"`
+	EventID   string            `json:"event_id,omitempty"`
+	Duplicate bool              `json:"duplicate,omitempty"`
+	Error     *utils.FieldError `json:"error,omitempty"`
+}
+
+func NewTrackingHandler(service *service.TrackingService, logger *zap.SugaredLogger, opts ...TrackingHandlerOption) *TrackingHandler {
+	h := &TrackingHandler{service: service, logger: logger}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *TrackingHandler) TrackEvent(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.logger)
 	var event model.TrackingEvent
 
 	if err := c.BodyParser(&event); err != nil {
-		h.logger.Warnw("Invalid tracking payload", "error", err)
+		log.Warnw("Invalid tracking payload", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request body",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request body",
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	if fieldErr, err := validator.ValidateStruct(&event); err != nil {
-		h.logger.Warnw("Validation failed", "error", err)
+		log.Warnw("Validation failed", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Validation failed",
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
 		})
 	} else if fieldErr != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusBadRequest,
-			Message: "Invalid request parameters",
-			Details: fieldErr,
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
+	withClient(c, &event)
+
+	// SDKs that cannot set a body field may pass the event ID as an idempotency key
+	if event.EventID == "" {
+		event.EventID = c.Get("Idempotency-Key")
+	}
+
+	if h.pipeline != nil {
+		return h.enqueue(c, event)
+	}
 
-	if err := h.service.Track(event); err != nil {
-		h.logger.Errorw("Failed to store tracking event", "error", err)
+	result, err := h.service.Track(c.UserContext(), event)
+	if err != nil {
+		log.Errorw("Failed to store tracking event", "error", err)
 
 		if err == service.ErrLineItemNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
-				Code:    fiber.StatusNotFound,
-				Message: "Line item not found",
+				Code:      fiber.StatusNotFound,
+				Message:   "Line item not found",
+				RequestID: logging.RequestID(c),
 			})
 		}
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
 
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Code:    fiber.StatusInternalServerError,
-			Message: "Failed to track event",
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to track event",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success":   true,
+		"event_id":  result.EventID,
+		"duplicate": result.Duplicate,
+	})
+}
+
+// TrackBatch handles POST /tracking/batch requests. Each event is validated and tracked
+// independently, so a batch can be partially accepted.
+func (h *TrackingHandler) TrackBatch(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.logger)
+	var batch model.TrackingEventBatch
+
+	if err := c.BodyParser(&batch); err != nil {
+		log.Warnw("Invalid tracking batch payload", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request body",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	if fieldErr, err := validator.ValidateStruct(&batch); err != nil {
+		log.Warnw("Validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
+		})
+	} else if fieldErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
 		})
 	}
 
-	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"success": true})
+	if len(batch.Events) > h.service.MaxBatchSize() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusRequestEntityTooLarge,
+			Message:   "Too many events in batch",
+			Details:   fiber.Map{"max_batch_size": h.service.MaxBatchSize()},
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	responses := make([]trackingBatchItemResponse, len(batch.Events))
+	valid := make([]model.TrackingEvent, 0, len(batch.Events))
+	validIndexes := make([]int, 0, len(batch.Events))
+
+	for i := range batch.Events {
+		event := batch.Events[i]
+		responses[i] = trackingBatchItemResponse{Index: i, EventID: event.EventID}
+
+		if fieldErr, err := validator.ValidateStruct(&event); err != nil {
+			responses[i].Status = batchStatusRejected
+			responses[i].Error = &utils.FieldError{Reason: "is invalid"}
+			continue
+		} else if fieldErr != nil {
+			responses[i].Status = batchStatusRejected
+			responses[i].Error = fieldErr
+			continue
+		}
+
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		withClient(c, &event)
+		valid = append(valid, event)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if h.pipeline != nil {
+		return h.enqueueBatch(c, responses, valid, validIndexes)
+	}
+
+	results, err := h.service.TrackBatch(c.UserContext(), valid)
+	if err != nil {
+		log.Errorw("Failed to store tracking batch", "size", len(batch.Events), "error", err)
+
+		if err == service.ErrBatchTooLarge {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusRequestEntityTooLarge,
+				Message:   "Too many events in batch",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to track events",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	accepted := 0
+	for j, result := range results {
+		i := validIndexes[j]
+		if result.Err != nil {
+			responses[i].Status = batchStatusRejected
+			responses[i].Error = batchItemError(result.Err)
+			continue
+		}
+		accepted++
+		responses[i].Status = batchStatusAccepted
+		responses[i].EventID = result.Result.EventID
+		responses[i].Duplicate = result.Result.Duplicate
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"accepted": accepted,
+		"rejected": len(batch.Events) - accepted,
+		"results":  responses,
+	})
+}
+
+// InvalidTrafficReport handles GET /lineitems/:id/invalid-traffic requests, reporting the
+// events of a line item flagged as invalid traffic by reason
+func (h *TrackingHandler) InvalidTrafficReport(c *fiber.Ctx) error {
+	log := logging.FromContext(c.UserContext(), h.logger)
+	var param validator.IDParam
+	if err := c.ParamsParser(&param); err != nil {
+		log.Warnw("Failed to parse path parameters", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid path parameters",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	if fieldErr, err := validator.ValidateStruct(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Validation failed",
+			RequestID: logging.RequestID(c),
+		})
+	} else if fieldErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusBadRequest,
+			Message:   "Invalid request parameters",
+			Details:   fieldErr,
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	report, err := h.service.InvalidTrafficReport(c.UserContext(), param.ID)
+	if err == nil {
+		// Line items of other advertisers are reported missing, so their IDs can't be probed
+		if principal, ok := auth.FromContext(c.UserContext()); ok && !principal.CanAccessAdvertiser(report.AdvertiserID) {
+			err = service.ErrLineItemNotFound
+		}
+	}
+	if err != nil {
+		if err == service.ErrLineItemNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusNotFound,
+				Message:   "Line item not found",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		if timedOut(c, err) {
+			return timeoutResponse(c)
+		}
+		log.Errorw("Failed to build invalid traffic report", "line_item_id", param.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusInternalServerError,
+			Message:   "Failed to retrieve invalid traffic report",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+// QueueStats handles GET /tracking/queue requests, reporting ingestion queue depth and lag
+func (h *TrackingHandler) QueueStats(c *fiber.Ctx) error {
+	if h.pipeline == nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"async": false})
+	}
+
+	stats := h.pipeline.Stats()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"async":       true,
+		"depth":       stats.Depth,
+		"capacity":    stats.Capacity,
+		"lag_ms":      stats.Lag.Milliseconds(),
+		"enqueued":    stats.Enqueued,
+		"processed":   stats.Processed,
+		"rejected":    stats.Rejected,
+		"failed":      stats.Failed,
+		"redriving":   stats.Redriving,
+		"wal_backlog": stats.WALBacklog,
+	})
+}
+
+func (h *TrackingHandler) enqueue(c *fiber.Ctx, event model.TrackingEvent) error {
+	log := logging.FromContext(c.UserContext(), h.logger)
+	if event.EventID == "" {
+		event.EventID = service.NewEventID()
+	}
+
+	if result, ok := h.service.RecentResult(event.EventID); ok {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"success":   true,
+			"event_id":  result.EventID,
+			"duplicate": true,
+		})
+	}
+
+	// Events are only checked against their line item once a worker stores them, too late to
+	// tell the client, so unknown line items are rejected here as they are when tracking synchronously.
+	// If line items cannot be looked up the event is queued anyway and the worker rejects it.
+	exists, err := h.service.LineItemsExist(c.UserContext(), []string{event.LineItemID})
+	if err != nil {
+		log.Warnw("Failed to look up line item of queued event", "line_item_id", event.LineItemID, "error", err)
+	} else if !exists[event.LineItemID] {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusNotFound,
+			Message:   "Line item not found",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	if err := h.pipeline.Enqueue(event); err != nil {
+		log.Warnw("Tracking event not queued", "event_id", event.EventID, "error", err)
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(utils.ErrorResponse{
+			Code:      fiber.StatusServiceUnavailable,
+			Message:   "Tracking queue is full, retry later",
+			RequestID: logging.RequestID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success":   true,
+		"event_id":  event.EventID,
+		"duplicate": false,
+	})
+}
+
+func (h *TrackingHandler) enqueueBatch(c *fiber.Ctx, responses []trackingBatchItemResponse, valid []model.TrackingEvent, validIndexes []int) error {
+	log := logging.FromContext(c.UserContext(), h.logger)
+	queued := make([]model.TrackingEvent, 0, len(valid))
+	queuedIndexes := make([]int, 0, len(valid))
+
+	accepted := 0
+	for j := range valid {
+		i := validIndexes[j]
+		if valid[j].EventID == "" {
+			valid[j].EventID = service.NewEventID()
+		}
+		responses[i].EventID = valid[j].EventID
+
+		if _, ok := h.service.RecentResult(valid[j].EventID); ok {
+			accepted++
+			responses[i].Status = batchStatusAccepted
+			responses[i].Duplicate = true
+			continue
+		}
+		queued = append(queued, valid[j])
+		queuedIndexes = append(queuedIndexes, i)
+	}
+
+	queued, queuedIndexes = h.rejectUnknownLineItems(c, responses, queued, queuedIndexes)
+
+	full := 0
+	for j, err := range h.pipeline.EnqueueBatch(queued) {
+		i := queuedIndexes[j]
+		if err != nil {
+			full++
+			responses[i].Status = batchStatusRejected
+			responses[i].Error = &utils.FieldError{Reason: err.Error()}
+			continue
+		}
+		accepted++
+		responses[i].Status = batchStatusAccepted
+	}
+
+	if full > 0 {
+		log.Warnw("Tracking batch partially queued", "queued", len(queued)-full, "rejected", full)
+		c.Set(fiber.HeaderRetryAfter, "1")
+		if full == len(queued) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusServiceUnavailable,
+				Message:   "Tracking queue is full, retry later",
+				RequestID: logging.RequestID(c),
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"accepted": accepted,
+		"rejected": len(responses) - accepted,
+		"results":  responses,
+	})
+}
+
+// rejectUnknownLineItems marks the events of batch whose line item does not exist as rejected,
+// as TrackBatch does when tracking synchronously, and returns the remaining events. If line
+// items cannot be looked up every event is returned and the worker rejects unknown ones.
+func (h *TrackingHandler) rejectUnknownLineItems(c *fiber.Ctx, responses []trackingBatchItemResponse, events []model.TrackingEvent, indexes []int) ([]model.TrackingEvent, []int) {
+	if len(events) == 0 {
+		return events, indexes
+	}
+
+	ids := make([]string, len(events))
+	for j, event := range events {
+		ids[j] = event.LineItemID
+	}
+	exists, err := h.service.LineItemsExist(c.UserContext(), ids)
+	if err != nil {
+		logging.FromContext(c.UserContext(), h.logger).Warnw("Failed to look up line items of queued batch", "size", len(events), "error", err)
+		return events, indexes
+	}
+
+	known := events[:0]
+	knownIndexes := indexes[:0]
+	for j, event := range events {
+		if !exists[event.LineItemID] {
+			responses[indexes[j]].Status = batchStatusRejected
+			responses[indexes[j]].Error = batchItemError(service.ErrLineItemNotFound)
+			continue
+		}
+		known = append(known, event)
+		knownIndexes = append(knownIndexes, indexes[j])
+	}
+	return known, knownIndexes
+}
+
+// withClient defaults the IP and user agent of event, used for invalid traffic detection,
+// to those of the request, for clients tracking events straight from the user's browser. The
+// IP is only defaulted when the client IP is read from a proxy header: otherwise it may be a
+// load balancer's or a publisher server's, shared by all its users, and events are left
+// without one so that the per-IP rule skips them.
+func withClient(c *fiber.Ctx, event *model.TrackingEvent) {
+	if event.IP == "" && c.App().Config().ProxyHeader != "" {
+		event.IP = c.IP()
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = c.Get(fiber.HeaderUserAgent)
+	}
+}
+
+func batchItemError(err error) *utils.FieldError {
+	if err == service.ErrLineItemNotFound {
+		return &utils.FieldError{Field: "LineItemID", Reason: "line item not found"}
+	}
+	return &utils.FieldError{Reason: err.Error()}
 }
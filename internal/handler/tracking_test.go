@@ -2,7 +2,10 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -10,9 +13,14 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/ingest"
+	"sweng-task/internal/ivt"
 	"sweng-task/internal/model"
+	"sweng-task/internal/ratelimit"
 	"sweng-task/internal/repository"
-	"sweng-task/internal/repository/mocks"
+	"sweng-task/internal/repository/memory"
 	"sweng-task/internal/service"
 	"sweng-task/internal/testutil"
 )
@@ -20,8 +28,8 @@ import (
 func setupTrackingTest(t *testing.T) (*fiber.App, repository.LineItemRepository, repository.TrackingRepository) {
 	app := testutil.SetupTestApp(t)
 
-	trackingRepo := mocks.NewInMemoryTrackingRepository()
-	lineItemRepo := mocks.NewInMemoryLineItemRepository()
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
 	logger := testutil.GetTestLogger()
 
 	lineItemService := service.NewLineItemService(lineItemRepo, logger)
@@ -37,7 +45,7 @@ func TestTrackingHandler_TrackEvent(t *testing.T) {
 	app, lineItemRepo, _ := setupTrackingTest(t)
 
 	lineItem := testutil.CreateTestLineItemEntity()
-	err := lineItemRepo.Create(lineItem)
+	err := lineItemRepo.Create(t.Context(), lineItem)
 	assert.NoError(t, err)
 
 	event := testutil.CreateTestTrackingEvent(lineItem.ID)
@@ -54,7 +62,7 @@ func TestTrackingHandler_TrackEvent(t *testing.T) {
 func TestTrackingHandler_TrackEvent_InvalidInput(t *testing.T) {
 	app, lineItemRepo, _ := setupTrackingTest(t)
 	lineItem := testutil.CreateTestLineItemEntity()
-	err := lineItemRepo.Create(lineItem)
+	err := lineItemRepo.Create(t.Context(), lineItem)
 	event := map[string]interface{}{
 		"event_type":   "invalid_type",
 		"line_item_id": lineItem.ID,
@@ -92,11 +100,71 @@ func TestTrackingHandler_TrackEvent_LineItemNotFound(t *testing.T) {
 	assert.Equal(t, "Line item not found", result["message"])
 }
 
+func TestTrackingHandler_TrackEvent_Timeout(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(slowLineItemRepository{memory.NewLineItemRepository()}, logger)
+	trackingService := service.NewTrackingService(memory.NewTrackingRepository(nil), lineItemService, logger)
+	app.Post("/api/v1/tracking", Timeout(20*time.Millisecond), NewTrackingHandler(trackingService, logger).TrackEvent)
+
+	// A lookup cut short by the deadline is not reported as a missing line item
+	body, _ := json.Marshal(testutil.CreateTestTrackingEvent("li_slow"))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+// slowStoreTrackingRepository stores events only after a delay, failing if their context was
+// cancelled meanwhile
+type slowStoreTrackingRepository struct {
+	*memory.TrackingRepository
+	delay time.Duration
+}
+
+func (r slowStoreTrackingRepository) Store(ctx context.Context, event *model.TrackingEventEntity) error {
+	time.Sleep(r.delay)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.TrackingRepository.Store(ctx, event)
+}
+
+func TestTrackingHandler_TrackEvent_DeadlineDuringStore(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	logger := testutil.GetTestLogger()
+
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := slowStoreTrackingRepository{TrackingRepository: memory.NewTrackingRepository(lineItemRepo), delay: 50 * time.Millisecond}
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger)
+	app.Post("/api/v1/tracking", Timeout(20*time.Millisecond), NewTrackingHandler(trackingService, logger).TrackEvent)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	// The deadline passing once the write started does not cut the write short
+	body, _ := json.Marshal(testutil.CreateTestTrackingEvent(lineItem.ID))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	assert.NoError(t, err)
+	assert.InDelta(t, lineItem.Bid/1000, stored.DailySpending, 1e-9)
+}
+
 func TestTrackingHandler_TrackEvent_DifferentEventTypes(t *testing.T) {
 	app, lineItemRepo, _ := setupTrackingTest(t)
 
 	lineItem := testutil.CreateTestLineItemEntity()
-	err := lineItemRepo.Create(lineItem)
+	err := lineItemRepo.Create(t.Context(), lineItem)
 	assert.NoError(t, err)
 
 	eventTypes := []model.TrackingEventType{
@@ -120,3 +188,528 @@ func TestTrackingHandler_TrackEvent_DifferentEventTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestTrackingHandler_TrackEvent_DuplicateNotChargedTwice(t *testing.T) {
+	app, lineItemRepo, trackingRepo := setupTrackingTest(t)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	err := lineItemRepo.Create(t.Context(), lineItem)
+	assert.NoError(t, err)
+
+	event := testutil.CreateTestTrackingEvent(lineItem.ID)
+	event.EventID = "evt_retry_1"
+
+	for i, expectedDuplicate := range []bool{false, true, true} {
+		body, _ := json.Marshal(event)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		var result map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, "evt_retry_1", result["event_id"])
+		assert.Equal(t, expectedDuplicate, result["duplicate"], "delivery %d", i)
+	}
+
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	assert.NoError(t, err)
+	assert.InDelta(t, lineItem.Bid/1000, stored.DailySpending, 1e-9)
+
+	events, err := trackingRepo.FindAll(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestTrackingHandler_TrackEvent_DuplicateAfterCacheMiss(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	// A zero-length window forces every lookup past the in-memory cache to storage
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger, service.WithDedupeWindow(0, 1))
+	app.Post("/api/v1/tracking", NewTrackingHandler(trackingService, logger).TrackEvent)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	event := testutil.CreateTestTrackingEvent(lineItem.ID)
+	body, _ := json.Marshal(event)
+
+	for _, expectedDuplicate := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "evt_header_1")
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		var result map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.Equal(t, expectedDuplicate, result["duplicate"])
+	}
+
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	assert.NoError(t, err)
+	assert.InDelta(t, lineItem.Bid/1000, stored.DailySpending, 1e-9)
+}
+
+// failingSpendLineItemRepository fails the first charge of daily spending, like a database
+// connection dropping between storing an event and charging it
+type failingSpendLineItemRepository struct {
+	*memory.LineItemRepository
+	failed bool
+}
+
+func (r *failingSpendLineItemRepository) IncreaseDailySpendingBatch(ctx context.Context, amounts map[string]float64) error {
+	if !r.failed {
+		r.failed = true
+		return errors.New("connection reset")
+	}
+	return r.LineItemRepository.IncreaseDailySpendingBatch(ctx, amounts)
+}
+
+func TestTrackingHandler_TrackEvent_RetryAfterFailedCharge(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	lineItemRepo := &failingSpendLineItemRepository{LineItemRepository: memory.NewLineItemRepository()}
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger)
+	app.Post("/api/v1/tracking", NewTrackingHandler(trackingService, logger).TrackEvent)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	event := testutil.CreateTestTrackingEvent(lineItem.ID)
+	event.EventID = "evt_charge_retry"
+	body, _ := json.Marshal(event)
+
+	for i, expectedStatus := range []int{http.StatusInternalServerError, http.StatusAccepted} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode, "delivery %d", i)
+	}
+
+	// The failed charge stored nothing, so the retry is not a duplicate and is charged
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	assert.NoError(t, err)
+	assert.InDelta(t, lineItem.Bid/1000, stored.DailySpending, 1e-9)
+
+	events, err := trackingRepo.FindAll(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func setupTrackingBatchTest(t *testing.T, opts ...service.TrackingOption) (*fiber.App, repository.LineItemRepository, repository.TrackingRepository) {
+	app := testutil.SetupTestApp(t)
+
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger, opts...)
+	handler := NewTrackingHandler(trackingService, logger)
+
+	app.Post("/api/v1/tracking/batch", handler.TrackBatch)
+
+	return app, lineItemRepo, trackingRepo
+}
+
+func TestTrackingHandler_TrackBatch_PartialSuccess(t *testing.T) {
+	app, lineItemRepo, trackingRepo := setupTrackingBatchTest(t)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	valid := testutil.CreateTestTrackingEvent(lineItem.ID)
+	valid.EventID = "evt_batch_1"
+	repeated := valid
+	invalid := testutil.CreateTestTrackingEvent(lineItem.ID)
+	invalid.EventType = "invalid_type"
+	missing := testutil.CreateTestTrackingEvent("li_missing")
+	click := testutil.CreateTestTrackingEvent(lineItem.ID)
+	click.EventType = model.TrackingEventTypeClick
+
+	body, _ := json.Marshal(model.TrackingEventBatch{
+		Events: []model.TrackingEvent{valid, invalid, missing, repeated, click},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var result struct {
+		Accepted int                         `json:"accepted"`
+		Rejected int                         `json:"rejected"`
+		Results  []trackingBatchItemResponse `json:"results"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 3, result.Accepted)
+	assert.Equal(t, 2, result.Rejected)
+	assert.Len(t, result.Results, 5)
+
+	assert.Equal(t, batchStatusAccepted, result.Results[0].Status)
+	assert.False(t, result.Results[0].Duplicate)
+	assert.Equal(t, batchStatusRejected, result.Results[1].Status)
+	assert.Equal(t, "EventType", result.Results[1].Error.Field)
+	assert.Equal(t, batchStatusRejected, result.Results[2].Status)
+	assert.Equal(t, "LineItemID", result.Results[2].Error.Field)
+	assert.Equal(t, batchStatusAccepted, result.Results[3].Status)
+	assert.True(t, result.Results[3].Duplicate)
+	assert.Equal(t, batchStatusAccepted, result.Results[4].Status)
+
+	events, err := trackingRepo.FindAll(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	assert.NoError(t, err)
+	assert.InDelta(t, 2*lineItem.Bid/1000, stored.DailySpending, 1e-9)
+}
+
+// recordingSink keeps the events published to it
+type recordingSink struct {
+	events []model.PublishedEvent
+}
+
+func (s *recordingSink) Publish(events []model.PublishedEvent) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestTrackingHandler_TrackBatch_PublishesAcceptedEvents(t *testing.T) {
+	published := &recordingSink{}
+	app, lineItemRepo, _ := setupTrackingBatchTest(t, service.WithEventSink(published))
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	event := testutil.CreateTestTrackingEvent(lineItem.ID)
+	event.EventID = "evt_publish_1"
+	missing := testutil.CreateTestTrackingEvent("li_missing")
+
+	// The second delivery of the batch is all duplicates and must not be published again
+	for i := 0; i < 2; i++ {
+		body, _ := json.Marshal(model.TrackingEventBatch{Events: []model.TrackingEvent{event, event, missing}})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	}
+
+	if assert.Len(t, published.events, 1) {
+		assert.Equal(t, "evt_publish_1", published.events[0].EventID)
+		assert.InDelta(t, lineItem.Bid/1000, published.events[0].Cost, 1e-9)
+	}
+}
+
+func TestTrackingHandler_TrackBatch_TooLarge(t *testing.T) {
+	app, _, _ := setupTrackingBatchTest(t, service.WithMaxBatchSize(1))
+
+	events := []model.TrackingEvent{
+		testutil.CreateTestTrackingEvent("li_1"),
+		testutil.CreateTestTrackingEvent("li_2"),
+	}
+	body, _ := json.Marshal(model.TrackingEventBatch{Events: events})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestTrackingHandler_TrackBatch_Empty(t *testing.T) {
+	app, _, _ := setupTrackingBatchTest(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader([]byte(`{"events":[]}`)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackingHandler_TrackEvent_Async(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger)
+	pipeline := ingest.NewPipeline(ingest.Config{QueueSize: 1, Workers: 1, BatchSize: 10, FlushInterval: time.Millisecond}, trackingService, logger)
+	handler := NewTrackingHandler(trackingService, logger, WithIngestPipeline(pipeline))
+	app.Post("/api/v1/tracking", handler.TrackEvent)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	send := func(lineItemID string) *http.Response {
+		body, _ := json.Marshal(testutil.CreateTestTrackingEvent(lineItemID))
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// Unknown line items are rejected before they take a place in the queue
+	assert.Equal(t, http.StatusNotFound, send("li_unknown").StatusCode)
+
+	// Workers are not running yet, so the second event finds the queue full
+	assert.Equal(t, http.StatusAccepted, send(lineItem.ID).StatusCode)
+	resp := send(lineItem.ID)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	pipeline.Start()
+	assert.NoError(t, pipeline.Shutdown(context.Background()))
+
+	events, err := trackingRepo.FindAll(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestTrackingHandler_TrackBatch_AsyncUnknownLineItem(t *testing.T) {
+	app := testutil.SetupTestApp(t)
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	logger := testutil.GetTestLogger()
+
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger)
+	pipeline := ingest.NewPipeline(ingest.Config{QueueSize: 10, Workers: 1, BatchSize: 10, FlushInterval: time.Millisecond}, trackingService, logger)
+	handler := NewTrackingHandler(trackingService, logger, WithIngestPipeline(pipeline))
+	app.Post("/api/v1/tracking/batch", handler.TrackBatch)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	assert.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	body, _ := json.Marshal(model.TrackingEventBatch{Events: []model.TrackingEvent{
+		testutil.CreateTestTrackingEvent(lineItem.ID),
+		testutil.CreateTestTrackingEvent("li_unknown"),
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var result struct {
+		Accepted int                         `json:"accepted"`
+		Rejected int                         `json:"rejected"`
+		Results  []trackingBatchItemResponse `json:"results"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 1, result.Accepted)
+	assert.Equal(t, 1, result.Rejected)
+	assert.Equal(t, batchStatusAccepted, result.Results[0].Status)
+	assert.Equal(t, batchStatusRejected, result.Results[1].Status)
+	if assert.NotNil(t, result.Results[1].Error) {
+		assert.Equal(t, "line item not found", result.Results[1].Error.Reason)
+	}
+	assert.Equal(t, uint64(1), pipeline.Stats().Enqueued, "only the known line item's event is queued")
+}
+
+func TestTrackingHandler_InvalidTraffic(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	filter := ivt.NewFilter(ivt.Config{
+		ClickWithoutImpression: true,
+		ImpressionWindow:       time.Hour,
+		BotUserAgents:          []string{"bot"},
+	}, trackingRepo, ratelimit.NewMemoryStore())
+
+	app := testutil.SetupTestApp(t)
+	advertiserID := "adv_123"
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.WithPrincipal(c.UserContext(), &auth.Principal{
+			ID:           "key_adv",
+			Role:         model.RoleAdvertiser,
+			AdvertiserID: advertiserID,
+		}))
+		return c.Next()
+	})
+	logger := testutil.GetTestLogger()
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger, service.WithIVTFilter(filter))
+	handler := NewTrackingHandler(trackingService, logger)
+	app.Post("/api/v1/tracking/batch", handler.TrackBatch)
+	app.Get("/api/v1/lineitems/:id/invalid-traffic", handler.InvalidTrafficReport)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	impression := testutil.CreateTestTrackingEvent(lineItem.ID)
+	botImpression := testutil.CreateTestTrackingEvent(lineItem.ID)
+	botImpression.UserAgent = "Googlebot/2.1"
+	orphanClick := testutil.CreateTestTrackingEvent(lineItem.ID)
+	orphanClick.EventType = model.TrackingEventTypeClick
+	orphanClick.UserID = "user_456"
+
+	body, _ := json.Marshal(model.TrackingEventBatch{Events: []model.TrackingEvent{impression, botImpression, orphanClick}})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var batch struct {
+		Accepted int `json:"accepted"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Equal(t, 3, batch.Accepted, "invalid traffic is accepted without telling the sender")
+
+	stored, err := lineItemRepo.GetByID(t.Context(), lineItem.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, lineItem.Bid/1000, stored.DailySpending, 1e-9, "only the valid impression is charged")
+
+	counts, err := trackingService.GetEventCounts(t.Context(), lineItem.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 1}, counts)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems/"+lineItem.ID+"/invalid-traffic", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var report model.InvalidTrafficReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, model.TrafficCounts{Impressions: 1}, report.Valid)
+	assert.Equal(t, model.TrafficCounts{Impressions: 1, Clicks: 1}, report.Invalid)
+	assert.InDelta(t, 2.0/3, report.InvalidRate, 1e-9)
+	assert.Equal(t, []model.InvalidTrafficReason{
+		{Reason: string(ivt.ReasonBotUserAgent), TrafficCounts: model.TrafficCounts{Impressions: 1}},
+		{Reason: string(ivt.ReasonClickWithoutImpression), TrafficCounts: model.TrafficCounts{Clicks: 1}},
+	}, report.Reasons)
+
+	// Reports of other advertisers' line items are not found
+	advertiserID = "adv_other"
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/lineitems/"+lineItem.ID+"/invalid-traffic", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTrackingHandler_InvalidTraffic_RequestClient(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	filter := ivt.NewFilter(ivt.Config{
+		MaxEventsPerIP: 2,
+		EventWindow:    time.Hour,
+		BotUserAgents:  []string{"bot"},
+	}, trackingRepo, ratelimit.NewMemoryStore())
+
+	app := fiber.New(fiber.Config{ProxyHeader: "X-Forwarded-For"})
+	logger := testutil.GetTestLogger()
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	// A zero-length window sends redeliveries to storage, where they are found to be duplicates
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger,
+		service.WithIVTFilter(filter), service.WithDedupeWindow(0, 1))
+	app.Post("/api/v1/tracking", NewTrackingHandler(trackingService, logger).TrackEvent)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	track := func(eventID, userAgent string) *model.TrackingEventEntity {
+		t.Helper()
+		event := testutil.CreateTestTrackingEvent(lineItem.ID)
+		event.EventID = eventID
+		body, _ := json.Marshal(event)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tracking", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		stored, err := trackingRepo.FindByEventID(t.Context(), eventID)
+		require.NoError(t, err)
+		return stored
+	}
+
+	first := track("evt_client_1", "Mozilla/5.0")
+	assert.Empty(t, first.InvalidReason)
+	assert.Equal(t, "203.0.113.7", first.IP, "the IP defaults to the request's")
+	assert.Equal(t, "Mozilla/5.0", first.UserAgent, "the user agent defaults to the request's")
+
+	// Redeliveries are not stored again and don't count towards the IP's events
+	for range 3 {
+		track("evt_client_1", "Mozilla/5.0")
+	}
+	assert.Empty(t, track("evt_client_2", "Mozilla/5.0").InvalidReason)
+	assert.Equal(t, string(ivt.ReasonExcessiveIPEvents), track("evt_client_3", "Mozilla/5.0").InvalidReason)
+	assert.Equal(t, string(ivt.ReasonBotUserAgent), track("evt_client_4", "Googlebot/2.1").InvalidReason)
+}
+
+func TestTrackingHandler_InvalidTraffic_NoClientIP(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	filter := ivt.NewFilter(ivt.Config{
+		MaxEventsPerIP: 2,
+		EventWindow:    time.Hour,
+	}, trackingRepo, ratelimit.NewMemoryStore())
+
+	// Without a proxy header the request IP may be a load balancer's or a publisher server's
+	app := testutil.SetupTestApp(t)
+	logger := testutil.GetTestLogger()
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger, service.WithIVTFilter(filter))
+	handler := NewTrackingHandler(trackingService, logger)
+	app.Post("/api/v1/tracking", handler.TrackEvent)
+	app.Post("/api/v1/tracking/batch", handler.TrackBatch)
+
+	lineItem := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), lineItem))
+
+	post := func(target string, body any) {
+		t.Helper()
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Less(t, resp.StatusCode, 300)
+	}
+
+	var batch []model.TrackingEvent
+	for i := range 10 {
+		event := testutil.CreateTestTrackingEvent(lineItem.ID)
+		event.EventID = fmt.Sprintf("evt_relayed_%d", i)
+		event.UserID = fmt.Sprintf("user_%d", i)
+		if i < 5 {
+			post("/api/v1/tracking", event)
+		} else {
+			batch = append(batch, event)
+		}
+	}
+	post("/api/v1/tracking/batch", model.TrackingEventBatch{Events: batch})
+
+	events, err := trackingRepo.FindAll(t.Context())
+	require.NoError(t, err)
+	require.Len(t, events, 10)
+	for _, event := range events {
+		assert.Empty(t, event.IP)
+		assert.Empty(t, event.InvalidReason, "events of many users relayed from one address are not excessive")
+	}
+}
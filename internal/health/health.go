@@ -0,0 +1,149 @@
+// Package health runs the dependency checks behind the service's readiness endpoint and
+// reports the version and build the service is running.
+package health
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statuses of the service and of each dependency
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Check reports whether a dependency is usable, returning the reason when it is not
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of one dependency check
+type CheckResult struct {
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency_ns"`
+}
+
+// BuildInfo identifies the binary that is running
+type BuildInfo struct {
+	GoVersion string `json:"go_version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// Report is the status of the service together with the result of every check
+type Report struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version"`
+	Build   BuildInfo              `json:"build"`
+	Checks  map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the registered dependency checks concurrently, each bounded by a timeout
+type Checker struct {
+	version string
+	build   BuildInfo
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewChecker creates a Checker reporting version and the build info embedded in the binary.
+// A timeout of zero leaves checks bounded only by the context they are run with.
+func NewChecker(version string, timeout time.Duration) *Checker {
+	return &Checker{
+		version: version,
+		build:   readBuildInfo(),
+		timeout: timeout,
+	}
+}
+
+// Register adds a check reported under name; the service is ready only while every
+// registered check passes
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Live reports the version and build without running any checks; a process that can
+// answer is alive
+func (c *Checker) Live() Report {
+	return Report{Status: StatusOK, Version: c.version, Build: c.build}
+}
+
+// Ready runs every check and reports the service unavailable if any of them fails
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check.check)
+		}()
+	}
+	wg.Wait()
+
+	report := c.Live()
+	report.Checks = make(map[string]CheckResult, len(checks))
+	for i, check := range checks {
+		report.Checks[check.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{Status: StatusOK, Latency: time.Since(start)}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// readBuildInfo reads the Go version and the VCS stamp recorded by go build
+func readBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}
+	}
+
+	build := BuildInfo{GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.BuildTime = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
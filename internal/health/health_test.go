@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Ready(t *testing.T) {
+	checker := NewChecker("1.2.3", 20*time.Millisecond)
+	checker.Register("database", func(ctx context.Context) error { return nil })
+
+	report := checker.Ready(t.Context())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, "1.2.3", report.Version)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	checker.Register("scheduler", func(ctx context.Context) error { return errors.New("scheduler not running") })
+	checker.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report = checker.Ready(t.Context())
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+	assert.Equal(t, StatusUnavailable, report.Checks["scheduler"].Status)
+	assert.Equal(t, "scheduler not running", report.Checks["scheduler"].Error)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error, "checks are bounded by the timeout")
+}
+
+func TestChecker_Live(t *testing.T) {
+	checker := NewChecker("1.2.3", time.Second)
+	checker.Register("database", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	report := checker.Live()
+	assert.Equal(t, StatusOK, report.Status, "liveness doesn't depend on dependencies")
+	assert.Empty(t, report.Checks)
+	assert.NotEmpty(t, report.Build.GoVersion)
+}
@@ -0,0 +1,494 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+	"sweng-task/internal/wal"
+)
+
+var (
+	ErrQueueFull      = errors.New("ingestion queue full")
+	ErrPipelineClosed = errors.New("ingestion pipeline closed")
+
+	errReplayStopped = errors.New("replay stopped by shutdown")
+)
+
+const (
+	maxProcessAttempts = 3
+	// defaultRedriveBackoff and defaultMaxRedriveBackoff bound the delay between re-drives
+	// of a failed batch when the Config leaves them unset
+	defaultRedriveBackoff    = time.Second
+	defaultMaxRedriveBackoff = time.Minute
+)
+
+// Processor persists a batch of tracking events. It is satisfied by service.TrackingService.
+type Processor interface {
+	TrackBatch(ctx context.Context, events []model.TrackingEvent) ([]service.BatchItemResult, error)
+}
+
+// Config controls the size and batching behaviour of a Pipeline
+type Config struct {
+	QueueSize     int
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+	// ProcessTimeout bounds each attempt to persist a batch; zero means no deadline
+	ProcessTimeout time.Duration
+	// WAL, when set, records events durably before Enqueue returns
+	WAL *wal.Log
+	// RedriveBackoff is the delay before a batch kept in the WAL after failing all attempts is
+	// processed again, doubling after every failure up to MaxRedriveBackoff
+	RedriveBackoff    time.Duration
+	MaxRedriveBackoff time.Duration
+}
+
+// Stats is a point-in-time snapshot of pipeline health. Redriving is the number of events of
+// failed batches waiting to be processed again.
+type Stats struct {
+	Depth      int           `json:"depth"`
+	Capacity   int           `json:"capacity"`
+	Lag        time.Duration `json:"lag_ns"`
+	Enqueued   uint64        `json:"enqueued"`
+	Processed  uint64        `json:"processed"`
+	Rejected   uint64        `json:"rejected"`
+	Failed     uint64        `json:"failed"`
+	Redriving  uint64        `json:"redriving"`
+	WALBacklog uint64        `json:"wal_backlog"`
+}
+
+type queuedEvent struct {
+	event      model.TrackingEvent
+	seq        uint64
+	enqueuedAt time.Time
+}
+
+// Pipeline accepts tracking events into a bounded in-memory queue and persists them
+// in batches from a pool of workers, decoupling request latency from database latency.
+type Pipeline struct {
+	cfg       Config
+	processor Processor
+	log       *zap.SugaredLogger
+
+	mu      sync.RWMutex
+	started bool
+	closed  bool
+	queue   chan queuedEvent
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	// failedBatches hands batches that failed all attempts to the re-drive loop, which
+	// stops retrying once stopping is closed and is done when redriveDone is closed
+	failedBatches chan []queuedEvent
+	redriveOnce   sync.Once
+	stopping      chan struct{}
+	redriveDone   chan struct{}
+
+	lag       atomic.Int64
+	enqueued  atomic.Uint64
+	processed atomic.Uint64
+	rejected  atomic.Uint64
+	failed    atomic.Uint64
+	redriving atomic.Uint64
+}
+
+func NewPipeline(cfg Config, processor Processor, log *zap.SugaredLogger) *Pipeline {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.RedriveBackoff <= 0 {
+		cfg.RedriveBackoff = defaultRedriveBackoff
+	}
+	if cfg.MaxRedriveBackoff < cfg.RedriveBackoff {
+		cfg.MaxRedriveBackoff = max(defaultMaxRedriveBackoff, cfg.RedriveBackoff)
+	}
+
+	return &Pipeline{
+		cfg:           cfg,
+		processor:     processor,
+		log:           log,
+		queue:         make(chan queuedEvent, cfg.QueueSize),
+		slots:         make(chan struct{}, cfg.QueueSize),
+		failedBatches: make(chan []queuedEvent, cfg.Workers),
+		stopping:      make(chan struct{}),
+		redriveDone:   make(chan struct{}),
+	}
+}
+
+// Start launches the worker pool, and with a WAL the loop re-driving failed batches and
+// the replay of events left unacknowledged by a previous run
+func (p *Pipeline) Start() {
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	if p.cfg.WAL != nil {
+		go p.redrive()
+		p.wg.Add(1)
+		go p.replay(p.cfg.WAL.LastSeq())
+	} else {
+		close(p.redriveDone)
+	}
+	p.mu.Lock()
+	p.started = true
+	p.mu.Unlock()
+	p.log.Infow("Ingestion pipeline started",
+		"workers", p.cfg.Workers,
+		"queue_size", p.cfg.QueueSize,
+		"batch_size", p.cfg.BatchSize,
+	)
+}
+
+// Enqueue adds an event to the queue without blocking. It returns ErrQueueFull when
+// the queue is at capacity so callers can apply backpressure.
+func (p *Pipeline) Enqueue(event model.TrackingEvent) error {
+	return p.EnqueueBatch([]model.TrackingEvent{event})[0]
+}
+
+// EnqueueBatch enqueues events in order and returns one error per event; events after
+// the queue fills up are rejected individually with ErrQueueFull. With a WAL configured
+// all accepted events are written with a single append.
+func (p *Pipeline) EnqueueBatch(events []model.TrackingEvent) []error {
+	errs := make([]error, len(events))
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		for i := range errs {
+			errs[i] = ErrPipelineClosed
+		}
+		return errs
+	}
+
+	// Replayed events must keep their identity so they are deduplicated, not double counted
+	events = append([]model.TrackingEvent(nil), events...)
+	for i := range events {
+		if events[i].EventID == "" {
+			events[i].EventID = service.NewEventID()
+		}
+	}
+
+	// Reserve queue slots first so an event is never written to the WAL and then rejected
+	reserved := 0
+	for reserved < len(events) && p.reserve() {
+		reserved++
+	}
+	for i := reserved; i < len(events); i++ {
+		errs[i] = ErrQueueFull
+	}
+	if reserved == 0 {
+		return errs
+	}
+
+	var firstSeq uint64
+	if p.cfg.WAL != nil {
+		seq, err := p.cfg.WAL.Append(events[:reserved])
+		if err != nil {
+			p.log.Errorw("Failed to append tracking events to write-ahead log", "error", err)
+			for i := 0; i < reserved; i++ {
+				<-p.slots
+				errs[i] = err
+			}
+			return errs
+		}
+		firstSeq = seq
+	}
+
+	now := time.Now()
+	for i := 0; i < reserved; i++ {
+		var seq uint64
+		if firstSeq > 0 {
+			seq = firstSeq + uint64(i)
+		}
+		p.queue <- queuedEvent{event: events[i], seq: seq, enqueuedAt: now}
+	}
+	p.enqueued.Add(uint64(reserved))
+	return errs
+}
+
+// replay persists events left unacknowledged in the WAL by a previous run, up to record
+// last, alongside the events enqueued since the start. Batches that fail are handed to the
+// re-drive loop, so that the pipeline starts while the database is unavailable; replay
+// stops at shutdown, leaving the events not yet replayed in the WAL for the next start.
+func (p *Pipeline) replay(last uint64) {
+	defer p.wg.Done()
+
+	var batch []queuedEvent
+	replayed := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		select {
+		case <-p.stopping:
+			return errReplayStopped
+		default:
+		}
+		if !p.process(batch) {
+			p.redriving.Add(uint64(len(batch)))
+			p.failedBatches <- batch
+		}
+		replayed += len(batch)
+		batch = nil
+		return nil
+	}
+
+	err := p.cfg.WAL.Replay(func(seq uint64, event model.TrackingEvent) error {
+		// Events enqueued since the start are processed by the workers
+		if seq > last {
+			return nil
+		}
+		batch = append(batch, queuedEvent{event: event, seq: seq, enqueuedAt: time.Now()})
+		if len(batch) >= p.cfg.BatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil && !errors.Is(err, errReplayStopped) {
+		p.log.Errorw("Failed to replay tracking events from write-ahead log", "replayed", replayed, "error", err)
+		return
+	}
+
+	p.log.Infow("Replayed tracking events from write-ahead log", "events", replayed)
+}
+
+// Shutdown stops accepting events and waits for the workers to drain the queue. Batches
+// that still fail are no longer re-driven but left in the WAL for the next start.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+		close(p.stopping)
+	}
+	started := p.started
+	p.mu.Unlock()
+
+	p.log.Infow("Draining ingestion pipeline", "depth", len(p.queue))
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		p.redriveOnce.Do(func() { close(p.failedBatches) })
+		if started {
+			<-p.redriveDone
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.log.Info("Ingestion pipeline drained")
+		return nil
+	case <-ctx.Done():
+		p.log.Errorw("Ingestion pipeline drain timed out", "remaining", len(p.queue))
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current queue depth, lag of the most recently processed batch and counters
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Depth:      len(p.queue),
+		Capacity:   cap(p.queue),
+		Lag:        time.Duration(p.lag.Load()),
+		Enqueued:   p.enqueued.Load(),
+		Processed:  p.processed.Load(),
+		Rejected:   p.rejected.Load(),
+		Failed:     p.failed.Load(),
+		Redriving:  p.redriving.Load(),
+		WALBacklog: p.walBacklog(),
+	}
+}
+
+// CheckBacklog reports an error once the pipeline stops accepting events, or its queue is
+// fuller than maxUsage of its capacity and new events are likely to be rejected
+func (p *Pipeline) CheckBacklog(maxUsage float64) error {
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+	if closed {
+		return ErrPipelineClosed
+	}
+
+	depth, capacity := len(p.queue), cap(p.queue)
+	if capacity > 0 && float64(depth) > maxUsage*float64(capacity) {
+		return fmt.Errorf("ingestion queue backlog %d of %d exceeds %.0f%% of capacity", depth, capacity, maxUsage*100)
+	}
+	return nil
+}
+
+func (p *Pipeline) walBacklog() uint64 {
+	if p.cfg.WAL == nil {
+		return 0
+	}
+	return p.cfg.WAL.Backlog()
+}
+
+func (p *Pipeline) reserve() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pipeline) worker() {
+	defer p.wg.Done()
+
+	for {
+		first, ok := p.take()
+		if !ok {
+			return
+		}
+		batch := p.collect(first)
+		if !p.process(batch) && p.cfg.WAL != nil {
+			// Blocks while the re-drive loop is behind, so that the queue fills up and
+			// Enqueue applies backpressure instead of failed batches piling up in memory
+			p.redriving.Add(uint64(len(batch)))
+			p.failedBatches <- batch
+		}
+	}
+}
+
+// redrive processes the batches that failed all attempts again, one at a time and in the
+// order they failed, backing off exponentially between attempts until they succeed. The
+// WAL can't acknowledge or compact the records after a failed batch until it succeeds.
+func (p *Pipeline) redrive() {
+	defer close(p.redriveDone)
+
+	for batch := range p.failedBatches {
+		p.redriveBatch(batch)
+		p.redriving.Add(^uint64(len(batch) - 1))
+	}
+}
+
+func (p *Pipeline) redriveBatch(batch []queuedEvent) {
+	backoff := p.cfg.RedriveBackoff
+	for {
+		select {
+		case <-p.stopping:
+			return
+		case <-time.After(backoff):
+		}
+
+		if p.process(batch) {
+			p.log.Infow("Re-drove failed tracking batch", "size", len(batch))
+			return
+		}
+		backoff = min(2*backoff, p.cfg.MaxRedriveBackoff)
+		p.log.Warnw("Re-drive of tracking batch failed", "size", len(batch), "next_attempt_in", backoff)
+	}
+}
+
+// take receives the next queued event and frees its slot
+func (p *Pipeline) take() (queuedEvent, bool) {
+	item, ok := <-p.queue
+	if ok {
+		<-p.slots
+	}
+	return item, ok
+}
+
+// collect fills a batch starting with first until it is full, the flush interval
+// elapses or the queue is closed
+func (p *Pipeline) collect(first queuedEvent) []queuedEvent {
+	batch := []queuedEvent{first}
+	timer := time.NewTimer(p.cfg.FlushInterval)
+	defer timer.Stop()
+
+	for len(batch) < p.cfg.BatchSize {
+		select {
+		case item, ok := <-p.queue:
+			if !ok {
+				return batch
+			}
+			<-p.slots
+			batch = append(batch, item)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (p *Pipeline) trackBatch(events []model.TrackingEvent) ([]service.BatchItemResult, error) {
+	ctx := context.Background()
+	if p.cfg.ProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.ProcessTimeout)
+		defer cancel()
+	}
+	return p.processor.TrackBatch(ctx, events)
+}
+
+// process persists a batch with retries and acknowledges it in the WAL. Events of a
+// batch that still fails are left in the WAL to be re-driven, or replayed on the next start.
+func (p *Pipeline) process(batch []queuedEvent) bool {
+	p.lag.Store(int64(time.Since(batch[0].enqueuedAt)))
+
+	events := make([]model.TrackingEvent, len(batch))
+	seqs := make([]uint64, 0, len(batch))
+	for i, item := range batch {
+		events[i] = item.event
+		if item.seq > 0 {
+			seqs = append(seqs, item.seq)
+		}
+	}
+
+	var results []service.BatchItemResult
+	var err error
+	for attempt := 1; attempt <= maxProcessAttempts; attempt++ {
+		results, err = p.trackBatch(events)
+		if err == nil {
+			break
+		}
+		p.log.Warnw("Failed to process tracking batch", "size", len(events), "attempt", attempt, "error", err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+
+	if err != nil {
+		p.failed.Add(uint64(len(events)))
+		if p.cfg.WAL != nil {
+			p.log.Errorw("Tracking batch failed after retries, keeping it in write-ahead log", "size", len(events), "error", err)
+		} else {
+			p.log.Errorw("Dropping tracking batch after retries", "size", len(events), "error", err)
+		}
+		return false
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			p.rejected.Add(1)
+			p.log.Warnw("Tracking event rejected",
+				"event_id", events[i].EventID,
+				"line_item_id", events[i].LineItemID,
+				"error", result.Err,
+			)
+			continue
+		}
+		p.processed.Add(1)
+	}
+
+	if p.cfg.WAL != nil && len(seqs) > 0 {
+		if err := p.cfg.WAL.Ack(seqs...); err != nil {
+			p.log.Errorw("Failed to acknowledge tracking events in write-ahead log", "error", err)
+		}
+	}
+	return true
+}
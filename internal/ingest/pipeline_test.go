@@ -0,0 +1,219 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+	"sweng-task/internal/testutil"
+	"sweng-task/internal/wal"
+)
+
+type recordingProcessor struct {
+	mu      sync.Mutex
+	batches [][]model.TrackingEvent
+	failFor int
+}
+
+func (p *recordingProcessor) TrackBatch(ctx context.Context, events []model.TrackingEvent) ([]service.BatchItemResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failFor > 0 {
+		p.failFor--
+		return nil, errors.New("database unavailable")
+	}
+
+	p.batches = append(p.batches, events)
+	results := make([]service.BatchItemResult, len(events))
+	for i, e := range events {
+		if e.LineItemID == "li_missing" {
+			results[i].Err = service.ErrLineItemNotFound
+			continue
+		}
+		results[i].Result = &model.TrackingResult{EventID: e.EventID}
+	}
+	return results, nil
+}
+
+func (p *recordingProcessor) eventCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, b := range p.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestPipeline_BatchesAndDrainsOnShutdown(t *testing.T) {
+	processor := &recordingProcessor{}
+	p := NewPipeline(Config{QueueSize: 100, Workers: 2, BatchSize: 10, FlushInterval: time.Hour}, processor, testutil.GetTestLogger())
+	p.Start()
+
+	for i := 0; i < 25; i++ {
+		assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	}
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_missing")))
+
+	assert.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, 26, processor.eventCount())
+
+	for _, batch := range processor.batches {
+		assert.LessOrEqual(t, len(batch), 10)
+	}
+
+	stats := p.Stats()
+	assert.Equal(t, 0, stats.Depth)
+	assert.Equal(t, uint64(26), stats.Enqueued)
+	assert.Equal(t, uint64(25), stats.Processed)
+	assert.Equal(t, uint64(1), stats.Rejected)
+
+	assert.ErrorIs(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")), ErrPipelineClosed)
+}
+
+func TestPipeline_QueueFull(t *testing.T) {
+	p := NewPipeline(Config{QueueSize: 1, Workers: 1, BatchSize: 1}, &recordingProcessor{}, testutil.GetTestLogger())
+
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	assert.ErrorIs(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")), ErrQueueFull)
+
+	errs := p.EnqueueBatch([]model.TrackingEvent{testutil.CreateTestTrackingEvent("li_1")})
+	assert.ErrorIs(t, errs[0], ErrQueueFull)
+	assert.Equal(t, 1, p.Stats().Depth)
+}
+
+func TestPipeline_CheckBacklog(t *testing.T) {
+	p := NewPipeline(Config{QueueSize: 4, Workers: 1, BatchSize: 1}, &recordingProcessor{}, testutil.GetTestLogger())
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	}
+	assert.NoError(t, p.CheckBacklog(0.75))
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	assert.Error(t, p.CheckBacklog(0.75))
+
+	p.Start()
+	assert.NoError(t, p.Shutdown(t.Context()))
+	assert.ErrorIs(t, p.CheckBacklog(0.75), ErrPipelineClosed)
+}
+
+func TestPipeline_RetriesFailedBatch(t *testing.T) {
+	processor := &recordingProcessor{failFor: 1}
+	p := NewPipeline(Config{QueueSize: 10, Workers: 1, BatchSize: 5, FlushInterval: time.Millisecond}, processor, testutil.GetTestLogger())
+	p.Start()
+
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	assert.NoError(t, p.Shutdown(context.Background()))
+
+	assert.Equal(t, 1, processor.eventCount())
+	assert.Equal(t, uint64(0), p.Stats().Failed)
+}
+
+func TestPipeline_RedrivesFailedBatch(t *testing.T) {
+	logger := testutil.GetTestLogger()
+	eventLog, err := wal.Open(t.TempDir(), wal.Options{Sync: wal.SyncAlways}, logger)
+	assert.NoError(t, err)
+	defer eventLog.Close()
+
+	// The database is down for every attempt of the first batch
+	processor := &recordingProcessor{failFor: maxProcessAttempts}
+	p := NewPipeline(Config{
+		QueueSize:      10,
+		Workers:        1,
+		BatchSize:      1,
+		FlushInterval:  time.Millisecond,
+		WAL:            eventLog,
+		RedriveBackoff: 10 * time.Millisecond,
+	}, processor, logger)
+	p.Start()
+
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	assert.Eventually(t, func() bool { return p.Stats().Failed == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+
+	// Both events are persisted and acknowledged without a restart
+	assert.Eventually(t, func() bool { return eventLog.Backlog() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, processor.eventCount())
+	assert.Zero(t, p.Stats().Redriving)
+	assert.NoError(t, p.Shutdown(context.Background()))
+}
+
+func TestPipeline_ReplaysEventsLeftInWAL(t *testing.T) {
+	dir := t.TempDir()
+	logger := testutil.GetTestLogger()
+
+	eventLog, err := wal.Open(dir, wal.Options{Sync: wal.SyncAlways}, logger)
+	assert.NoError(t, err)
+
+	// The database is down for the whole first run
+	down := &recordingProcessor{failFor: 1000}
+	p := NewPipeline(Config{QueueSize: 10, Workers: 1, BatchSize: 10, FlushInterval: time.Millisecond, WAL: eventLog}, down, logger)
+	p.Start()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+	}
+	assert.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, uint64(3), p.Stats().WALBacklog)
+	assert.NoError(t, eventLog.Close())
+
+	eventLog, err = wal.Open(dir, wal.Options{Sync: wal.SyncAlways}, logger)
+	assert.NoError(t, err)
+	defer eventLog.Close()
+
+	up := &recordingProcessor{}
+	p = NewPipeline(Config{QueueSize: 10, Workers: 1, BatchSize: 2, WAL: eventLog}, up, logger)
+	p.Start()
+	assert.Eventually(t, func() bool { return eventLog.Backlog() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Shutdown(context.Background()))
+
+	assert.Equal(t, 3, up.eventCount())
+	for _, batch := range up.batches {
+		for _, e := range batch {
+			assert.NotEmpty(t, e.EventID)
+		}
+	}
+}
+
+func TestPipeline_StartsWhileReplayFails(t *testing.T) {
+	dir := t.TempDir()
+	logger := testutil.GetTestLogger()
+
+	eventLog, err := wal.Open(dir, wal.Options{Sync: wal.SyncAlways}, logger)
+	assert.NoError(t, err)
+	_, err = eventLog.Append([]model.TrackingEvent{
+		testutil.CreateTestTrackingEvent("li_1"),
+		testutil.CreateTestTrackingEvent("li_1"),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, eventLog.Close())
+
+	eventLog, err = wal.Open(dir, wal.Options{Sync: wal.SyncAlways}, logger)
+	assert.NoError(t, err)
+	defer eventLog.Close()
+
+	// The database is down for every attempt of the replayed batch
+	processor := &recordingProcessor{failFor: maxProcessAttempts}
+	p := NewPipeline(Config{
+		QueueSize:      10,
+		Workers:        1,
+		BatchSize:      10,
+		FlushInterval:  time.Millisecond,
+		WAL:            eventLog,
+		RedriveBackoff: 10 * time.Millisecond,
+	}, processor, logger)
+	p.Start()
+	assert.Eventually(t, func() bool { return p.Stats().Failed == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Enqueue(testutil.CreateTestTrackingEvent("li_1")))
+
+	// The failed replay is re-driven, and events enqueued meanwhile are not replayed again
+	assert.Eventually(t, func() bool { return eventLog.Backlog() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, 3, processor.eventCount())
+}
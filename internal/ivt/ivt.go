@@ -0,0 +1,440 @@
+// Package ivt detects invalid traffic: tracking events generated by bots, click farms and
+// misbehaving integrations rather than by users engaging with ads. A Filter applies
+// configurable rules to each event; events it flags are stored with the reason but neither
+// charged nor counted for bid estimation.
+package ivt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"sweng-task/internal/cache"
+	"sweng-task/internal/model"
+	"sweng-task/internal/ratelimit"
+	"sweng-task/internal/repository"
+)
+
+// Reason is why an event was flagged as invalid traffic
+type Reason string
+
+const (
+	// ReasonClickWithoutImpression flags clicks by users shown no impression of the line item
+	// within the impression window
+	ReasonClickWithoutImpression Reason = "click_without_impression"
+	// ReasonClickTooSoon flags clicks faster after the impression than a person can react
+	ReasonClickTooSoon Reason = "click_too_soon"
+	// ReasonExcessiveUserEvents and ReasonExcessiveIPEvents flag events beyond the rate a
+	// single user or client IP produces when browsing
+	ReasonExcessiveUserEvents Reason = "excessive_user_events"
+	ReasonExcessiveIPEvents   Reason = "excessive_ip_events"
+	// ReasonBotUserAgent flags events from user agents of known crawlers and headless browsers
+	ReasonBotUserAgent Reason = "bot_user_agent"
+	// ReasonImpossibleCTR flags clicks of users clicking a larger share of their impressions
+	// than people do
+	ReasonImpossibleCTR Reason = "impossible_ctr"
+)
+
+const (
+	// impressionCacheCapacity bounds the recent impressions remembered to check clicks
+	// without querying the repository
+	impressionCacheCapacity = 100000
+	// ctrSweepInterval is how often the click-through counters of idle users are dropped
+	ctrSweepInterval = time.Minute
+	// maxClockSkew is how far ahead of the server's clock event timestamps may be before they
+	// are taken to be the time the event was checked
+	maxClockSkew = time.Minute
+)
+
+// Config selects the rules a Filter applies. Zero values disable a rule.
+type Config struct {
+	// ClickWithoutImpression flags clicks without an impression of the same line item by the
+	// same user within ImpressionWindow
+	ClickWithoutImpression bool
+	ImpressionWindow       time.Duration
+	// MinClickDelay is the shortest time between an impression and a valid click on it
+	MinClickDelay time.Duration
+	// MaxEventsPerUser and MaxEventsPerIP are the numbers of events a user or client IP may
+	// produce within EventWindow
+	MaxEventsPerUser int
+	MaxEventsPerIP   int
+	EventWindow      time.Duration
+	// BotUserAgents are matched case-insensitively as substrings of the user agent
+	BotUserAgents []string
+	// MaxCTR is the highest click-through rate of a user within CTRWindow, applied once the
+	// user has made MinClicks clicks
+	MaxCTR    float64
+	MinClicks int
+	CTRWindow time.Duration
+}
+
+// ImpressionLookup finds a user's last valid impression of a line item at or before a time,
+// failing with repository.ErrEventNotFound when there is none. It is satisfied by
+// repository.TrackingRepository.
+type ImpressionLookup interface {
+	LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error)
+}
+
+// Filter applies the invalid traffic rules of its Config to tracking events. Rules on users
+// skip events without a user ID, as those can't be attributed. Per user and per IP rates are
+// kept in a ratelimit.Store and the other state in memory, so that each instance judges the
+// traffic it receives.
+type Filter struct {
+	cfg         Config
+	impressions ImpressionLookup
+	rates       ratelimit.Store
+	botAgents   []string
+	userLimit   ratelimit.Limit
+	ipLimit     ratelimit.Limit
+
+	// recent caches the last impression per line item and user to spare the repository the
+	// lookups of clicks following shortly after
+	recent *cache.TTLCache[string, time.Time]
+	ctr    ctrCounters
+	now    func() time.Time
+}
+
+// NewFilter creates a Filter looking up impressions older than its own cache in impressions
+// and keeping event rates in rates
+func NewFilter(cfg Config, impressions ImpressionLookup, rates ratelimit.Store) *Filter {
+	f := &Filter{
+		cfg:         cfg,
+		impressions: impressions,
+		rates:       rates,
+		userLimit:   windowLimit(cfg.MaxEventsPerUser, cfg.EventWindow),
+		ipLimit:     windowLimit(cfg.MaxEventsPerIP, cfg.EventWindow),
+		ctr:         ctrCounters{counters: make(map[string]*ctrCounter)},
+		now:         time.Now,
+	}
+	if cfg.MaxCTR > 0 {
+		f.ctr.window = cfg.CTRWindow
+	}
+	for _, agent := range cfg.BotUserAgents {
+		if agent = strings.ToLower(strings.TrimSpace(agent)); agent != "" {
+			f.botAgents = append(f.botAgents, agent)
+		}
+	}
+	if cfg.ClickWithoutImpression || cfg.MinClickDelay > 0 {
+		f.recent = cache.NewTTLCache[string, time.Time](max(cfg.ImpressionWindow, cfg.MinClickDelay), impressionCacheCapacity)
+	}
+	return f
+}
+
+// windowLimit spreads n events over window as a token bucket allowing all of them at once
+func windowLimit(n int, window time.Duration) ratelimit.Limit {
+	if n <= 0 || window <= 0 {
+		return ratelimit.Limit{}
+	}
+	return ratelimit.Limit{Rate: float64(n) / window.Seconds(), Burst: n}
+}
+
+// Check returns the reason event is invalid traffic, or an empty reason for valid events.
+// Check doesn't change the filter's state: call Record once the event is stored, so that
+// duplicates and retries of an event are not counted again. An error means a rule could not
+// be applied; the event should then be treated as valid.
+func (f *Filter) Check(ctx context.Context, event model.TrackingEvent) (Reason, error) {
+	return f.check(ctx, event, newPending())
+}
+
+// CheckBatch checks events in order like Check, taking the earlier events of the batch into
+// account as if they were recorded. Clicks are judged against the impressions stored so far
+// and those anywhere in the batch, since a client may send a click ahead of its impression;
+// nothing waits for impressions still to come, so that floods of clicks without impressions
+// don't hold up ingestion. Events whose rules could not be applied are treated as valid and
+// their errors joined.
+func (f *Filter) CheckBatch(ctx context.Context, events []model.TrackingEvent) ([]Reason, error) {
+	p := newPending()
+	reasons := make([]Reason, len(events))
+	var errs []error
+	var unmatched []int
+	for i, event := range events {
+		reason, err := f.check(ctx, event, p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		reasons[i] = reason
+		p.record(f, event, reason)
+		if reason == ReasonClickWithoutImpression {
+			unmatched = append(unmatched, i)
+		}
+	}
+
+	// Clicks followed in the batch by an impression of the same line item and user are
+	// checked again
+	for _, i := range unmatched {
+		if _, ok := p.impressions[impressionKey(events[i].LineItemID, events[i].UserID)]; !ok {
+			continue
+		}
+		reason, err := f.checkClick(ctx, events[i], f.at(events[i]), p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reasons[i] = reason
+		if reason == "" || reason == ReasonImpossibleCTR {
+			p.ctr[events[i].UserID] = p.ctr[events[i].UserID].add(0, 1)
+		}
+	}
+	return reasons, errors.Join(errs...)
+}
+
+// Record remembers an event stored with reason, the outcome of checking it: it counts
+// towards the per user and per IP rates, and valid impressions and clicks are remembered for
+// checking the user's later clicks
+func (f *Filter) Record(ctx context.Context, event model.TrackingEvent, reason Reason) error {
+	if reason == ReasonBotUserAgent {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range f.rateKeys(event) {
+		if _, err := f.rates.Take(ctx, r.key, r.limit); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	at := f.at(event)
+	switch {
+	case event.UserID == "":
+	case event.EventType == model.TrackingEventTypeImpression && reason == "":
+		if f.recent != nil {
+			f.recent.Set(impressionKey(event.LineItemID, event.UserID), at)
+		}
+		f.ctr.add(event.UserID, f.now(), 1, 0)
+	case event.EventType == model.TrackingEventTypeClick && (reason == "" || reason == ReasonImpossibleCTR):
+		f.ctr.add(event.UserID, f.now(), 0, 1)
+	}
+	return errors.Join(errs...)
+}
+
+// pending is the state that the events checked earlier in a batch will add once recorded
+type pending struct {
+	takes       map[string]int
+	impressions map[string]time.Time
+	ctr         map[string]ctrCount
+}
+
+type ctrCount struct {
+	impressions int64
+	clicks      int64
+}
+
+func (c ctrCount) add(impressions, clicks int64) ctrCount {
+	return ctrCount{impressions: c.impressions + impressions, clicks: c.clicks + clicks}
+}
+
+func newPending() *pending {
+	return &pending{
+		takes:       make(map[string]int),
+		impressions: make(map[string]time.Time),
+		ctr:         make(map[string]ctrCount),
+	}
+}
+
+// record adds what Record would for event to p
+func (p *pending) record(f *Filter, event model.TrackingEvent, reason Reason) {
+	if reason == ReasonBotUserAgent {
+		return
+	}
+	for _, r := range f.rateKeys(event) {
+		p.takes[r.key]++
+	}
+	if event.UserID == "" {
+		return
+	}
+	switch {
+	case event.EventType == model.TrackingEventTypeImpression && reason == "":
+		key := impressionKey(event.LineItemID, event.UserID)
+		if at := f.at(event); at.After(p.impressions[key]) {
+			p.impressions[key] = at
+		}
+		p.ctr[event.UserID] = p.ctr[event.UserID].add(1, 0)
+	case event.EventType == model.TrackingEventTypeClick && (reason == "" || reason == ReasonImpossibleCTR):
+		p.ctr[event.UserID] = p.ctr[event.UserID].add(0, 1)
+	}
+}
+
+func (f *Filter) check(ctx context.Context, event model.TrackingEvent, p *pending) (Reason, error) {
+	if f.isBot(event.UserAgent) {
+		return ReasonBotUserAgent, nil
+	}
+
+	reason, err := f.checkRates(ctx, event, p)
+	if reason != "" || err != nil {
+		return reason, err
+	}
+
+	if event.EventType != model.TrackingEventTypeClick || event.UserID == "" {
+		return "", nil
+	}
+	return f.checkClick(ctx, event, f.at(event), p)
+}
+
+// at returns the time event happened, defaulting to now. Timestamps are set by clients, so
+// those further in the future than maxClockSkew are clamped to now.
+func (f *Filter) at(event model.TrackingEvent) time.Time {
+	now := f.now()
+	if event.Timestamp.IsZero() || event.Timestamp.Sub(now) > maxClockSkew {
+		return now
+	}
+	return event.Timestamp
+}
+
+func (f *Filter) isBot(userAgent string) bool {
+	if userAgent == "" || len(f.botAgents) == 0 {
+		return false
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range f.botAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+type rateKey struct {
+	key    string
+	limit  ratelimit.Limit
+	reason Reason
+}
+
+// rateKeys returns the rate buckets event counts towards
+func (f *Filter) rateKeys(event model.TrackingEvent) []rateKey {
+	var keys []rateKey
+	if f.ipLimit.Enabled() && event.IP != "" {
+		keys = append(keys, rateKey{key: "ivt:ip:" + event.IP, limit: f.ipLimit, reason: ReasonExcessiveIPEvents})
+	}
+	if f.userLimit.Enabled() && event.UserID != "" {
+		keys = append(keys, rateKey{key: "ivt:user:" + event.UserID, limit: f.userLimit, reason: ReasonExcessiveUserEvents})
+	}
+	return keys
+}
+
+func (f *Filter) checkRates(ctx context.Context, event model.TrackingEvent, p *pending) (Reason, error) {
+	for _, r := range f.rateKeys(event) {
+		decision, err := f.rates.Peek(ctx, r.key, r.limit)
+		if err != nil {
+			return "", err
+		}
+		if !decision.Allowed || decision.Remaining-p.takes[r.key] < 1 {
+			return r.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// checkClick applies the rules on the impression a click follows and on the user's CTR
+func (f *Filter) checkClick(ctx context.Context, event model.TrackingEvent, at time.Time, p *pending) (Reason, error) {
+	if reason, err := f.checkImpression(ctx, event, at, p); reason != "" || err != nil {
+		return reason, err
+	}
+
+	if f.ctr.window <= 0 {
+		return "", nil
+	}
+	counts := f.ctr.get(event.UserID, f.now()).add(0, 1)
+	counts = counts.add(p.ctr[event.UserID].impressions, p.ctr[event.UserID].clicks)
+	if counts.clicks >= int64(f.cfg.MinClicks) && float64(counts.clicks) > f.cfg.MaxCTR*float64(counts.impressions) {
+		return ReasonImpossibleCTR, nil
+	}
+	return "", nil
+}
+
+// checkImpression applies the rules on the impression a click follows
+func (f *Filter) checkImpression(ctx context.Context, event model.TrackingEvent, at time.Time, p *pending) (Reason, error) {
+	if f.recent == nil {
+		return "", nil
+	}
+
+	key := impressionKey(event.LineItemID, event.UserID)
+	impression, ok := f.recent.Get(key)
+	if batched, found := p.impressions[key]; found && !batched.After(at) && (!ok || batched.After(impression)) {
+		impression, ok = batched, true
+	}
+	if !ok || impression.After(at) {
+		var err error
+		impression, err = f.impressions.LastImpression(ctx, event.LineItemID, event.UserID, at)
+		switch {
+		case errors.Is(err, repository.ErrEventNotFound):
+			if f.cfg.ClickWithoutImpression {
+				return ReasonClickWithoutImpression, nil
+			}
+			return "", nil
+		case err != nil:
+			return "", err
+		}
+	}
+
+	if f.cfg.ClickWithoutImpression && f.cfg.ImpressionWindow > 0 && at.Sub(impression) > f.cfg.ImpressionWindow {
+		return ReasonClickWithoutImpression, nil
+	}
+	if at.Sub(impression) < f.cfg.MinClickDelay {
+		return ReasonClickTooSoon, nil
+	}
+	return "", nil
+}
+
+func impressionKey(lineItemID, userID string) string {
+	return lineItemID + "\x00" + userID
+}
+
+// ctrCounters counts each user's impressions and valid clicks over fixed windows of the time
+// they are recorded at, rather than of their timestamps, so that clients can't move events
+// out of a window
+type ctrCounters struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	counters  map[string]*ctrCounter
+	lastSweep time.Time
+}
+
+type ctrCounter struct {
+	start       time.Time
+	impressions int64
+	clicks      int64
+}
+
+// get returns the counts of userID in the window current at time at
+func (c *ctrCounters) get(userID string, at time.Time) ctrCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[userID]
+	if !ok || at.Sub(counter.start) >= c.window {
+		return ctrCount{}
+	}
+	return ctrCount{impressions: counter.impressions, clicks: counter.clicks}
+}
+
+// add records impressions and clicks of userID at time at and returns the user's counts in
+// the current window. Counting is disabled without a window.
+func (c *ctrCounters) add(userID string, at time.Time, impressions, clicks int64) (int64, int64) {
+	if c.window <= 0 {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if at.Sub(c.lastSweep) >= ctrSweepInterval {
+		for id, counter := range c.counters {
+			if at.Sub(counter.start) >= c.window {
+				delete(c.counters, id)
+			}
+		}
+		c.lastSweep = at
+	}
+
+	counter, ok := c.counters[userID]
+	if !ok || at.Sub(counter.start) >= c.window {
+		counter = &ctrCounter{start: at}
+		c.counters[userID] = counter
+	}
+	counter.impressions += impressions
+	counter.clicks += clicks
+	return counter.impressions, counter.clicks
+}
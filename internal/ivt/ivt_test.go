@@ -0,0 +1,265 @@
+package ivt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/ratelimit"
+	"sweng-task/internal/repository"
+)
+
+// impressionLog is an ImpressionLookup over a fixed set of impressions per line item and user
+type impressionLog struct {
+	impressions map[string]time.Time
+	err         error
+	lookups     int
+}
+
+func (l *impressionLog) LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error) {
+	l.lookups++
+	if l.err != nil {
+		return time.Time{}, l.err
+	}
+	at, ok := l.impressions[impressionKey(lineItemID, userID)]
+	if !ok || at.After(before) {
+		return time.Time{}, repository.ErrEventNotFound
+	}
+	return at, nil
+}
+
+func testConfig() Config {
+	return Config{
+		ClickWithoutImpression: true,
+		ImpressionWindow:       24 * time.Hour,
+		MinClickDelay:          time.Second,
+		MaxEventsPerUser:       100,
+		MaxEventsPerIP:         100,
+		EventWindow:            time.Minute,
+		BotUserAgents:          []string{"bot", " HeadlessChrome "},
+		MaxCTR:                 0.5,
+		MinClicks:              3,
+		CTRWindow:              time.Hour,
+	}
+}
+
+func event(eventType model.TrackingEventType, userID string, at time.Time) model.TrackingEvent {
+	return model.TrackingEvent{
+		EventType:  eventType,
+		LineItemID: "li_1",
+		UserID:     userID,
+		Timestamp:  at,
+		IP:         "203.0.113.7",
+		UserAgent:  "Mozilla/5.0 (X11; Linux x86_64)",
+	}
+}
+
+// checkAndRecord checks e and records it with the outcome, like the tracking service does for
+// newly stored events
+func checkAndRecord(t *testing.T, filter *Filter, e model.TrackingEvent) Reason {
+	t.Helper()
+	reason, err := filter.Check(t.Context(), e)
+	require.NoError(t, err)
+	require.NoError(t, filter.Record(t.Context(), e, reason))
+	return reason
+}
+
+func TestFilter_Clicks(t *testing.T) {
+	now := time.Now()
+	lookup := &impressionLog{impressions: map[string]time.Time{
+		impressionKey("li_1", "stored"): now.Add(-time.Hour),
+		impressionKey("li_1", "stale"):  now.Add(-48 * time.Hour),
+	}}
+	filter := NewFilter(testConfig(), lookup, ratelimit.NewMemoryStore())
+
+	check := func(e model.TrackingEvent) Reason {
+		t.Helper()
+		return checkAndRecord(t, filter, e)
+	}
+
+	assert.Equal(t, ReasonClickWithoutImpression, check(event(model.TrackingEventTypeClick, "unknown", now)))
+	assert.Equal(t, ReasonClickWithoutImpression, check(event(model.TrackingEventTypeClick, "stale", now)))
+	assert.Empty(t, check(event(model.TrackingEventTypeClick, "stored", now)), "impressions are looked up in the repository")
+
+	assert.Empty(t, check(event(model.TrackingEventTypeImpression, "fresh", now)))
+	lookups := lookup.lookups
+	assert.Equal(t, ReasonClickTooSoon, check(event(model.TrackingEventTypeClick, "fresh", now.Add(100*time.Millisecond))))
+	assert.Empty(t, check(event(model.TrackingEventTypeClick, "fresh", now.Add(5*time.Second))))
+	assert.Equal(t, lookups, lookup.lookups, "recent impressions are served from memory")
+
+	assert.Empty(t, check(event(model.TrackingEventTypeClick, "", now)), "anonymous clicks can't be attributed")
+	assert.Empty(t, check(event(model.TrackingEventTypeConversion, "unknown", now)))
+}
+
+func TestFilter_BotUserAgents(t *testing.T) {
+	filter := NewFilter(testConfig(), &impressionLog{}, ratelimit.NewMemoryStore())
+
+	for _, agent := range []string{"Googlebot/2.1", "Mozilla/5.0 HeadlessChrome/120.0"} {
+		e := event(model.TrackingEventTypeImpression, "user-1", time.Now())
+		e.UserAgent = agent
+		reason, err := filter.Check(t.Context(), e)
+		require.NoError(t, err)
+		assert.Equal(t, ReasonBotUserAgent, reason, agent)
+	}
+}
+
+func TestFilter_ExcessiveEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxEventsPerUser = 3
+	cfg.MaxEventsPerIP = 5
+	cfg.EventWindow = time.Hour
+	filter := NewFilter(cfg, &impressionLog{}, ratelimit.NewMemoryStore())
+
+	check := func(userID string) Reason {
+		t.Helper()
+		return checkAndRecord(t, filter, event(model.TrackingEventTypeImpression, userID, time.Now()))
+	}
+
+	// Checking alone, e.g. of a duplicate that is not stored again, counts nothing
+	for range 5 {
+		reason, err := filter.Check(t.Context(), event(model.TrackingEventTypeImpression, "user-1", time.Now()))
+		require.NoError(t, err)
+		assert.Empty(t, reason)
+	}
+
+	for range 3 {
+		assert.Empty(t, check("user-1"))
+	}
+	assert.Equal(t, ReasonExcessiveUserEvents, check("user-1"))
+	assert.Empty(t, check("user-2"))
+	assert.Equal(t, ReasonExcessiveIPEvents, check("user-3"), "users share the events of their IP")
+}
+
+func TestFilter_ImpossibleCTR(t *testing.T) {
+	cfg := testConfig()
+	cfg.MinClickDelay = 0
+	filter := NewFilter(cfg, &impressionLog{}, ratelimit.NewMemoryStore())
+	now := time.Now()
+	clock := now
+	filter.now = func() time.Time { return clock }
+
+	check := func(eventType model.TrackingEventType, at time.Time) Reason {
+		t.Helper()
+		clock = at
+		return checkAndRecord(t, filter, event(eventType, "user-1", at))
+	}
+
+	for i := range 4 {
+		assert.Empty(t, check(model.TrackingEventTypeImpression, now.Add(time.Duration(i)*time.Second)))
+	}
+	assert.Empty(t, check(model.TrackingEventTypeClick, now.Add(10*time.Second)))
+	assert.Empty(t, check(model.TrackingEventTypeClick, now.Add(11*time.Second)), "a CTR of 50% is allowed")
+	assert.Equal(t, ReasonImpossibleCTR, check(model.TrackingEventTypeClick, now.Add(12*time.Second)))
+
+	// Counts start over in the next window
+	later := now.Add(2 * time.Hour)
+	assert.Empty(t, check(model.TrackingEventTypeImpression, later))
+	assert.Empty(t, check(model.TrackingEventTypeClick, later.Add(time.Second)))
+}
+
+func TestFilter_ImpossibleCTRWithForgedTimestamps(t *testing.T) {
+	cfg := testConfig()
+	cfg.MinClickDelay = 0
+	cfg.ClickWithoutImpression = false
+	filter := NewFilter(cfg, &impressionLog{}, ratelimit.NewMemoryStore())
+	now := time.Now()
+
+	for i := range 4 {
+		assert.Empty(t, checkAndRecord(t, filter, event(model.TrackingEventTypeImpression, "user-1", now)), i)
+	}
+	// Clicks dated hours apart still fall into the window they are received in
+	assert.Empty(t, checkAndRecord(t, filter, event(model.TrackingEventTypeClick, "user-1", now.Add(-3*time.Hour))))
+	assert.Empty(t, checkAndRecord(t, filter, event(model.TrackingEventTypeClick, "user-1", now.Add(3*time.Hour))))
+	assert.Equal(t, ReasonImpossibleCTR, checkAndRecord(t, filter, event(model.TrackingEventTypeClick, "user-1", now.Add(6*time.Hour))))
+}
+
+func TestFilter_FutureTimestamps(t *testing.T) {
+	filter := NewFilter(testConfig(), &impressionLog{}, ratelimit.NewMemoryStore())
+	now := time.Now()
+	filter.now = func() time.Time { return now }
+
+	assert.Equal(t, now.Add(maxClockSkew), filter.at(event(model.TrackingEventTypeClick, "user-1", now.Add(maxClockSkew))), "small clock skew is allowed")
+	assert.Equal(t, now, filter.at(event(model.TrackingEventTypeClick, "user-1", now.Add(24*time.Hour))))
+	assert.Equal(t, now.Add(-time.Hour), filter.at(event(model.TrackingEventTypeClick, "user-1", now.Add(-time.Hour))))
+}
+
+func TestFilter_Disabled(t *testing.T) {
+	filter := NewFilter(Config{}, &impressionLog{}, ratelimit.NewMemoryStore())
+
+	e := event(model.TrackingEventTypeClick, "user-1", time.Now())
+	e.UserAgent = "Googlebot/2.1"
+	for range 10 {
+		assert.Empty(t, checkAndRecord(t, filter, e))
+	}
+}
+
+func TestFilter_LookupError(t *testing.T) {
+	lookupErr := errors.New("database unavailable")
+	filter := NewFilter(testConfig(), &impressionLog{err: lookupErr}, ratelimit.NewMemoryStore())
+
+	_, err := filter.Check(t.Context(), event(model.TrackingEventTypeClick, "user-1", time.Now()))
+	assert.ErrorIs(t, err, lookupErr)
+}
+
+func TestFilter_CheckBatch(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxEventsPerUser = 3
+	cfg.EventWindow = time.Hour
+	filter := NewFilter(cfg, &impressionLog{}, ratelimit.NewMemoryStore())
+	now := time.Now()
+
+	reasons, err := filter.CheckBatch(t.Context(), []model.TrackingEvent{
+		event(model.TrackingEventTypeImpression, "user-1", now),
+		event(model.TrackingEventTypeClick, "user-1", now.Add(5*time.Second)),
+		event(model.TrackingEventTypeClick, "user-1", now.Add(6*time.Second)),
+		event(model.TrackingEventTypeImpression, "user-1", now.Add(7*time.Second)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Reason{"", "", "", ReasonExcessiveUserEvents}, reasons,
+		"clicks follow the impression of their batch, which counts towards the user's events")
+
+	// Nothing was recorded, so a later click finds no impression
+	reason, err := filter.Check(t.Context(), event(model.TrackingEventTypeClick, "user-1", now.Add(time.Minute)))
+	require.NoError(t, err)
+	assert.Equal(t, ReasonClickWithoutImpression, reason)
+}
+
+func TestFilter_CheckBatchClickAheadOfImpression(t *testing.T) {
+	lookups := &impressionLog{}
+	filter := NewFilter(testConfig(), lookups, ratelimit.NewMemoryStore())
+	now := time.Now()
+
+	reasons, err := filter.CheckBatch(t.Context(), []model.TrackingEvent{
+		event(model.TrackingEventTypeClick, "user-1", now.Add(5*time.Second)),
+		event(model.TrackingEventTypeImpression, "user-1", now),
+		event(model.TrackingEventTypeClick, "user-2", now.Add(5*time.Second)),
+		event(model.TrackingEventTypeImpression, "user-2", now.Add(6*time.Second)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Reason{"", "", ReasonClickWithoutImpression, ""}, reasons,
+		"clicks match impressions sent after them in the batch, if they happened before")
+}
+
+func TestFilter_CheckBatchDoesNotWaitForImpressions(t *testing.T) {
+	lookups := &impressionLog{}
+	filter := NewFilter(testConfig(), lookups, ratelimit.NewMemoryStore())
+	now := time.Now()
+
+	var clicks []model.TrackingEvent
+	for i := range 50 {
+		clicks = append(clicks, event(model.TrackingEventTypeClick, fmt.Sprintf("user-%d", i), now))
+	}
+	start := time.Now()
+	reasons, err := filter.CheckBatch(t.Context(), clicks)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "recent clicks without impressions are flagged at once")
+	for _, reason := range reasons {
+		assert.Equal(t, ReasonClickWithoutImpression, reason)
+	}
+	assert.Equal(t, len(clicks), lookups.lookups, "each click is looked up once")
+}
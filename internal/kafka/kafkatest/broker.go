@@ -0,0 +1,261 @@
+// Package kafkatest provides an in-process Kafka broker stub for tests. It understands
+// just enough of the protocol to serve the Metadata and Produce requests sent by
+// kafka.Producer and records every message it receives.
+package kafkatest
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+
+	"sweng-task/internal/kafka"
+)
+
+const (
+	errNone           int16 = 0
+	errCorruptMessage int16 = 2
+	errUnknownTopic   int16 = 3
+)
+
+// Message is a record received by the broker
+type Message struct {
+	Topic     string
+	Partition int32
+	kafka.Record
+}
+
+// Broker is a single-node broker listening on a loopback port
+type Broker struct {
+	listener net.Listener
+	host     string
+	port     int32
+
+	mu       sync.Mutex
+	topics   map[string]int32
+	messages []Message
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewBroker starts a broker serving the given topics and their partition counts
+func NewBroker(topics map[string]int32) (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	host, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	b := &Broker{
+		listener: listener,
+		host:     host,
+		port:     int32(port),
+		topics:   topics,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	b.wg.Add(1)
+	go b.serve()
+	return b, nil
+}
+
+// Addr returns the host:port the broker listens on
+func (b *Broker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Messages returns every message received so far
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.messages...)
+}
+
+// DropConnections closes every client connection, as a broker restart would
+func (b *Broker) DropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the broker and closes all client connections
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+	b.mu.Lock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go b.handle(conn)
+	}
+}
+
+func (b *Broker) handle(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+	}()
+
+	for {
+		frame, err := kafka.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+
+		req := kafka.NewDecoder(frame)
+		apiKey := req.Int16()
+		apiVersion := req.Int16()
+		correlationID := req.Int32()
+		req.Str() // client id
+		if req.Err() != nil {
+			return
+		}
+
+		var resp kafka.Encoder
+		resp.Int32(correlationID)
+
+		switch {
+		case apiKey == kafka.APIKeyMetadata && apiVersion == kafka.MetadataVersion:
+			b.metadata(req, &resp)
+		case apiKey == kafka.APIKeyProduce && apiVersion == kafka.ProduceVersion:
+			acks, ok := b.produce(req, &resp)
+			if !ok {
+				return
+			}
+			if acks == 0 {
+				continue
+			}
+		default:
+			// Unsupported requests close the connection, as a real broker would
+			return
+		}
+
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(resp.Bytes())))
+		if _, err := conn.Write(append(out, resp.Bytes()...)); err != nil {
+			return
+		}
+	}
+}
+
+func (b *Broker) metadata(req *kafka.Decoder, resp *kafka.Encoder) {
+	var requested []string
+	for i, n := 0, req.ArrayLen(); i < n; i++ {
+		requested = append(requested, req.Str())
+	}
+
+	resp.ArrayLen(1)
+	resp.Int32(0)
+	resp.String(b.host)
+	resp.Int32(b.port)
+	resp.NullableString(nil)
+	resp.Int32(0) // controller id
+
+	resp.ArrayLen(len(requested))
+	for _, topic := range requested {
+		partitions, ok := b.topics[topic]
+		if !ok {
+			resp.Int16(errUnknownTopic)
+			resp.String(topic)
+			resp.Bool(false)
+			resp.ArrayLen(0)
+			continue
+		}
+		resp.Int16(errNone)
+		resp.String(topic)
+		resp.Bool(false)
+		resp.ArrayLen(int(partitions))
+		for p := int32(0); p < partitions; p++ {
+			resp.Int16(errNone)
+			resp.Int32(p)
+			resp.Int32(0) // leader
+			resp.ArrayLen(1)
+			resp.Int32(0)
+			resp.ArrayLen(1)
+			resp.Int32(0)
+		}
+	}
+}
+
+func (b *Broker) produce(req *kafka.Decoder, resp *kafka.Encoder) (int16, bool) {
+	req.Str() // transactional id
+	acks := req.Int16()
+	req.Int32() // timeout
+
+	type partitionResult struct {
+		index int32
+		code  int16
+	}
+	type topicResult struct {
+		name       string
+		partitions []partitionResult
+	}
+
+	var results []topicResult
+	for i, topics := 0, req.ArrayLen(); i < topics; i++ {
+		result := topicResult{name: req.Str()}
+		for j, partitions := 0, req.ArrayLen(); j < partitions; j++ {
+			index := req.Int32()
+			batch := req.Bytes32()
+			if req.Err() != nil {
+				return 0, false
+			}
+
+			code := errNone
+			records, err := kafka.DecodeRecordBatches(batch)
+			b.mu.Lock()
+			count, known := b.topics[result.name]
+			switch {
+			case !known || index >= count:
+				code = errUnknownTopic
+			case err != nil:
+				code = errCorruptMessage
+			default:
+				for _, r := range records {
+					b.messages = append(b.messages, Message{Topic: result.name, Partition: index, Record: r})
+				}
+			}
+			b.mu.Unlock()
+
+			result.partitions = append(result.partitions, partitionResult{index: index, code: code})
+		}
+		results = append(results, result)
+	}
+
+	resp.ArrayLen(len(results))
+	for _, result := range results {
+		resp.String(result.name)
+		resp.ArrayLen(len(result.partitions))
+		for _, p := range result.partitions {
+			resp.Int32(p.index)
+			resp.Int16(p.code)
+			resp.Int64(0)  // base offset
+			resp.Int64(-1) // log append time
+		}
+	}
+	resp.Int32(0) // throttle time
+	return acks, true
+}
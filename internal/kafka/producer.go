@@ -0,0 +1,345 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProducerConfig configures a Producer
+type ProducerConfig struct {
+	Brokers  []string
+	ClientID string
+	// Acks is the number of acknowledgements required: 0, 1 or -1 for all in-sync replicas
+	Acks    int16
+	Timeout time.Duration
+}
+
+// ProduceError is returned when a broker rejects records for a partition
+type ProduceError struct {
+	Topic     string
+	Partition int32
+	Code      int16
+}
+
+func (e *ProduceError) Error() string {
+	return fmt.Sprintf("kafka: produce to %s/%d failed with error code %d", e.Topic, e.Partition, e.Code)
+}
+
+// ErrClosed is returned by Produce once the producer is closed
+var ErrClosed = errors.New("kafka: producer closed")
+
+type partitionMetadata struct {
+	leaders []string // leader address, indexed by partition
+}
+
+// brokerConn is the connection to one broker. Requests on it are serialised, since
+// responses are read in the order requests were sent, but brokers are written to in parallel.
+type brokerConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// Producer is a minimal synchronous Kafka producer speaking the wire protocol directly.
+// It discovers partition leaders via Metadata requests and writes uncompressed record batches.
+// It is safe for concurrent use; no lock shared between callers is held during network I/O.
+type Producer struct {
+	cfg           ProducerConfig
+	correlationID atomic.Int32
+
+	mu     sync.Mutex
+	topics map[string]partitionMetadata
+	conns  map[string]*brokerConn
+	closed bool
+}
+
+func NewProducer(cfg ProducerConfig) *Producer {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Producer{
+		cfg:    cfg,
+		topics: make(map[string]partitionMetadata),
+		conns:  make(map[string]*brokerConn),
+	}
+}
+
+// Produce writes records to topic, partitioned by key. Metadata is refreshed and the
+// write retried once if a broker connection fails or a partition has moved.
+func (p *Producer) Produce(topic string, records []Record) error {
+	err := p.produce(topic, records)
+	if err != nil && !errors.Is(err, ErrClosed) {
+		p.mu.Lock()
+		delete(p.topics, topic)
+		p.mu.Unlock()
+		err = p.produce(topic, records)
+	}
+	return err
+}
+
+// Close releases all broker connections, waiting for requests in flight on them
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[string]*brokerConn)
+	p.mu.Unlock()
+
+	for _, bc := range conns {
+		bc.mu.Lock()
+		bc.reset()
+		bc.closed = true
+		bc.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *Producer) produce(topic string, records []Record) error {
+	meta, err := p.metadata(topic)
+	if err != nil {
+		return err
+	}
+
+	// Group records by partition, then partitions by leader
+	byPartition := make(map[int32][]Record)
+	for _, r := range records {
+		partition := int32(Partition(r.Key, len(meta.leaders)))
+		byPartition[partition] = append(byPartition[partition], r)
+	}
+	byLeader := make(map[string][]int32)
+	for partition := range byPartition {
+		leader := meta.leaders[partition]
+		byLeader[leader] = append(byLeader[leader], partition)
+	}
+
+	for addr, partitions := range byLeader {
+		if addr == "" {
+			return fmt.Errorf("kafka: no leader address for %s partitions %v", topic, partitions)
+		}
+
+		var body Encoder
+		body.NullableString(nil) // transactional id
+		body.Int16(p.cfg.Acks)
+		body.Int32(int32(p.cfg.Timeout / time.Millisecond))
+		body.ArrayLen(1)
+		body.String(topic)
+		body.ArrayLen(len(partitions))
+		for _, partition := range partitions {
+			body.Int32(partition)
+			body.Bytes32(EncodeRecordBatch(byPartition[partition]))
+		}
+
+		resp, err := p.roundTrip(addr, APIKeyProduce, ProduceVersion, body.Bytes(), p.cfg.Acks != 0)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			continue
+		}
+		if err := checkProduceResponse(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkProduceResponse(resp *Decoder) error {
+	for i, topics := 0, resp.ArrayLen(); i < topics; i++ {
+		topic := resp.Str()
+		for j, partitions := 0, resp.ArrayLen(); j < partitions; j++ {
+			partition := resp.Int32()
+			code := resp.Int16()
+			resp.Int64() // base offset
+			resp.Int64() // log append time
+			if code != 0 {
+				return &ProduceError{Topic: topic, Partition: partition, Code: code}
+			}
+		}
+	}
+	resp.Int32() // throttle time
+	return resp.Err()
+}
+
+func (p *Producer) metadata(topic string) (partitionMetadata, error) {
+	p.mu.Lock()
+	meta, ok := p.topics[topic]
+	p.mu.Unlock()
+	if ok {
+		return meta, nil
+	}
+
+	var body Encoder
+	body.ArrayLen(1)
+	body.String(topic)
+
+	var lastErr error
+	for _, addr := range p.cfg.Brokers {
+		resp, err := p.roundTrip(addr, APIKeyMetadata, MetadataVersion, body.Bytes(), true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		brokers := make(map[int32]string)
+		for i, n := 0, resp.ArrayLen(); i < n; i++ {
+			nodeID := resp.Int32()
+			host := resp.Str()
+			port := resp.Int32()
+			resp.Str() // rack
+			brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		resp.Int32() // controller id
+
+		topics := make(map[string]partitionMetadata)
+		for i, n := 0, resp.ArrayLen(); i < n; i++ {
+			code := resp.Int16()
+			name := resp.Str()
+			resp.Bool() // is internal
+			partitions := resp.ArrayLen()
+			meta := partitionMetadata{leaders: make([]string, partitions)}
+			for j := 0; j < partitions; j++ {
+				resp.Int16() // partition error code
+				index := resp.Int32()
+				leader := resp.Int32()
+				for k, replicas := 0, resp.ArrayLen(); k < replicas; k++ {
+					resp.Int32()
+				}
+				for k, isr := 0, resp.ArrayLen(); k < isr; k++ {
+					resp.Int32()
+				}
+				if index >= 0 && int(index) < partitions {
+					meta.leaders[index] = brokers[leader]
+				}
+			}
+			if code == 0 && partitions > 0 {
+				topics[name] = meta
+			}
+		}
+		if err := resp.Err(); err != nil {
+			lastErr = err
+			continue
+		}
+
+		p.mu.Lock()
+		for name, meta := range topics {
+			p.topics[name] = meta
+		}
+		p.mu.Unlock()
+
+		if meta, ok := topics[topic]; ok {
+			return meta, nil
+		}
+		lastErr = fmt.Errorf("kafka: topic %q not available", topic)
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("kafka: no brokers configured")
+	}
+	return partitionMetadata{}, lastErr
+}
+
+// roundTrip sends a request and, if expectResponse is set, returns a decoder
+// positioned after the response header. The connection is dropped on any I/O
+// error, since a partial request or response leaves it unusable.
+func (p *Producer) roundTrip(addr string, apiKey, apiVersion int16, body []byte, expectResponse bool) (*Decoder, error) {
+	bc, err := p.broker(addr)
+	if err != nil {
+		return nil, err
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.closed {
+		return nil, ErrClosed
+	}
+	if bc.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, p.cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		bc.conn = conn
+	}
+
+	correlationID := p.correlationID.Add(1)
+
+	var req Encoder
+	req.Int32(0) // size placeholder
+	req.Int16(apiKey)
+	req.Int16(apiVersion)
+	req.Int32(correlationID)
+	req.String(p.cfg.ClientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf[0:4], uint32(len(req.buf)-4))
+
+	if err := bc.conn.SetDeadline(time.Now().Add(p.cfg.Timeout)); err != nil {
+		bc.reset()
+		return nil, err
+	}
+	if _, err := bc.conn.Write(req.buf); err != nil {
+		bc.reset()
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+
+	resp, err := ReadFrame(bc.conn)
+	if err != nil {
+		bc.reset()
+		return nil, err
+	}
+	d := NewDecoder(resp)
+	if got := d.Int32(); got != correlationID {
+		bc.reset()
+		return nil, fmt.Errorf("kafka: correlation id mismatch: got %d, want %d", got, correlationID)
+	}
+	return d, nil
+}
+
+// broker returns the connection to addr, which is dialled on first use
+func (p *Producer) broker(addr string) (*brokerConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+	bc, ok := p.conns[addr]
+	if !ok {
+		bc = &brokerConn{}
+		p.conns[addr] = bc
+	}
+	return bc, nil
+}
+
+// reset closes the connection so that the next request dials again. bc.mu must be held.
+func (bc *brokerConn) reset() {
+	if bc.conn != nil {
+		bc.conn.Close()
+		bc.conn = nil
+	}
+}
+
+// ReadFrame reads a single size-prefixed protocol frame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > 100<<20 {
+		return nil, ErrMalformed
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
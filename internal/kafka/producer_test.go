@@ -0,0 +1,120 @@
+package kafka_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/kafka"
+	"sweng-task/internal/kafka/kafkatest"
+)
+
+func newTestBroker(t *testing.T, topics map[string]int32) *kafkatest.Broker {
+	t.Helper()
+	broker, err := kafkatest.NewBroker(topics)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = broker.Close() })
+	return broker
+}
+
+func newTestProducer(t *testing.T, broker *kafkatest.Broker, acks int16) *kafka.Producer {
+	t.Helper()
+	p := kafka.NewProducer(kafka.ProducerConfig{Brokers: []string{broker.Addr()}, ClientID: "test", Acks: acks})
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestProducer_RoundTrip(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 3})
+	p := newTestProducer(t, broker, 1)
+
+	records := []kafka.Record{
+		{Key: []byte("li_1"), Value: []byte("first"), Timestamp: 1767225600000},
+		{Key: []byte("li_2"), Value: []byte("second"), Timestamp: 1767225600100},
+		{Key: []byte("li_1"), Value: []byte("third"), Timestamp: 1767225600200},
+	}
+	require.NoError(t, p.Produce("events", records))
+
+	messages := broker.Messages()
+	require.Len(t, messages, len(records))
+	byValue := make(map[string]kafkatest.Message)
+	for _, msg := range messages {
+		byValue[string(msg.Value)] = msg
+	}
+	for _, r := range records {
+		msg, ok := byValue[string(r.Value)]
+		require.True(t, ok, string(r.Value))
+		assert.Equal(t, "events", msg.Topic)
+		assert.Equal(t, r.Key, msg.Key)
+		assert.Equal(t, r.Timestamp, msg.Timestamp)
+		assert.Equal(t, int32(kafka.Partition(r.Key, 3)), msg.Partition)
+	}
+}
+
+func TestProducer_WithoutAcks(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 1})
+	p := newTestProducer(t, broker, 0)
+
+	require.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("v")}}))
+	// Nothing is read back without acks, so the connection must stay usable for the next request
+	require.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("v")}}))
+	assert.Eventually(t, func() bool { return len(broker.Messages()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestProducer_ConcurrentProduce(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 4})
+	p := newTestProducer(t, broker, -1)
+
+	const producers, perProducer = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perProducer; j++ {
+				key := fmt.Sprintf("li_%d", i)
+				assert.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte(key), Value: []byte(fmt.Sprintf("%s_%d", key, j))}}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	messages := broker.Messages()
+	require.Len(t, messages, producers*perProducer)
+	seen := make(map[string]bool)
+	for _, msg := range messages {
+		seen[string(msg.Value)] = true
+	}
+	assert.Len(t, seen, producers*perProducer, "every record arrives once")
+}
+
+func TestProducer_ReconnectsAfterConnectionLoss(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 1})
+	p := newTestProducer(t, broker, 1)
+
+	require.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("before")}}))
+	broker.DropConnections()
+	require.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("after")}}))
+	assert.Len(t, broker.Messages(), 2)
+}
+
+func TestProducer_UnknownTopic(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 1})
+	p := newTestProducer(t, broker, 1)
+
+	err := p.Produce("missing", []kafka.Record{{Key: []byte("li_1"), Value: []byte("v")}})
+	assert.ErrorContains(t, err, `topic "missing" not available`)
+	assert.Empty(t, broker.Messages())
+}
+
+func TestProducer_Closed(t *testing.T) {
+	broker := newTestBroker(t, map[string]int32{"events": 1})
+	p := newTestProducer(t, broker, 1)
+
+	require.NoError(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("v")}}))
+	require.NoError(t, p.Close())
+	assert.ErrorIs(t, p.Produce("events", []kafka.Record{{Key: []byte("li_1"), Value: []byte("v")}}), kafka.ErrClosed)
+}
@@ -0,0 +1,316 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// API keys and versions of the requests spoken by the producer
+const (
+	APIKeyProduce  int16 = 0
+	APIKeyMetadata int16 = 3
+
+	ProduceVersion  int16 = 3
+	MetadataVersion int16 = 1
+)
+
+const recordBatchMagic = 2
+
+var (
+	ErrMalformed  = errors.New("kafka: malformed message")
+	ErrChecksum   = errors.New("kafka: record batch checksum mismatch")
+	castagnoliCRC = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Record is a single key/value message
+type Record struct {
+	Key       []byte
+	Value     []byte
+	Timestamp int64 // milliseconds since epoch
+}
+
+// Encoder builds Kafka wire-format messages
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) Bytes() []byte { return e.buf }
+
+func (e *Encoder) Int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *Encoder) Int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *Encoder) Int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *Encoder) Int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *Encoder) Varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Int8(1)
+	} else {
+		e.Int8(0)
+	}
+}
+
+func (e *Encoder) String(s string) {
+	e.Int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// NullableString encodes nil as length -1
+func (e *Encoder) NullableString(s *string) {
+	if s == nil {
+		e.Int16(-1)
+		return
+	}
+	e.String(*s)
+}
+
+func (e *Encoder) Bytes32(b []byte) {
+	e.Int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) ArrayLen(n int) { e.Int32(int32(n)) }
+
+// Decoder reads Kafka wire-format messages. The first error is sticky and returned by Err.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(b []byte) *Decoder { return &Decoder{buf: b} }
+
+func (d *Decoder) Err() error     { return d.err }
+func (d *Decoder) Remaining() int { return len(d.buf) }
+
+func (d *Decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *Decoder) Int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *Decoder) Int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *Decoder) Int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *Decoder) Int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *Decoder) Varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Bool() bool { return d.Int8() != 0 }
+
+func (d *Decoder) Str() string {
+	n := d.Int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *Decoder) Bytes32() []byte {
+	n := d.Int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+func (d *Decoder) ArrayLen() int {
+	n := d.Int32()
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// EncodeRecordBatch encodes records as a single v2 record batch without compression
+func EncodeRecordBatch(records []Record) []byte {
+	var baseTimestamp, maxTimestamp int64
+	if len(records) > 0 {
+		baseTimestamp = records[0].Timestamp
+		maxTimestamp = records[0].Timestamp
+	}
+	for _, r := range records {
+		baseTimestamp = min(baseTimestamp, r.Timestamp)
+		maxTimestamp = max(maxTimestamp, r.Timestamp)
+	}
+
+	// Everything from attributes onwards is covered by the CRC
+	var body Encoder
+	body.Int16(0) // attributes: no compression, create time
+	body.Int32(int32(len(records) - 1))
+	body.Int64(baseTimestamp)
+	body.Int64(maxTimestamp)
+	body.Int64(-1) // producer id
+	body.Int16(-1) // producer epoch
+	body.Int32(-1) // base sequence
+	body.ArrayLen(len(records))
+	for i, r := range records {
+		var rec Encoder
+		rec.Int8(0)
+		rec.Varint(r.Timestamp - baseTimestamp)
+		rec.Varint(int64(i))
+		if r.Key == nil {
+			rec.Varint(-1)
+		} else {
+			rec.Varint(int64(len(r.Key)))
+			rec.buf = append(rec.buf, r.Key...)
+		}
+		rec.Varint(int64(len(r.Value)))
+		rec.buf = append(rec.buf, r.Value...)
+		rec.Varint(0) // headers
+
+		body.Varint(int64(len(rec.buf)))
+		body.buf = append(body.buf, rec.buf...)
+	}
+
+	var batch Encoder
+	batch.Int64(0) // base offset, assigned by the broker
+	batch.Int32(int32(4 + 1 + 4 + len(body.buf)))
+	batch.Int32(0) // partition leader epoch
+	batch.Int8(recordBatchMagic)
+	batch.Int32(int32(crc32.Checksum(body.buf, castagnoliCRC)))
+	batch.buf = append(batch.buf, body.buf...)
+	return batch.buf
+}
+
+// DecodeRecordBatches decodes every uncompressed v2 record batch in b
+func DecodeRecordBatches(b []byte) ([]Record, error) {
+	var records []Record
+	d := NewDecoder(b)
+	for d.Remaining() > 0 {
+		d.Int64() // base offset
+		length := d.Int32()
+		batch := NewDecoder(d.take(int(length)))
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		batch.Int32() // partition leader epoch
+		if magic := batch.Int8(); magic != recordBatchMagic {
+			return nil, ErrMalformed
+		}
+		checksum := uint32(batch.Int32())
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		if crc32.Checksum(batch.buf, castagnoliCRC) != checksum {
+			return nil, ErrChecksum
+		}
+
+		if attributes := batch.Int16(); attributes&0x7 != 0 {
+			return nil, ErrMalformed
+		}
+		batch.Int32() // last offset delta
+		baseTimestamp := batch.Int64()
+		batch.Int64() // max timestamp
+		batch.Int64() // producer id
+		batch.Int16() // producer epoch
+		batch.Int32() // base sequence
+		count := batch.ArrayLen()
+
+		for i := 0; i < count; i++ {
+			rec := NewDecoder(batch.take(int(batch.Varint())))
+			rec.Int8() // attributes
+			timestampDelta := rec.Varint()
+			rec.Varint() // offset delta
+			var key []byte
+			if keyLen := rec.Varint(); keyLen >= 0 {
+				key = rec.take(int(keyLen))
+			}
+			value := rec.take(int(rec.Varint()))
+			if err := rec.Err(); err != nil {
+				return nil, err
+			}
+			records = append(records, Record{Key: key, Value: value, Timestamp: baseTimestamp + timestampDelta})
+		}
+		if err := batch.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, d.Err()
+}
+
+// Partition maps a key to a partition the same way the Java client's default partitioner does
+func Partition(key []byte, partitions int) int {
+	return int(murmur2(key)&0x7fffffff) % partitions
+}
+
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordBatch_RoundTrip(t *testing.T) {
+	records := []Record{
+		{Key: []byte("li_1"), Value: []byte(`{"event_id":"evt_1"}`), Timestamp: 1767225600000},
+		{Key: nil, Value: []byte("no key"), Timestamp: 1767225600250},
+		{Key: []byte("li_2"), Value: []byte{}, Timestamp: 1767225599000},
+	}
+
+	// Brokers may return several batches back to back
+	b := append(EncodeRecordBatch(records[:2]), EncodeRecordBatch(records[2:])...)
+	got, err := DecodeRecordBatches(b)
+	require.NoError(t, err)
+	require.Len(t, got, len(records))
+	for i, r := range records {
+		assert.Equal(t, r.Key, got[i].Key)
+		assert.Equal(t, string(r.Value), string(got[i].Value))
+		assert.Equal(t, r.Timestamp, got[i].Timestamp)
+	}
+}
+
+func TestRecordBatch_Corrupt(t *testing.T) {
+	b := EncodeRecordBatch([]Record{{Key: []byte("li_1"), Value: []byte("value")}})
+
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)-3] ^= 0xff
+	_, err := DecodeRecordBatches(corrupt)
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	_, err = DecodeRecordBatches(b[:len(b)-1])
+	assert.Error(t, err, "truncated batch")
+}
+
+func TestPartition(t *testing.T) {
+	for _, key := range []string{"li_1", "li_2", "", "a much longer line item key"} {
+		p := Partition([]byte(key), 3)
+		assert.GreaterOrEqual(t, p, 0)
+		assert.Less(t, p, 3)
+		assert.Equal(t, p, Partition([]byte(key), 3), "partitioning is stable")
+	}
+}
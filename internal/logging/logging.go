@@ -0,0 +1,128 @@
+// Package logging builds the service's zap logger and carries a request-scoped logger,
+// tagged with the request ID and trace ID, from the HTTP layer through the services and
+// repositories.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestIDHeader carries the request ID, accepted from callers and returned on every response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from callers, which end up in every log line
+const maxRequestIDLength = 128
+
+const requestIDKey = "requestid"
+
+type loggerKey struct{}
+
+// New builds a JSON production logger writing entries at level and above: debug, info,
+// warn or error
+func New(level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	return cfg.Build()
+}
+
+// WithLogger returns a copy of ctx carrying log
+func WithLogger(ctx context.Context, log *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// FromContext returns the logger carried by ctx, or fallback when it carries none, e.g.
+// in background jobs
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if log, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return log
+	}
+	return fallback
+}
+
+// RequestID returns the ID assigned to the request by Middleware
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// Middleware assigns every request an ID, taken from its X-Request-ID header when valid
+// and generated otherwise, and returns it in the response header. Handlers and the
+// services they call log through a logger tagged with the request ID and the trace ID of
+// the request's span, found with FromContext. Each request is access logged once it has
+// been handled.
+func Middleware(log *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Locals(requestIDKey, id)
+		c.Set(RequestIDHeader, id)
+
+		requestLog := log.With("request_id", id)
+		if spanContext := trace.SpanContextFromContext(c.UserContext()); spanContext.IsValid() {
+			requestLog = requestLog.With("trace_id", spanContext.TraceID().String())
+		}
+		c.SetUserContext(WithLogger(c.UserContext(), requestLog))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler sets the status after the middleware chain has returned
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+
+		fields := []interface{}{
+			"method", c.Method(),
+			"path", c.Path(),
+			"route", c.Route().Path,
+			"status", status,
+			"latency", time.Since(start),
+			"ip", c.IP(),
+			"user_agent", c.Get(fiber.HeaderUserAgent),
+			"bytes", len(c.Response().Body()),
+		}
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			requestLog.Errorw("HTTP request", fields...)
+		case status >= fiber.StatusBadRequest:
+			requestLog.Warnw("HTTP request", fields...)
+		default:
+			requestLog.Infow("HTTP request", fields...)
+		}
+		return err
+	}
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so that caller supplied
+// IDs can't forge log fields or response headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew_LogLevel(t *testing.T) {
+	logger, err := New("warn")
+	require.NoError(t, err)
+	assert.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+	assert.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+
+	_, err = New("loud")
+	assert.Error(t, err)
+}
+
+func TestMiddleware_RequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	app := fiber.New()
+	app.Use(Middleware(zap.New(core).Sugar()))
+	app.Get("/lineitems/:id", func(c *fiber.Ctx) error {
+		FromContext(c.UserContext(), nil).Infow("Handling request")
+		return c.Status(fiber.StatusNotFound).SendString(RequestID(c))
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "accepts caller ID", header: "req-123", expected: "req-123"},
+		{name: "generates missing ID"},
+		{name: "replaces invalid ID", header: "bad id\twith spaces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.TakeAll()
+			req := httptest.NewRequest(http.MethodGet, "/lineitems/li_1", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			id := resp.Header.Get(RequestIDHeader)
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, id)
+			} else {
+				assert.Len(t, id, 36, "a UUID is generated")
+			}
+
+			entries := logs.AllUntimed()
+			require.Len(t, entries, 2)
+			assert.Equal(t, "Handling request", entries[0].Message)
+			assert.Equal(t, id, entries[0].ContextMap()["request_id"], "handlers log with the request ID")
+
+			access := entries[1]
+			assert.Equal(t, "HTTP request", access.Message)
+			assert.Equal(t, zapcore.WarnLevel, access.Level)
+			assert.Equal(t, id, access.ContextMap()["request_id"])
+			assert.Equal(t, "/lineitems/:id", access.ContextMap()["route"])
+			assert.EqualValues(t, http.StatusNotFound, access.ContextMap()["status"])
+		})
+	}
+}
+
+func TestFromContext_Fallback(t *testing.T) {
+	fallback := zap.NewNop().Sugar()
+	assert.Same(t, fallback, FromContext(t.Context(), fallback))
+}
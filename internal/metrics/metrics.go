@@ -0,0 +1,286 @@
+// Package metrics exposes the service's Prometheus metrics. A nil *Metrics is valid and
+// records nothing, so components can be used without metrics in tests.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "adserver"
+
+// No-fill reasons of ad requests that returned no ads
+const (
+	NoFillNoCandidates = "no_candidates"
+	NoFillTimeout      = "timeout"
+	NoFillError        = "error"
+)
+
+// Outcomes of tracked events
+const (
+	TrackingAccepted  = "accepted"
+	TrackingDuplicate = "duplicate"
+	TrackingRejected  = "rejected"
+	// TrackingInvalid counts events stored as invalid traffic without being charged
+	TrackingInvalid = "invalid"
+)
+
+// Outcomes of events handed to an event sink
+const (
+	SinkPublished = "published"
+	SinkDropped   = "dropped"
+	SinkFailed    = "failed"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	adRequests        *prometheus.CounterVec
+	adNoFill          *prometheus.CounterVec
+	adCandidates      prometheus.Histogram
+	adDegraded        *prometheus.CounterVec
+	bids              prometheus.Histogram
+	pacingAdjustments prometheus.Counter
+	pacingFactor      prometheus.Histogram
+
+	trackingEvents *prometheus.CounterVec
+	lineItemSpend  *prometheus.CounterVec
+	anomalyAlerts  *prometheus.CounterVec
+	sinkEvents     *prometheus.CounterVec
+
+	schedulerJobRuns        *prometheus.CounterVec
+	schedulerJobLastSuccess *prometheus.GaugeVec
+}
+
+// New creates the metrics in a fresh registry, together with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"method", "route"}),
+
+		adRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ad_requests_total",
+			Help:      "Ad requests by outcome; the fill rate is the share of filled requests.",
+		}, []string{"outcome"}),
+		adNoFill: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ad_no_fill_total",
+			Help:      "Ad requests that returned no ads, by reason.",
+		}, []string{"reason"}),
+		adCandidates: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ad_candidates",
+			Help:      "Line items matching each ad request.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+		adDegraded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ad_degraded_total",
+			Help:      "Ad requests whose bids were estimated on fallback event counts, by cause.",
+		}, []string{"cause"}),
+		bids: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ad_bid",
+			Help:      "Estimated bids of the ads served, after pacing.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		}),
+		pacingAdjustments: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pacing_adjustments_total",
+			Help:      "Bids reduced because their line item was spending ahead of its daily budget.",
+		}),
+		pacingFactor: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pacing_reduce_factor",
+			Help:      "Factor applied to bids reduced by pacing.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}),
+
+		trackingEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tracking_events_total",
+			Help:      "Tracked events by event type and result.",
+		}, []string{"type", "result"}),
+		lineItemSpend: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "line_item_spend_total",
+			Help:      "Spend charged to each line item since the process started.",
+		}, []string{"line_item_id"}),
+		anomalyAlerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "anomaly_alerts_total",
+			Help:      "Anomalies detected in line item performance, by metric and whether the line item was paused.",
+		}, []string{"metric", "paused"}),
+		sinkEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sink_events_total",
+			Help:      "Accepted events handed to event sinks, by sink and result.",
+		}, []string{"sink", "result"}),
+
+		schedulerJobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scheduler_job_runs_total",
+			Help:      "Runs of scheduled jobs by job and result.",
+		}, []string{"job", "result"}),
+		schedulerJobLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "scheduler_job_last_success_timestamp_seconds",
+			Help:      "Unix time of the latest successful run of each scheduled job.",
+		}, []string{"job"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.adRequests, m.adNoFill, m.adCandidates, m.adDegraded, m.bids, m.pacingAdjustments, m.pacingFactor,
+		m.trackingEvents, m.lineItemSpend, m.anomalyAlerts, m.sinkEvents,
+		m.schedulerJobRuns, m.schedulerJobLastSuccess,
+	)
+	return m
+}
+
+// Registry returns the registry the metrics are exposed from
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware counts and times every request by its route pattern, e.g. /api/v1/lineitems/:id,
+// so the number of series stays bounded. Requests that match no route are labelled with the
+// prefix of the last middleware they passed through.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler sets the status after the middleware chain has returned
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+
+		route := c.Route().Path
+		m.httpRequests.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// RegisterDB exposes the connection pool stats of db, labelled with name
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// AdRequest records the outcome of an ad request that matched candidates line items and
+// served bids. noFillReason is set when no ads were served.
+func (m *Metrics) AdRequest(candidates int, bids []float64, noFillReason string) {
+	if m == nil {
+		return
+	}
+
+	if noFillReason != "" {
+		m.adRequests.WithLabelValues("no_fill").Inc()
+		m.adNoFill.WithLabelValues(noFillReason).Inc()
+	} else {
+		m.adRequests.WithLabelValues("filled").Inc()
+	}
+	m.adCandidates.Observe(float64(candidates))
+	for _, bid := range bids {
+		m.bids.Observe(bid)
+	}
+}
+
+// AdDegraded records an ad request served on fallback event counts
+func (m *Metrics) AdDegraded(cause string) {
+	if m == nil {
+		return
+	}
+	m.adDegraded.WithLabelValues(cause).Inc()
+}
+
+// PacingAdjustment records a bid reduced by reduceFactor
+func (m *Metrics) PacingAdjustment(reduceFactor float64) {
+	if m == nil {
+		return
+	}
+	m.pacingAdjustments.Inc()
+	m.pacingFactor.Observe(reduceFactor)
+}
+
+// TrackingEvent records the result of tracking an event of eventType
+func (m *Metrics) TrackingEvent(eventType, result string) {
+	if m == nil {
+		return
+	}
+	m.trackingEvents.WithLabelValues(eventType, result).Inc()
+}
+
+// Spend records amount charged to a line item
+func (m *Metrics) Spend(lineItemID string, amount float64) {
+	if m == nil || amount <= 0 {
+		return
+	}
+	m.lineItemSpend.WithLabelValues(lineItemID).Add(amount)
+}
+
+// AnomalyAlert records an anomaly detected in metric, and whether its line item was paused
+func (m *Metrics) AnomalyAlert(metric string, paused bool) {
+	if m == nil {
+		return
+	}
+	m.anomalyAlerts.WithLabelValues(metric, strconv.FormatBool(paused)).Inc()
+}
+
+// SinkEvents records count events handed to sink with result
+func (m *Metrics) SinkEvents(sink, result string, count int) {
+	if m == nil || count == 0 {
+		return
+	}
+	m.sinkEvents.WithLabelValues(sink, result).Add(float64(count))
+}
+
+// SchedulerJob records a run of a scheduled job, which failed when err is set
+func (m *Metrics) SchedulerJob(job string, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.schedulerJobRuns.WithLabelValues(job, "failure").Inc()
+		return
+	}
+	m.schedulerJobRuns.WithLabelValues(job, "success").Inc()
+	m.schedulerJobLastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	m := New()
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/lineitems/:id", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "unavailable")
+	})
+
+	for _, path := range []string{"/lineitems/li_1", "/lineitems/li_2", "/fail"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/lineitems/:id", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/fail", "503")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.httpDuration))
+}
+
+func TestMetrics_AdRequest(t *testing.T) {
+	m := New()
+	m.AdRequest(3, []float64{1.5, 0.5}, "")
+	m.AdRequest(0, nil, NoFillNoCandidates)
+	m.AdRequest(0, nil, NoFillTimeout)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.adRequests.WithLabelValues("filled")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.adRequests.WithLabelValues("no_fill")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.adNoFill.WithLabelValues(NoFillTimeout)))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.bids))
+}
+
+func TestMetrics_NilRecordsNothing(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.AdRequest(1, []float64{1}, "")
+		m.AdDegraded("stats_timeout")
+		m.PacingAdjustment(0.5)
+		m.TrackingEvent("click", TrackingAccepted)
+		m.Spend("li_1", 1)
+		m.SchedulerJob("prune_event_stats", nil)
+		m.RegisterDB(nil, "postgres")
+	})
+}
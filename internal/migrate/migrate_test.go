@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"sweng-task/internal/testutil"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_create_widgets.up.sql":   {Data: []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY);")},
+		"0001_create_widgets.down.sql": {Data: []byte("DROP TABLE widgets;")},
+		"0002_add_name.up.sql": {Data: []byte(`
+			-- Statements run together
+			ALTER TABLE widgets ADD COLUMN name TEXT;
+			CREATE INDEX idx_widgets_name ON widgets (name);`)},
+		"0002_add_name.down.sql": {Data: []byte("DROP INDEX idx_widgets_name;\nALTER TABLE widgets DROP COLUMN name;")},
+		"0003_seed.up.sql":       {Data: []byte("INSERT INTO widgets (name) VALUES ('first');")},
+		"0003_seed.down.sql":     {Data: []byte("-- Nothing to revert")},
+		"README.md":              {Data: []byte("Not a migration")},
+	}
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	return database
+}
+
+func columnExists(t *testing.T, database *gorm.DB, table, column string) bool {
+	var count int64
+	require.NoError(t, database.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count).Error)
+	return count > 0
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testMigrations())
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create_widgets", migrations[0].Name)
+	assert.Equal(t, "seed", migrations[2].Name)
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":      {"create.up.sql": {}},
+		"missing up":    {"0001_create.down.sql": {Data: []byte("DROP TABLE x;")}},
+		"renamed pair":  {"0001_a.up.sql": {Data: []byte("SELECT 1;")}, "0001_b.down.sql": {}},
+		"duplicate ups": {"0001_a.up.sql": {Data: []byte("SELECT 1;")}, "001_a.up.sql": {Data: []byte("SELECT 2;")}},
+	} {
+		_, err := Load(fsys)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	database := newTestDB(t)
+	migrator, err := New(database, testMigrations(), testutil.GetTestLogger())
+	require.NoError(t, err)
+
+	pending, err := migrator.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, 3, pending)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	assert.Equal(t, 3, applied)
+	assert.True(t, columnExists(t, database, "widgets", "name"))
+
+	applied, err = migrator.Up()
+	require.NoError(t, err)
+	assert.Zero(t, applied, "applied migrations are not run again")
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	for _, status := range statuses {
+		assert.True(t, status.Applied(), "migration %d", status.Version)
+	}
+
+	reverted, err := migrator.Down(2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.False(t, columnExists(t, database, "widgets", "name"))
+	assert.True(t, columnExists(t, database, "widgets", "id"))
+
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Applied())
+	assert.False(t, statuses[1].Applied())
+	assert.False(t, statuses[2].Applied())
+
+	reverted, err = migrator.Down(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, columnExists(t, database, "widgets", "id"))
+}
+
+func TestMigrator_FailedMigrationRollsBack(t *testing.T) {
+	database := newTestDB(t)
+	fsys := testMigrations()
+	fsys["0004_broken.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE widgets ADD COLUMN size INTEGER;\nALTER TABLE missing ADD COLUMN x TEXT;")}
+	migrator, err := New(database, fsys, testutil.GetTestLogger())
+	require.NoError(t, err)
+
+	applied, err := migrator.Up()
+	assert.ErrorContains(t, err, "4_broken")
+	assert.Equal(t, 3, applied)
+	assert.False(t, columnExists(t, database, "widgets", "size"), "the failed migration is rolled back as a whole")
+
+	pending, err := migrator.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+}
+
+func TestMigrator_UnknownAppliedVersion(t *testing.T) {
+	database := newTestDB(t)
+	migrator, err := New(database, testMigrations(), testutil.GetTestLogger())
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	// An older build that only knows the first migration
+	older, err := New(database, fstest.MapFS{
+		"0001_create_widgets.up.sql":   {Data: []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY);")},
+		"0001_create_widgets.down.sql": {Data: []byte("DROP TABLE widgets;")},
+	}, testutil.GetTestLogger())
+	require.NoError(t, err)
+
+	statuses, err := older.Status()
+	require.NoError(t, err)
+	assert.Len(t, statuses, 3, "applied migrations unknown to the build are listed")
+
+	_, err = older.Down(1)
+	assert.ErrorContains(t, err, "unknown to this build")
+}
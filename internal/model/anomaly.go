@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+)
+
+// AnomalyMetric is an hourly rate of a line item watched for anomalies
+type AnomalyMetric string
+
+const (
+	// AnomalyMetricCTR is clicks per impression
+	AnomalyMetricCTR AnomalyMetric = "ctr"
+	// AnomalyMetricCVR is conversions per click
+	AnomalyMetricCVR AnomalyMetric = "cvr"
+	// AnomalyMetricSpend is the amount charged per hour
+	AnomalyMetricSpend AnomalyMetric = "spend"
+)
+
+// AnomalyAlert reports an hour in which a metric of a line item deviated from its baseline,
+// the exponentially weighted mean and standard deviation of the hours before
+type AnomalyAlert struct {
+	ID           uint64 `json:"id"`
+	LineItemID   string `json:"line_item_id"`
+	AdvertiserID string `json:"advertiser_id"`
+	// Placement is empty for alerts on the line item across all placements
+	Placement   string        `json:"placement,omitempty"`
+	Metric      AnomalyMetric `json:"metric"`
+	WindowStart time.Time     `json:"window_start"`
+	Value       float64       `json:"value"`
+	Baseline    float64       `json:"baseline"`
+	StdDev      float64       `json:"std_dev"`
+	// ZScore is how many standard deviations Value is above, or below when negative, Baseline
+	ZScore float64 `json:"z_score"`
+	// Paused is set when the line item was paused because of the alert
+	Paused    bool      `json:"paused"`
+	CreatedAt time.Time `json:"created_at"`
+}
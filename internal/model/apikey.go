@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+)
+
+// Role determines the endpoints an API key may call
+type Role string
+
+const (
+	// RoleAdmin may call every endpoint, including key management
+	RoleAdmin Role = "admin"
+	// RoleAdvertiser manages the line items of a single advertiser
+	RoleAdvertiser Role = "advertiser"
+	// RolePublisher requests ads and tracks events, as publisher sites and SDKs do
+	RolePublisher Role = "publisher"
+)
+
+// APIKey describes an API key; the key itself is only returned when it is created
+type APIKey struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Role         Role       `json:"role"`
+	AdvertiserID string     `json:"advertiser_id,omitempty"`
+	Prefix       string     `json:"prefix"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeyCreate represents the data needed to create a new API key
+type APIKeyCreate struct {
+	Name         string `json:"name" validate:"required"`
+	Role         Role   `json:"role" validate:"required,oneof=admin advertiser publisher"`
+	AdvertiserID string `json:"advertiser_id" validate:"required_if=Role advertiser,excluded_unless=Role advertiser"`
+}
+
+// APIKeyCreated is returned once when a key is created. Only a hash of Key is stored, so it
+// can't be retrieved again.
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -39,6 +39,12 @@ type LineItemCreate struct {
 	Keywords     []string `json:"keywords,omitempty"`
 }
 
+// LineItemStatusUpdate represents a change of status requested for a line item, e.g. to
+// resume one paused by anomaly detection
+type LineItemStatusUpdate struct {
+	Status LineItemStatus `json:"status" validate:"required,oneof=active paused"`
+}
+
 // Ad represents an advertisement ready to be served
 type Ad struct {
 	ID           string  `json:"id"`
@@ -61,16 +67,83 @@ const (
 // TrackingEvent represents a user interaction with an ad
 
 type TrackingEvent struct {
+	EventID    string            `json:"event_id" validate:"omitempty,max=128"`
 	EventType  TrackingEventType `json:"event_type" validate:"required,oneof=impression click conversion"`
 	LineItemID string            `json:"line_item_id" validate:"required"`
 	Timestamp  time.Time         `json:"timestamp"`
 	Placement  string            `json:"placement"`
 	UserID     string            `json:"user_id"`
 	Metadata   map[string]string `json:"metadata"`
+	// IP and UserAgent identify the user's client for invalid traffic detection
+	IP        string `json:"ip,omitempty" validate:"omitempty,ip"`
+	UserAgent string `json:"user_agent,omitempty" validate:"omitempty,max=1024"`
 }
 
+// TrackingEventBatch is a set of tracking events submitted in a single request
+type TrackingEventBatch struct {
+	Events []TrackingEvent `json:"events" validate:"required,min=1"`
+}
+
+// TrackingResult is the outcome of tracking an event. Duplicate deliveries of the same
+// event ID return the result recorded for the original delivery.
+type TrackingResult struct {
+	EventID   string  `json:"event_id"`
+	Cost      float64 `json:"cost"`
+	Duplicate bool    `json:"duplicate"`
+	// InvalidReason is set for events recorded as invalid traffic, which are not charged
+	InvalidReason string `json:"invalid_reason,omitempty"`
+}
+
+// PublishedEvent is an accepted tracking event as delivered to event sinks
+type PublishedEvent struct {
+	TrackingEvent
+	Cost          float64   `json:"cost"`
+	AcceptedAt    time.Time `json:"accepted_at"`
+	InvalidReason string    `json:"invalid_reason,omitempty"`
+}
+
+// EventCounts are event totals used for bid estimation. They are whole numbers unless
+// older events have been decayed to a fraction of their weight.
 type EventCounts struct {
-	Impressions int
-	Clicks      int
-	Conversions int
+	Impressions float64
+	Clicks      float64
+	Conversions float64
+}
+
+// LineItemEventCounts are the event counts of a line item overall and within a single placement
+type LineItemEventCounts struct {
+	Total     EventCounts
+	Placement EventCounts
+}
+
+// TrafficCounts are the numbers of events of each type
+type TrafficCounts struct {
+	Impressions int64 `json:"impressions"`
+	Clicks      int64 `json:"clicks"`
+	Conversions int64 `json:"conversions"`
+}
+
+// InvalidTrafficReason counts the events marked invalid for one reason
+type InvalidTrafficReason struct {
+	Reason string `json:"reason"`
+	TrafficCounts
+}
+
+// InvalidTrafficReport compares the valid and invalid traffic of a line item. Only valid
+// events are charged and counted for bid estimation.
+type InvalidTrafficReport struct {
+	LineItemID   string        `json:"line_item_id"`
+	AdvertiserID string        `json:"advertiser_id"`
+	Valid        TrafficCounts `json:"valid"`
+	Invalid      TrafficCounts `json:"invalid"`
+	// InvalidRate is the share of all events of the line item that were invalid
+	InvalidRate float64                `json:"invalid_rate"`
+	Reasons     []InvalidTrafficReason `json:"reasons"`
+}
+
+// InvalidEventCount is the number of invalid events of one type and reason
+type InvalidEventCount struct {
+	Reason    string
+	EventType TrackingEventType
+	Count     int64
 }
@@ -27,15 +27,95 @@ func (LineItemEntity) TableName() string {
 
 type TrackingEventEntity struct {
 	ID         uint64            `gorm:"primaryKey"`
+	EventID    string            `gorm:"type:text;uniqueIndex:idx_tracking_events_event_id"`
 	EventType  TrackingEventType `gorm:"type:text;index:idx_event_type"`
 	LineItemID string            `gorm:"not null;index:idx_line_item_id"`
 	LineItem   LineItemEntity    `gorm:"foreignKey:LineItemID;references:ID;constraint:OnDelete:CASCADE"`
 	Timestamp  time.Time         `gorm:"index:idx_timestamp"`
 	Placement  string            `gorm:"index:idx_placement"`
 	UserID     string
-	Metadata   map[string]string `gorm:"type:jsonb"`
+	Metadata   map[string]string `gorm:"type:jsonb;serializer:json"`
+	Cost       float64           `gorm:"not null;default:0"`
+	IP         string            `gorm:"type:text;not null"`
+	UserAgent  string            `gorm:"type:text;not null"`
+	// InvalidReason is set for events marked as invalid traffic, which are stored with no
+	// cost and left out of the event counters
+	InvalidReason string `gorm:"type:text;not null"`
 }
 
 func (TrackingEventEntity) TableName() string {
 	return "tracking_events"
 }
+
+// EventCounterEntity holds event counts rolled up on ingest. An empty LineItemID or
+// Placement counts events across all line items or placements, so every stored event
+// increments four rows.
+type EventCounterEntity struct {
+	LineItemID  string `gorm:"primaryKey;type:text"`
+	Placement   string `gorm:"primaryKey;type:text"`
+	Impressions int    `gorm:"not null;default:0"`
+	Clicks      int    `gorm:"not null;default:0"`
+	Conversions int    `gorm:"not null;default:0"`
+}
+
+func (EventCounterEntity) TableName() string {
+	return "event_counters"
+}
+
+// EventCounterBucketSize is the time span counted by each EventCounterBucketEntity
+const EventCounterBucketSize = time.Hour
+
+// EventCounterBucketEntity holds the events counted into an EventCounterEntity row during
+// the hour starting at BucketStart, by event timestamp, and the sum of their cost
+type EventCounterBucketEntity struct {
+	LineItemID  string    `gorm:"primaryKey;type:text"`
+	Placement   string    `gorm:"primaryKey;type:text"`
+	BucketStart time.Time `gorm:"primaryKey;index:idx_event_counter_buckets_bucket_start"`
+	Impressions int       `gorm:"not null;default:0"`
+	Clicks      int       `gorm:"not null;default:0"`
+	Conversions int       `gorm:"not null;default:0"`
+	Spend       float64   `gorm:"not null;default:0"`
+	UpdatedAt   time.Time `gorm:"not null;index:idx_event_counter_buckets_updated_at"`
+}
+
+func (EventCounterBucketEntity) TableName() string {
+	return "event_counter_buckets"
+}
+
+// APIKeyEntity stores an API key by the SHA-256 hash of the key. Prefix holds the first
+// characters of the key so that operators can tell keys apart.
+type APIKeyEntity struct {
+	ID           string    `gorm:"primaryKey"`
+	Name         string    `gorm:"not null"`
+	Role         Role      `gorm:"type:text;not null"`
+	AdvertiserID string    `gorm:"type:text"`
+	Prefix       string    `gorm:"not null"`
+	Hash         string    `gorm:"not null;uniqueIndex:idx_api_keys_hash"`
+	CreatedAt    time.Time `gorm:"not null"`
+	RevokedAt    *time.Time
+}
+
+func (APIKeyEntity) TableName() string {
+	return "api_keys"
+}
+
+// AnomalyAlertEntity stores an AnomalyAlert. Alerts are unique per line item, placement,
+// metric and hour.
+type AnomalyAlertEntity struct {
+	ID           uint64        `gorm:"primaryKey"`
+	LineItemID   string        `gorm:"not null;uniqueIndex:idx_anomaly_alerts_window"`
+	AdvertiserID string        `gorm:"not null;index:idx_anomaly_alerts_advertiser"`
+	Placement    string        `gorm:"not null;uniqueIndex:idx_anomaly_alerts_window"`
+	Metric       AnomalyMetric `gorm:"type:text;not null;uniqueIndex:idx_anomaly_alerts_window"`
+	WindowStart  time.Time     `gorm:"not null;uniqueIndex:idx_anomaly_alerts_window;index:idx_anomaly_alerts_advertiser"`
+	Value        float64       `gorm:"not null"`
+	Baseline     float64       `gorm:"not null"`
+	StdDev       float64       `gorm:"not null"`
+	ZScore       float64       `gorm:"not null"`
+	Paused       bool          `gorm:"not null;default:false"`
+	CreatedAt    time.Time     `gorm:"not null"`
+}
+
+func (AnomalyAlertEntity) TableName() string {
+	return "anomaly_alerts"
+}
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"sort"
+	"time"
+)
+
 func ToEntityLineItem(dto LineItem) LineItemEntity {
 	return LineItemEntity{
 		ID:           dto.ID,
@@ -67,22 +72,241 @@ func ToAd(e LineItemEntity) Ad {
 
 func ToEntityTrackingEvent(dto TrackingEvent) TrackingEventEntity {
 	return TrackingEventEntity{
+		EventID:    dto.EventID,
 		EventType:  dto.EventType,
 		LineItemID: dto.LineItemID,
 		Timestamp:  dto.Timestamp,
 		Placement:  dto.Placement,
 		UserID:     dto.UserID,
 		Metadata:   dto.Metadata,
+		IP:         dto.IP,
+		UserAgent:  dto.UserAgent,
 	}
 }
 
 func ToDTOTrackingEvent(e TrackingEventEntity) TrackingEvent {
 	return TrackingEvent{
+		EventID:    e.EventID,
 		EventType:  e.EventType,
 		LineItemID: e.LineItemID,
 		Timestamp:  e.Timestamp,
 		Placement:  e.Placement,
 		UserID:     e.UserID,
 		Metadata:   e.Metadata,
+		IP:         e.IP,
+		UserAgent:  e.UserAgent,
+	}
+}
+
+func ToPublishedEvent(e TrackingEventEntity, acceptedAt time.Time) PublishedEvent {
+	return PublishedEvent{
+		TrackingEvent: ToDTOTrackingEvent(e),
+		Cost:          e.Cost,
+		AcceptedAt:    acceptedAt,
+		InvalidReason: e.InvalidReason,
+	}
+}
+
+func ToTrackingResult(e TrackingEventEntity, duplicate bool) TrackingResult {
+	return TrackingResult{
+		EventID:       e.EventID,
+		Cost:          e.Cost,
+		Duplicate:     duplicate,
+		InvalidReason: e.InvalidReason,
+	}
+}
+
+func ToEventCounts(e EventCounterEntity) EventCounts {
+	return EventCounts{
+		Impressions: float64(e.Impressions),
+		Clicks:      float64(e.Clicks),
+		Conversions: float64(e.Conversions),
+	}
+}
+
+func ToEventCounterBucketCounts(e EventCounterBucketEntity) EventCounts {
+	return EventCounts{
+		Impressions: float64(e.Impressions),
+		Clicks:      float64(e.Clicks),
+		Conversions: float64(e.Conversions),
+	}
+}
+
+// ToEventCounterDeltas rolls valid events up into the counter rows they increment, ordered
+// by line item and placement so concurrent writers lock rows in the same order
+func ToEventCounterDeltas(events []*TrackingEventEntity) []EventCounterEntity {
+	buckets := rollUpEvents(events, func(*TrackingEventEntity) time.Time { return time.Time{} })
+	deltas := make([]EventCounterEntity, len(buckets))
+	for i, b := range buckets {
+		deltas[i] = EventCounterEntity{
+			LineItemID:  b.LineItemID,
+			Placement:   b.Placement,
+			Impressions: b.Impressions,
+			Clicks:      b.Clicks,
+			Conversions: b.Conversions,
+		}
+	}
+	return deltas
+}
+
+// ToSpendingAmounts sums the cost of events per line item, leaving out events that cost
+// nothing such as invalid traffic
+func ToSpendingAmounts(events []*TrackingEventEntity) map[string]float64 {
+	amounts := make(map[string]float64)
+	for _, e := range events {
+		if e.Cost > 0 {
+			amounts[e.LineItemID] += e.Cost
+		}
+	}
+	return amounts
+}
+
+// ToEventCounterBucketDeltas rolls valid events up into the hourly counter buckets they
+// increment, stamped with updatedAt
+func ToEventCounterBucketDeltas(events []*TrackingEventEntity, updatedAt time.Time) []EventCounterBucketEntity {
+	deltas := rollUpEvents(events, func(e *TrackingEventEntity) time.Time {
+		return e.Timestamp.UTC().Truncate(EventCounterBucketSize)
+	})
+	for i := range deltas {
+		deltas[i].UpdatedAt = updatedAt
+	}
+	return deltas
+}
+
+func rollUpEvents(events []*TrackingEventEntity, bucketOf func(*TrackingEventEntity) time.Time) []EventCounterBucketEntity {
+	type key struct {
+		lineItemID, placement string
+		bucketStart           time.Time
+	}
+	deltas := make(map[key]*EventCounterBucketEntity)
+
+	for _, e := range events {
+		// Invalid traffic is kept out of the counts bids are estimated from
+		if e.InvalidReason != "" {
+			continue
+		}
+		bucketStart := bucketOf(e)
+
+		// Totals across line items are summed from these rows when read, as a row every
+		// event incremented would serialise all writers on its lock
+		keys := []key{
+			{e.LineItemID, "", bucketStart},
+			{e.LineItemID, e.Placement, bucketStart},
+		}
+		for i, k := range keys {
+			// An event without a placement maps onto the all-placements row only once
+			if i == 1 && k.placement == "" {
+				continue
+			}
+			delta, ok := deltas[k]
+			if !ok {
+				delta = &EventCounterBucketEntity{LineItemID: k.lineItemID, Placement: k.placement, BucketStart: k.bucketStart}
+				deltas[k] = delta
+			}
+			switch e.EventType {
+			case TrackingEventTypeImpression:
+				delta.Impressions++
+			case TrackingEventTypeClick:
+				delta.Clicks++
+			case TrackingEventTypeConversion:
+				delta.Conversions++
+			}
+			delta.Spend += e.Cost
+		}
+	}
+
+	result := make([]EventCounterBucketEntity, 0, len(deltas))
+	for _, delta := range deltas {
+		result = append(result, *delta)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.LineItemID != b.LineItemID {
+			return a.LineItemID < b.LineItemID
+		}
+		if a.Placement != b.Placement {
+			return a.Placement < b.Placement
+		}
+		return a.BucketStart.Before(b.BucketStart)
+	})
+	return result
+}
+
+func ToDTOAPIKey(e APIKeyEntity) APIKey {
+	return APIKey{
+		ID:           e.ID,
+		Name:         e.Name,
+		Role:         e.Role,
+		AdvertiserID: e.AdvertiserID,
+		Prefix:       e.Prefix,
+		CreatedAt:    e.CreatedAt,
+		RevokedAt:    e.RevokedAt,
+	}
+}
+
+func ToDTOAnomalyAlert(e AnomalyAlertEntity) AnomalyAlert {
+	return AnomalyAlert{
+		ID:           e.ID,
+		LineItemID:   e.LineItemID,
+		AdvertiserID: e.AdvertiserID,
+		Placement:    e.Placement,
+		Metric:       e.Metric,
+		WindowStart:  e.WindowStart,
+		Value:        e.Value,
+		Baseline:     e.Baseline,
+		StdDev:       e.StdDev,
+		ZScore:       e.ZScore,
+		Paused:       e.Paused,
+		CreatedAt:    e.CreatedAt,
+	}
+}
+
+// ToInvalidTrafficReport builds the invalid traffic report of lineItem from its counted
+// valid events and its invalid events by reason, listing reasons in alphabetical order
+func ToInvalidTrafficReport(lineItem LineItem, valid EventCounts, invalid []InvalidEventCount) InvalidTrafficReport {
+	report := InvalidTrafficReport{
+		LineItemID:   lineItem.ID,
+		AdvertiserID: lineItem.AdvertiserID,
+		Valid: TrafficCounts{
+			Impressions: int64(valid.Impressions),
+			Clicks:      int64(valid.Clicks),
+			Conversions: int64(valid.Conversions),
+		},
+		Reasons: []InvalidTrafficReason{},
+	}
+
+	byReason := make(map[string]*TrafficCounts)
+	for _, c := range invalid {
+		counts, ok := byReason[c.Reason]
+		if !ok {
+			counts = &TrafficCounts{}
+			byReason[c.Reason] = counts
+		}
+		counts.add(c.EventType, c.Count)
+		report.Invalid.add(c.EventType, c.Count)
+	}
+	for reason, counts := range byReason {
+		report.Reasons = append(report.Reasons, InvalidTrafficReason{Reason: reason, TrafficCounts: *counts})
+	}
+	sort.Slice(report.Reasons, func(i, j int) bool { return report.Reasons[i].Reason < report.Reasons[j].Reason })
+
+	if total := report.Valid.total() + report.Invalid.total(); total > 0 {
+		report.InvalidRate = float64(report.Invalid.total()) / float64(total)
+	}
+	return report
+}
+
+func (c *TrafficCounts) add(eventType TrackingEventType, n int64) {
+	switch eventType {
+	case TrackingEventTypeImpression:
+		c.Impressions += n
+	case TrackingEventTypeClick:
+		c.Clicks += n
+	case TrackingEventTypeConversion:
+		c.Conversions += n
+	}
+}
+
+func (c TrafficCounts) total() int64 {
+	return c.Impressions + c.Clicks + c.Conversions
 }
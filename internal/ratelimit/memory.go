@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	memoryShards = 32
+	// sweepInterval is how often each shard drops the buckets of idle clients
+	sweepInterval = time.Minute
+)
+
+// MemoryStore keeps buckets in process memory, so that each instance enforces limits on its
+// own. Buckets are sharded by key to keep lock contention low on the ad and tracking paths,
+// and dropped once idle long enough to have refilled.
+type MemoryStore struct {
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+	now    func() time.Time
+}
+
+type memoryShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{seed: maphash.MakeSeed(), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*bucket)
+	}
+	return s
+}
+
+// Take removes a token from the bucket of key after refilling it for the time since it was
+// last used
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	return s.decide(key, limit, true), nil
+}
+
+// Peek reports whether the bucket of key has a token left without removing it
+func (s *MemoryStore) Peek(ctx context.Context, key string, limit Limit) (Decision, error) {
+	return s.decide(key, limit, false), nil
+}
+
+func (s *MemoryStore) decide(key string, limit Limit, take bool) Decision {
+	now := s.now()
+	shard := &s.shards[maphash.String(s.seed, key)%memoryShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastSweep) >= sweepInterval {
+		shard.sweep(now)
+	}
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		if !take {
+			return Decision{Allowed: limit.Burst >= 1, Remaining: limit.Burst}
+		}
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		shard.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return Decision{
+			RetryAfter: time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)),
+		}
+	}
+	if take {
+		b.tokens--
+	}
+	return Decision{Allowed: true, Remaining: int(b.tokens)}
+}
+
+// size returns the number of buckets held
+func (s *MemoryStore) size() int {
+	n := 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		n += len(s.shards[i].buckets)
+		s.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.updated = now
+	}
+}
+
+// sweep drops full buckets, which behave exactly like the new bucket created on next use
+func (s *memoryShard) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1_700_000_000, 0)
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		decision, err := store.Take(t.Context(), "client", limit)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, i, decision.Remaining)
+	}
+
+	decision, err := store.Take(t.Context(), "client", limit)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed, "the burst is used up")
+	assert.Equal(t, 500*time.Millisecond, decision.RetryAfter)
+
+	decision, err = store.Take(t.Context(), "other", limit)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed, "buckets are kept per key")
+
+	now = now.Add(500 * time.Millisecond)
+	decision, err = store.Take(t.Context(), "client", limit)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed, "a token is refilled after 1/rate")
+
+	now = now.Add(time.Hour)
+	decision, err = store.Take(t.Context(), "client", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 2, decision.Remaining, "refills stop at the burst")
+}
+
+func TestMemoryStore_Peek(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	decision, err := store.Peek(t.Context(), "client", limit)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 2, decision.Remaining)
+	assert.Zero(t, store.size(), "peeking creates no bucket")
+
+	for range 2 {
+		_, err = store.Take(t.Context(), "client", limit)
+		require.NoError(t, err)
+	}
+	for range 2 {
+		decision, err = store.Peek(t.Context(), "client", limit)
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed, "peeking takes no token")
+	}
+}
+
+func TestMemoryStore_SweepsIdleBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1_700_000_000, 0)
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 10, Burst: 10}
+
+	for i := 0; i < 100; i++ {
+		_, err := store.Take(t.Context(), fmt.Sprintf("client-%d", i), limit)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 100, store.size())
+
+	// A shard sweeps on its next use once the interval has passed; its buckets have refilled
+	// by then and are dropped
+	now = now.Add(sweepInterval)
+	_, err := store.Take(t.Context(), "active", limit)
+	require.NoError(t, err)
+	shard := &store.shards[maphash.String(store.seed, "active")%memoryShards]
+	assert.Len(t, shard.buckets, 1)
+	assert.Contains(t, shard.buckets, "active")
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Rate: 0.001, Burst: 50}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := store.Take(t.Context(), "client", limit)
+			assert.NoError(t, err)
+			if decision.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, allowed)
+}
@@ -0,0 +1,144 @@
+// Package ratelimit throttles API clients with token buckets. Each route's rule sets the
+// rate and burst of the buckets and what a bucket is kept per: API key, client IP or
+// advertiser. Buckets live in a Store, in memory per instance until a shared store is needed.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/utils"
+)
+
+// Headers describing the limit applied to a request
+const (
+	HeaderLimit     = "X-RateLimit-Limit"
+	HeaderRemaining = "X-RateLimit-Remaining"
+)
+
+// Limit is the size and refill rate of a token bucket
+type Limit struct {
+	// Rate is the number of requests per second a client may make on average
+	Rate float64
+	// Burst is the number of requests a client may make at once after being idle
+	Burst int
+}
+
+// Enabled reports whether the limit throttles anything; a zero limit allows every request
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Decision is the outcome of taking a token from a bucket
+type Decision struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// RetryAfter is how long until the next token is available when not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Implementations shared between instances, e.g. in Redis,
+// enforce limits across the whole deployment instead of per instance.
+type Store interface {
+	// Take removes a token from the bucket of key, created full with limit when missing
+	Take(ctx context.Context, key string, limit Limit) (Decision, error)
+	// Peek reports what Take would decide without removing a token
+	Peek(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// KeyFunc returns what a request's bucket is kept per
+type KeyFunc func(c *fiber.Ctx) string
+
+// Keys of the buckets, selectable by name in the configuration
+const (
+	KeyAPIKey     = "api_key"
+	KeyIP         = "ip"
+	KeyAdvertiser = "advertiser"
+)
+
+// ByIP keeps a bucket per client IP
+func ByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// ByAPIKey keeps a bucket per credential, i.e. per API key or per bearer token subject, and
+// per client IP for unauthenticated requests
+func ByAPIKey(c *fiber.Ctx) string {
+	principal, ok := auth.FromContext(c.UserContext())
+	if !ok {
+		return ByIP(c)
+	}
+	return "principal:" + principal.ID
+}
+
+// ByAdvertiser keeps a bucket per advertiser shared by all of its keys and dashboard users;
+// other principals get a bucket per credential
+func ByAdvertiser(c *fiber.Ctx) string {
+	principal, ok := auth.FromContext(c.UserContext())
+	if !ok || principal.AdvertiserID == "" {
+		return ByAPIKey(c)
+	}
+	return "advertiser:" + principal.AdvertiserID
+}
+
+// KeyFuncByName returns the KeyFunc named by one of the Key constants
+func KeyFuncByName(name string) (KeyFunc, error) {
+	switch name {
+	case KeyAPIKey:
+		return ByAPIKey, nil
+	case KeyIP:
+		return ByIP, nil
+	case KeyAdvertiser:
+		return ByAdvertiser, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q", name)
+	}
+}
+
+// Rule is the limit of one route or group of routes. Routes sharing a rule name share
+// buckets.
+type Rule struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Middleware rejects requests exceeding rule with 429 and a Retry-After header. Requests
+// are let through when the store fails, so that an outage of a shared store doesn't take
+// the API down with it.
+func Middleware(store Store, rule Rule, log *zap.SugaredLogger) fiber.Handler {
+	if !rule.Limit.Enabled() {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	burst := strconv.Itoa(rule.Limit.Burst)
+
+	return func(c *fiber.Ctx) error {
+		decision, err := store.Take(c.UserContext(), rule.Name+":"+rule.Key(c), rule.Limit)
+		if err != nil {
+			logging.FromContext(c.UserContext(), log).Errorw("Failed to apply rate limit", "rule", rule.Name, "error", err)
+			return c.Next()
+		}
+
+		c.Set(HeaderLimit, burst)
+		c.Set(HeaderRemaining, strconv.Itoa(decision.Remaining))
+		if !decision.Allowed {
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(retryAfter, 1)))
+			logging.FromContext(c.UserContext(), log).Infow("Rate limited request", "rule", rule.Name)
+			return c.Status(fiber.StatusTooManyRequests).JSON(utils.ErrorResponse{
+				Code:      fiber.StatusTooManyRequests,
+				Message:   "Rate limit exceeded",
+				RequestID: logging.RequestID(c),
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/model"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	return Decision{}, errors.New("store unavailable")
+}
+
+func (failingStore) Peek(ctx context.Context, key string, limit Limit) (Decision, error) {
+	return Decision{}, errors.New("store unavailable")
+}
+
+// withPrincipal authenticates requests by their X-API-Key header without checking it
+func withPrincipal(c *fiber.Ctx) error {
+	if id := c.Get(auth.APIKeyHeader); id != "" {
+		principal := &auth.Principal{ID: id, Role: model.RoleAdvertiser, AdvertiserID: c.Get("X-Advertiser")}
+		c.SetUserContext(auth.WithPrincipal(c.UserContext(), principal))
+	}
+	return c.Next()
+}
+
+func TestMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Use(withPrincipal)
+	app.Get("/", Middleware(NewMemoryStore(), Rule{Name: "ads", Limit: Limit{Rate: 0.01, Burst: 2}, Key: ByAPIKey}, zap.NewNop().Sugar()),
+		func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	get := func(apiKey string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := get("key-1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(HeaderLimit))
+	assert.Equal(t, "1", resp.Header.Get(HeaderRemaining))
+	assert.Equal(t, http.StatusOK, get("key-1").StatusCode)
+
+	resp = get("key-1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "100", resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Equal(t, "0", resp.Header.Get(HeaderRemaining))
+
+	assert.Equal(t, http.StatusOK, get("key-2").StatusCode, "other keys have their own bucket")
+}
+
+func TestMiddleware_StoreFailureAllowsRequests(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", Middleware(failingStore{}, Rule{Name: "ads", Limit: Limit{Rate: 1, Burst: 1}, Key: ByIP}, zap.NewNop().Sugar()),
+		func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMiddleware_Disabled(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", Middleware(failingStore{}, Rule{Name: "ads"}, zap.NewNop().Sugar()),
+		func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(HeaderLimit))
+}
+
+func TestKeyFuncs(t *testing.T) {
+	app := fiber.New()
+	app.Use(withPrincipal)
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(map[string]string{"ip": ByIP(c), "api_key": ByAPIKey(c), "advertiser": ByAdvertiser(c)})
+	})
+
+	keys := func(apiKey, advertiserID string) map[string]string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		if advertiserID != "" {
+			req.Header.Set("X-Advertiser", advertiserID)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		var keys map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+		return keys
+	}
+
+	anonymous := keys("", "")
+	assert.Equal(t, anonymous["ip"], anonymous["api_key"], "unauthenticated requests are keyed by IP")
+	assert.Equal(t, anonymous["ip"], anonymous["advertiser"])
+
+	advertiser := keys("key-1", "adv_1")
+	assert.Equal(t, "principal:key-1", advertiser["api_key"])
+	assert.Equal(t, "advertiser:adv_1", advertiser["advertiser"])
+	assert.Equal(t, "advertiser:adv_1", keys("key-2", "adv_1")["advertiser"], "keys of an advertiser share its bucket")
+
+	assert.Equal(t, "principal:key-3", keys("key-3", "")["advertiser"], "principals without an advertiser are keyed by credential")
+
+	_, err := KeyFuncByName("session")
+	assert.Error(t, err)
+}
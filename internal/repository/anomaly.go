@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// AnomalyAlertFilter selects alerts; zero fields match every alert
+type AnomalyAlertFilter struct {
+	LineItemID   string
+	AdvertiserID string
+	Metric       model.AnomalyMetric
+	// Since selects alerts on hours starting at or after it
+	Since time.Time
+	// Limit caps the number of alerts returned
+	Limit int
+}
+
+type AnomalyAlertRepository interface {
+	// Create stores the alert, returning ErrDuplicateAlert if the line item already has an
+	// alert on the same placement, metric and hour
+	Create(ctx context.Context, alert *model.AnomalyAlertEntity) error
+	// List returns the alerts matching filter, latest hour first
+	List(ctx context.Context, filter AnomalyAlertFilter) ([]*model.AnomalyAlertEntity, error)
+}
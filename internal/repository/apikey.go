@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKeyEntity) error
+	// GetByHash returns the key with the given hash, including revoked keys, or ErrAPIKeyNotFound
+	GetByHash(ctx context.Context, hash string) (*model.APIKeyEntity, error)
+	// GetAll returns every key, oldest first
+	GetAll(ctx context.Context) ([]*model.APIKeyEntity, error)
+	// Revoke marks the key revoked at revokedAt, returning ErrAPIKeyNotFound for unknown IDs.
+	// Revoking a revoked key keeps its original revocation time.
+	Revoke(ctx context.Context, id string, revokedAt time.Time) error
+}
@@ -0,0 +1,11 @@
+package repository
+
+import "errors"
+
+var (
+	ErrAPIKeyNotFound   = errors.New("api key not found")
+	ErrDuplicateAlert   = errors.New("duplicate anomaly alert")
+	ErrDuplicateEvent   = errors.New("duplicate tracking event")
+	ErrEventNotFound    = errors.New("tracking event not found")
+	ErrLineItemNotFound = errors.New("line item not found")
+)
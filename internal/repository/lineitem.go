@@ -1,14 +1,20 @@
 package repository
 
 import (
+	"context"
 	"sweng-task/internal/model"
 )
 
 type LineItemRepository interface {
-	Create(item *model.LineItemEntity) error
-	GetByID(id string) (*model.LineItemEntity, error)
-	GetAll(advertiserID, placement string) ([]*model.LineItemEntity, error)
-	FindMatchingLineItems(placement, category, keyword string) ([]*model.LineItemEntity, error)
-	ResetDailySpending() (err error)
-	IncreaseDailySpending(lineItemID string, amount float64) error
+	Create(ctx context.Context, item *model.LineItemEntity) error
+	GetByID(ctx context.Context, id string) (*model.LineItemEntity, error)
+	GetByIDs(ctx context.Context, ids []string) ([]*model.LineItemEntity, error)
+	GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItemEntity, error)
+	FindMatchingLineItems(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error)
+	ResetDailySpending(ctx context.Context) (err error)
+	IncreaseDailySpending(ctx context.Context, lineItemID string, amount float64) error
+	// IncreaseDailySpendingBatch applies all amounts, keyed by line item ID, in a single update
+	IncreaseDailySpendingBatch(ctx context.Context, amounts map[string]float64) error
+	// UpdateStatus sets the status of the line item, returning ErrLineItemNotFound for unknown IDs
+	UpdateStatus(ctx context.Context, id string, status model.LineItemStatus) error
 }
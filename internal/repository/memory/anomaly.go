@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+// AnomalyAlertRepository keeps anomaly alerts in memory. Alerts are copied in and out, so
+// callers never share state with the store.
+type AnomalyAlertRepository struct {
+	mu     sync.RWMutex
+	alerts []model.AnomalyAlertEntity
+	nextID uint64
+}
+
+func NewAnomalyAlertRepository() *AnomalyAlertRepository {
+	return &AnomalyAlertRepository{nextID: 1}
+}
+
+func (r *AnomalyAlertRepository) Create(ctx context.Context, alert *model.AnomalyAlertEntity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.alerts {
+		existing := &r.alerts[i]
+		if existing.LineItemID == alert.LineItemID && existing.Placement == alert.Placement &&
+			existing.Metric == alert.Metric && existing.WindowStart.Equal(alert.WindowStart) {
+			return repository.ErrDuplicateAlert
+		}
+	}
+
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = time.Now()
+	}
+	alert.ID = r.nextID
+	r.nextID++
+	r.alerts = append(r.alerts, *alert)
+	return nil
+}
+
+func (r *AnomalyAlertRepository) List(ctx context.Context, filter repository.AnomalyAlertFilter) ([]*model.AnomalyAlertEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var alerts []*model.AnomalyAlertEntity
+	for i := range r.alerts {
+		alert := r.alerts[i]
+		if (filter.LineItemID != "" && alert.LineItemID != filter.LineItemID) ||
+			(filter.AdvertiserID != "" && alert.AdvertiserID != filter.AdvertiserID) ||
+			(filter.Metric != "" && alert.Metric != filter.Metric) ||
+			alert.WindowStart.Before(filter.Since) {
+			continue
+		}
+		alerts = append(alerts, &alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].WindowStart.Equal(alerts[j].WindowStart) {
+			return alerts[i].WindowStart.After(alerts[j].WindowStart)
+		}
+		return alerts[i].ID > alerts[j].ID
+	})
+	if filter.Limit > 0 && len(alerts) > filter.Limit {
+		alerts = alerts[:filter.Limit]
+	}
+	return alerts, nil
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+// APIKeyRepository keeps API keys in memory. Keys are copied in and out, so callers never
+// share state with the store.
+type APIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]*model.APIKeyEntity
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{
+		keys: make(map[string]*model.APIKeyEntity),
+	}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *model.APIKeyEntity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.keys[key.ID]; exists {
+		return fmt.Errorf("api key %q already exists", key.ID)
+	}
+	for _, existing := range r.keys {
+		if existing.Hash == key.Hash {
+			return fmt.Errorf("api key hash of %q already exists", key.ID)
+		}
+	}
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.keys[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+func (r *APIKeyRepository) GetByHash(ctx context.Context, hash string) (*model.APIKeyEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Hash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+func (r *APIKeyRepository) GetAll(ctx context.Context) ([]*model.APIKeyEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*model.APIKeyEntity, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return repository.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func copyAPIKey(key *model.APIKeyEntity) *model.APIKeyEntity {
+	c := *key
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
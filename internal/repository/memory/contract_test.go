@@ -0,0 +1,19 @@
+package memory
+
+import (
+	"testing"
+
+	"sweng-task/internal/repository/repotest"
+)
+
+func TestRepositoryContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		lineItems := NewLineItemRepository()
+		return repotest.Repositories{
+			LineItems: lineItems,
+			Tracking:  NewTrackingRepository(lineItems),
+			APIKeys:   NewAPIKeyRepository(),
+			Alerts:    NewAnomalyAlertRepository(),
+		}
+	})
+}
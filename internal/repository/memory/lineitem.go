@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+// LineItemRepository keeps line items in memory with the same semantics as the Postgres
+// repository. Items are copied in and out, so callers never share state with the store.
+type LineItemRepository struct {
+	mu    sync.RWMutex
+	items map[string]*model.LineItemEntity
+}
+
+func NewLineItemRepository() *LineItemRepository {
+	return &LineItemRepository{
+		items: make(map[string]*model.LineItemEntity),
+	}
+}
+
+func (r *LineItemRepository) Create(ctx context.Context, item *model.LineItemEntity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.items[item.ID]; exists {
+		return fmt.Errorf("line item %q already exists", item.ID)
+	}
+
+	now := time.Now()
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = now
+	}
+	if item.UpdatedAt.IsZero() {
+		item.UpdatedAt = now
+	}
+	r.items[item.ID] = copyLineItem(item)
+	return nil
+}
+
+func (r *LineItemRepository) GetByID(ctx context.Context, id string) (*model.LineItemEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	item, exists := r.items[id]
+	if !exists {
+		return nil, repository.ErrLineItemNotFound
+	}
+	return copyLineItem(item), nil
+}
+
+func (r *LineItemRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.LineItemEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	return r.filter(func(item *model.LineItemEntity) bool {
+		return wanted[item.ID]
+	}), nil
+}
+
+func (r *LineItemRepository) GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItemEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.filter(func(item *model.LineItemEntity) bool {
+		return (advertiserID == "" || item.AdvertiserID == advertiserID) &&
+			(placement == "" || item.Placement == placement)
+	}), nil
+}
+
+func (r *LineItemRepository) FindMatchingLineItems(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Matches "? = ANY(categories)" in Postgres, which compares case-sensitively
+	return r.filter(func(item *model.LineItemEntity) bool {
+		return item.Placement == placement &&
+			item.Status == model.LineItemStatusActive &&
+			item.DailySpending < item.Budget &&
+			(category == "" || slices.Contains(item.Categories, category)) &&
+			(keyword == "" || slices.Contains(item.Keywords, keyword))
+	}), nil
+}
+
+func (r *LineItemRepository) ResetDailySpending(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, item := range r.items {
+		if item.DailySpending > 0 {
+			item.DailySpending = 0
+			item.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+// IncreaseDailySpending ignores unknown line items, like an UPDATE that matches no rows
+func (r *LineItemRepository) IncreaseDailySpending(ctx context.Context, lineItemID string, amount float64) error {
+	return r.IncreaseDailySpendingBatch(ctx, map[string]float64{lineItemID: amount})
+}
+
+func (r *LineItemRepository) IncreaseDailySpendingBatch(ctx context.Context, amounts map[string]float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, amount := range amounts {
+		if item, exists := r.items[id]; exists {
+			item.DailySpending += amount
+			item.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *LineItemRepository) UpdateStatus(ctx context.Context, id string, status model.LineItemStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, exists := r.items[id]
+	if !exists {
+		return repository.ErrLineItemNotFound
+	}
+	item.Status = status
+	item.UpdatedAt = time.Now()
+	return nil
+}
+
+// filter returns copies of the matching items, oldest first. Callers must hold the lock.
+func (r *LineItemRepository) filter(match func(item *model.LineItemEntity) bool) []*model.LineItemEntity {
+	var result []*model.LineItemEntity
+	for _, item := range r.items {
+		if match(item) {
+			result = append(result, copyLineItem(item))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func copyLineItem(item *model.LineItemEntity) *model.LineItemEntity {
+	c := *item
+	c.Categories = slices.Clone(item.Categories)
+	c.Keywords = slices.Clone(item.Keywords)
+	return &c
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/testutil"
+)
+
+func TestLineItemRepository_FindMatchingLineItems(t *testing.T) {
+	repo := NewLineItemRepository()
+
+	matching := testutil.CreateTestLineItemEntity()
+	spent := testutil.CreateTestLineItemEntity()
+	spent.DailySpending = spent.Budget
+	paused := testutil.CreateTestLineItemEntity()
+	paused.Status = model.LineItemStatusPaused
+	otherPlacement := testutil.CreateTestLineItemEntity()
+	otherPlacement.Placement = "sidebar"
+	for _, item := range []*model.LineItemEntity{matching, spent, paused, otherPlacement} {
+		require.NoError(t, repo.Create(t.Context(), item))
+	}
+
+	items, err := repo.FindMatchingLineItems(t.Context(), "homepage_top", "electronics", "test")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, matching.ID, items[0].ID)
+
+	// Like "? = ANY(categories)", matching is exact and case-sensitive
+	items, err = repo.FindMatchingLineItems(t.Context(), "homepage_top", "Electronics", "")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	items, err = repo.FindMatchingLineItems(t.Context(), "homepage_top", "", "other")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestLineItemRepository_ReturnsCopies(t *testing.T) {
+	repo := NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, repo.Create(t.Context(), item))
+	assert.False(t, item.CreatedAt.IsZero())
+
+	item.Categories[0] = "changed"
+	got, err := repo.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "electronics", got.Categories[0])
+
+	got.Budget = 0
+	got, err = repo.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, got.Budget)
+
+	_, err = repo.GetByID(t.Context(), "li_unknown")
+	assert.ErrorIs(t, err, repository.ErrLineItemNotFound)
+	assert.Error(t, repo.Create(t.Context(), item))
+}
+
+func TestLineItemRepository_GetAllOrdersByCreation(t *testing.T) {
+	repo := NewLineItemRepository()
+	now := time.Now()
+	newer := testutil.CreateTestLineItemEntity()
+	newer.CreatedAt = now
+	older := testutil.CreateTestLineItemEntity()
+	older.CreatedAt = now.Add(-time.Hour)
+	older.AdvertiserID = "adv_other"
+	require.NoError(t, repo.Create(t.Context(), newer))
+	require.NoError(t, repo.Create(t.Context(), older))
+
+	items, err := repo.GetAll(t.Context(), "", "")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, older.ID, items[0].ID)
+	assert.Equal(t, newer.ID, items[1].ID)
+
+	items, err = repo.GetAll(t.Context(), "adv_other", "homepage_top")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, older.ID, items[0].ID)
+
+	items, err = repo.GetByIDs(t.Context(), []string{newer.ID, "li_unknown"})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, newer.ID, items[0].ID)
+}
+
+func TestLineItemRepository_DailySpending(t *testing.T) {
+	repo := NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, repo.Create(t.Context(), item))
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, repo.IncreaseDailySpending(t.Context(), item.ID, 2))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, repo.IncreaseDailySpendingBatch(t.Context(), map[string]float64{item.ID: 800, "li_unknown": 1}))
+	assert.NoError(t, repo.IncreaseDailySpending(t.Context(), "li_unknown", 1))
+
+	got, err := repo.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, got.DailySpending)
+
+	items, err := repo.FindMatchingLineItems(t.Context(), item.Placement, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, items, "line items that spent their budget are excluded")
+
+	require.NoError(t, repo.ResetDailySpending(t.Context()))
+	items, err = repo.FindMatchingLineItems(t.Context(), item.Placement, "", "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Zero(t, items[0].DailySpending)
+}
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type counterKey struct {
+	lineItemID string
+	placement  string
+}
+
+type bucketKey struct {
+	counterKey
+	bucketStart int64
+}
+
+// TrackingRepository keeps tracking events, event counters and hourly counter buckets in
+// memory with the same semantics as the Postgres repository. Event IDs are unique, including
+// the empty ID, as they are under the unique index on tracking_events.event_id.
+type TrackingRepository struct {
+	mu        sync.RWMutex
+	events    []model.TrackingEventEntity
+	byEventID map[string]int
+	counters  map[counterKey]model.EventCounterEntity
+	buckets   map[bucketKey]model.EventCounterBucketEntity
+	lineItems repository.LineItemRepository
+}
+
+// NewTrackingRepository creates a TrackingRepository charging the events it stores to their
+// line items in lineItems. A nil lineItems stores events without charging them.
+func NewTrackingRepository(lineItems repository.LineItemRepository) *TrackingRepository {
+	return &TrackingRepository{
+		byEventID: make(map[string]int),
+		counters:  make(map[counterKey]model.EventCounterEntity),
+		buckets:   make(map[bucketKey]model.EventCounterBucketEntity),
+		lineItems: lineItems,
+	}
+}
+
+func (r *TrackingRepository) Store(ctx context.Context, event *model.TrackingEventEntity) error {
+	duplicates, err := r.StoreBatch(ctx, []*model.TrackingEventEntity{event})
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return repository.ErrDuplicateEvent
+	}
+	return nil
+}
+
+func (r *TrackingRepository) StoreBatch(ctx context.Context, events []*model.TrackingEventEntity) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var duplicates []string
+	fresh := make([]*model.TrackingEventEntity, 0, len(events))
+	seen := make(map[string]bool, len(events))
+	for _, event := range events {
+		if _, exists := r.byEventID[event.EventID]; exists || seen[event.EventID] {
+			duplicates = append(duplicates, event.EventID)
+			continue
+		}
+		seen[event.EventID] = true
+		fresh = append(fresh, event)
+	}
+
+	// Charge before storing anything, so that a failed charge stores nothing, like the
+	// rolled back transaction of the SQL repositories
+	if r.lineItems != nil {
+		if err := r.lineItems.IncreaseDailySpendingBatch(ctx, model.ToSpendingAmounts(fresh)); err != nil {
+			return nil, err
+		}
+	}
+	for _, event := range fresh {
+		r.insert(event)
+	}
+	r.incrementCounters(fresh)
+	return duplicates, nil
+}
+
+// insert assigns the next ID to event and stores a copy. Callers must hold the write lock and
+// have checked that the event ID is not stored yet.
+func (r *TrackingRepository) insert(event *model.TrackingEventEntity) {
+	event.ID = uint64(len(r.events) + 1)
+	r.byEventID[event.EventID] = len(r.events)
+	r.events = append(r.events, copyEvent(event))
+}
+
+func (r *TrackingRepository) incrementCounters(events []*model.TrackingEventEntity) {
+	for _, delta := range model.ToEventCounterDeltas(events) {
+		key := counterKey{lineItemID: delta.LineItemID, placement: delta.Placement}
+		counter, ok := r.counters[key]
+		if ok {
+			delta.Impressions += counter.Impressions
+			delta.Clicks += counter.Clicks
+			delta.Conversions += counter.Conversions
+		}
+		r.counters[key] = delta
+	}
+
+	for _, delta := range model.ToEventCounterBucketDeltas(events, time.Now().UTC()) {
+		key := bucketKey{
+			counterKey:  counterKey{lineItemID: delta.LineItemID, placement: delta.Placement},
+			bucketStart: delta.BucketStart.Unix(),
+		}
+		bucket, ok := r.buckets[key]
+		if ok {
+			delta.Impressions += bucket.Impressions
+			delta.Clicks += bucket.Clicks
+			delta.Conversions += bucket.Conversions
+			delta.Spend += bucket.Spend
+		}
+		r.buckets[key] = delta
+	}
+}
+
+func (r *TrackingRepository) FindByEventID(ctx context.Context, eventID string) (*model.TrackingEventEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, exists := r.byEventID[eventID]
+	if !exists {
+		return nil, repository.ErrEventNotFound
+	}
+	event := copyEvent(&r.events[i])
+	return &event, nil
+}
+
+func (r *TrackingRepository) FindByEventIDs(ctx context.Context, eventIDs []string) ([]*model.TrackingEventEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]*model.TrackingEventEntity, 0, len(eventIDs))
+	seen := make(map[string]bool, len(eventIDs))
+	for _, id := range eventIDs {
+		i, exists := r.byEventID[id]
+		if !exists || seen[id] {
+			continue
+		}
+		seen[id] = true
+		event := copyEvent(&r.events[i])
+		results = append(results, &event)
+	}
+	return results, nil
+}
+
+func (r *TrackingRepository) FindAll(ctx context.Context) ([]*model.TrackingEventEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]*model.TrackingEventEntity, 0, len(r.events))
+	for i := range r.events {
+		event := copyEvent(&r.events[i])
+		results = append(results, &event)
+	}
+	return results, nil
+}
+
+func (r *TrackingRepository) CountEvents(ctx context.Context, lineItemID string, placement string) (model.EventCounts, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if lineItemID == "" {
+		return r.total(placement), nil
+	}
+	return model.ToEventCounts(r.counters[counterKey{lineItemID: lineItemID, placement: placement}]), nil
+}
+
+func (r *TrackingRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[string]model.LineItemEventCounts, len(lineItemIDs))
+	for _, id := range lineItemIDs {
+		counts[id] = model.LineItemEventCounts{
+			Total:     model.ToEventCounts(r.counters[counterKey{lineItemID: id}]),
+			Placement: model.ToEventCounts(r.counters[counterKey{lineItemID: id, placement: placement}]),
+		}
+		if id == "" {
+			counts[id] = model.LineItemEventCounts{Total: r.total(""), Placement: r.total(placement)}
+		}
+	}
+	return counts, nil
+}
+
+// total sums the counters of all line items in placement. Callers must hold the lock.
+func (r *TrackingRepository) total(placement string) model.EventCounts {
+	var sum model.EventCounterEntity
+	for key, counter := range r.counters {
+		if key.placement == placement {
+			sum.Impressions += counter.Impressions
+			sum.Clicks += counter.Clicks
+			sum.Conversions += counter.Conversions
+		}
+	}
+	return model.ToEventCounts(sum)
+}
+
+func (r *TrackingRepository) LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var last time.Time
+	found := false
+	for i := range r.events {
+		e := &r.events[i]
+		if e.LineItemID != lineItemID || e.UserID != userID || e.EventType != model.TrackingEventTypeImpression ||
+			e.InvalidReason != "" || e.Timestamp.After(before) {
+			continue
+		}
+		if !found || e.Timestamp.After(last) {
+			last = e.Timestamp
+			found = true
+		}
+	}
+	if !found {
+		return time.Time{}, repository.ErrEventNotFound
+	}
+	return last, nil
+}
+
+func (r *TrackingRepository) CountInvalidEvents(ctx context.Context, lineItemID string) ([]model.InvalidEventCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return countInvalidEvents(r.events, lineItemID), nil
+}
+
+func (r *TrackingRepository) ListEventCounters(ctx context.Context) ([]*model.EventCounterEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]*model.EventCounterEntity, 0, len(r.counters))
+	for _, c := range r.counters {
+		counter := c
+		results = append(results, &counter)
+	}
+	return results, nil
+}
+
+func (r *TrackingRepository) ListEventCounterBuckets(ctx context.Context, updatedSince time.Time) ([]*model.EventCounterBucketEntity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var results []*model.EventCounterBucketEntity
+	for _, b := range r.buckets {
+		if !b.UpdatedAt.Before(updatedSince) {
+			bucket := b
+			results = append(results, &bucket)
+		}
+	}
+	return results, nil
+}
+
+func (r *TrackingRepository) DeleteEventCounterBuckets(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, b := range r.buckets {
+		if b.BucketStart.Before(before) {
+			delete(r.buckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func copyEvent(event *model.TrackingEventEntity) model.TrackingEventEntity {
+	c := *event
+	c.Metadata = maps.Clone(event.Metadata)
+	return c
+}
+
+func countInvalidEvents(events []model.TrackingEventEntity, lineItemID string) []model.InvalidEventCount {
+	type key struct {
+		reason    string
+		eventType model.TrackingEventType
+	}
+	counts := make(map[key]int64)
+	for i := range events {
+		if events[i].LineItemID == lineItemID && events[i].InvalidReason != "" {
+			counts[key{events[i].InvalidReason, events[i].EventType}]++
+		}
+	}
+
+	results := make([]model.InvalidEventCount, 0, len(counts))
+	for k, n := range counts {
+		results = append(results, model.InvalidEventCount{Reason: k.reason, EventType: k.eventType, Count: n})
+	}
+	return results
+}
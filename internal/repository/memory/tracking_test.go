@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/testutil"
+)
+
+func TestTrackingRepository_StoreRejectsDuplicates(t *testing.T) {
+	repo := NewTrackingRepository(nil)
+
+	event := testutil.CreateTestTrackingEventEntity("li_1")
+	event.EventID = "evt_1"
+	require.NoError(t, repo.Store(t.Context(), event))
+	assert.Equal(t, uint64(1), event.ID)
+
+	assert.ErrorIs(t, repo.Store(t.Context(), event), repository.ErrDuplicateEvent)
+
+	second := testutil.CreateTestTrackingEventEntity("li_1")
+	second.EventID = "evt_2"
+	third := testutil.CreateTestTrackingEventEntity("li_2")
+	third.EventID = "evt_3"
+	duplicates, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{event, second, third, second})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt_1", "evt_2"}, duplicates)
+
+	events, err := repo.FindByEventIDs(t.Context(), []string{"evt_3", "evt_2", "evt_missing"})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "li_2", events[0].LineItemID)
+
+	_, err = repo.FindByEventID(t.Context(), "evt_missing")
+	assert.ErrorIs(t, err, repository.ErrEventNotFound)
+
+	all, err := repo.FindAll(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestTrackingRepository_ReturnsCopies(t *testing.T) {
+	repo := NewTrackingRepository(nil)
+	event := testutil.CreateTestTrackingEventEntity("li_1")
+	event.EventID = "evt_1"
+	require.NoError(t, repo.Store(t.Context(), event))
+
+	event.Metadata["device"] = "desktop"
+	got, err := repo.FindByEventID(t.Context(), "evt_1")
+	require.NoError(t, err)
+	assert.Equal(t, "mobile", got.Metadata["device"])
+}
+
+func TestTrackingRepository_CountsEvents(t *testing.T) {
+	repo := NewTrackingRepository(nil)
+
+	newEvent := func(id, lineItemID, placement string, eventType model.TrackingEventType) *model.TrackingEventEntity {
+		event := testutil.CreateTestTrackingEventEntity(lineItemID)
+		event.EventID = id
+		event.Placement = placement
+		event.EventType = eventType
+		return event
+	}
+	require.NoError(t, repo.Store(t.Context(), newEvent("evt_1", "li_1", "homepage_top", model.TrackingEventTypeImpression)))
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		newEvent("evt_2", "li_1", "homepage_top", model.TrackingEventTypeClick),
+		newEvent("evt_3", "li_1", "sidebar", model.TrackingEventTypeImpression),
+		newEvent("evt_4", "li_2", "", model.TrackingEventTypeConversion),
+	})
+	require.NoError(t, err)
+
+	counts, err := repo.CountEvents(t.Context(), "li_1", "homepage_top")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 1, Clicks: 1}, counts)
+
+	counts, err = repo.CountEvents(t.Context(), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 2, Clicks: 1, Conversions: 1}, counts)
+
+	counts, err = repo.CountEvents(t.Context(), "li_2", "")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Conversions: 1}, counts, "events without a placement are counted once")
+
+	batch, err := repo.CountEventsBatch(t.Context(), []string{"", "li_1", "li_unknown"}, "sidebar")
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemEventCounts{
+		Total:     model.EventCounts{Impressions: 2, Clicks: 1, Conversions: 1},
+		Placement: model.EventCounts{Impressions: 1},
+	}, batch[""])
+	assert.Equal(t, model.LineItemEventCounts{
+		Total:     model.EventCounts{Impressions: 2, Clicks: 1},
+		Placement: model.EventCounts{Impressions: 1},
+	}, batch["li_1"])
+	assert.Equal(t, model.LineItemEventCounts{}, batch["li_unknown"])
+
+	counters, err := repo.ListEventCounters(t.Context())
+	require.NoError(t, err)
+	assert.NotEmpty(t, counters)
+}
+
+func TestTrackingRepository_EventCounterBuckets(t *testing.T) {
+	repo := NewTrackingRepository(nil)
+	before := time.Now().Add(-time.Second)
+
+	old := testutil.CreateTestTrackingEventEntity("li_1")
+	old.EventID = "evt_old"
+	old.Timestamp = time.Now().Add(-48 * time.Hour)
+	recent := testutil.CreateTestTrackingEventEntity("li_1")
+	recent.EventID = "evt_recent"
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{old, recent})
+	require.NoError(t, err)
+
+	buckets, err := repo.ListEventCounterBuckets(t.Context(), before)
+	require.NoError(t, err)
+	assert.NotEmpty(t, buckets)
+
+	buckets, err = repo.ListEventCounterBuckets(t.Context(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, buckets)
+
+	deleted, err := repo.DeleteEventCounterBuckets(t.Context(), time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Positive(t, deleted)
+
+	buckets, err = repo.ListEventCounterBuckets(t.Context(), time.Time{})
+	require.NoError(t, err)
+	for _, bucket := range buckets {
+		assert.True(t, bucket.BucketStart.After(time.Now().Add(-24*time.Hour)))
+	}
+}
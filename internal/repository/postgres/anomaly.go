@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type AnomalyAlertPostgresRepository struct {
+	db  *gorm.DB
+	log *zap.SugaredLogger
+}
+
+func NewAnomalyAlertPostgresRepository(db *gorm.DB, log *zap.SugaredLogger) *AnomalyAlertPostgresRepository {
+	return &AnomalyAlertPostgresRepository{db: db, log: log}
+}
+
+func (r *AnomalyAlertPostgresRepository) Create(ctx context.Context, alert *model.AnomalyAlertEntity) error {
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = time.Now()
+	}
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "line_item_id"}, {Name: "placement"}, {Name: "metric"}, {Name: "window_start"}},
+		DoNothing: true,
+	}).Create(alert)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repository.ErrDuplicateAlert
+	}
+	return nil
+}
+
+func (r *AnomalyAlertPostgresRepository) List(ctx context.Context, filter repository.AnomalyAlertFilter) ([]*model.AnomalyAlertEntity, error) {
+	var alerts []*model.AnomalyAlertEntity
+	query := r.db.WithContext(ctx).Model(&model.AnomalyAlertEntity{})
+
+	if filter.LineItemID != "" {
+		query = query.Where("line_item_id = ?", filter.LineItemID)
+	}
+	if filter.AdvertiserID != "" {
+		query = query.Where("advertiser_id = ?", filter.AdvertiserID)
+	}
+	if filter.Metric != "" {
+		query = query.Where("metric = ?", filter.Metric)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("window_start >= ?", filter.Since)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	err := query.Order("window_start DESC, id DESC").Find(&alerts).Error
+	return alerts, err
+}
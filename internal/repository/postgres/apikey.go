@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type APIKeyPostgresRepository struct {
+	db  *gorm.DB
+	log *zap.SugaredLogger
+}
+
+func NewAPIKeyPostgresRepository(db *gorm.DB, log *zap.SugaredLogger) *APIKeyPostgresRepository {
+	return &APIKeyPostgresRepository{db: db, log: log}
+}
+
+func (r *APIKeyPostgresRepository) Create(ctx context.Context, key *model.APIKeyEntity) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *APIKeyPostgresRepository) GetByHash(ctx context.Context, hash string) (*model.APIKeyEntity, error) {
+	var key model.APIKeyEntity
+	result := r.db.WithContext(ctx).First(&key, "hash = ?", hash)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrAPIKeyNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+func (r *APIKeyPostgresRepository) GetAll(ctx context.Context) ([]*model.APIKeyEntity, error) {
+	var keys []*model.APIKeyEntity
+	err := r.db.WithContext(ctx).Order("created_at, id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *APIKeyPostgresRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key model.APIKeyEntity
+		if err := tx.Select("id").First(&key, "id = ?", id).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return repository.ErrAPIKeyNotFound
+		} else if err != nil {
+			return err
+		}
+		return tx.Model(&model.APIKeyEntity{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", revokedAt).Error
+	})
+}
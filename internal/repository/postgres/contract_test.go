@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/db"
+	"sweng-task/internal/repository/repotest"
+	"sweng-task/internal/testutil"
+)
+
+// TestRepositoryContract runs against the database configured through the APP_DATABASE_*
+// variables, or a locally started Postgres, and is skipped when neither is available. It
+// empties the tables it uses.
+func TestRepositoryContract(t *testing.T) {
+	database, err := db.ConnectPostgres(repotest.PostgresConfig(t))
+	require.NoError(t, err)
+	log := testutil.GetTestLogger()
+	require.NoError(t, db.RunMigrations(database, "postgres", log))
+
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		require.NoError(t, database.Exec("TRUNCATE line_items, tracking_events, event_counters, event_counter_buckets, api_keys, anomaly_alerts CASCADE").Error)
+		return repotest.Repositories{
+			LineItems: NewLineItemPostgresRepository(database, log),
+			Tracking:  NewTrackingPostgresRepository(database, log),
+			APIKeys:   NewAPIKeyPostgresRepository(database, log),
+			Alerts:    NewAnomalyAlertPostgresRepository(database, log),
+		}
+	})
+}
@@ -1,9 +1,18 @@
 package postgres
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"sweng-task/internal/logging"
 	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
 )
 
 type LineItemPostgresRepository struct {
@@ -15,23 +24,36 @@ func NewLineItemPostgresRepository(db *gorm.DB, log *zap.SugaredLogger) *LineIte
 	return &LineItemPostgresRepository{db: db, log: log}
 }
 
-func (r *LineItemPostgresRepository) Create(item *model.LineItemEntity) error {
-	result := r.db.Create(item)
+func (r *LineItemPostgresRepository) Create(ctx context.Context, item *model.LineItemEntity) error {
+	result := r.db.WithContext(ctx).Create(item)
 	return result.Error
 }
 
-func (r *LineItemPostgresRepository) GetByID(id string) (*model.LineItemEntity, error) {
+func (r *LineItemPostgresRepository) GetByID(ctx context.Context, id string) (*model.LineItemEntity, error) {
 	var item model.LineItemEntity
-	result := r.db.First(&item, "id = ?", id)
+	result := r.db.WithContext(ctx).First(&item, "id = ?", id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrLineItemNotFound
+	}
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &item, nil
 }
 
-func (r *LineItemPostgresRepository) GetAll(advertiserID, placement string) ([]*model.LineItemEntity, error) {
+func (r *LineItemPostgresRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.LineItemEntity, error) {
+	var items []*model.LineItemEntity
+	if len(ids) == 0 {
+		return items, nil
+	}
+
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&items).Error
+	return items, err
+}
+
+func (r *LineItemPostgresRepository) GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItemEntity, error) {
 	var items []*model.LineItemEntity
-	query := r.db.Model(&model.LineItemEntity{})
+	query := r.db.WithContext(ctx).Model(&model.LineItemEntity{})
 
 	if advertiserID != "" {
 		query = query.Where("advertiser_id = ?", advertiserID)
@@ -44,10 +66,10 @@ func (r *LineItemPostgresRepository) GetAll(advertiserID, placement string) ([]*
 	return items, err
 }
 
-func (r *LineItemPostgresRepository) FindMatchingLineItems(placement, category, keyword string) ([]*model.LineItemEntity, error) {
+func (r *LineItemPostgresRepository) FindMatchingLineItems(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error) {
 	var items []*model.LineItemEntity
 
-	query := r.db.Where("placement = ? AND status = ? AND daily_spending < budget", placement, "active")
+	query := r.db.WithContext(ctx).Where("placement = ? AND status = ? AND daily_spending < budget", placement, "active")
 
 	if category != "" {
 		query = query.Where("? = ANY(categories)", category)
@@ -60,23 +82,23 @@ func (r *LineItemPostgresRepository) FindMatchingLineItems(placement, category,
 	return items, err
 }
 
-func (r *LineItemPostgresRepository) ResetDailySpending() error {
-	result := r.db.Model(&model.LineItemEntity{}).
+func (r *LineItemPostgresRepository) ResetDailySpending(ctx context.Context) error {
+	result := r.db.WithContext(ctx).Model(&model.LineItemEntity{}).
 		Where("daily_spending > 0").
 		Update("daily_spending", 0)
 
 	if result.Error != nil {
-		r.log.Errorw("Failed to reset daily budgets", "error", result.Error)
+		logging.FromContext(ctx, r.log).Errorw("Failed to reset daily budgets", "error", result.Error)
 		return result.Error
 	}
 
-	r.log.Infow("Daily budgets reset", "affected_rows", result.RowsAffected)
+	logging.FromContext(ctx, r.log).Infow("Daily budgets reset", "affected_rows", result.RowsAffected)
 	return nil
 }
 
-func (r *LineItemPostgresRepository) IncreaseDailySpending(lineItemID string, amount float64) error {
+func (r *LineItemPostgresRepository) IncreaseDailySpending(ctx context.Context, lineItemID string, amount float64) error {
 
-	result := r.db.Model(&model.LineItemEntity{}).
+	result := r.db.WithContext(ctx).Model(&model.LineItemEntity{}).
 		Where("id = ?", lineItemID).
 		Update("daily_spending", gorm.Expr("daily_spending + ?", amount))
 
@@ -85,3 +107,57 @@ func (r *LineItemPostgresRepository) IncreaseDailySpending(lineItemID string, am
 	}
 	return nil
 }
+
+func (r *LineItemPostgresRepository) UpdateStatus(ctx context.Context, id string, status model.LineItemStatus) error {
+	result := r.db.WithContext(ctx).Model(&model.LineItemEntity{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repository.ErrLineItemNotFound
+	}
+	return nil
+}
+
+func (r *LineItemPostgresRepository) IncreaseDailySpendingBatch(ctx context.Context, amounts map[string]float64) error {
+	if len(amounts) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return increaseDailySpending(tx, amounts)
+	})
+}
+
+// increaseDailySpending adds amounts, keyed by line item ID, to the daily spending of the line
+// items within tx. An UPDATE ... FROM locks rows in whatever order its join yields them, so
+// the rows are locked first in ID order, making concurrent writers wait on each other rather
+// than deadlock. The tracking repository calls it in the transaction storing the events
+// charged.
+func increaseDailySpending(tx *gorm.DB, amounts map[string]float64) error {
+	if len(amounts) == 0 {
+		return nil
+	}
+
+	ids := slices.Sorted(maps.Keys(amounts))
+	var locked []string
+	err := tx.Raw("SELECT id FROM line_items WHERE id IN ? ORDER BY id FOR UPDATE", ids).
+		Scan(&locked).Error
+	if err != nil {
+		return err
+	}
+
+	values := make([]string, 0, len(amounts))
+	args := make([]interface{}, 0, len(amounts)*2)
+	for _, id := range ids {
+		values = append(values, "(?, ?::double precision)")
+		args = append(args, id, amounts[id])
+	}
+
+	sql := "UPDATE line_items SET daily_spending = line_items.daily_spending + v.amount " +
+		"FROM (VALUES " + strings.Join(values, ", ") + ") AS v(id, amount) " +
+		"WHERE line_items.id = v.id"
+
+	return tx.Exec(sql, args...).Error
+}
@@ -1,10 +1,20 @@
 package postgres
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"sweng-task/internal/logging"
 	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
 )
 
 type TrackingPostgresRepository struct {
@@ -16,14 +26,30 @@ func NewTrackingPostgresRepository(db *gorm.DB, log *zap.SugaredLogger) *Trackin
 	return &TrackingPostgresRepository{db: db, log: log}
 }
 
-func (r *TrackingPostgresRepository) Store(event *model.TrackingEventEntity) error {
+func (r *TrackingPostgresRepository) Store(ctx context.Context, event *model.TrackingEventEntity) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "event_id"}},
+			DoNothing: true,
+		}).Create(event)
 
-	if err := r.db.Create(&event).Error; err != nil {
-		r.log.Errorw("Failed to insert tracking event", "error", err)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrDuplicateEvent
+		}
+		return recordStored(tx, []*model.TrackingEventEntity{event})
+	})
+	if errors.Is(err, repository.ErrDuplicateEvent) {
+		return err
+	}
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to insert tracking event", "error", err)
 		return err
 	}
 
-	r.log.Infow("Tracking event stored",
+	logging.FromContext(ctx, r.log).Infow("Tracking event stored",
 		"event_type", event.EventType,
 		"line_item_id", event.LineItemID,
 		"placement", event.Placement,
@@ -32,50 +58,280 @@ func (r *TrackingPostgresRepository) Store(event *model.TrackingEventEntity) err
 	return nil
 }
 
-func (r *TrackingPostgresRepository) FindAll() ([]*model.TrackingEventEntity, error) {
-	var events []*model.TrackingEventEntity
+func (r *TrackingPostgresRepository) StoreBatch(ctx context.Context, events []*model.TrackingEventEntity) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	// GORM cannot tell which rows of a bulk insert were skipped by ON CONFLICT DO NOTHING,
+	// so the insert is written by hand and returns the event IDs that were actually stored.
+	values := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*11)
+	for _, e := range events {
+		metadata, err := json.Marshal(e.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?::jsonb, ?, ?, ?, ?)")
+		args = append(args, e.EventID, e.EventType, e.LineItemID, e.Timestamp, e.Placement, e.UserID, string(metadata), e.Cost,
+			e.IP, e.UserAgent, e.InvalidReason)
+	}
+
+	sql := "INSERT INTO tracking_events (event_id, event_type, line_item_id, timestamp, placement, user_id, metadata, cost, ip, user_agent, invalid_reason) " +
+		"VALUES " + strings.Join(values, ", ") + " " +
+		"ON CONFLICT (event_id) DO NOTHING RETURNING id, event_id"
 
-	if err := r.db.Find(&events).Error; err != nil {
-		r.log.Errorw("Failed to fetch tracking events", "error", err)
+	var inserted []struct {
+		ID      uint64
+		EventID string
+	}
+	var duplicates []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw(sql, args...).Scan(&inserted).Error; err != nil {
+			return err
+		}
+
+		ids := make(map[string]uint64, len(inserted))
+		for _, row := range inserted {
+			ids[row.EventID] = row.ID
+		}
+
+		stored := make([]*model.TrackingEventEntity, 0, len(inserted))
+		for _, e := range events {
+			if id, ok := ids[e.EventID]; ok {
+				e.ID = id
+				stored = append(stored, e)
+			} else {
+				duplicates = append(duplicates, e.EventID)
+			}
+		}
+		return recordStored(tx, stored)
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to insert tracking event batch", "size", len(events), "error", err)
 		return nil, err
 	}
 
-	r.log.Infow("Fetched tracking events", "count", len(events))
+	logging.FromContext(ctx, r.log).Infow("Tracking event batch stored", "stored", len(inserted), "duplicates", len(duplicates))
+	return duplicates, nil
+}
+
+func (r *TrackingPostgresRepository) FindByEventIDs(ctx context.Context, eventIDs []string) ([]*model.TrackingEventEntity, error) {
+	var events []*model.TrackingEventEntity
+	if len(eventIDs) == 0 {
+		return events, nil
+	}
+
+	if err := r.db.WithContext(ctx).Where("event_id IN ?", eventIDs).Find(&events).Error; err != nil {
+		return nil, err
+	}
 	return events, nil
 }
 
-func (r *TrackingPostgresRepository) CountEvents(lineItemID string, placement string) (model.EventCounts, error) {
-	var groupedCounts []struct {
-		EventType string
-		Count     int
+func (r *TrackingPostgresRepository) FindByEventID(ctx context.Context, eventID string) (*model.TrackingEventEntity, error) {
+	var event model.TrackingEventEntity
+	if err := r.db.WithContext(ctx).First(&event, "event_id = ?", eventID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repository.ErrEventNotFound
+		}
+		return nil, err
 	}
+	return &event, nil
+}
 
-	query := r.db.Model(&model.TrackingEventEntity{}).
-		Select("event_type, COUNT(*) as count")
+func (r *TrackingPostgresRepository) FindAll(ctx context.Context) ([]*model.TrackingEventEntity, error) {
+	var events []*model.TrackingEventEntity
 
-	if lineItemID != "" {
-		query = query.Where("line_item_id = ?", lineItemID)
+	if err := r.db.WithContext(ctx).Find(&events).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch tracking events", "error", err)
+		return nil, err
 	}
-	if placement != "" {
-		query = query.Where("placement = ?", placement)
+
+	logging.FromContext(ctx, r.log).Infow("Fetched tracking events", "count", len(events))
+	return events, nil
+}
+
+func (r *TrackingPostgresRepository) CountEvents(ctx context.Context, lineItemID string, placement string) (model.EventCounts, error) {
+	if lineItemID == "" {
+		totals, err := countTotals(r.db.WithContext(ctx), []string{placement})
+		if err != nil {
+			return model.EventCounts{}, err
+		}
+		return totals[placement], nil
 	}
 
-	if err := query.Group("event_type").Scan(&groupedCounts).Error; err != nil {
+	var counters []model.EventCounterEntity
+	err := r.db.WithContext(ctx).Where("line_item_id = ? AND placement = ?", lineItemID, placement).
+		Limit(1).
+		Find(&counters).Error
+	if err != nil {
 		return model.EventCounts{}, err
 	}
+	if len(counters) == 0 {
+		return model.EventCounts{}, nil
+	}
+	return model.ToEventCounts(counters[0]), nil
+}
+
+func (r *TrackingPostgresRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	counts := make(map[string]model.LineItemEventCounts, len(lineItemIDs))
+	if len(lineItemIDs) == 0 {
+		return counts, nil
+	}
 
-	// Map results
-	var counts model.EventCounts
-	for _, row := range groupedCounts {
-		switch row.EventType {
-		case string(model.TrackingEventTypeImpression):
-			counts.Impressions = row.Count
-		case string(model.TrackingEventTypeClick):
-			counts.Clicks = row.Count
-		case string(model.TrackingEventTypeConversion):
-			counts.Conversions = row.Count
+	var counters []model.EventCounterEntity
+	err := r.db.WithContext(ctx).Where("line_item_id IN ? AND placement IN ?", lineItemIDs, []string{"", placement}).
+		Find(&counters).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counters", "line_items", len(lineItemIDs), "error", err)
+		return nil, err
+	}
+
+	for _, id := range lineItemIDs {
+		counts[id] = model.LineItemEventCounts{}
+	}
+	for _, counter := range counters {
+		c := counts[counter.LineItemID]
+		if counter.Placement == "" {
+			c.Total = model.ToEventCounts(counter)
+		}
+		if counter.Placement == placement {
+			c.Placement = model.ToEventCounts(counter)
 		}
+		counts[counter.LineItemID] = c
 	}
 
+	if slices.Contains(lineItemIDs, "") {
+		totals, err := countTotals(r.db.WithContext(ctx), []string{"", placement})
+		if err != nil {
+			logging.FromContext(ctx, r.log).Errorw("Failed to sum event counters", "error", err)
+			return nil, err
+		}
+		counts[""] = model.LineItemEventCounts{Total: totals[""], Placement: totals[placement]}
+	}
+	return counts, nil
+}
+
+func (r *TrackingPostgresRepository) LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error) {
+	var events []model.TrackingEventEntity
+	err := r.db.WithContext(ctx).Select("timestamp").
+		Where("line_item_id = ? AND user_id = ? AND event_type = ? AND invalid_reason = '' AND timestamp <= ?",
+			lineItemID, userID, model.TrackingEventTypeImpression, before).
+		Order("timestamp DESC").
+		Limit(1).
+		Find(&events).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to find last impression", "line_item_id", lineItemID, "error", err)
+		return time.Time{}, err
+	}
+	if len(events) == 0 {
+		return time.Time{}, repository.ErrEventNotFound
+	}
+	return events[0].Timestamp, nil
+}
+
+func (r *TrackingPostgresRepository) CountInvalidEvents(ctx context.Context, lineItemID string) ([]model.InvalidEventCount, error) {
+	var counts []model.InvalidEventCount
+	err := r.db.WithContext(ctx).Model(&model.TrackingEventEntity{}).
+		Select("invalid_reason AS reason, event_type, COUNT(*) AS count").
+		Where("line_item_id = ? AND invalid_reason <> ''", lineItemID).
+		Group("invalid_reason, event_type").
+		Scan(&counts).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to count invalid events", "line_item_id", lineItemID, "error", err)
+		return nil, err
+	}
 	return counts, nil
 }
+
+func (r *TrackingPostgresRepository) ListEventCounters(ctx context.Context) ([]*model.EventCounterEntity, error) {
+	var counters []*model.EventCounterEntity
+	if err := r.db.WithContext(ctx).Find(&counters).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counters", "error", err)
+		return nil, err
+	}
+	return counters, nil
+}
+
+func (r *TrackingPostgresRepository) ListEventCounterBuckets(ctx context.Context, updatedSince time.Time) ([]*model.EventCounterBucketEntity, error) {
+	var buckets []*model.EventCounterBucketEntity
+	if err := r.db.WithContext(ctx).Where("updated_at >= ?", updatedSince).Find(&buckets).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counter buckets", "error", err)
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (r *TrackingPostgresRepository) DeleteEventCounterBuckets(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("bucket_start < ?", before).Delete(&model.EventCounterBucketEntity{})
+	if result.Error != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to delete event counter buckets", "before", before, "error", result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// countTotals sums the counters of all line items in each of placements, an empty placement
+// summing their all-placements counters
+func countTotals(db *gorm.DB, placements []string) (map[string]model.EventCounts, error) {
+	var sums []model.EventCounterEntity
+	err := db.Model(&model.EventCounterEntity{}).
+		Select("placement, CAST(SUM(impressions) AS BIGINT) AS impressions, CAST(SUM(clicks) AS BIGINT) AS clicks, CAST(SUM(conversions) AS BIGINT) AS conversions").
+		Where("line_item_id <> '' AND placement IN ?", placements).
+		Group("placement").
+		Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]model.EventCounts, len(sums))
+	for _, sum := range sums {
+		totals[sum.Placement] = model.ToEventCounts(sum)
+	}
+	return totals, nil
+}
+
+// recordStored counts newly stored events and charges their cost to their line items, in the
+// transaction that stored them so that no event is stored without being charged
+func recordStored(tx *gorm.DB, events []*model.TrackingEventEntity) error {
+	if err := incrementCounters(tx, events); err != nil {
+		return err
+	}
+	return increaseDailySpending(tx, model.ToSpendingAmounts(events))
+}
+
+// incrementCounters adds the events to their rolled-up counters and hourly buckets with one upsert each
+func incrementCounters(tx *gorm.DB, events []*model.TrackingEventEntity) error {
+	deltas := model.ToEventCounterDeltas(events)
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "line_item_id"}, {Name: "placement"}},
+		DoUpdates: counterIncrements("event_counters"),
+	}).Create(&deltas).Error
+	if err != nil {
+		return err
+	}
+
+	buckets := model.ToEventCounterBucketDeltas(events, time.Now().UTC())
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "line_item_id"}, {Name: "placement"}, {Name: "bucket_start"}},
+		DoUpdates: append(counterIncrements("event_counter_buckets"),
+			clause.Assignment{Column: clause.Column{Name: "spend"}, Value: gorm.Expr("event_counter_buckets.spend + EXCLUDED.spend")},
+			clause.Assignment{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("EXCLUDED.updated_at")},
+		),
+	}).Create(&buckets).Error
+}
+
+func counterIncrements(table string) clause.Set {
+	set := make(clause.Set, 0, 3)
+	for _, column := range []string{"impressions", "clicks", "conversions"} {
+		set = append(set, clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value:  gorm.Expr(table + "." + column + " + EXCLUDED." + column),
+		})
+	}
+	return set
+}
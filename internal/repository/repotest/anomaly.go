@@ -0,0 +1,73 @@
+package repotest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+func testAnomalyAlerts(t *testing.T, repos Repositories) {
+	first := newLineItem(t, repos, nil)
+	second := newLineItem(t, repos, func(item *model.LineItemEntity) { item.AdvertiserID = "adv_other" })
+	hour := time.Now().Truncate(time.Hour)
+
+	newAlert := func(item *model.LineItemEntity, metric model.AnomalyMetric, windowStart time.Time) *model.AnomalyAlertEntity {
+		return &model.AnomalyAlertEntity{
+			LineItemID:   item.ID,
+			AdvertiserID: item.AdvertiserID,
+			Placement:    item.Placement,
+			Metric:       metric,
+			WindowStart:  windowStart,
+			Value:        0.2,
+			Baseline:     0.05,
+			StdDev:       0.01,
+			ZScore:       15,
+		}
+	}
+	older := newAlert(first, model.AnomalyMetricCTR, hour.Add(-3*time.Hour))
+	latest := newAlert(first, model.AnomalyMetricSpend, hour.Add(-time.Hour))
+	latest.Paused = true
+	other := newAlert(second, model.AnomalyMetricCTR, hour.Add(-2*time.Hour))
+	for _, alert := range []*model.AnomalyAlertEntity{older, latest, other} {
+		require.NoError(t, repos.Alerts.Create(t.Context(), alert))
+		assert.NotZero(t, alert.ID)
+	}
+
+	assert.ErrorIs(t, repos.Alerts.Create(t.Context(), newAlert(first, model.AnomalyMetricSpend, hour.Add(-time.Hour))), repository.ErrDuplicateAlert)
+	require.NoError(t, repos.Alerts.Create(t.Context(), func() *model.AnomalyAlertEntity {
+		alert := newAlert(first, model.AnomalyMetricSpend, hour.Add(-time.Hour))
+		alert.Placement = ""
+		return alert
+	}()), "alerts of a single placement and of all placements are distinct")
+
+	alerts, err := repos.Alerts.List(t.Context(), repository.AnomalyAlertFilter{LineItemID: first.ID, Since: hour.Add(-3 * time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, alerts, 3)
+	assert.True(t, alerts[0].WindowStart.Equal(latest.WindowStart), "latest hour first")
+	assert.True(t, alerts[2].WindowStart.Equal(older.WindowStart))
+	assert.Equal(t, older.ID, alerts[2].ID)
+	assert.Equal(t, model.AnomalyMetricCTR, alerts[2].Metric)
+	assert.Equal(t, first.AdvertiserID, alerts[2].AdvertiserID)
+	assert.Equal(t, 0.2, alerts[2].Value)
+	assert.Equal(t, 15.0, alerts[2].ZScore)
+	assert.False(t, alerts[2].Paused)
+	assert.NotZero(t, alerts[2].CreatedAt)
+
+	alerts, err = repos.Alerts.List(t.Context(), repository.AnomalyAlertFilter{Since: hour.Add(-2 * time.Hour)})
+	require.NoError(t, err)
+	assert.Len(t, alerts, 3, "alerts on earlier hours are left out")
+
+	alerts, err = repos.Alerts.List(t.Context(), repository.AnomalyAlertFilter{AdvertiserID: "adv_other"})
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, other.ID, alerts[0].ID)
+
+	alerts, err = repos.Alerts.List(t.Context(), repository.AnomalyAlertFilter{Metric: model.AnomalyMetricSpend, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, model.AnomalyMetricSpend, alerts[0].Metric)
+}
@@ -0,0 +1,63 @@
+package repotest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+func testAPIKeys(t *testing.T, repos Repositories) {
+	createdAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	admin := &model.APIKeyEntity{
+		ID:        "key_admin",
+		Name:      "ops",
+		Role:      model.RoleAdmin,
+		Prefix:    "ak_admin",
+		Hash:      "hash_admin",
+		CreatedAt: createdAt,
+	}
+	advertiser := &model.APIKeyEntity{
+		ID:           "key_advertiser",
+		Name:         "adv_123 dashboard",
+		Role:         model.RoleAdvertiser,
+		AdvertiserID: "adv_123",
+		Prefix:       "ak_advert",
+		Hash:         "hash_advertiser",
+		CreatedAt:    createdAt.Add(time.Minute),
+	}
+	require.NoError(t, repos.APIKeys.Create(t.Context(), admin))
+	require.NoError(t, repos.APIKeys.Create(t.Context(), advertiser))
+	assert.Error(t, repos.APIKeys.Create(t.Context(), &model.APIKeyEntity{
+		ID: "key_other", Name: "copy", Role: model.RolePublisher, Prefix: "ak_admin", Hash: "hash_admin",
+	}), "key hashes are unique")
+
+	got, err := repos.APIKeys.GetByHash(t.Context(), "hash_advertiser")
+	require.NoError(t, err)
+	assert.Equal(t, advertiser.ID, got.ID)
+	assert.Equal(t, model.RoleAdvertiser, got.Role)
+	assert.Equal(t, "adv_123", got.AdvertiserID)
+	assert.WithinDuration(t, advertiser.CreatedAt, got.CreatedAt, time.Millisecond)
+	assert.Nil(t, got.RevokedAt)
+
+	_, err = repos.APIKeys.GetByHash(t.Context(), "hash_unknown")
+	assert.ErrorIs(t, err, repository.ErrAPIKeyNotFound)
+
+	keys, err := repos.APIKeys.GetAll(t.Context())
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, []string{admin.ID, advertiser.ID}, []string{keys[0].ID, keys[1].ID}, "oldest first")
+
+	revokedAt := time.Now().Truncate(time.Millisecond)
+	require.NoError(t, repos.APIKeys.Revoke(t.Context(), advertiser.ID, revokedAt))
+	require.NoError(t, repos.APIKeys.Revoke(t.Context(), advertiser.ID, revokedAt.Add(time.Hour)))
+	assert.ErrorIs(t, repos.APIKeys.Revoke(t.Context(), "key_unknown", revokedAt), repository.ErrAPIKeyNotFound)
+
+	got, err = repos.APIKeys.GetByHash(t.Context(), "hash_advertiser")
+	require.NoError(t, err)
+	require.NotNil(t, got.RevokedAt, "revoked keys are still found, so that they can be rejected")
+	assert.WithinDuration(t, revokedAt, *got.RevokedAt, time.Millisecond, "the first revocation time is kept")
+}
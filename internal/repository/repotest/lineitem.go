@@ -0,0 +1,239 @@
+package repotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+func testCreateAndGet(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Keywords = nil
+	})
+
+	got, err := repos.LineItems.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, item.Name, got.Name)
+	assert.Equal(t, item.Budget, got.Budget)
+	assert.Equal(t, []string{"electronics"}, []string(got.Categories))
+	assert.Empty(t, got.Keywords)
+	assert.Equal(t, model.LineItemStatusActive, got.Status)
+	assert.WithinDuration(t, item.CreatedAt, got.CreatedAt, time.Millisecond)
+
+	_, err = repos.LineItems.GetByID(t.Context(), "li_unknown")
+	assert.ErrorIs(t, err, repository.ErrLineItemNotFound)
+
+	assert.Error(t, repos.LineItems.Create(t.Context(), item), "line item IDs are unique")
+
+	items, err := repos.LineItems.GetByIDs(t.Context(), []string{item.ID, "li_unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{item.ID}, lineItemIDs(items))
+
+	items, err = repos.LineItems.GetByIDs(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func testGetAll(t *testing.T, repos Repositories) {
+	first := newLineItem(t, repos, nil)
+	otherAdvertiser := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.AdvertiserID = "adv_other"
+	})
+	otherPlacement := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Placement = "sidebar"
+	})
+
+	items, err := repos.LineItems.GetAll(t.Context(), "", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first.ID, otherAdvertiser.ID, otherPlacement.ID}, lineItemIDs(items))
+
+	items, err = repos.LineItems.GetAll(t.Context(), "adv_123", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first.ID, otherPlacement.ID}, lineItemIDs(items))
+
+	items, err = repos.LineItems.GetAll(t.Context(), "adv_123", "homepage_top")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first.ID}, lineItemIDs(items))
+}
+
+func testFindMatching(t *testing.T, repos Repositories) {
+	matching := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Categories = []string{"sports", "electronics"}
+		item.Keywords = []string{"phone", "test"}
+	})
+	newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Status = model.LineItemStatusPaused
+	})
+	newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Placement = "sidebar"
+	})
+	noTargeting := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Categories = nil
+		item.Keywords = nil
+	})
+
+	items, err := repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", "", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{matching.ID, noTargeting.ID}, lineItemIDs(items))
+
+	items, err = repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", "electronics", "phone")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{matching.ID}, lineItemIDs(items))
+
+	// Categories and keywords match any element of the array, exactly and case-sensitively
+	for _, filter := range [][2]string{{"Electronics", ""}, {"electro", ""}, {"", "PHONE"}, {"electronics", "tablet"}} {
+		items, err = repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", filter[0], filter[1])
+		require.NoError(t, err)
+		assert.Empty(t, items, "category %q keyword %q", filter[0], filter[1])
+	}
+}
+
+func testDailySpending(t *testing.T, repos Repositories) {
+	first := newLineItem(t, repos, nil)
+	second := newLineItem(t, repos, nil)
+
+	require.NoError(t, repos.LineItems.IncreaseDailySpending(t.Context(), first.ID, 1.5))
+	require.NoError(t, repos.LineItems.IncreaseDailySpendingBatch(t.Context(), map[string]float64{first.ID: 2, second.ID: 0.5}))
+	assert.NoError(t, repos.LineItems.IncreaseDailySpending(t.Context(), "li_unknown", 1), "unknown line items are ignored")
+
+	got, err := repos.LineItems.GetByID(t.Context(), first.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 3.5, got.DailySpending, 1e-9)
+	got, err = repos.LineItems.GetByID(t.Context(), second.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, got.DailySpending, 1e-9)
+
+	require.NoError(t, repos.LineItems.ResetDailySpending(t.Context()))
+	items, err := repos.LineItems.GetAll(t.Context(), "", "")
+	require.NoError(t, err)
+	for _, item := range items {
+		assert.Zero(t, item.DailySpending)
+	}
+}
+
+func testBudgetExclusions(t *testing.T, repos Repositories) {
+	underBudget := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.DailySpending = item.Budget - 0.01
+	})
+	atBudget := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.DailySpending = item.Budget
+	})
+	newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.DailySpending = item.Budget + 1
+	})
+	zeroBudget := newLineItem(t, repos, func(item *model.LineItemEntity) {
+		item.Budget = 0
+	})
+
+	items, err := repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", "electronics", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{underBudget.ID}, lineItemIDs(items), "line items are served while spending < budget")
+
+	// Spending up to the budget takes a line item out of selection until the daily reset
+	require.NoError(t, repos.LineItems.IncreaseDailySpending(t.Context(), underBudget.ID, 0.01))
+	items, err = repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", "electronics", "")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	require.NoError(t, repos.LineItems.ResetDailySpending(t.Context()))
+	items, err = repos.LineItems.FindMatchingLineItems(t.Context(), "homepage_top", "electronics", "")
+	require.NoError(t, err)
+	assert.NotContains(t, lineItemIDs(items), zeroBudget.ID, "a zero budget never serves")
+	assert.Contains(t, lineItemIDs(items), underBudget.ID)
+	assert.Contains(t, lineItemIDs(items), atBudget.ID)
+	assert.Len(t, items, 3)
+}
+
+func testConcurrentSpending(t *testing.T, repos Repositories) {
+	const (
+		workers    = 20
+		increments = 25
+		amount     = 0.5
+	)
+	first := newLineItem(t, repos, nil)
+	second := newLineItem(t, repos, nil)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				// Alternate single and batched updates so both paths race against each other
+				if w%2 == 0 {
+					assert.NoError(t, repos.LineItems.IncreaseDailySpending(t.Context(), first.ID, amount))
+				} else {
+					assert.NoError(t, repos.LineItems.IncreaseDailySpendingBatch(t.Context(), map[string]float64{first.ID: amount, second.ID: amount}))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := repos.LineItems.GetByID(t.Context(), first.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, workers*increments*amount, got.DailySpending, 1e-6, "no increment is lost")
+
+	got, err = repos.LineItems.GetByID(t.Context(), second.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, workers/2*increments*amount, got.DailySpending, 1e-6)
+}
+
+func testConcurrentBatchSpending(t *testing.T, repos Repositories) {
+	const (
+		workers = 10
+		batches = 20
+		items   = 10
+		amount  = 0.25
+	)
+	lineItems := make([]*model.LineItemEntity, items)
+	for i := range lineItems {
+		lineItems[i] = newLineItem(t, repos, nil)
+	}
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				// Overlapping batches over a shifting subset of the line items, so writers
+				// would deadlock if they locked rows in different orders
+				amounts := make(map[string]float64, items-1)
+				for i := range items - 1 {
+					amounts[lineItems[(w+b+i)%items].ID] = amount
+				}
+				assert.NoError(t, repos.LineItems.IncreaseDailySpendingBatch(t.Context(), amounts))
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total float64
+	for _, item := range lineItems {
+		got, err := repos.LineItems.GetByID(t.Context(), item.ID)
+		require.NoError(t, err)
+		total += got.DailySpending
+	}
+	assert.InDelta(t, workers*batches*(items-1)*amount, total, 1e-6, "no increment is lost")
+}
+
+func testUpdateStatus(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+
+	require.NoError(t, repos.LineItems.UpdateStatus(t.Context(), item.ID, model.LineItemStatusPaused))
+	got, err := repos.LineItems.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemStatusPaused, got.Status)
+
+	matching, err := repos.LineItems.FindMatchingLineItems(t.Context(), item.Placement, "", "")
+	require.NoError(t, err)
+	assert.NotContains(t, lineItemIDs(matching), item.ID, "paused line items are not served")
+
+	assert.ErrorIs(t, repos.LineItems.UpdateStatus(t.Context(), "li_unknown", model.LineItemStatusPaused), repository.ErrLineItemNotFound)
+}
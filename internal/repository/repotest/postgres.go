@@ -0,0 +1,83 @@
+package repotest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/config"
+)
+
+// requirePostgresEnv names the variable that, when set, fails Postgres tests that would be
+// skipped, so that CI notices when they stop running
+const requirePostgresEnv = "TEST_REQUIRE_POSTGRES"
+
+// PostgresConfig returns the database that Postgres tests run against: the one configured
+// through the APP_DATABASE_* variables, or else a throwaway instance started with the initdb
+// and pg_ctl binaries on the PATH and stopped when the test ends. The test is skipped when
+// neither is available, unless TEST_REQUIRE_POSTGRES is set.
+func PostgresConfig(t *testing.T) config.DatabaseConfig {
+	t.Helper()
+
+	if os.Getenv("APP_DATABASE_HOST") != "" {
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		return cfg.Database
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		skipPostgres(t, "APP_DATABASE_HOST not set and initdb not found")
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		skipPostgres(t, "APP_DATABASE_HOST not set and pg_ctl not found")
+	}
+
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+		// initdb refuses to run as root, among other environment problems
+		skipPostgres(t, "Failed to initialise a local Postgres: %v\n%s", err, out)
+	}
+
+	port := freePort(t)
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", dataDir, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput(); err != nil {
+		skipPostgres(t, "Failed to start a local Postgres: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "-w", "stop").Run()
+	})
+
+	return config.DatabaseConfig{
+		Driver:   "postgres",
+		Host:     "127.0.0.1",
+		Port:     port,
+		User:     "postgres",
+		Database: "postgres",
+	}
+}
+
+// skipPostgres skips the test for want of a Postgres, or fails it if TEST_REQUIRE_POSTGRES is set
+func skipPostgres(t *testing.T, format string, args ...any) {
+	t.Helper()
+
+	if os.Getenv(requirePostgresEnv) != "" {
+		t.Fatalf(format+" (%s is set)", append(args, requirePostgresEnv)...)
+	}
+	t.Skipf(format, args...)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
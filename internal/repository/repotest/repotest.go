@@ -0,0 +1,80 @@
+// Package repotest holds the contract tests that every implementation of the repository
+// interfaces must pass, so that storage backends can be swapped without changing behaviour
+package repotest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/testutil"
+)
+
+// Repositories is one storage backend under test
+type Repositories struct {
+	LineItems repository.LineItemRepository
+	Tracking  repository.TrackingRepository
+	APIKeys   repository.APIKeyRepository
+	Alerts    repository.AnomalyAlertRepository
+}
+
+// Factory returns empty repositories of the backend under test. It is called once per test.
+type Factory func(t *testing.T) Repositories
+
+// Run runs the contract tests against the repositories returned by newRepos
+func Run(t *testing.T, newRepos Factory) {
+	t.Run("LineItems", func(t *testing.T) {
+		t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, newRepos(t)) })
+		t.Run("GetAll", func(t *testing.T) { testGetAll(t, newRepos(t)) })
+		t.Run("FindMatching", func(t *testing.T) { testFindMatching(t, newRepos(t)) })
+		t.Run("DailySpending", func(t *testing.T) { testDailySpending(t, newRepos(t)) })
+		t.Run("BudgetExclusions", func(t *testing.T) { testBudgetExclusions(t, newRepos(t)) })
+		t.Run("ConcurrentSpending", func(t *testing.T) { testConcurrentSpending(t, newRepos(t)) })
+		t.Run("ConcurrentBatchSpending", func(t *testing.T) { testConcurrentBatchSpending(t, newRepos(t)) })
+		t.Run("UpdateStatus", func(t *testing.T) { testUpdateStatus(t, newRepos(t)) })
+	})
+	t.Run("Tracking", func(t *testing.T) {
+		t.Run("StoreAndFind", func(t *testing.T) { testStoreAndFind(t, newRepos(t)) })
+		t.Run("StoreBatch", func(t *testing.T) { testStoreBatch(t, newRepos(t)) })
+		t.Run("CountEvents", func(t *testing.T) { testCountEvents(t, newRepos(t)) })
+		t.Run("CountAggregation", func(t *testing.T) { testCountAggregation(t, newRepos(t)) })
+		t.Run("ConcurrentStore", func(t *testing.T) { testConcurrentStore(t, newRepos(t)) })
+		t.Run("CounterBuckets", func(t *testing.T) { testCounterBuckets(t, newRepos(t)) })
+		t.Run("InvalidTraffic", func(t *testing.T) { testInvalidTraffic(t, newRepos(t)) })
+		t.Run("ChargesSpending", func(t *testing.T) { testChargesSpending(t, newRepos(t)) })
+	})
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newRepos(t)) })
+	t.Run("AnomalyAlerts", func(t *testing.T) { testAnomalyAlerts(t, newRepos(t)) })
+}
+
+func newLineItem(t *testing.T, repos Repositories, modify func(item *model.LineItemEntity)) *model.LineItemEntity {
+	t.Helper()
+
+	item := testutil.CreateTestLineItemEntity()
+	item.CreatedAt = time.Now()
+	item.UpdatedAt = item.CreatedAt
+	if modify != nil {
+		modify(item)
+	}
+	require.NoError(t, repos.LineItems.Create(t.Context(), item))
+	return item
+}
+
+func newEvent(lineItemID, placement string, eventType model.TrackingEventType) *model.TrackingEventEntity {
+	event := testutil.CreateTestTrackingEventEntity(lineItemID)
+	event.EventID = "evt_" + uuid.New().String()
+	event.Placement = placement
+	event.EventType = eventType
+	return event
+}
+
+func lineItemIDs(items []*model.LineItemEntity) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
@@ -0,0 +1,337 @@
+package repotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+func testStoreAndFind(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	event := newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick)
+	event.Cost = 0.25
+
+	require.NoError(t, repos.Tracking.Store(t.Context(), event))
+	assert.NotZero(t, event.ID)
+	assert.ErrorIs(t, repos.Tracking.Store(t.Context(), newEventWithID(event)), repository.ErrDuplicateEvent)
+
+	got, err := repos.Tracking.FindByEventID(t.Context(), event.EventID)
+	require.NoError(t, err)
+	assert.Equal(t, event.ID, got.ID)
+	assert.Equal(t, event.LineItemID, got.LineItemID)
+	assert.Equal(t, model.TrackingEventTypeClick, got.EventType)
+	assert.Equal(t, map[string]string{"device": "mobile"}, got.Metadata)
+	assert.Equal(t, 0.25, got.Cost)
+	assert.WithinDuration(t, event.Timestamp, got.Timestamp, time.Millisecond)
+
+	_, err = repos.Tracking.FindByEventID(t.Context(), "evt_unknown")
+	assert.ErrorIs(t, err, repository.ErrEventNotFound)
+
+	events, err := repos.Tracking.FindAll(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func newEventWithID(event *model.TrackingEventEntity) *model.TrackingEventEntity {
+	duplicate := newEvent(event.LineItemID, event.Placement, event.EventType)
+	duplicate.EventID = event.EventID
+	return duplicate
+}
+
+func testStoreBatch(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	stored := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	require.NoError(t, repos.Tracking.Store(t.Context(), stored))
+
+	first := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	second := newEvent(item.ID, "sidebar", model.TrackingEventTypeClick)
+	duplicates, err := repos.Tracking.StoreBatch(t.Context(), []*model.TrackingEventEntity{first, newEventWithID(stored), second})
+	require.NoError(t, err)
+	assert.Equal(t, []string{stored.EventID}, duplicates)
+	assert.NotZero(t, first.ID)
+	assert.NotZero(t, second.ID)
+
+	events, err := repos.Tracking.FindByEventIDs(t.Context(), []string{first.EventID, second.EventID, "evt_unknown"})
+	require.NoError(t, err)
+	var eventIDs []string
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.EventID)
+	}
+	assert.ElementsMatch(t, []string{first.EventID, second.EventID}, eventIDs)
+
+	duplicates, err = repos.Tracking.StoreBatch(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+}
+
+func testChargesSpending(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	other := newLineItem(t, repos, nil)
+
+	event := newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick)
+	event.Cost = 0.25
+	require.NoError(t, repos.Tracking.Store(t.Context(), event))
+	assert.ErrorIs(t, repos.Tracking.Store(t.Context(), newEventWithID(event)), repository.ErrDuplicateEvent)
+
+	batch := []*model.TrackingEventEntity{
+		newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression),
+		newEvent(other.ID, "sidebar", model.TrackingEventTypeImpression),
+		newEventWithID(event),
+	}
+	batch[0].Cost = 0.5
+	batch[1].Cost = 1
+	batch[2].Cost = 0.25
+	_, err := repos.Tracking.StoreBatch(t.Context(), batch)
+	require.NoError(t, err)
+
+	got, err := repos.LineItems.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.75, got.DailySpending, 1e-9, "duplicates are not charged again")
+	got, err = repos.LineItems.GetByID(t.Context(), other.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, got.DailySpending, 1e-9)
+}
+
+func testCountEvents(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	_, err := repos.Tracking.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression),
+		newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick),
+		newEvent(item.ID, "sidebar", model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+
+	counts, err := repos.Tracking.CountEvents(t.Context(), item.ID, "homepage_top")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 1, Clicks: 1}, counts)
+
+	counts, err = repos.Tracking.CountEvents(t.Context(), item.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 2, Clicks: 1}, counts)
+
+	batch, err := repos.Tracking.CountEventsBatch(t.Context(), []string{"", item.ID}, "sidebar")
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemEventCounts{
+		Total:     model.EventCounts{Impressions: 2, Clicks: 1},
+		Placement: model.EventCounts{Impressions: 1},
+	}, batch[item.ID])
+	assert.Equal(t, batch[item.ID], batch[""])
+}
+
+func testCounterBuckets(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	before := time.Now().Add(-time.Second)
+
+	old := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	old.Timestamp = time.Now().Add(-48 * time.Hour)
+	recent := newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick)
+	recent.Cost = 0.5
+	_, err := repos.Tracking.StoreBatch(t.Context(), []*model.TrackingEventEntity{old, recent})
+	require.NoError(t, err)
+	another := newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick)
+	another.Cost = 0.25
+	require.NoError(t, repos.Tracking.Store(t.Context(), another))
+
+	buckets, err := repos.Tracking.ListEventCounterBuckets(t.Context(), before)
+	require.NoError(t, err)
+	recentStart := recent.Timestamp.UTC().Truncate(model.EventCounterBucketSize)
+	var found bool
+	for _, bucket := range buckets {
+		if bucket.LineItemID == item.ID && bucket.Placement == "homepage_top" && bucket.BucketStart.Equal(recentStart) {
+			found = true
+			assert.Equal(t, 2, bucket.Clicks, "increments of the same bucket add up")
+			assert.InDelta(t, 0.75, bucket.Spend, 1e-9, "spend of the same bucket adds up")
+		}
+	}
+	assert.True(t, found, "bucket of the recent events is listed")
+
+	buckets, err = repos.Tracking.ListEventCounterBuckets(t.Context(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, buckets)
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	deleted, err := repos.Tracking.DeleteEventCounterBuckets(t.Context(), cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted, "the old event's bucket in total and in its placement")
+
+	buckets, err = repos.Tracking.ListEventCounterBuckets(t.Context(), time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, buckets, 2)
+	for _, bucket := range buckets {
+		assert.True(t, bucket.BucketStart.After(cutoff))
+	}
+}
+
+func testInvalidTraffic(t *testing.T, repos Repositories) {
+	item := newLineItem(t, repos, nil)
+	now := time.Now()
+
+	older := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	older.UserID = "user-1"
+	older.Timestamp = now.Add(-time.Hour)
+	latest := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	latest.UserID = "user-1"
+	latest.Timestamp = now.Add(-time.Minute)
+	latest.IP = "203.0.113.7"
+	latest.UserAgent = "Mozilla/5.0"
+	botImpression := newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	botImpression.UserID = "user-1"
+	botImpression.InvalidReason = "bot_user_agent"
+	orphanClick := newEvent(item.ID, "homepage_top", model.TrackingEventTypeClick)
+	orphanClick.UserID = "user-2"
+	orphanClick.InvalidReason = "click_without_impression"
+	_, err := repos.Tracking.StoreBatch(t.Context(), []*model.TrackingEventEntity{older, latest, botImpression})
+	require.NoError(t, err)
+	require.NoError(t, repos.Tracking.Store(t.Context(), orphanClick))
+
+	got, err := repos.Tracking.FindByEventID(t.Context(), latest.EventID)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", got.IP)
+	assert.Equal(t, "Mozilla/5.0", got.UserAgent)
+
+	counts, err := repos.Tracking.CountEvents(t.Context(), item.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: 2}, counts, "invalid events are not counted")
+
+	last, err := repos.Tracking.LastImpression(t.Context(), item.ID, "user-1", now)
+	require.NoError(t, err)
+	assert.WithinDuration(t, latest.Timestamp, last, time.Millisecond, "invalid impressions are ignored")
+
+	last, err = repos.Tracking.LastImpression(t.Context(), item.ID, "user-1", now.Add(-30*time.Minute))
+	require.NoError(t, err)
+	assert.WithinDuration(t, older.Timestamp, last, time.Millisecond)
+
+	_, err = repos.Tracking.LastImpression(t.Context(), item.ID, "user-2", now)
+	assert.ErrorIs(t, err, repository.ErrEventNotFound)
+
+	invalid, err := repos.Tracking.CountInvalidEvents(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []model.InvalidEventCount{
+		{Reason: "bot_user_agent", EventType: model.TrackingEventTypeImpression, Count: 1},
+		{Reason: "click_without_impression", EventType: model.TrackingEventTypeClick, Count: 1},
+	}, invalid)
+}
+
+func testCountAggregation(t *testing.T, repos Repositories) {
+	first := newLineItem(t, repos, nil)
+	second := newLineItem(t, repos, nil)
+	_, err := repos.Tracking.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		newEvent(first.ID, "homepage_top", model.TrackingEventTypeImpression),
+		newEvent(first.ID, "homepage_top", model.TrackingEventTypeImpression),
+		newEvent(first.ID, "sidebar", model.TrackingEventTypeClick),
+		newEvent(first.ID, "", model.TrackingEventTypeConversion),
+		newEvent(second.ID, "homepage_top", model.TrackingEventTypeClick),
+		newEvent(second.ID, "", model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.Tracking.Store(t.Context(), newEvent(second.ID, "sidebar", model.TrackingEventTypeConversion)))
+
+	for _, tc := range []struct {
+		lineItemID, placement string
+		expected              model.EventCounts
+	}{
+		{first.ID, "homepage_top", model.EventCounts{Impressions: 2}},
+		{first.ID, "sidebar", model.EventCounts{Clicks: 1}},
+		// Events without a placement only count towards the all-placements totals
+		{first.ID, "", model.EventCounts{Impressions: 2, Clicks: 1, Conversions: 1}},
+		{second.ID, "", model.EventCounts{Impressions: 1, Clicks: 1, Conversions: 1}},
+		{"", "homepage_top", model.EventCounts{Impressions: 2, Clicks: 1}},
+		{"", "sidebar", model.EventCounts{Clicks: 1, Conversions: 1}},
+		{"", "", model.EventCounts{Impressions: 3, Clicks: 2, Conversions: 2}},
+		{"li_unknown", "", model.EventCounts{}},
+	} {
+		counts, err := repos.Tracking.CountEvents(t.Context(), tc.lineItemID, tc.placement)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, counts, "line item %q placement %q", tc.lineItemID, tc.placement)
+	}
+
+	batch, err := repos.Tracking.CountEventsBatch(t.Context(), []string{"", first.ID, second.ID, "li_unknown"}, "homepage_top")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]model.LineItemEventCounts{
+		"": {
+			Total:     model.EventCounts{Impressions: 3, Clicks: 2, Conversions: 2},
+			Placement: model.EventCounts{Impressions: 2, Clicks: 1},
+		},
+		first.ID: {
+			Total:     model.EventCounts{Impressions: 2, Clicks: 1, Conversions: 1},
+			Placement: model.EventCounts{Impressions: 2},
+		},
+		second.ID: {
+			Total:     model.EventCounts{Impressions: 1, Clicks: 1, Conversions: 1},
+			Placement: model.EventCounts{Clicks: 1},
+		},
+		"li_unknown": {},
+	}, batch)
+
+	// The listed counters agree with CountEvents
+	counters, err := repos.Tracking.ListEventCounters(t.Context())
+	require.NoError(t, err)
+	for _, counter := range counters {
+		counts, err := repos.Tracking.CountEvents(t.Context(), counter.LineItemID, counter.Placement)
+		require.NoError(t, err)
+		assert.Equal(t, model.ToEventCounts(*counter), counts)
+	}
+	assert.Len(t, counters, 6, "one row per line item and placement, the totals across line items being summed")
+}
+
+func testConcurrentStore(t *testing.T, repos Repositories) {
+	const (
+		workers = 8
+		events  = 50
+	)
+	item := newLineItem(t, repos, nil)
+
+	// Every worker stores the same events, so each must be stored exactly once
+	shared := make([]*model.TrackingEventEntity, events)
+	for i := range shared {
+		shared[i] = newEvent(item.ID, "homepage_top", model.TrackingEventTypeImpression)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	duplicates := 0
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := make([]*model.TrackingEventEntity, 0, events)
+			for _, event := range shared {
+				batch = append(batch, newEventWithID(event))
+			}
+
+			var dups []string
+			if w%2 == 0 {
+				var err error
+				dups, err = repos.Tracking.StoreBatch(t.Context(), batch)
+				assert.NoError(t, err)
+			} else {
+				for _, event := range batch {
+					if err := repos.Tracking.Store(t.Context(), event); err != nil {
+						assert.ErrorIs(t, err, repository.ErrDuplicateEvent)
+						dups = append(dups, event.EventID)
+					}
+				}
+			}
+
+			mu.Lock()
+			duplicates += len(dups)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, (workers-1)*events, duplicates)
+
+	all, err := repos.Tracking.FindAll(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, all, events)
+
+	counts, err := repos.Tracking.CountEvents(t.Context(), item.ID, "homepage_top")
+	require.NoError(t, err)
+	assert.Equal(t, model.EventCounts{Impressions: events}, counts, "counters only count stored events")
+}
@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type APIKeySQLiteRepository struct {
+	db  *gorm.DB
+	log *zap.SugaredLogger
+}
+
+func NewAPIKeySQLiteRepository(db *gorm.DB, log *zap.SugaredLogger) *APIKeySQLiteRepository {
+	return &APIKeySQLiteRepository{db: db, log: log}
+}
+
+func (r *APIKeySQLiteRepository) Create(ctx context.Context, key *model.APIKeyEntity) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	// Times are stored in UTC because SQLite compares them as text
+	row := *key
+	row.CreatedAt = row.CreatedAt.UTC()
+	if row.RevokedAt != nil {
+		revokedAt := row.RevokedAt.UTC()
+		row.RevokedAt = &revokedAt
+	}
+	return r.db.WithContext(ctx).Create(&row).Error
+}
+
+func (r *APIKeySQLiteRepository) GetByHash(ctx context.Context, hash string) (*model.APIKeyEntity, error) {
+	var key model.APIKeyEntity
+	result := r.db.WithContext(ctx).First(&key, "hash = ?", hash)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrAPIKeyNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+func (r *APIKeySQLiteRepository) GetAll(ctx context.Context) ([]*model.APIKeyEntity, error) {
+	var keys []*model.APIKeyEntity
+	err := r.db.WithContext(ctx).Order("created_at, id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *APIKeySQLiteRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key model.APIKeyEntity
+		if err := tx.Select("id").First(&key, "id = ?", id).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return repository.ErrAPIKeyNotFound
+		} else if err != nil {
+			return err
+		}
+		return tx.Model(&model.APIKeyEntity{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", revokedAt.UTC()).Error
+	})
+}
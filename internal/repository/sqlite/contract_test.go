@@ -0,0 +1,34 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/config"
+	"sweng-task/internal/db"
+	"sweng-task/internal/repository/repotest"
+	"sweng-task/internal/repository/sqlite"
+	"sweng-task/internal/testutil"
+)
+
+func TestRepositoryContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		database, err := db.ConnectSQLite(config.DatabaseConfig{SQLitePath: filepath.Join(t.TempDir(), "test.db")})
+		require.NoError(t, err)
+		log := testutil.GetTestLogger()
+		require.NoError(t, db.RunMigrations(database, "sqlite", log))
+		t.Cleanup(func() {
+			if sqlDB, err := database.DB(); err == nil {
+				sqlDB.Close()
+			}
+		})
+
+		return repotest.Repositories{
+			LineItems: sqlite.NewLineItemSQLiteRepository(database, log),
+			Tracking:  sqlite.NewTrackingSQLiteRepository(database, log),
+			APIKeys:   sqlite.NewAPIKeySQLiteRepository(database, log),
+			Alerts:    sqlite.NewAnomalyAlertSQLiteRepository(database, log),
+		}
+	})
+}
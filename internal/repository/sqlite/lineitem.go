@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type LineItemSQLiteRepository struct {
+	db  *gorm.DB
+	log *zap.SugaredLogger
+}
+
+func NewLineItemSQLiteRepository(db *gorm.DB, log *zap.SugaredLogger) *LineItemSQLiteRepository {
+	return &LineItemSQLiteRepository{db: db, log: log}
+}
+
+func (r *LineItemSQLiteRepository) Create(ctx context.Context, item *model.LineItemEntity) error {
+	now := time.Now()
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = now
+	}
+	if item.UpdatedAt.IsZero() {
+		item.UpdatedAt = now
+	}
+
+	row := toLineItemRow(item)
+	return r.db.WithContext(ctx).Create(&row).Error
+}
+
+func (r *LineItemSQLiteRepository) GetByID(ctx context.Context, id string) (*model.LineItemEntity, error) {
+	var row lineItemRow
+	result := r.db.WithContext(ctx).First(&row, "id = ?", id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrLineItemNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return toLineItemEntity(row), nil
+}
+
+func (r *LineItemSQLiteRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.LineItemEntity, error) {
+	if len(ids) == 0 {
+		return []*model.LineItemEntity{}, nil
+	}
+
+	var rows []lineItemRow
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return toLineItemEntities(rows), nil
+}
+
+func (r *LineItemSQLiteRepository) GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItemEntity, error) {
+	query := r.db.WithContext(ctx).Model(&lineItemRow{})
+
+	if advertiserID != "" {
+		query = query.Where("advertiser_id = ?", advertiserID)
+	}
+	if placement != "" {
+		query = query.Where("placement = ?", placement)
+	}
+
+	var rows []lineItemRow
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return toLineItemEntities(rows), nil
+}
+
+func (r *LineItemSQLiteRepository) FindMatchingLineItems(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error) {
+	query := r.db.WithContext(ctx).Where("placement = ? AND status = ? AND daily_spending < budget", placement, "active")
+
+	// json_each stands in for Postgres "? = ANY(...)" on the JSON encoded arrays
+	if category != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(line_items.categories) WHERE json_each.value = ?)", category)
+	}
+	if keyword != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(line_items.keywords) WHERE json_each.value = ?)", keyword)
+	}
+
+	var rows []lineItemRow
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return toLineItemEntities(rows), nil
+}
+
+func (r *LineItemSQLiteRepository) ResetDailySpending(ctx context.Context) error {
+	result := r.db.WithContext(ctx).Model(&lineItemRow{}).
+		Where("daily_spending > 0").
+		Updates(map[string]interface{}{"daily_spending": 0, "updated_at": time.Now().UTC()})
+
+	if result.Error != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to reset daily budgets", "error", result.Error)
+		return result.Error
+	}
+
+	logging.FromContext(ctx, r.log).Infow("Daily budgets reset", "affected_rows", result.RowsAffected)
+	return nil
+}
+
+func (r *LineItemSQLiteRepository) IncreaseDailySpending(ctx context.Context, lineItemID string, amount float64) error {
+	return increaseDailySpending(r.db.WithContext(ctx), map[string]float64{lineItemID: amount})
+}
+
+func (r *LineItemSQLiteRepository) IncreaseDailySpendingBatch(ctx context.Context, amounts map[string]float64) error {
+	if len(amounts) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return increaseDailySpending(tx, amounts)
+	})
+}
+
+func (r *LineItemSQLiteRepository) UpdateStatus(ctx context.Context, id string, status model.LineItemStatus) error {
+	result := r.db.WithContext(ctx).Model(&lineItemRow{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now().UTC()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repository.ErrLineItemNotFound
+	}
+	return nil
+}
+
+// increaseDailySpending adds amounts, keyed by line item ID, to the daily spending of the line
+// items within tx. The tracking repository calls it in the transaction storing the events
+// charged.
+func increaseDailySpending(tx *gorm.DB, amounts map[string]float64) error {
+	now := time.Now().UTC()
+	for id, amount := range amounts {
+		err := tx.Model(&lineItemRow{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"daily_spending": gorm.Expr("daily_spending + ?", amount),
+				"updated_at":     now,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
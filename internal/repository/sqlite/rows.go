@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// SQLite has no array or jsonb columns, so line items and tracking events are stored
+// through row types that keep Categories, Keywords and Metadata as JSON text. Times are
+// stored in UTC because SQLite compares them as text. The schema is created by the
+// migrations in internal/db/migrations/sqlite.
+
+type lineItemRow struct {
+	ID            string               `gorm:"primaryKey"`
+	Name          string               `gorm:"not null"`
+	AdvertiserID  string               `gorm:"not null;index:idx_line_items_advertiser_id"`
+	Bid           float64              `gorm:"not null;check:bid >= 0"`
+	Budget        float64              `gorm:"not null;check:budget >= 0"`
+	DailySpending float64              `gorm:"not null;default:0;check:daily_spending >= 0"`
+	Placement     string               `gorm:"not null;index:idx_line_items_placement"`
+	Categories    []string             `gorm:"serializer:json"`
+	Keywords      []string             `gorm:"serializer:json"`
+	Status        model.LineItemStatus `gorm:"type:text;not null;index:idx_line_items_status"`
+	CreatedAt     time.Time            `gorm:"index:idx_line_items_created_at"`
+	UpdatedAt     time.Time
+}
+
+func (lineItemRow) TableName() string {
+	return "line_items"
+}
+
+type trackingEventRow struct {
+	ID            uint64                  `gorm:"primaryKey"`
+	EventID       string                  `gorm:"type:text;uniqueIndex:idx_tracking_events_event_id"`
+	EventType     model.TrackingEventType `gorm:"type:text;index:idx_tracking_events_event_type"`
+	LineItemID    string                  `gorm:"not null;index:idx_tracking_events_line_item_id"`
+	Timestamp     time.Time               `gorm:"index:idx_tracking_events_timestamp"`
+	Placement     string                  `gorm:"index:idx_tracking_events_placement"`
+	UserID        string
+	Metadata      map[string]string `gorm:"serializer:json"`
+	Cost          float64           `gorm:"not null;default:0"`
+	IP            string            `gorm:"not null"`
+	UserAgent     string            `gorm:"not null"`
+	InvalidReason string            `gorm:"not null"`
+}
+
+func (trackingEventRow) TableName() string {
+	return "tracking_events"
+}
+
+func toLineItemRow(e *model.LineItemEntity) lineItemRow {
+	return lineItemRow{
+		ID:            e.ID,
+		Name:          e.Name,
+		AdvertiserID:  e.AdvertiserID,
+		Bid:           e.Bid,
+		Budget:        e.Budget,
+		DailySpending: e.DailySpending,
+		Placement:     e.Placement,
+		Categories:    e.Categories,
+		Keywords:      e.Keywords,
+		Status:        e.Status,
+		CreatedAt:     e.CreatedAt.UTC(),
+		UpdatedAt:     e.UpdatedAt.UTC(),
+	}
+}
+
+func toLineItemEntity(r lineItemRow) *model.LineItemEntity {
+	return &model.LineItemEntity{
+		ID:            r.ID,
+		Name:          r.Name,
+		AdvertiserID:  r.AdvertiserID,
+		Bid:           r.Bid,
+		Budget:        r.Budget,
+		DailySpending: r.DailySpending,
+		Placement:     r.Placement,
+		Categories:    r.Categories,
+		Keywords:      r.Keywords,
+		Status:        r.Status,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+	}
+}
+
+func toLineItemEntities(rows []lineItemRow) []*model.LineItemEntity {
+	items := make([]*model.LineItemEntity, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, toLineItemEntity(r))
+	}
+	return items
+}
+
+func toTrackingEventRow(e *model.TrackingEventEntity) trackingEventRow {
+	return trackingEventRow{
+		ID:            e.ID,
+		EventID:       e.EventID,
+		EventType:     e.EventType,
+		LineItemID:    e.LineItemID,
+		Timestamp:     e.Timestamp.UTC(),
+		Placement:     e.Placement,
+		UserID:        e.UserID,
+		Metadata:      e.Metadata,
+		Cost:          e.Cost,
+		IP:            e.IP,
+		UserAgent:     e.UserAgent,
+		InvalidReason: e.InvalidReason,
+	}
+}
+
+func toTrackingEventEntities(rows []trackingEventRow) []*model.TrackingEventEntity {
+	events := make([]*model.TrackingEventEntity, 0, len(rows))
+	for _, r := range rows {
+		events = append(events, &model.TrackingEventEntity{
+			ID:            r.ID,
+			EventID:       r.EventID,
+			EventType:     r.EventType,
+			LineItemID:    r.LineItemID,
+			Timestamp:     r.Timestamp,
+			Placement:     r.Placement,
+			UserID:        r.UserID,
+			Metadata:      r.Metadata,
+			Cost:          r.Cost,
+			IP:            r.IP,
+			UserAgent:     r.UserAgent,
+			InvalidReason: r.InvalidReason,
+		})
+	}
+	return events
+}
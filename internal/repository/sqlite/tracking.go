@@ -0,0 +1,314 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+type TrackingSQLiteRepository struct {
+	db  *gorm.DB
+	log *zap.SugaredLogger
+}
+
+func NewTrackingSQLiteRepository(db *gorm.DB, log *zap.SugaredLogger) *TrackingSQLiteRepository {
+	return &TrackingSQLiteRepository{db: db, log: log}
+}
+
+func (r *TrackingSQLiteRepository) Store(ctx context.Context, event *model.TrackingEventEntity) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		inserted, err := insertEvent(tx, event)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return repository.ErrDuplicateEvent
+		}
+		return recordStored(tx, []*model.TrackingEventEntity{event})
+	})
+	if errors.Is(err, repository.ErrDuplicateEvent) {
+		return err
+	}
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to insert tracking event", "error", err)
+		return err
+	}
+	return nil
+}
+
+// StoreBatch inserts the events one by one within a single transaction; SQLite serialises
+// writers, so this costs little more than a multi-row insert
+func (r *TrackingSQLiteRepository) StoreBatch(ctx context.Context, events []*model.TrackingEventEntity) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	var duplicates []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		duplicates = nil
+		stored := make([]*model.TrackingEventEntity, 0, len(events))
+		for _, event := range events {
+			inserted, err := insertEvent(tx, event)
+			if err != nil {
+				return err
+			}
+			if !inserted {
+				duplicates = append(duplicates, event.EventID)
+				continue
+			}
+			stored = append(stored, event)
+		}
+		return recordStored(tx, stored)
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to insert tracking event batch", "size", len(events), "error", err)
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// insertEvent stores event and sets its ID, or reports false if its event ID is already stored
+func insertEvent(tx *gorm.DB, event *model.TrackingEventEntity) (bool, error) {
+	row := toTrackingEventRow(event)
+	row.ID = 0
+
+	result := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_id"}},
+		DoNothing: true,
+	}).Create(&row)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	event.ID = row.ID
+	return true, nil
+}
+
+func (r *TrackingSQLiteRepository) FindByEventIDs(ctx context.Context, eventIDs []string) ([]*model.TrackingEventEntity, error) {
+	if len(eventIDs) == 0 {
+		return []*model.TrackingEventEntity{}, nil
+	}
+
+	var rows []trackingEventRow
+	if err := r.db.WithContext(ctx).Where("event_id IN ?", eventIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return toTrackingEventEntities(rows), nil
+}
+
+func (r *TrackingSQLiteRepository) FindByEventID(ctx context.Context, eventID string) (*model.TrackingEventEntity, error) {
+	var row trackingEventRow
+	if err := r.db.WithContext(ctx).First(&row, "event_id = ?", eventID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repository.ErrEventNotFound
+		}
+		return nil, err
+	}
+	return toTrackingEventEntities([]trackingEventRow{row})[0], nil
+}
+
+func (r *TrackingSQLiteRepository) FindAll(ctx context.Context) ([]*model.TrackingEventEntity, error) {
+	var rows []trackingEventRow
+	if err := r.db.WithContext(ctx).Order("id").Find(&rows).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch tracking events", "error", err)
+		return nil, err
+	}
+	return toTrackingEventEntities(rows), nil
+}
+
+func (r *TrackingSQLiteRepository) CountEvents(ctx context.Context, lineItemID string, placement string) (model.EventCounts, error) {
+	if lineItemID == "" {
+		totals, err := countTotals(r.db.WithContext(ctx), []string{placement})
+		if err != nil {
+			return model.EventCounts{}, err
+		}
+		return totals[placement], nil
+	}
+
+	var counters []model.EventCounterEntity
+	err := r.db.WithContext(ctx).Where("line_item_id = ? AND placement = ?", lineItemID, placement).
+		Limit(1).
+		Find(&counters).Error
+	if err != nil {
+		return model.EventCounts{}, err
+	}
+	if len(counters) == 0 {
+		return model.EventCounts{}, nil
+	}
+	return model.ToEventCounts(counters[0]), nil
+}
+
+func (r *TrackingSQLiteRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	counts := make(map[string]model.LineItemEventCounts, len(lineItemIDs))
+	if len(lineItemIDs) == 0 {
+		return counts, nil
+	}
+
+	var counters []model.EventCounterEntity
+	err := r.db.WithContext(ctx).Where("line_item_id IN ? AND placement IN ?", lineItemIDs, []string{"", placement}).
+		Find(&counters).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counters", "line_items", len(lineItemIDs), "error", err)
+		return nil, err
+	}
+
+	for _, id := range lineItemIDs {
+		counts[id] = model.LineItemEventCounts{}
+	}
+	for _, counter := range counters {
+		c := counts[counter.LineItemID]
+		if counter.Placement == "" {
+			c.Total = model.ToEventCounts(counter)
+		}
+		if counter.Placement == placement {
+			c.Placement = model.ToEventCounts(counter)
+		}
+		counts[counter.LineItemID] = c
+	}
+
+	if slices.Contains(lineItemIDs, "") {
+		totals, err := countTotals(r.db.WithContext(ctx), []string{"", placement})
+		if err != nil {
+			logging.FromContext(ctx, r.log).Errorw("Failed to sum event counters", "error", err)
+			return nil, err
+		}
+		counts[""] = model.LineItemEventCounts{Total: totals[""], Placement: totals[placement]}
+	}
+	return counts, nil
+}
+
+func (r *TrackingSQLiteRepository) LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error) {
+	var rows []trackingEventRow
+	err := r.db.WithContext(ctx).Select("timestamp").
+		Where("line_item_id = ? AND user_id = ? AND event_type = ? AND invalid_reason = '' AND timestamp <= ?",
+			lineItemID, userID, model.TrackingEventTypeImpression, before.UTC()).
+		Order("timestamp DESC").
+		Limit(1).
+		Find(&rows).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to find last impression", "line_item_id", lineItemID, "error", err)
+		return time.Time{}, err
+	}
+	if len(rows) == 0 {
+		return time.Time{}, repository.ErrEventNotFound
+	}
+	return rows[0].Timestamp, nil
+}
+
+func (r *TrackingSQLiteRepository) CountInvalidEvents(ctx context.Context, lineItemID string) ([]model.InvalidEventCount, error) {
+	var counts []model.InvalidEventCount
+	err := r.db.WithContext(ctx).Model(&trackingEventRow{}).
+		Select("invalid_reason AS reason, event_type, COUNT(*) AS count").
+		Where("line_item_id = ? AND invalid_reason <> ''", lineItemID).
+		Group("invalid_reason, event_type").
+		Scan(&counts).Error
+	if err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to count invalid events", "line_item_id", lineItemID, "error", err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (r *TrackingSQLiteRepository) ListEventCounters(ctx context.Context) ([]*model.EventCounterEntity, error) {
+	var counters []*model.EventCounterEntity
+	if err := r.db.WithContext(ctx).Find(&counters).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counters", "error", err)
+		return nil, err
+	}
+	return counters, nil
+}
+
+func (r *TrackingSQLiteRepository) ListEventCounterBuckets(ctx context.Context, updatedSince time.Time) ([]*model.EventCounterBucketEntity, error) {
+	var buckets []*model.EventCounterBucketEntity
+	if err := r.db.WithContext(ctx).Where("updated_at >= ?", updatedSince.UTC()).Find(&buckets).Error; err != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to fetch event counter buckets", "error", err)
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (r *TrackingSQLiteRepository) DeleteEventCounterBuckets(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("bucket_start < ?", before.UTC()).Delete(&model.EventCounterBucketEntity{})
+	if result.Error != nil {
+		logging.FromContext(ctx, r.log).Errorw("Failed to delete event counter buckets", "before", before, "error", result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// countTotals sums the counters of all line items in each of placements, an empty placement
+// summing their all-placements counters
+func countTotals(db *gorm.DB, placements []string) (map[string]model.EventCounts, error) {
+	var sums []model.EventCounterEntity
+	err := db.Model(&model.EventCounterEntity{}).
+		Select("placement, SUM(impressions) AS impressions, SUM(clicks) AS clicks, SUM(conversions) AS conversions").
+		Where("line_item_id <> '' AND placement IN ?", placements).
+		Group("placement").
+		Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]model.EventCounts, len(sums))
+	for _, sum := range sums {
+		totals[sum.Placement] = model.ToEventCounts(sum)
+	}
+	return totals, nil
+}
+
+// recordStored counts newly stored events and charges their cost to their line items, in the
+// transaction that stored them so that no event is stored without being charged
+func recordStored(tx *gorm.DB, events []*model.TrackingEventEntity) error {
+	if err := incrementCounters(tx, events); err != nil {
+		return err
+	}
+	return increaseDailySpending(tx, model.ToSpendingAmounts(events))
+}
+
+// incrementCounters adds the events to their rolled-up counters and hourly buckets with one upsert each
+func incrementCounters(tx *gorm.DB, events []*model.TrackingEventEntity) error {
+	deltas := model.ToEventCounterDeltas(events)
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "line_item_id"}, {Name: "placement"}},
+		DoUpdates: counterIncrements("event_counters"),
+	}).Create(&deltas).Error
+	if err != nil {
+		return err
+	}
+
+	buckets := model.ToEventCounterBucketDeltas(events, time.Now().UTC())
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "line_item_id"}, {Name: "placement"}, {Name: "bucket_start"}},
+		DoUpdates: append(counterIncrements("event_counter_buckets"),
+			clause.Assignment{Column: clause.Column{Name: "spend"}, Value: gorm.Expr("event_counter_buckets.spend + excluded.spend")},
+			clause.Assignment{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("excluded.updated_at")},
+		),
+	}).Create(&buckets).Error
+}
+
+func counterIncrements(table string) clause.Set {
+	set := make(clause.Set, 0, 3)
+	for _, column := range []string{"impressions", "clicks", "conversions"} {
+		set = append(set, clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value:  gorm.Expr(table + "." + column + " + excluded." + column),
+		})
+	}
+	return set
+}
@@ -1,11 +1,40 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"sweng-task/internal/model"
 )
 
 type TrackingRepository interface {
-	Store(event *model.TrackingEventEntity) error
-	FindAll() ([]*model.TrackingEventEntity, error)
-	CountEvents(lineItemID string, placement string) (model.EventCounts, error)
+	// Store inserts the event and returns ErrDuplicateEvent if its EventID was already stored.
+	// Store and StoreBatch increment the event counters and hourly counter buckets of every
+	// valid event they insert; invalid traffic is stored but not counted. They add the Cost of
+	// every event they insert to the daily spending of its line item in the same transaction,
+	// so that a failed charge stores nothing and the event can be retried.
+	Store(ctx context.Context, event *model.TrackingEventEntity) error
+	// StoreBatch inserts events in bulk and returns the event IDs that were already stored
+	StoreBatch(ctx context.Context, events []*model.TrackingEventEntity) (duplicates []string, err error)
+	FindByEventID(ctx context.Context, eventID string) (*model.TrackingEventEntity, error)
+	FindByEventIDs(ctx context.Context, eventIDs []string) ([]*model.TrackingEventEntity, error)
+	FindAll(ctx context.Context) ([]*model.TrackingEventEntity, error)
+	// CountEvents returns the pre-aggregated counts; an empty lineItemID or placement counts across all of them
+	CountEvents(ctx context.Context, lineItemID string, placement string) (model.EventCounts, error)
+	// CountEventsBatch returns, keyed by line item ID, the counts of each line item overall and
+	// within placement. An empty ID counts across all line items, which takes a second query.
+	CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error)
+	// ListEventCounters returns the counters of each line item, overall and per placement.
+	// Counts across all line items are not stored but summed from these.
+	ListEventCounters(ctx context.Context) ([]*model.EventCounterEntity, error)
+	// ListEventCounterBuckets returns the hourly counter buckets of each line item updated at or
+	// after updatedSince
+	ListEventCounterBuckets(ctx context.Context, updatedSince time.Time) ([]*model.EventCounterBucketEntity, error)
+	// LastImpression returns the timestamp of the latest valid impression of the line item
+	// served to userID at or before the given time, or ErrEventNotFound if there is none
+	LastImpression(ctx context.Context, lineItemID, userID string, before time.Time) (time.Time, error)
+	// CountInvalidEvents returns the number of invalid events of the line item by reason and type
+	CountInvalidEvents(ctx context.Context, lineItemID string) ([]model.InvalidEventCount, error)
+	// DeleteEventCounterBuckets removes the hourly counter buckets that start before the given time
+	DeleteEventCounterBuckets(ctx context.Context, before time.Time) (int64, error)
 }
@@ -1,45 +1,121 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"sweng-task/internal/metrics"
 	"sweng-task/internal/service"
 )
 
+var ErrNotRunning = errors.New("scheduler not running")
+
 type Scheduler struct {
 	lineItemService *service.LineItemService
+	trackingService *service.TrackingService
+	anomalyService  *service.AnomalyService
 	log             *zap.SugaredLogger
+	metrics         *metrics.Metrics
+
+	mu   sync.Mutex
+	cron *cron.Cron
 }
 
-func NewScheduler(lineItemService *service.LineItemService, log *zap.SugaredLogger) *Scheduler {
-	return &Scheduler{
+// Option configures optional Scheduler jobs
+type Option func(*Scheduler)
+
+// WithAnomalyDetection runs anomaly detection on the last complete hour every hour
+func WithAnomalyDetection(anomalyService *service.AnomalyService) Option {
+	return func(s *Scheduler) {
+		s.anomalyService = anomalyService
+	}
+}
+
+// WithMetrics records the outcome of every job run in m
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Scheduler) {
+		s.metrics = m
+	}
+}
+
+func NewScheduler(lineItemService *service.LineItemService, trackingService *service.TrackingService, log *zap.SugaredLogger, opts ...Option) *Scheduler {
+	s := &Scheduler{
 		lineItemService: lineItemService,
+		trackingService: trackingService,
 		log:             log,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Scheduler) Start() {
 	c := cron.New(cron.WithLocation(time.Local))
 
-	_, err := c.AddFunc("0 0 * * *", func() {
-		s.log.Info("Starting daily budget reset...")
-		err := s.lineItemService.ResetDailySpending()
-		if err != nil {
-			s.log.Errorf("Failed to reset budgets: %v", err)
-		} else {
-			s.log.Info("Daily budget reset complete.")
-		}
-	})
-	if err != nil {
-		s.log.Fatalf("Failed to add cron job: %v", err)
+	s.add(c, "0 0 * * *", "daily_budget_reset", s.lineItemService.ResetDailySpending)
+	s.add(c, "5 * * * *", "prune_event_stats", s.trackingService.PruneEventStats)
+	if s.anomalyService != nil {
+		s.add(c, "10 * * * *", "anomaly_detection", s.anomalyService.Detect)
 	}
 
 	c.Start()
+	s.mu.Lock()
+	s.cron = c
+	s.mu.Unlock()
 	s.log.Info("Scheduler started")
+}
+
+func (s *Scheduler) add(c *cron.Cron, spec, job string, fn func(ctx context.Context) error) {
+	if _, err := c.AddFunc(spec, func() { s.run(job, fn) }); err != nil {
+		s.log.Fatalf("Failed to add cron job %s: %v", job, err)
+	}
+}
 
-	go func() {
-		select {}
-	}()
+// run runs job once, logging its outcome and recording it in the metrics
+func (s *Scheduler) run(job string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	err := fn(context.Background())
+	s.metrics.SchedulerJob(job, err)
+	if err != nil {
+		s.log.Errorw("Scheduled job failed", "job", job, "duration", time.Since(start), "error", err)
+		return err
+	}
+	s.log.Infow("Scheduled job complete", "job", job, "duration", time.Since(start))
+	return nil
+}
+
+// Stop stops scheduling jobs and waits for running jobs to finish, or for ctx to be done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	c := s.cron
+	s.cron = nil
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	select {
+	case <-c.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check reports whether jobs are being scheduled. Failed job runs don't make the service
+// unready, since the next run may well succeed; they are logged and counted in the
+// scheduler_job_runs_total metric instead.
+func (s *Scheduler) Check(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cron == nil {
+		return ErrNotRunning
+	}
+	return nil
 }
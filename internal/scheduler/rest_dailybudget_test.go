@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/service"
+	"sweng-task/internal/testutil"
+)
+
+func newTestScheduler(opts ...Option) *Scheduler {
+	logger := testutil.GetTestLogger()
+	lineItemRepo := memory.NewLineItemRepository()
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingService := service.NewTrackingService(memory.NewTrackingRepository(lineItemRepo), lineItemService, logger)
+	return NewScheduler(lineItemService, trackingService, logger, opts...)
+}
+
+func TestScheduler_Check(t *testing.T) {
+	s := newTestScheduler()
+	assert.ErrorIs(t, s.Check(t.Context()), ErrNotRunning)
+
+	s.Start()
+	assert.NoError(t, s.Check(t.Context()))
+
+	require.NoError(t, s.Stop(t.Context()))
+	assert.ErrorIs(t, s.Check(t.Context()), ErrNotRunning)
+	assert.NoError(t, s.Stop(t.Context()), "stopping twice is a no-op")
+}
+
+func TestScheduler_RunRecordsOutcome(t *testing.T) {
+	m := metrics.New()
+	s := newTestScheduler(WithMetrics(m))
+	s.Start()
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+
+	failure := errors.New("database unavailable")
+	assert.ErrorIs(t, s.run("prune_event_stats", func(ctx context.Context) error { return failure }), failure)
+	// A failed run is reported in the metrics without making the service unready
+	assert.NoError(t, s.Check(t.Context()))
+
+	require.NoError(t, s.run("prune_event_stats", func(ctx context.Context) error { return nil }))
+	require.NoError(t, s.run("daily_budget_reset", s.lineItemService.ResetDailySpending))
+
+	expected := `
+# HELP adserver_scheduler_job_runs_total Runs of scheduled jobs by job and result.
+# TYPE adserver_scheduler_job_runs_total counter
+adserver_scheduler_job_runs_total{job="daily_budget_reset",result="success"} 1
+adserver_scheduler_job_runs_total{job="prune_event_stats",result="failure"} 1
+adserver_scheduler_job_runs_total{job="prune_event_stats",result="success"} 1
+`
+	assert.NoError(t, promtestutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "adserver_scheduler_job_runs_total"))
+	count, err := promtestutil.GatherAndCount(m.Registry(), "adserver_scheduler_job_last_success_timestamp_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestScheduler_AnomalyDetection(t *testing.T) {
+	logger := testutil.GetTestLogger()
+	lineItemRepo := memory.NewLineItemRepository()
+	lineItemService := service.NewLineItemService(lineItemRepo, logger)
+	trackingRepo := memory.NewTrackingRepository(lineItemRepo)
+	trackingService := service.NewTrackingService(trackingRepo, lineItemService, logger)
+	anomalyService := service.NewAnomalyService(memory.NewAnomalyAlertRepository(), trackingRepo, lineItemService, service.AnomalyConfig{
+		Baseline:   168 * time.Hour,
+		MinSamples: 24,
+		Alpha:      0.1,
+		Threshold:  3,
+		AutoPause:  true,
+	}, logger)
+
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), item))
+	// 48 hours with a CTR around 2%, then a last complete hour with a CTR of 50%
+	target := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	var events []*model.TrackingEventEntity
+	for i := 48; i >= 0; i-- {
+		hour := target.Add(-time.Duration(i) * time.Hour).Add(30 * time.Minute)
+		clicks := 1 + i%3
+		if i == 0 {
+			clicks = 50
+		}
+		for n := 0; n < 100+clicks; n++ {
+			eventType := model.TrackingEventTypeImpression
+			if n >= 100 {
+				eventType = model.TrackingEventTypeClick
+			}
+			events = append(events, &model.TrackingEventEntity{
+				EventID:    fmt.Sprintf("evt_%d_%d", i, n),
+				EventType:  eventType,
+				LineItemID: item.ID,
+				Timestamp:  hour,
+				Placement:  item.Placement,
+			})
+		}
+	}
+	_, err := trackingRepo.StoreBatch(t.Context(), events)
+	require.NoError(t, err)
+
+	m := metrics.New()
+	s := NewScheduler(lineItemService, trackingService, logger, WithAnomalyDetection(anomalyService), WithMetrics(m))
+	s.Start()
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+
+	// Run the job scheduled ten minutes past every hour as cron would
+	s.mu.Lock()
+	entries := s.cron.Entries()
+	s.mu.Unlock()
+	require.Len(t, entries, 3)
+	var ran bool
+	for _, entry := range entries {
+		if entry.Next.Minute() == 10 {
+			entry.Job.Run()
+			ran = true
+		}
+	}
+	require.True(t, ran, "anomaly detection is scheduled")
+
+	expected := `
+# HELP adserver_scheduler_job_runs_total Runs of scheduled jobs by job and result.
+# TYPE adserver_scheduler_job_runs_total counter
+adserver_scheduler_job_runs_total{job="anomaly_detection",result="success"} 1
+`
+	assert.NoError(t, promtestutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "adserver_scheduler_job_runs_total"))
+
+	alerts, err := anomalyService.ListAlerts(t.Context(), repository.AnomalyAlertFilter{LineItemID: item.ID})
+	require.NoError(t, err)
+	require.NotEmpty(t, alerts)
+	assert.Equal(t, model.AnomalyMetricCTR, alerts[0].Metric)
+	assert.Equal(t, target, alerts[0].WindowStart.UTC())
+	got, err := lineItemService.GetByID(t.Context(), item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemStatusPaused, got.Status)
+}
+
+func TestScheduler_WithoutAnomalyDetection(t *testing.T) {
+	s := newTestScheduler()
+	s.Start()
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Len(t, s.cron.Entries(), 2, "only the budget reset and pruning are scheduled")
+}
+
+func TestScheduler_StopWaitsForRunningJobs(t *testing.T) {
+	s := newTestScheduler()
+	s.Start()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.mu.Lock()
+	_, err := s.cron.AddFunc("@every 1s", func() {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+	})
+	s.mu.Unlock()
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("job did not start")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Stop(ctx), context.DeadlineExceeded, "Stop gives up on a job running past ctx")
+	assert.ErrorIs(t, s.Check(t.Context()), ErrNotRunning, "no jobs are scheduled once stopping")
+	close(release)
+}
@@ -1,12 +1,18 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"time"
 
+	"sweng-task/internal/logging"
+	"sweng-task/internal/metrics"
 	"sweng-task/internal/model"
+	"sweng-task/internal/tracing"
 	"sweng-task/internal/utils"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -14,64 +20,182 @@ type AdService struct {
 	log             *zap.SugaredLogger
 	lineItemService *LineItemService
 	trackingService *TrackingService
+	strategy        utils.BidStrategy
+	statsTimeout    time.Duration
+	metrics         *metrics.Metrics
+}
+
+// AdSelection is the outcome of an ad request
+type AdSelection struct {
+	Ads []model.Ad
+	// Degraded is set when bids were estimated from fallback event counts because fresh
+	// counts were not available within the stats budget
+	Degraded bool
+}
+
+// AdOption configures optional AdService behaviour
+type AdOption func(*AdService)
+
+// WithBidStrategy sets the strategy used to estimate bids; it defaults to
+// AvgConversionRateStrategy over all events ever tracked
+func WithBidStrategy(strategy utils.BidStrategy) AdOption {
+	return func(s *AdService) {
+		s.strategy = strategy
+	}
+}
+
+// WithStatsTimeout bounds the event count lookup of each ad request, leaving the rest of the
+// request's latency budget to serve ads on fallback counts when the lookup is slow. Zero
+// leaves the lookup bounded only by the request deadline.
+func WithStatsTimeout(timeout time.Duration) AdOption {
+	return func(s *AdService) {
+		s.statsTimeout = timeout
+	}
+}
+
+// WithAdMetrics records ad selection outcomes, bids and pacing adjustments in m
+func WithAdMetrics(m *metrics.Metrics) AdOption {
+	return func(s *AdService) {
+		s.metrics = m
+	}
 }
 
 func NewAdService(
 	lineItemService *LineItemService,
 	trackingService *TrackingService,
 	log *zap.SugaredLogger,
-
+	opts ...AdOption,
 ) *AdService {
-	return &AdService{
+	s := &AdService{
 		lineItemService: lineItemService,
 		trackingService: trackingService,
 		log:             log,
+		strategy:        utils.AvgConversionRateStrategy{},
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *AdService) GetWinningAds(placement, category, keyword string, limit int) ([]model.Ad, error) {
-	s.log.Infow("Selecting winning ads", "placement", placement, "category", category, "keyword", keyword)
+func (s *AdService) GetWinningAds(ctx context.Context, placement, category, keyword string, limit int) (_ AdSelection, err error) {
+	ctx, span := tracing.Start(ctx, "AdService.GetWinningAds",
+		attribute.String("ad.placement", placement),
+		attribute.String("ad.category", category),
+		attribute.String("ad.keyword", keyword),
+		attribute.Int("ad.limit", limit),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	logging.FromContext(ctx, s.log).Infow("Selecting winning ads", "placement", placement, "category", category, "keyword", keyword)
 
-	lineItems, err := s.fetchMatchedLineItems(placement, category, keyword)
+	lineItems, err := s.fetchMatchedLineItems(ctx, placement, category, keyword)
 	if err != nil {
-		return nil, err
+		reason := metrics.NoFillError
+		if errors.Is(err, context.DeadlineExceeded) {
+			reason = metrics.NoFillTimeout
+		}
+		s.metrics.AdRequest(0, nil, reason)
+		return AdSelection{}, err
 	}
 
-	scoredItems := s.estimateBid(lineItems, placement)
-	selected := s.sortAndSelectAds(scoredItems, limit)
+	scoredItems, degraded := s.estimateBid(ctx, lineItems, placement)
+	s.pace(ctx, scoredItems)
+	selected := s.sortAndSelectAds(ctx, scoredItems, limit)
 
-	return s.mapToAds(selected), nil
+	span.SetAttributes(attribute.Int("ad.candidates", len(lineItems)), attribute.Int("ad.selected", len(selected)), attribute.Bool("ad.degraded", degraded))
+	s.recordSelection(len(lineItems), selected)
+	return AdSelection{Ads: s.mapToAds(selected), Degraded: degraded}, nil
 }
 
-func (s *AdService) fetchMatchedLineItems(placement, category, keyword string) ([]*model.LineItemEntity, error) {
-	return s.lineItemService.FindMatchingLineItems(placement, category, keyword)
+func (s *AdService) recordSelection(candidates int, selected []*model.LineItemEntity) {
+	if len(selected) == 0 {
+		s.metrics.AdRequest(candidates, nil, metrics.NoFillNoCandidates)
+		return
+	}
+
+	bids := make([]float64, len(selected))
+	for i, item := range selected {
+		bids[i] = item.Bid
+	}
+	s.metrics.AdRequest(candidates, bids, "")
+}
+
+func (s *AdService) fetchMatchedLineItems(ctx context.Context, placement, category, keyword string) (_ []*model.LineItemEntity, err error) {
+	ctx, span := tracing.Start(ctx, "AdService.fetchMatchedLineItems")
+	defer func() { tracing.End(span, err) }()
+
+	return s.lineItemService.FindMatchingLineItems(ctx, placement, category, keyword)
 }
 
-func (s *AdService) estimateBid(items []*model.LineItemEntity, placement string) []*model.LineItemEntity {
-	globalEventCounts, _ := s.trackingService.GetEventCounts("", "")
-	placementEventCounts, _ := s.trackingService.GetEventCounts("", placement)
+// estimateBid prices the items on their event counts. When the counts cannot be fetched
+// within the stats budget it prices them on fallback counts instead and reports degraded.
+func (s *AdService) estimateBid(ctx context.Context, items []*model.LineItemEntity, placement string) (_ []*model.LineItemEntity, degraded bool) {
+	ctx, span := tracing.Start(ctx, "AdService.estimateBid", attribute.Int("ad.candidates", len(items)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("ad.degraded", degraded))
+		span.End()
+	}()
+
+	// The empty ID fetches the counts across all line items alongside the candidates'
+	lineItemIDs := make([]string, 0, len(items)+1)
+	lineItemIDs = append(lineItemIDs, "")
+	for _, item := range items {
+		lineItemIDs = append(lineItemIDs, item.ID)
+	}
 
-	strategy := utils.AvgConversionRateStrategy{}
+	counts, err := s.eventCounts(ctx, lineItemIDs, placement)
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) {
+			s.metrics.AdDegraded("stats_timeout")
+		} else {
+			s.metrics.AdDegraded("stats_error")
+		}
+		logging.FromContext(ctx, s.log).Warnw("Event counts unavailable, estimating bids on fallback counts", "placement", placement, "error", err)
+
+		counts = s.trackingService.FallbackEventCounts(lineItemIDs, placement)
+		degraded = true
+	}
 
+	global := counts[""]
 	for _, item := range items {
-		itemEventCounts, _ := s.trackingService.GetEventCounts(item.ID, "")
-		itemPlacementEventCounts, _ := s.trackingService.GetEventCounts(item.ID, placement)
+		itemCounts := counts[item.ID]
 
-		estimatedBid := strategy.Calculate(
+		item.Bid = s.strategy.Calculate(
 			item.Bid,
-			globalEventCounts,
-			placementEventCounts,
-			itemEventCounts,
-			itemPlacementEventCounts,
+			global.Total,
+			global.Placement,
+			itemCounts.Total,
+			itemCounts.Placement,
 		)
+	}
+
+	return items, degraded
+}
+
+// pace reduces the estimated bids of items spending ahead of their daily budget
+func (s *AdService) pace(ctx context.Context, items []*model.LineItemEntity) {
+	_, span := tracing.Start(ctx, "AdService.pace")
+	defer span.End()
 
-		item.Bid = s.applyPacing(item, estimatedBid)
+	for _, item := range items {
+		item.Bid = s.applyPacing(ctx, item, item.Bid)
 	}
+}
 
-	return items
+func (s *AdService) eventCounts(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	if s.statsTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.statsTimeout)
+		defer cancel()
+	}
+	return s.trackingService.GetEventCountsBatch(ctx, lineItemIDs, placement, s.strategy.StatsWindow())
 }
 
-func (s *AdService) sortAndSelectAds(items []*model.LineItemEntity, limit int) []*model.LineItemEntity {
+func (s *AdService) sortAndSelectAds(ctx context.Context, items []*model.LineItemEntity, limit int) []*model.LineItemEntity {
+	_, span := tracing.Start(ctx, "AdService.sortAndSelectAds")
+	defer span.End()
+
 	sort.SliceStable(items, func(i, j int) bool {
 		return items[i].Bid > items[j].Bid
 	})
@@ -97,7 +221,7 @@ func (s *AdService) mapToAds(items []*model.LineItemEntity) []model.Ad {
 	return ads
 }
 
-func (s *AdService) applyPacing(item *model.LineItemEntity, bid float64) float64 {
+func (s *AdService) applyPacing(ctx context.Context, item *model.LineItemEntity, bid float64) float64 {
 	if item.Budget == 0 {
 		return bid
 	}
@@ -109,8 +233,9 @@ func (s *AdService) applyPacing(item *model.LineItemEntity, bid float64) float64
 	if item.DailySpending > expectedSpending {
 		reduceFactor := expectedSpending / item.DailySpending
 		adjustedBid := bid * reduceFactor
+		s.metrics.PacingAdjustment(reduceFactor)
 
-		s.log.Infow("Pacing adjustment applied",
+		logging.FromContext(ctx, s.log).Infow("Pacing adjustment applied",
 			"line_item_id", item.ID,
 			"original_bid", bid,
 			"adjusted_bid", adjustedBid,
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/testutil"
+	"sweng-task/internal/utils"
+)
+
+// countingTrackingRepository records how event counts are looked up
+type countingTrackingRepository struct {
+	*memory.TrackingRepository
+	single, batch int
+}
+
+func (r *countingTrackingRepository) CountEvents(ctx context.Context, lineItemID string, placement string) (model.EventCounts, error) {
+	r.single++
+	return r.TrackingRepository.CountEvents(ctx, lineItemID, placement)
+}
+
+func (r *countingTrackingRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	r.batch++
+	return r.TrackingRepository.CountEventsBatch(ctx, lineItemIDs, placement)
+}
+
+func TestAdService_GetWinningAds_FetchesCountsInOneLookup(t *testing.T) {
+	logger := testutil.GetTestLogger()
+	lineItemRepo := memory.NewLineItemRepository()
+	trackingRepo := &countingTrackingRepository{TrackingRepository: memory.NewTrackingRepository(nil)}
+
+	strong := testutil.CreateTestLineItemEntity()
+	weak := testutil.CreateTestLineItemEntity()
+	for _, item := range []*model.LineItemEntity{strong, weak} {
+		require.NoError(t, lineItemRepo.Create(t.Context(), item))
+	}
+
+	var events []*model.TrackingEventEntity
+	track := func(item *model.LineItemEntity, eventType model.TrackingEventType, n int) {
+		for i := 0; i < n; i++ {
+			events = append(events, &model.TrackingEventEntity{
+				EventID:    fmt.Sprintf("evt_%s_%s_%d", item.ID, eventType, i),
+				EventType:  eventType,
+				LineItemID: item.ID,
+				Placement:  item.Placement,
+			})
+		}
+	}
+	track(strong, model.TrackingEventTypeImpression, 200)
+	track(strong, model.TrackingEventTypeConversion, 40)
+	track(weak, model.TrackingEventTypeImpression, 200)
+	track(weak, model.TrackingEventTypeConversion, 1)
+	_, err := trackingRepo.StoreBatch(t.Context(), events)
+	require.NoError(t, err)
+
+	lineItemService := NewLineItemService(lineItemRepo, logger)
+	trackingService := NewTrackingService(trackingRepo, lineItemService, logger)
+	adService := NewAdService(lineItemService, trackingService, logger)
+
+	selection, err := adService.GetWinningAds(t.Context(), strong.Placement, "", "", 2)
+	require.NoError(t, err)
+	assert.False(t, selection.Degraded)
+	ads := selection.Ads
+	require.Len(t, ads, 2)
+	assert.Equal(t, strong.ID, ads[0].ID)
+	assert.Greater(t, ads[0].Bid, ads[1].Bid)
+
+	assert.Equal(t, 1, trackingRepo.batch)
+	assert.Zero(t, trackingRepo.single)
+}
+
+// unavailableTrackingRepository fails event count lookups, blocking until the lookup's
+// deadline when err is nil
+type unavailableTrackingRepository struct {
+	*memory.TrackingRepository
+	err error
+}
+
+func (r unavailableTrackingRepository) CountEventsBatch(ctx context.Context, lineItemIDs []string, placement string) (map[string]model.LineItemEventCounts, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAdService_GetWinningAds_DegradesWhenStatsUnavailable(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		cause string
+	}{
+		{name: "slow lookup", cause: "stats_timeout"},
+		{name: "failed lookup", err: errors.New("connection refused"), cause: "stats_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := testutil.GetTestLogger()
+			lineItemRepo := memory.NewLineItemRepository()
+			item := testutil.CreateTestLineItemEntity()
+			require.NoError(t, lineItemRepo.Create(t.Context(), item))
+			maxBid := item.Bid
+
+			trackingRepo := unavailableTrackingRepository{TrackingRepository: memory.NewTrackingRepository(nil), err: tt.err}
+			lineItemService := NewLineItemService(lineItemRepo, logger)
+			trackingService := NewTrackingService(trackingRepo, lineItemService, logger)
+			m := metrics.New()
+			adService := NewAdService(lineItemService, trackingService, logger, WithStatsTimeout(10*time.Millisecond), WithAdMetrics(m))
+
+			selection, err := adService.GetWinningAds(t.Context(), item.Placement, "", "", 1)
+			require.NoError(t, err)
+			assert.True(t, selection.Degraded)
+			require.Len(t, selection.Ads, 1)
+			assert.Equal(t, maxBid*utils.BidFallbackMultiplier, selection.Ads[0].Bid)
+
+			expected := fmt.Sprintf(`
+# HELP adserver_ad_degraded_total Ad requests whose bids were estimated on fallback event counts, by cause.
+# TYPE adserver_ad_degraded_total counter
+adserver_ad_degraded_total{cause=%q} 1
+`, tt.cause)
+			assert.NoError(t, promtestutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "adserver_ad_degraded_total"))
+		})
+	}
+}
+
+func TestTrackingService_FallbackEventCounts(t *testing.T) {
+	logger := testutil.GetTestLogger()
+	repo := memory.NewTrackingRepository(nil)
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		{EventID: "evt_1", LineItemID: "li_1", Placement: "homepage", EventType: model.TrackingEventTypeImpression},
+		{EventID: "evt_2", LineItemID: "li_1", Placement: "sidebar", EventType: model.TrackingEventTypeImpression},
+	})
+	require.NoError(t, err)
+
+	// Without a counter cache every line item gets default counts
+	trackingService := NewTrackingService(repo, nil, logger)
+	assert.Equal(t, map[string]model.LineItemEventCounts{"li_1": {}}, trackingService.FallbackEventCounts([]string{"li_1"}, "homepage"))
+
+	counters := NewEventCounterCache(repo, time.Hour, 24*time.Hour, logger)
+	require.NoError(t, counters.Refresh(t.Context()))
+	trackingService = NewTrackingService(repo, nil, logger, WithEventCounterCache(counters))
+
+	counts := trackingService.FallbackEventCounts([]string{"li_1", "li_unknown"}, "homepage")
+	assert.Equal(t, model.EventCounts{Impressions: 2}, counts["li_1"].Total)
+	assert.Equal(t, model.EventCounts{Impressions: 1}, counts["li_1"].Placement)
+	assert.Equal(t, model.LineItemEventCounts{}, counts["li_unknown"])
+}
+
+func TestAdService_GetWinningAds_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	logger := testutil.GetTestLogger()
+	lineItemRepo := memory.NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), item))
+
+	lineItemService := NewLineItemService(lineItemRepo, logger)
+	trackingService := NewTrackingService(memory.NewTrackingRepository(nil), lineItemService, logger)
+	adService := NewAdService(lineItemService, trackingService, logger)
+
+	_, err := adService.GetWinningAds(t.Context(), item.Placement, "", "", 1)
+	require.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root := spans["AdService.GetWinningAds"]
+	require.NotNil(t, root)
+
+	children := map[string]string{
+		"AdService.fetchMatchedLineItems":     "AdService.GetWinningAds",
+		"AdService.estimateBid":               "AdService.GetWinningAds",
+		"AdService.pace":                      "AdService.GetWinningAds",
+		"AdService.sortAndSelectAds":          "AdService.GetWinningAds",
+		"TrackingService.GetEventCountsBatch": "AdService.estimateBid",
+	}
+	for name, parent := range children {
+		require.Contains(t, spans, name)
+		assert.Equal(t, spans[parent].SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		assert.Equal(t, root.SpanContext().TraceID(), spans[name].SpanContext().TraceID(), name)
+	}
+}
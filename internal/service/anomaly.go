@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+// minRelativeStdDev floors the standard deviation of a baseline at a share of its mean, so
+// that metrics which barely moved so far don't alert on the smallest change
+const minRelativeStdDev = 0.1
+
+// anomalyMetrics are the metrics checked for each line item and placement
+var anomalyMetrics = []model.AnomalyMetric{model.AnomalyMetricCTR, model.AnomalyMetricCVR, model.AnomalyMetricSpend}
+
+// AnomalyConfig tunes anomaly detection
+type AnomalyConfig struct {
+	// Baseline is how far back the hours forming the baseline of a metric go
+	Baseline time.Duration
+	// MinSamples is the number of hours a baseline needs before the metric is checked
+	MinSamples int
+	// Alpha is the weight of each hour in the exponentially weighted baseline; higher values
+	// follow changes faster
+	Alpha float64
+	// Threshold is the z-score from which a deviation from the baseline is an anomaly
+	Threshold float64
+	// MinImpressions and MinClicks are the events an hour needs for its CTR, respectively
+	// CVR, to be taken into account, so that rates of a handful of events don't raise alerts
+	MinImpressions int
+	MinClicks      int
+	// AutoPause pauses active line items whose CTR, CVR or spend rises anomalously
+	AutoPause bool
+}
+
+// AnomalyService detects hours in which the CTR, CVR or spend of a line item deviates from
+// its baseline, in total and per placement, from the hourly event counter buckets
+type AnomalyService struct {
+	alerts    repository.AnomalyAlertRepository
+	tracking  repository.TrackingRepository
+	lineItems *LineItemService
+	cfg       AnomalyConfig
+	log       *zap.SugaredLogger
+	metrics   *metrics.Metrics
+	now       func() time.Time
+}
+
+// AnomalyOption configures optional AnomalyService behaviour
+type AnomalyOption func(*AnomalyService)
+
+// WithAnomalyMetrics records the anomalies detected in m
+func WithAnomalyMetrics(m *metrics.Metrics) AnomalyOption {
+	return func(s *AnomalyService) {
+		s.metrics = m
+	}
+}
+
+// NewAnomalyService creates a new AnomalyService
+func NewAnomalyService(alerts repository.AnomalyAlertRepository, tracking repository.TrackingRepository, lineItems *LineItemService, cfg AnomalyConfig, log *zap.SugaredLogger, opts ...AnomalyOption) *AnomalyService {
+	s := &AnomalyService{
+		alerts:    alerts,
+		tracking:  tracking,
+		lineItems: lineItems,
+		cfg:       cfg,
+		log:       log,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// seriesKey identifies the hourly counts of a line item on a placement, or on all placements
+// when the placement is empty
+type seriesKey struct {
+	lineItemID string
+	placement  string
+}
+
+// Detect checks the last complete hour of each line item against the baseline of the hours
+// before it and stores an alert for each metric deviating by at least the threshold. Hours
+// without events are not sampled. Running Detect again for the same hour stores no new alerts.
+func (s *AnomalyService) Detect(ctx context.Context) error {
+	target := s.now().Truncate(model.EventCounterBucketSize).Add(-model.EventCounterBucketSize)
+	since := target.Add(-s.cfg.Baseline)
+
+	buckets, err := s.tracking.ListEventCounterBuckets(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	series := make(map[seriesKey][]*model.EventCounterBucketEntity)
+	for _, b := range buckets {
+		if b.LineItemID == "" || b.BucketStart.Before(since) || b.BucketStart.After(target) {
+			continue
+		}
+		key := seriesKey{lineItemID: b.LineItemID, placement: b.Placement}
+		series[key] = append(series[key], b)
+	}
+
+	var (
+		keys []seriesKey
+		ids  []string
+		seen = make(map[string]bool)
+	)
+	for key, hours := range series {
+		sort.Slice(hours, func(i, j int) bool { return hours[i].BucketStart.Before(hours[j].BucketStart) })
+		if len(hours) <= s.cfg.MinSamples || !hours[len(hours)-1].BucketStart.Equal(target) {
+			continue
+		}
+		keys = append(keys, key)
+		if !seen[key.lineItemID] {
+			seen[key.lineItemID] = true
+			ids = append(ids, key.lineItemID)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].lineItemID != keys[j].lineItemID {
+			return keys[i].lineItemID < keys[j].lineItemID
+		}
+		return keys[i].placement < keys[j].placement
+	})
+
+	items, err := s.lineItems.GetByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	detected := 0
+	for _, key := range keys {
+		item, ok := items[key.lineItemID]
+		if !ok {
+			continue
+		}
+		hours := series[key]
+		for _, metric := range anomalyMetrics {
+			alert, ok := s.check(metric, hours)
+			if !ok {
+				continue
+			}
+			alert.LineItemID = item.ID
+			alert.AdvertiserID = item.AdvertiserID
+			alert.Placement = key.placement
+			alert.WindowStart = target
+			alert.CreatedAt = s.now()
+			alert.Paused = s.cfg.AutoPause && alert.ZScore > 0 && item.Status == model.LineItemStatusActive
+
+			if err := s.alerts.Create(ctx, alert); err != nil {
+				if !errors.Is(err, repository.ErrDuplicateAlert) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			detected++
+			s.metrics.AnomalyAlert(string(metric), alert.Paused)
+			logging.FromContext(ctx, s.log).Warnw("Anomaly detected",
+				"line_item_id", item.ID,
+				"placement", key.placement,
+				"metric", metric,
+				"window_start", target,
+				"value", alert.Value,
+				"baseline", alert.Baseline,
+				"z_score", alert.ZScore,
+				"paused", alert.Paused,
+			)
+
+			if alert.Paused {
+				if err := s.lineItems.UpdateStatus(ctx, item.ID, model.LineItemStatusPaused); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				item.Status = model.LineItemStatusPaused
+			}
+		}
+	}
+
+	logging.FromContext(ctx, s.log).Infow("Anomaly detection complete", "window_start", target, "series", len(keys), "alerts", detected)
+	return errors.Join(errs...)
+}
+
+// check compares the last of hours, oldest first, to the baseline of the hours before it and
+// returns an alert when it deviates by at least the threshold
+func (s *AnomalyService) check(metric model.AnomalyMetric, hours []*model.EventCounterBucketEntity) (*model.AnomalyAlertEntity, bool) {
+	last := len(hours) - 1
+	value, ok := s.sample(metric, hours[last])
+	if !ok {
+		return nil, false
+	}
+
+	var samples []float64
+	for _, b := range hours[:last] {
+		if x, ok := s.sample(metric, b); ok {
+			samples = append(samples, x)
+		}
+	}
+	if len(samples) < max(s.cfg.MinSamples, 1) {
+		return nil, false
+	}
+
+	mean, stdDev := ewma(samples, s.cfg.Alpha)
+	stdDev = math.Max(stdDev, minRelativeStdDev*math.Abs(mean))
+	if stdDev == 0 {
+		return nil, false
+	}
+	z := (value - mean) / stdDev
+	if math.Abs(z) < s.cfg.Threshold {
+		return nil, false
+	}
+	return &model.AnomalyAlertEntity{
+		Metric:   metric,
+		Value:    value,
+		Baseline: mean,
+		StdDev:   stdDev,
+		ZScore:   z,
+	}, true
+}
+
+// sample returns the value of metric in an hour, or false when the hour has too few events
+// for the metric to be meaningful
+func (s *AnomalyService) sample(metric model.AnomalyMetric, b *model.EventCounterBucketEntity) (float64, bool) {
+	switch metric {
+	case model.AnomalyMetricCTR:
+		if b.Impressions == 0 || b.Impressions < s.cfg.MinImpressions {
+			return 0, false
+		}
+		return float64(b.Clicks) / float64(b.Impressions), true
+	case model.AnomalyMetricCVR:
+		if b.Clicks == 0 || b.Clicks < s.cfg.MinClicks {
+			return 0, false
+		}
+		return float64(b.Conversions) / float64(b.Clicks), true
+	default:
+		// The cost charged when the events were stored, so bid changes are not applied to
+		// past hours
+		return b.Spend, true
+	}
+}
+
+// ewma returns the exponentially weighted moving mean and standard deviation of samples,
+// oldest first, weighting each new sample by alpha
+func ewma(samples []float64, alpha float64) (float64, float64) {
+	mean, variance := samples[0], 0.0
+	for _, x := range samples[1:] {
+		diff := x - mean
+		incr := alpha * diff
+		mean += incr
+		variance = (1 - alpha) * (variance + diff*incr)
+	}
+	return mean, math.Sqrt(variance)
+}
+
+// ListAlerts returns the alerts matching filter, latest hour first
+func (s *AnomalyService) ListAlerts(ctx context.Context, filter repository.AnomalyAlertFilter) ([]*model.AnomalyAlert, error) {
+	entities, err := s.alerts.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]*model.AnomalyAlert, 0, len(entities))
+	for _, entity := range entities {
+		dto := model.ToDTOAnomalyAlert(*entity)
+		alerts = append(alerts, &dto)
+	}
+	return alerts, nil
+}
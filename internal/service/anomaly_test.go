@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/testutil"
+)
+
+// bucketTrackingRepository serves fixed hourly counter buckets
+type bucketTrackingRepository struct {
+	*memory.TrackingRepository
+	buckets []*model.EventCounterBucketEntity
+}
+
+func (r *bucketTrackingRepository) ListEventCounterBuckets(ctx context.Context, updatedSince time.Time) ([]*model.EventCounterBucketEntity, error) {
+	return r.buckets, nil
+}
+
+// hourlyBuckets returns 48 hours of 1000 impressions with a CTR around 2%, charged at a bid
+// of 2.5, on placement and on all placements, followed by an hour at target with lastClicks
+// clicks
+func hourlyBuckets(lineItemID, placement string, target time.Time, lastClicks int) []*model.EventCounterBucketEntity {
+	var buckets []*model.EventCounterBucketEntity
+	for i := 48; i >= 0; i-- {
+		clicks := 18 + 2*(i%3)
+		if i == 0 {
+			clicks = lastClicks
+		}
+		for _, p := range []string{placement, ""} {
+			buckets = append(buckets, &model.EventCounterBucketEntity{
+				LineItemID:  lineItemID,
+				Placement:   p,
+				BucketStart: target.Add(-time.Duration(i) * time.Hour),
+				Impressions: 1000,
+				Clicks:      clicks,
+				Spend:       float64(1000+clicks) * 2.5 / 1000,
+			})
+		}
+	}
+	return buckets
+}
+
+func TestAnomalyService_Detect(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	lineItems := NewLineItemService(lineItemRepo, testutil.GetTestLogger())
+
+	spiking := testutil.CreateTestLineItemEntity()
+	dropping := testutil.CreateTestLineItemEntity()
+	steady := testutil.CreateTestLineItemEntity()
+	for _, item := range []*model.LineItemEntity{spiking, dropping, steady} {
+		require.NoError(t, lineItemRepo.Create(t.Context(), item))
+	}
+
+	now := time.Date(2026, 1, 10, 12, 30, 0, 0, time.UTC)
+	target := time.Date(2026, 1, 10, 11, 0, 0, 0, time.UTC)
+	tracking := &bucketTrackingRepository{TrackingRepository: memory.NewTrackingRepository(nil)}
+	tracking.buckets = append(tracking.buckets, hourlyBuckets(spiking.ID, "homepage_top", target, 200)...)
+	tracking.buckets = append(tracking.buckets, hourlyBuckets(dropping.ID, "homepage_top", target, 2)...)
+	tracking.buckets = append(tracking.buckets, hourlyBuckets(steady.ID, "homepage_top", target, 20)...)
+	// The current hour is incomplete and not checked
+	tracking.buckets = append(tracking.buckets, &model.EventCounterBucketEntity{
+		LineItemID: steady.ID, BucketStart: target.Add(time.Hour), Impressions: 1000, Clicks: 900,
+	})
+
+	alerts := memory.NewAnomalyAlertRepository()
+	svc := NewAnomalyService(alerts, tracking, lineItems, AnomalyConfig{
+		Baseline:       168 * time.Hour,
+		MinSamples:     24,
+		Alpha:          0.1,
+		Threshold:      3,
+		MinImpressions: 200,
+		MinClicks:      20,
+		AutoPause:      true,
+	}, testutil.GetTestLogger())
+	svc.now = func() time.Time { return now }
+
+	require.NoError(t, svc.Detect(t.Context()))
+
+	got, err := svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{LineItemID: spiking.ID})
+	require.NoError(t, err)
+	require.Len(t, got, 2, "the spike is reported in total and on its placement")
+	for _, alert := range got {
+		assert.Equal(t, model.AnomalyMetricCTR, alert.Metric)
+		assert.Equal(t, target, alert.WindowStart.UTC())
+		assert.InDelta(t, 0.2, alert.Value, 1e-9)
+		assert.InDelta(t, 0.02, alert.Baseline, 0.002)
+		assert.Greater(t, alert.ZScore, 3.0)
+	}
+	assert.True(t, got[0].Paused != got[1].Paused, "the line item is paused once")
+
+	item, err := lineItems.GetByID(t.Context(), spiking.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemStatusPaused, item.Status)
+
+	got, err = svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{LineItemID: dropping.ID})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Less(t, got[0].ZScore, -3.0)
+	assert.False(t, got[0].Paused || got[1].Paused, "drops are reported without pausing")
+	item, err = lineItems.GetByID(t.Context(), dropping.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.LineItemStatusActive, item.Status)
+
+	got, err = svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{LineItemID: steady.ID})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// Detecting the same hour again stores no new alerts
+	require.NoError(t, svc.Detect(t.Context()))
+	got, err = svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{})
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+}
+
+func TestAnomalyService_DetectSpend(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	// The bid was raised tenfold for the last hour
+	item.Bid = 25
+	require.NoError(t, lineItemRepo.Create(t.Context(), item))
+
+	now := time.Date(2026, 1, 10, 12, 30, 0, 0, time.UTC)
+	target := time.Date(2026, 1, 10, 11, 0, 0, 0, time.UTC)
+	tracking := &bucketTrackingRepository{
+		TrackingRepository: memory.NewTrackingRepository(nil),
+		buckets:            hourlyBuckets(item.ID, "homepage_top", target, 20),
+	}
+	for _, b := range tracking.buckets {
+		if b.BucketStart.Equal(target) {
+			b.Spend *= 10
+		}
+	}
+
+	svc := NewAnomalyService(memory.NewAnomalyAlertRepository(), tracking, NewLineItemService(lineItemRepo, testutil.GetTestLogger()), AnomalyConfig{
+		Baseline:   168 * time.Hour,
+		MinSamples: 24,
+		Alpha:      0.1,
+		Threshold:  3,
+	}, testutil.GetTestLogger())
+	svc.now = func() time.Time { return now }
+
+	require.NoError(t, svc.Detect(t.Context()))
+	got, err := svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{})
+	require.NoError(t, err)
+	require.Len(t, got, 2, "past hours keep the spend charged at the bid of the time")
+	for _, alert := range got {
+		assert.Equal(t, model.AnomalyMetricSpend, alert.Metric)
+		assert.InDelta(t, 25.5, alert.Value, 1e-9)
+		assert.InDelta(t, 2.55, alert.Baseline, 0.01)
+	}
+}
+
+func TestAnomalyService_DetectNeedsBaseline(t *testing.T) {
+	lineItemRepo := memory.NewLineItemRepository()
+	item := testutil.CreateTestLineItemEntity()
+	require.NoError(t, lineItemRepo.Create(t.Context(), item))
+
+	now := time.Date(2026, 1, 10, 12, 30, 0, 0, time.UTC)
+	target := time.Date(2026, 1, 10, 11, 0, 0, 0, time.UTC)
+	tracking := &bucketTrackingRepository{
+		TrackingRepository: memory.NewTrackingRepository(nil),
+		buckets:            hourlyBuckets(item.ID, "homepage_top", target, 200),
+	}
+
+	alerts := memory.NewAnomalyAlertRepository()
+	svc := NewAnomalyService(alerts, tracking, NewLineItemService(lineItemRepo, testutil.GetTestLogger()), AnomalyConfig{
+		Baseline:   168 * time.Hour,
+		MinSamples: 72,
+		Alpha:      0.1,
+		Threshold:  3,
+	}, testutil.GetTestLogger())
+	svc.now = func() time.Time { return now }
+
+	require.NoError(t, svc.Detect(t.Context()))
+	got, err := svc.ListAlerts(t.Context(), repository.AnomalyAlertFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, got, "48 hours are too few for a baseline of 72")
+}
+
+func TestEWMA(t *testing.T) {
+	mean, stdDev := ewma([]float64{5, 5, 5, 5}, 0.5)
+	assert.Equal(t, 5.0, mean)
+	assert.Zero(t, stdDev)
+
+	mean, stdDev = ewma([]float64{0, 10}, 0.5)
+	assert.Equal(t, 5.0, mean)
+	assert.InDelta(t, 5.0, stdDev, 1e-9)
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/cache"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+const (
+	apiKeyPrefix       = "ak_"
+	apiKeyDisplayChars = 8
+	apiKeyCacheSize    = 10000
+)
+
+// APIKeyService issues and revokes API keys and authenticates requests by them. Keys are
+// random 256-bit secrets, so they are stored as SHA-256 hashes rather than with a slow
+// password hash, which keeps authentication cheap enough for every ad request.
+type APIKeyService struct {
+	repo  repository.APIKeyRepository
+	log   *zap.SugaredLogger
+	cache *cache.TTLCache[string, *model.APIKeyEntity]
+	// unknown holds hashes the repository had no key for, so that repeated requests with an
+	// invalid key don't each query it
+	unknown *cache.TTLCache[string, struct{}]
+	// bootstrapHash is the hash of a key accepted as admin without being stored, so that the
+	// first keys can be created through the API
+	bootstrapHash string
+}
+
+// APIKeyOption configures optional APIKeyService behaviour
+type APIKeyOption func(*APIKeyService)
+
+// WithAPIKeyCacheTTL caches looked up keys for ttl, so that requests don't query the
+// repository; a revoked key is still accepted until its entry expires
+func WithAPIKeyCacheTTL(ttl time.Duration) APIKeyOption {
+	return func(s *APIKeyService) {
+		if ttl > 0 {
+			s.cache = cache.NewTTLCache[string, *model.APIKeyEntity](ttl, apiKeyCacheSize)
+		}
+	}
+}
+
+// WithAPIKeyNegativeCacheTTL remembers unknown keys for ttl, so that clients retrying with an
+// invalid key don't query the repository on every request. New keys are random, so they
+// are never among the unknown keys.
+func WithAPIKeyNegativeCacheTTL(ttl time.Duration) APIKeyOption {
+	return func(s *APIKeyService) {
+		if ttl > 0 {
+			s.unknown = cache.NewTTLCache[string, struct{}](ttl, apiKeyCacheSize)
+		}
+	}
+}
+
+// WithBootstrapAdminKey accepts key as an admin key in addition to the stored keys
+func WithBootstrapAdminKey(key string) APIKeyOption {
+	return func(s *APIKeyService) {
+		if key != "" {
+			s.bootstrapHash = HashAPIKey(key)
+		}
+	}
+}
+
+// NewAPIKeyService creates a new APIKeyService
+func NewAPIKeyService(repo repository.APIKeyRepository, log *zap.SugaredLogger, opts ...APIKeyOption) *APIKeyService {
+	s := &APIKeyService{
+		repo: repo,
+		log:  log,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Create issues a new key. The key is only returned here.
+func (s *APIKeyService) Create(ctx context.Context, input model.APIKeyCreate) (*model.APIKeyCreated, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	entity := &model.APIKeyEntity{
+		ID:           "key_" + uuid.New().String(),
+		Name:         input.Name,
+		Role:         input.Role,
+		AdvertiserID: input.AdvertiserID,
+		Prefix:       key[:len(apiKeyPrefix)+apiKeyDisplayChars],
+		Hash:         HashAPIKey(key),
+		CreatedAt:    time.Now(),
+	}
+	if err := s.repo.Create(ctx, entity); err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.log).Infow("API key created",
+		"api_key_id", entity.ID,
+		"role", entity.Role,
+		"advertiser_id", entity.AdvertiserID,
+	)
+
+	return &model.APIKeyCreated{APIKey: model.ToDTOAPIKey(*entity), Key: key}, nil
+}
+
+// GetAll returns every key, including revoked ones
+func (s *APIKeyService) GetAll(ctx context.Context) ([]*model.APIKey, error) {
+	entities, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*model.APIKey, 0, len(entities))
+	for _, entity := range entities {
+		key := model.ToDTOAPIKey(*entity)
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+// Revoke stops the key with the given ID from authenticating. Instances that cached the key
+// keep accepting it until their cache entry expires.
+func (s *APIKeyService) Revoke(ctx context.Context, id string) error {
+	err := s.repo.Revoke(ctx, id, time.Now())
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx, s.log).Infow("API key revoked", "api_key_id", id)
+	return nil
+}
+
+// Authenticate returns the principal of apiKey, or auth.ErrInvalidCredentials for unknown
+// and revoked keys
+func (s *APIKeyService) Authenticate(ctx context.Context, apiKey string) (*auth.Principal, error) {
+	hash := HashAPIKey(apiKey)
+	if s.bootstrapHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(s.bootstrapHash)) == 1 {
+		return &auth.Principal{ID: "bootstrap", Role: model.RoleAdmin}, nil
+	}
+
+	entity, err := s.lookup(ctx, hash)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entity.RevokedAt != nil {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	return &auth.Principal{
+		ID:           entity.ID,
+		Role:         entity.Role,
+		AdvertiserID: entity.AdvertiserID,
+	}, nil
+}
+
+func (s *APIKeyService) lookup(ctx context.Context, hash string) (*model.APIKeyEntity, error) {
+	if s.cache != nil {
+		if entity, ok := s.cache.Get(hash); ok {
+			return entity, nil
+		}
+	}
+
+	if s.unknown != nil {
+		if _, ok := s.unknown.Get(hash); ok {
+			return nil, repository.ErrAPIKeyNotFound
+		}
+	}
+
+	entity, err := s.repo.GetByHash(ctx, hash)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) && s.unknown != nil {
+		s.unknown.Set(hash, struct{}{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cache != nil {
+		s.cache.Set(hash, entity)
+	}
+	return entity, nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash keys are stored and looked up by
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
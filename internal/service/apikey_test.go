@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/auth"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/testutil"
+)
+
+func TestAPIKeyService_CreateAndAuthenticate(t *testing.T) {
+	repo := memory.NewAPIKeyRepository()
+	keys := NewAPIKeyService(repo, testutil.GetTestLogger())
+
+	created, err := keys.Create(t.Context(), model.APIKeyCreate{Name: "dashboard", Role: model.RoleAdvertiser, AdvertiserID: "adv_1"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+
+	stored, err := repo.GetAll(t.Context())
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.NotEqual(t, created.Key, stored[0].Hash, "only the hash of the key is stored")
+	assert.Equal(t, HashAPIKey(created.Key), stored[0].Hash)
+
+	principal, err := keys.Authenticate(t.Context(), created.Key)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.Principal{ID: created.ID, Role: model.RoleAdvertiser, AdvertiserID: "adv_1"}, principal)
+
+	_, err = keys.Authenticate(t.Context(), created.Key+"x")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	require.NoError(t, keys.Revoke(t.Context(), created.ID))
+	_, err = keys.Authenticate(t.Context(), created.Key)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials, "revoked keys are rejected")
+
+	assert.ErrorIs(t, keys.Revoke(t.Context(), "key_unknown"), ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyService_CachedKeysOutliveRevocation(t *testing.T) {
+	keys := NewAPIKeyService(memory.NewAPIKeyRepository(), testutil.GetTestLogger(), WithAPIKeyCacheTTL(time.Hour))
+
+	created, err := keys.Create(t.Context(), model.APIKeyCreate{Name: "sdk", Role: model.RolePublisher})
+	require.NoError(t, err)
+	_, err = keys.Authenticate(t.Context(), created.Key)
+	require.NoError(t, err)
+
+	require.NoError(t, keys.Revoke(t.Context(), created.ID))
+	_, err = keys.Authenticate(t.Context(), created.Key)
+	assert.NoError(t, err, "a cached key is accepted until its entry expires")
+}
+
+// countingAPIKeyRepository counts GetByHash queries
+type countingAPIKeyRepository struct {
+	*memory.APIKeyRepository
+	lookups int
+}
+
+func (r *countingAPIKeyRepository) GetByHash(ctx context.Context, hash string) (*model.APIKeyEntity, error) {
+	r.lookups++
+	return r.APIKeyRepository.GetByHash(ctx, hash)
+}
+
+func TestAPIKeyService_CachesUnknownKeys(t *testing.T) {
+	repo := &countingAPIKeyRepository{APIKeyRepository: memory.NewAPIKeyRepository()}
+	keys := NewAPIKeyService(repo, testutil.GetTestLogger(), WithAPIKeyNegativeCacheTTL(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		_, err := keys.Authenticate(t.Context(), "ak_unknown")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	}
+	assert.Equal(t, 1, repo.lookups, "an unknown key is looked up once")
+
+	created, err := keys.Create(t.Context(), model.APIKeyCreate{Name: "sdk", Role: model.RolePublisher})
+	require.NoError(t, err)
+	_, err = keys.Authenticate(t.Context(), created.Key)
+	require.NoError(t, err, "known keys are not affected")
+}
+
+func TestAPIKeyService_BootstrapAdminKey(t *testing.T) {
+	keys := NewAPIKeyService(memory.NewAPIKeyRepository(), testutil.GetTestLogger(), WithBootstrapAdminKey("bootstrap-secret"))
+
+	principal, err := keys.Authenticate(t.Context(), "bootstrap-secret")
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleAdmin, principal.Role)
+
+	all, err := keys.GetAll(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, all, "the bootstrap key isn't stored")
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/utils"
+)
+
+// bucketRefreshOverlap re-reads buckets updated shortly before the previous refresh, so that
+// increments committed late by slow transactions or other instances are not missed
+const bucketRefreshOverlap = time.Minute
+
+type counterKey struct {
+	lineItemID string
+	placement  string
+}
+
+// EventCounterCache serves event counts from an in-memory snapshot of the pre-aggregated
+// counters and of the hourly buckets within the retention period, reloaded in the background.
+// Counts may lag ingest by up to one refresh interval.
+type EventCounterCache struct {
+	repo      repository.TrackingRepository
+	interval  time.Duration
+	retention time.Duration
+	log       *zap.SugaredLogger
+
+	snapshot atomic.Pointer[map[counterKey]model.EventCounts]
+
+	bucketsMu    sync.RWMutex
+	buckets      map[counterKey]map[int64]model.EventCounts // keyed by bucket start in Unix seconds
+	bucketsSince time.Time
+	bucketsReady bool
+	prunedBefore int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewEventCounterCache(repo repository.TrackingRepository, interval, retention time.Duration, log *zap.SugaredLogger) *EventCounterCache {
+	return &EventCounterCache{
+		repo:      repo,
+		interval:  interval,
+		retention: retention,
+		log:       log,
+		buckets:   make(map[counterKey]map[int64]model.EventCounts),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Refresh replaces the snapshot with the counters currently stored and loads the hourly
+// buckets updated since the previous refresh
+func (c *EventCounterCache) Refresh(ctx context.Context) error {
+	counters, err := c.repo.ListEventCounters(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := make(map[counterKey]model.EventCounts, len(counters))
+	for _, counter := range counters {
+		// Totals across line items are not stored but summed here
+		if counter.LineItemID == "" {
+			continue
+		}
+		counts := model.ToEventCounts(*counter)
+		snapshot[counterKey{lineItemID: counter.LineItemID, placement: counter.Placement}] = counts
+		total := counterKey{placement: counter.Placement}
+		snapshot[total] = addCounts(snapshot[total], counts, 1)
+	}
+	c.snapshot.Store(&snapshot)
+
+	return c.refreshBuckets(ctx, time.Now())
+}
+
+func (c *EventCounterCache) refreshBuckets(ctx context.Context, now time.Time) error {
+	c.bucketsMu.RLock()
+	since := c.bucketsSince
+	c.bucketsMu.RUnlock()
+
+	buckets, err := c.repo.ListEventCounterBuckets(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	oldest := now.Add(-c.retention).Truncate(model.EventCounterBucketSize).Unix()
+
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+
+	// Buckets hold absolute counts, so re-reading one only overwrites it
+	for _, bucket := range buckets {
+		start := bucket.BucketStart.Unix()
+		if start < oldest || bucket.LineItemID == "" {
+			continue
+		}
+		key := counterKey{lineItemID: bucket.LineItemID, placement: bucket.Placement}
+		counts := model.ToEventCounterBucketCounts(*bucket)
+		previous := c.buckets[key][start]
+		c.setBucket(key, start, counts)
+
+		// The totals across line items take the change from the counts previously read
+		total := counterKey{placement: bucket.Placement}
+		c.setBucket(total, start, addCounts(addCounts(c.buckets[total][start], previous, -1), counts, 1))
+	}
+
+	// Buckets only expire once an hour
+	if oldest > c.prunedBefore {
+		for key, byStart := range c.buckets {
+			for start := range byStart {
+				if start < oldest {
+					delete(byStart, start)
+				}
+			}
+			if len(byStart) == 0 {
+				delete(c.buckets, key)
+			}
+		}
+		c.prunedBefore = oldest
+	}
+
+	c.bucketsSince = now.Add(-bucketRefreshOverlap)
+	c.bucketsReady = true
+	return nil
+}
+
+func (c *EventCounterCache) setBucket(key counterKey, start int64, counts model.EventCounts) {
+	if c.buckets[key] == nil {
+		c.buckets[key] = make(map[int64]model.EventCounts)
+	}
+	c.buckets[key][start] = counts
+}
+
+// addCounts returns a plus b multiplied by sign
+func addCounts(a, b model.EventCounts, sign float64) model.EventCounts {
+	return model.EventCounts{
+		Impressions: a.Impressions + sign*b.Impressions,
+		Clicks:      a.Clicks + sign*b.Clicks,
+		Conversions: a.Conversions + sign*b.Conversions,
+	}
+}
+
+// Get returns the cached counts for a line item and placement, either of which may be
+// empty to count across all of them. ok is false until the first successful Refresh.
+func (c *EventCounterCache) Get(lineItemID, placement string) (counts model.EventCounts, ok bool) {
+	snapshot := c.snapshot.Load()
+	if snapshot == nil {
+		return model.EventCounts{}, false
+	}
+	return (*snapshot)[counterKey{lineItemID: lineItemID, placement: placement}], true
+}
+
+// GetWindow returns the counts for a line item and placement weighted by window as of now.
+// Each hourly bucket is weighted by the age of its midpoint. ok is false until the first
+// successful Refresh.
+func (c *EventCounterCache) GetWindow(lineItemID, placement string, window utils.StatsWindow, now time.Time) (counts model.EventCounts, ok bool) {
+	if window.AllTime() {
+		return c.Get(lineItemID, placement)
+	}
+
+	c.bucketsMu.RLock()
+	defer c.bucketsMu.RUnlock()
+
+	if !c.bucketsReady {
+		return model.EventCounts{}, false
+	}
+
+	for start, bucket := range c.buckets[counterKey{lineItemID: lineItemID, placement: placement}] {
+		midpoint := time.Unix(start, 0).Add(model.EventCounterBucketSize / 2)
+		weight := window.Weight(now.Sub(midpoint))
+		if weight == 0 {
+			continue
+		}
+		counts.Impressions += weight * bucket.Impressions
+		counts.Clicks += weight * bucket.Clicks
+		counts.Conversions += weight * bucket.Conversions
+	}
+	return counts, true
+}
+
+// Start refreshes the snapshot every interval until Stop is called. A refresh that takes
+// longer than the interval is cancelled.
+func (c *EventCounterCache) Start() {
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+				if err := c.Refresh(ctx); err != nil {
+					c.log.Errorw("Failed to refresh event counters", "error", err)
+				}
+				cancel()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background refreshes started by Start
+func (c *EventCounterCache) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
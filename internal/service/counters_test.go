@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/testutil"
+	"sweng-task/internal/utils"
+)
+
+func counterTestEvent(id, lineItemID, placement string, eventType model.TrackingEventType) *model.TrackingEventEntity {
+	return &model.TrackingEventEntity{EventID: id, LineItemID: lineItemID, Placement: placement, EventType: eventType}
+}
+
+func TestEventCounterCache_RollsUpOnIngest(t *testing.T) {
+	repo := memory.NewTrackingRepository(nil)
+	counters := NewEventCounterCache(repo, time.Hour, 24*time.Hour, testutil.GetTestLogger())
+
+	_, ok := counters.Get("", "")
+	assert.False(t, ok, "counts are unavailable before the first refresh")
+
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		counterTestEvent("evt_1", "li_1", "homepage", model.TrackingEventTypeImpression),
+		counterTestEvent("evt_2", "li_1", "homepage", model.TrackingEventTypeClick),
+		counterTestEvent("evt_3", "li_1", "sidebar", model.TrackingEventTypeImpression),
+		counterTestEvent("evt_4", "li_2", "homepage", model.TrackingEventTypeConversion),
+		counterTestEvent("evt_5", "li_2", "", model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+	// Duplicates are not counted again
+	assert.ErrorIs(t, repo.Store(t.Context(), counterTestEvent("evt_1", "li_1", "homepage", model.TrackingEventTypeImpression)), repository.ErrDuplicateEvent)
+	require.NoError(t, counters.Refresh(t.Context()))
+
+	tests := []struct {
+		lineItemID, placement string
+		want                  model.EventCounts
+	}{
+		{"", "", model.EventCounts{Impressions: 3, Clicks: 1, Conversions: 1}},
+		{"", "homepage", model.EventCounts{Impressions: 1, Clicks: 1, Conversions: 1}},
+		{"li_1", "", model.EventCounts{Impressions: 2, Clicks: 1}},
+		{"li_1", "sidebar", model.EventCounts{Impressions: 1}},
+		{"li_2", "", model.EventCounts{Impressions: 1, Conversions: 1}},
+		{"li_3", "homepage", model.EventCounts{}},
+	}
+	for _, tt := range tests {
+		counts, ok := counters.Get(tt.lineItemID, tt.placement)
+		assert.True(t, ok)
+		assert.Equal(t, tt.want, counts, "line item %q, placement %q", tt.lineItemID, tt.placement)
+
+		stored, err := repo.CountEvents(t.Context(), tt.lineItemID, tt.placement)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, stored)
+	}
+}
+
+func TestEventCounterCache_RefreshesInBackground(t *testing.T) {
+	repo := memory.NewTrackingRepository(nil)
+	counters := NewEventCounterCache(repo, 10*time.Millisecond, 24*time.Hour, testutil.GetTestLogger())
+	counters.Start()
+	defer counters.Stop(context.Background())
+
+	require.NoError(t, repo.Store(t.Context(), counterTestEvent("evt_1", "li_1", "homepage", model.TrackingEventTypeClick)))
+
+	assert.Eventually(t, func() bool {
+		counts, _ := counters.Get("li_1", "homepage")
+		return counts.Clicks == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestEventCounterCache_GetWindow(t *testing.T) {
+	repo := memory.NewTrackingRepository(nil)
+	counters := NewEventCounterCache(repo, time.Hour, 7*24*time.Hour, testutil.GetTestLogger())
+
+	now := time.Now().UTC().Truncate(time.Hour).Add(30 * time.Minute)
+	at := func(id string, age time.Duration, eventType model.TrackingEventType) *model.TrackingEventEntity {
+		e := counterTestEvent(id, "li_1", "homepage", eventType)
+		e.Timestamp = now.Add(-age)
+		return e
+	}
+
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		at("evt_1", 0, model.TrackingEventTypeImpression),
+		at("evt_2", 0, model.TrackingEventTypeClick),
+		at("evt_3", 24*time.Hour, model.TrackingEventTypeImpression),
+		at("evt_4", 24*time.Hour, model.TrackingEventTypeImpression),
+		// Beyond retention: kept in the lifetime counters only
+		at("evt_5", 30*24*time.Hour, model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+	require.NoError(t, counters.Refresh(t.Context()))
+
+	tests := []struct {
+		name   string
+		window utils.StatsWindow
+		want   model.EventCounts
+	}{
+		{"all time", utils.StatsWindow{}, model.EventCounts{Impressions: 4, Clicks: 1}},
+		{"sliding window", utils.StatsWindow{Window: 12 * time.Hour}, model.EventCounts{Impressions: 1, Clicks: 1}},
+		{"retained events", utils.StatsWindow{Window: 48 * time.Hour}, model.EventCounts{Impressions: 3, Clicks: 1}},
+		{"decay", utils.StatsWindow{HalfLife: 24 * time.Hour}, model.EventCounts{Impressions: 2, Clicks: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts, ok := counters.GetWindow("li_1", "", tt.window, now)
+			assert.True(t, ok)
+			assert.InDelta(t, tt.want.Impressions, counts.Impressions, 1e-9)
+			assert.InDelta(t, tt.want.Clicks, counts.Clicks, 1e-9)
+			assert.InDelta(t, tt.want.Conversions, counts.Conversions, 1e-9)
+		})
+	}
+}
+
+func TestEventCounterCache_GetWindowTotals(t *testing.T) {
+	repo := memory.NewTrackingRepository(nil)
+	counters := NewEventCounterCache(repo, time.Hour, 24*time.Hour, testutil.GetTestLogger())
+	now := time.Now().UTC()
+	at := func(id, lineItemID, placement string, eventType model.TrackingEventType) *model.TrackingEventEntity {
+		e := counterTestEvent(id, lineItemID, placement, eventType)
+		e.Timestamp = now
+		return e
+	}
+	window := utils.StatsWindow{Window: 2 * time.Hour}
+
+	_, err := repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		at("evt_1", "li_1", "homepage", model.TrackingEventTypeImpression),
+		at("evt_2", "li_2", "homepage", model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+	require.NoError(t, counters.Refresh(t.Context()))
+
+	// Buckets read again hold their absolute counts, which replace rather than add to the totals
+	_, err = repo.StoreBatch(t.Context(), []*model.TrackingEventEntity{
+		at("evt_3", "li_1", "homepage", model.TrackingEventTypeClick),
+		at("evt_4", "li_2", "sidebar", model.TrackingEventTypeImpression),
+	})
+	require.NoError(t, err)
+	require.NoError(t, counters.Refresh(t.Context()))
+
+	counts, ok := counters.GetWindow("", "homepage", window, now)
+	assert.True(t, ok)
+	assert.Equal(t, model.EventCounts{Impressions: 2, Clicks: 1}, counts)
+	counts, _ = counters.GetWindow("", "", window, now)
+	assert.Equal(t, model.EventCounts{Impressions: 3, Clicks: 1}, counts)
+	counts, _ = counters.Get("", "")
+	assert.Equal(t, model.EventCounts{Impressions: 3, Clicks: 1}, counts)
+}
@@ -4,4 +4,6 @@ import "errors"
 
 var (
 	ErrLineItemNotFound = errors.New("line item not found")
+	ErrBatchTooLarge    = errors.New("tracking batch too large")
+	ErrAPIKeyNotFound   = errors.New("api key not found")
 )
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/metrics"
 	"sweng-task/internal/model"
 	"sweng-task/internal/repository"
 	"time"
@@ -11,20 +15,43 @@ import (
 
 // LineItemService provides operations for line items
 type LineItemService struct {
-	repo repository.LineItemRepository
-	log  *zap.SugaredLogger
+	repo    repository.LineItemRepository
+	log     *zap.SugaredLogger
+	index   *LineItemIndex
+	metrics *metrics.Metrics
+}
+
+// LineItemOption configures optional LineItemService behaviour
+type LineItemOption func(*LineItemService)
+
+// WithLineItemIndex serves FindMatchingLineItems from index and keeps it informed of changes
+func WithLineItemIndex(index *LineItemIndex) LineItemOption {
+	return func(s *LineItemService) {
+		s.index = index
+	}
+}
+
+// WithLineItemMetrics records the spend charged to each line item in m
+func WithLineItemMetrics(m *metrics.Metrics) LineItemOption {
+	return func(s *LineItemService) {
+		s.metrics = m
+	}
 }
 
 // NewLineItemService creates a new LineItemService
-func NewLineItemService(repo repository.LineItemRepository, log *zap.SugaredLogger) *LineItemService {
-	return &LineItemService{
+func NewLineItemService(repo repository.LineItemRepository, log *zap.SugaredLogger, opts ...LineItemOption) *LineItemService {
+	s := &LineItemService{
 		repo: repo,
 		log:  log,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Create creates a new line item
-func (s *LineItemService) Create(input model.LineItemCreate) (*model.LineItem, error) {
+func (s *LineItemService) Create(ctx context.Context, input model.LineItemCreate) (*model.LineItem, error) {
 	now := time.Now()
 
 	// Map to entity and populate defaults
@@ -34,11 +61,14 @@ func (s *LineItemService) Create(input model.LineItemCreate) (*model.LineItem, e
 	lineItem.UpdatedAt = now
 
 	// Save to DB
-	if err := s.repo.Create(&lineItem); err != nil {
+	if err := s.repo.Create(ctx, &lineItem); err != nil {
 		return nil, err
 	}
+	if s.index != nil {
+		s.index.Invalidate()
+	}
 
-	s.log.Infow("Line item created",
+	logging.FromContext(ctx, s.log).Infow("Line item created",
 		"id", lineItem.ID,
 		"name", lineItem.Name,
 		"advertiser_id", lineItem.AdvertiserID,
@@ -51,19 +81,79 @@ func (s *LineItemService) Create(input model.LineItemCreate) (*model.LineItem, e
 }
 
 // GetByID retrieves a line item by ID
-func (s *LineItemService) GetByID(id string) (*model.LineItem, error) {
-	item, err := s.repo.GetByID(id)
-	if err != nil {
+func (s *LineItemService) GetByID(ctx context.Context, id string) (*model.LineItem, error) {
+	item, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrLineItemNotFound) {
 		return nil, ErrLineItemNotFound
-
+	}
+	if err != nil {
+		return nil, err
 	}
 	dto := model.ToDTOLineItem(*item)
 	return &dto, nil
 }
 
+// GetByIDs retrieves the line items with the given IDs, keyed by ID. Unknown IDs are omitted.
+func (s *LineItemService) GetByIDs(ctx context.Context, ids []string) (map[string]*model.LineItem, error) {
+	entityItems, err := s.repo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*model.LineItem, len(entityItems))
+	for _, entityItem := range entityItems {
+		dto := model.ToDTOLineItem(*entityItem)
+		items[dto.ID] = &dto
+	}
+	return items, nil
+}
+
+// Exists reports which of ids belong to an existing line item. Active line items in the
+// index are answered without querying the repository.
+func (s *LineItemService) Exists(ctx context.Context, ids []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(ids))
+	var unindexed []string
+	for _, id := range ids {
+		if s.index != nil && s.index.Contains(id) {
+			exists[id] = true
+			continue
+		}
+		unindexed = append(unindexed, id)
+	}
+	if len(unindexed) == 0 {
+		return exists, nil
+	}
+
+	items, err := s.repo.GetByIDs(ctx, unindexed)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		exists[item.ID] = true
+	}
+	return exists, nil
+}
+
+// UpdateStatus sets the status of a line item, e.g. to pause it
+func (s *LineItemService) UpdateStatus(ctx context.Context, id string, status model.LineItemStatus) error {
+	err := s.repo.UpdateStatus(ctx, id, status)
+	if errors.Is(err, repository.ErrLineItemNotFound) {
+		return ErrLineItemNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if s.index != nil {
+		s.index.Invalidate()
+	}
+
+	logging.FromContext(ctx, s.log).Infow("Line item status updated", "id", id, "status", status)
+	return nil
+}
+
 // GetAll retrieves all line items, optionally filtered by advertiser ID and placement
-func (s *LineItemService) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
-	entityItems, err := s.repo.GetAll(advertiserID, placement)
+func (s *LineItemService) GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItem, error) {
+	entityItems, err := s.repo.GetAll(ctx, advertiserID, placement)
 	if err != nil {
 		return nil, err
 	}
@@ -79,8 +169,14 @@ func (s *LineItemService) GetAll(advertiserID, placement string) ([]*model.LineI
 
 // FindMatchingLineItems finds line items matching the given placement and filters
 // This method will be used by the AdService when implementing the ad selection logic
-func (s *LineItemService) FindMatchingLineItems(placement string, category, keyword string) ([]*model.LineItemEntity, error) {
-	entityItems, err := s.repo.FindMatchingLineItems(placement, category, keyword)
+func (s *LineItemService) FindMatchingLineItems(ctx context.Context, placement string, category, keyword string) ([]*model.LineItemEntity, error) {
+	if s.index != nil {
+		if items, ok := s.index.FindMatchingLineItems(placement, category, keyword); ok {
+			return items, nil
+		}
+	}
+
+	entityItems, err := s.repo.FindMatchingLineItems(ctx, placement, category, keyword)
 	if err != nil {
 		return nil, err
 	}
@@ -88,21 +184,27 @@ func (s *LineItemService) FindMatchingLineItems(placement string, category, keyw
 	return entityItems, nil
 }
 
-func (s *LineItemService) ResetDailySpending() error {
-	err := s.repo.ResetDailySpending()
+func (s *LineItemService) ResetDailySpending(ctx context.Context) error {
+	err := s.repo.ResetDailySpending(ctx)
 	if err != nil {
-		s.log.Errorw("ResetDailySpending failed", "error", err)
+		logging.FromContext(ctx, s.log).Errorw("ResetDailySpending failed", "error", err)
 		return err
 	}
+	if s.index != nil {
+		s.index.Invalidate()
+	}
 
-	s.log.Info("✅ Daily budgets reset successfully")
+	logging.FromContext(ctx, s.log).Info("✅ Daily budgets reset successfully")
 	return nil
 }
 
-func (s *LineItemService) IncreaseDailySpending(lineItemID string, costPerEvent float64) error {
-	if err := s.repo.IncreaseDailySpending(lineItemID, costPerEvent); err != nil {
-		s.log.Errorw("Failed to increase daily spending", "line_item_id", lineItemID, "error", err)
-		return err
+// RecordSpending brings the line item index and spend metrics up to date with amounts, keyed
+// by line item ID, that the tracking repository charged along with the events it stored
+func (s *LineItemService) RecordSpending(amounts map[string]float64) {
+	for lineItemID, amount := range amounts {
+		if s.index != nil {
+			s.index.AddSpending(lineItemID, amount)
+		}
+		s.metrics.Spend(lineItemID, amount)
 	}
-	return nil
 }
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/changefeed"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+)
+
+// LineItemIndex answers ad selection queries from an in-memory snapshot of the active line
+// items, keyed by placement with inverted indexes for categories and keywords. Refreshes
+// build a new snapshot and swap it in, so readers never block or see a partial index.
+type LineItemIndex struct {
+	repo     repository.LineItemRepository
+	interval time.Duration
+	log      *zap.SugaredLogger
+
+	snapshot atomic.Pointer[lineItemSnapshot]
+
+	// refreshMu serialises refreshes. mu guards pending, the spend added while a refresh
+	// reads the repository, which is added again to the snapshot it builds so that spend
+	// charged after the read isn't lost. Spend committed before the read is then counted
+	// twice until the next refresh, erring on the side of stopping line items early.
+	refreshMu sync.Mutex
+	mu        sync.Mutex
+	pending   map[string]float64
+
+	invalidated chan struct{}
+	stopOnce    sync.Once
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+type lineItemSnapshot struct {
+	byID        map[string]*indexedLineItem
+	byPlacement map[string]*placementIndex
+}
+
+type placementIndex struct {
+	items      []*indexedLineItem
+	byCategory map[string][]*indexedLineItem
+	byKeyword  map[string][]*indexedLineItem
+}
+
+type indexedLineItem struct {
+	item       model.LineItemEntity
+	categories map[string]struct{}
+	keywords   map[string]struct{}
+	// spending holds the float64 bits of the daily spending, which changes between refreshes
+	spending atomic.Uint64
+}
+
+func NewLineItemIndex(repo repository.LineItemRepository, interval time.Duration, log *zap.SugaredLogger) *LineItemIndex {
+	return &LineItemIndex{
+		repo:        repo,
+		interval:    interval,
+		log:         log,
+		invalidated: make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Refresh rebuilds the index from the repository
+func (idx *LineItemIndex) Refresh(ctx context.Context) error {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	idx.mu.Lock()
+	idx.pending = make(map[string]float64)
+	idx.mu.Unlock()
+	defer func() {
+		idx.mu.Lock()
+		idx.pending = nil
+		idx.mu.Unlock()
+	}()
+
+	items, err := idx.repo.GetAll(ctx, "", "")
+	if err != nil {
+		return err
+	}
+
+	snapshot := &lineItemSnapshot{
+		byID:        make(map[string]*indexedLineItem),
+		byPlacement: make(map[string]*placementIndex),
+	}
+
+	// A stable order keeps ad selection deterministic between refreshes
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.Before(items[j].CreatedAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	for _, item := range items {
+		if item.Status != model.LineItemStatusActive {
+			continue
+		}
+
+		indexed := &indexedLineItem{
+			item:       *item,
+			categories: toSet(item.Categories),
+			keywords:   toSet(item.Keywords),
+		}
+		indexed.spending.Store(math.Float64bits(item.DailySpending))
+		snapshot.byID[item.ID] = indexed
+
+		placement, ok := snapshot.byPlacement[item.Placement]
+		if !ok {
+			placement = &placementIndex{
+				byCategory: make(map[string][]*indexedLineItem),
+				byKeyword:  make(map[string][]*indexedLineItem),
+			}
+			snapshot.byPlacement[item.Placement] = placement
+		}
+		placement.items = append(placement.items, indexed)
+		for category := range indexed.categories {
+			placement.byCategory[category] = append(placement.byCategory[category], indexed)
+		}
+		for keyword := range indexed.keywords {
+			placement.byKeyword[keyword] = append(placement.byKeyword[keyword], indexed)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for lineItemID, amount := range idx.pending {
+		if indexed, ok := snapshot.byID[lineItemID]; ok {
+			indexed.addSpending(amount)
+		}
+	}
+	idx.snapshot.Store(snapshot)
+	return nil
+}
+
+// Invalidate schedules a refresh; repeated calls before it runs are coalesced
+func (idx *LineItemIndex) Invalidate() {
+	select {
+	case idx.invalidated <- struct{}{}:
+	default:
+	}
+}
+
+// HandleChange applies a change feed event: spending changes are applied in place and any
+// other change schedules a refresh
+func (idx *LineItemIndex) HandleChange(event changefeed.Event) {
+	if event.Type == changefeed.LineItemSpending {
+		if indexed, ok := idx.lookup(event.LineItemID); ok {
+			indexed.spending.Store(math.Float64bits(event.DailySpending))
+		}
+		return
+	}
+	idx.Invalidate()
+}
+
+// AddSpending records spend charged since the last refresh so budget checks stay current
+func (idx *LineItemIndex) AddSpending(lineItemID string, amount float64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.pending != nil {
+		idx.pending[lineItemID] += amount
+	}
+	if indexed, ok := idx.lookup(lineItemID); ok {
+		indexed.addSpending(amount)
+	}
+}
+
+// Contains reports whether lineItemID is an active line item in the index
+func (idx *LineItemIndex) Contains(lineItemID string) bool {
+	_, ok := idx.lookup(lineItemID)
+	return ok
+}
+
+func (idx *LineItemIndex) lookup(lineItemID string) (*indexedLineItem, bool) {
+	snapshot := idx.snapshot.Load()
+	if snapshot == nil {
+		return nil, false
+	}
+	indexed, ok := snapshot.byID[lineItemID]
+	return indexed, ok
+}
+
+// FindMatchingLineItems returns copies of the active line items in placement that have budget
+// left and match the optional category and keyword. ok is false until the first successful Refresh.
+func (idx *LineItemIndex) FindMatchingLineItems(placement, category, keyword string) (items []*model.LineItemEntity, ok bool) {
+	snapshot := idx.snapshot.Load()
+	if snapshot == nil {
+		return nil, false
+	}
+
+	placementItems, found := snapshot.byPlacement[placement]
+	if !found {
+		return nil, true
+	}
+
+	candidates := placementItems.items
+	if category != "" {
+		candidates = placementItems.byCategory[category]
+	}
+	if keyword != "" && len(placementItems.byKeyword[keyword]) < len(candidates) {
+		candidates = placementItems.byKeyword[keyword]
+	}
+
+	for _, indexed := range candidates {
+		if category != "" {
+			if _, match := indexed.categories[category]; !match {
+				continue
+			}
+		}
+		if keyword != "" {
+			if _, match := indexed.keywords[keyword]; !match {
+				continue
+			}
+		}
+
+		spending := math.Float64frombits(indexed.spending.Load())
+		if spending >= indexed.item.Budget {
+			continue
+		}
+
+		item := indexed.item
+		item.DailySpending = spending
+		items = append(items, &item)
+	}
+	return items, true
+}
+
+// Start refreshes the index every interval and whenever it is invalidated, until Stop is
+// called. A refresh that takes longer than the interval is cancelled.
+func (idx *LineItemIndex) Start() {
+	go func() {
+		defer close(idx.done)
+
+		ticker := time.NewTicker(idx.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-idx.invalidated:
+			case <-idx.stop:
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), idx.interval)
+			if err := idx.Refresh(ctx); err != nil {
+				idx.log.Errorw("Failed to refresh line item index", "error", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Stop ends background refreshes started by Start
+func (idx *LineItemIndex) Stop(ctx context.Context) error {
+	idx.stopOnce.Do(func() { close(idx.stop) })
+
+	select {
+	case <-idx.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addSpending adds amount to the daily spending; spending changes from the change feed are
+// stored concurrently
+func (indexed *indexedLineItem) addSpending(amount float64) {
+	for {
+		old := indexed.spending.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + amount)
+		if indexed.spending.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/changefeed"
+	"sweng-task/internal/config"
+	"sweng-task/internal/db"
+	"sweng-task/internal/model"
+	"sweng-task/internal/repository"
+	"sweng-task/internal/repository/memory"
+	"sweng-task/internal/repository/postgres"
+	"sweng-task/internal/testutil"
+)
+
+func indexTestLineItem(placement string, categories, keywords []string) *model.LineItemEntity {
+	item := testutil.CreateTestLineItemEntity()
+	item.Placement = placement
+	item.Categories = categories
+	item.Keywords = keywords
+	return item
+}
+
+func TestLineItemIndex_FindMatchingLineItems(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+
+	_, ok := index.FindMatchingLineItems("homepage", "", "")
+	assert.False(t, ok, "the index is unavailable before the first refresh")
+
+	shoes := indexTestLineItem("homepage", []string{"fashion"}, []string{"shoes"})
+	phones := indexTestLineItem("homepage", []string{"electronics"}, []string{"phone", "sale"})
+	hats := indexTestLineItem("homepage", []string{"fashion"}, []string{"hats", "sale"})
+	paused := indexTestLineItem("homepage", []string{"fashion"}, []string{"sale"})
+	paused.Status = model.LineItemStatusPaused
+	spent := indexTestLineItem("homepage", []string{"fashion"}, []string{"sale"})
+	spent.DailySpending = spent.Budget
+	sidebar := indexTestLineItem("sidebar", []string{"fashion"}, []string{"sale"})
+	for _, item := range []*model.LineItemEntity{shoes, phones, hats, paused, spent, sidebar} {
+		require.NoError(t, repo.Create(t.Context(), item))
+	}
+	require.NoError(t, index.Refresh(t.Context()))
+
+	tests := []struct {
+		name              string
+		placement         string
+		category, keyword string
+		expected          []*model.LineItemEntity
+	}{
+		{name: "placement", placement: "homepage", expected: []*model.LineItemEntity{shoes, phones, hats}},
+		{name: "category", placement: "homepage", category: "fashion", expected: []*model.LineItemEntity{shoes, hats}},
+		{name: "keyword", placement: "homepage", keyword: "sale", expected: []*model.LineItemEntity{phones, hats}},
+		{name: "category and keyword", placement: "homepage", category: "fashion", keyword: "sale", expected: []*model.LineItemEntity{hats}},
+		{name: "unknown category", placement: "homepage", category: "garden"},
+		{name: "unknown placement", placement: "footer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, ok := index.FindMatchingLineItems(tt.placement, tt.category, tt.keyword)
+			assert.True(t, ok)
+
+			var ids, expectedIDs []string
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			for _, item := range tt.expected {
+				expectedIDs = append(expectedIDs, item.ID)
+			}
+			assert.ElementsMatch(t, expectedIDs, ids)
+		})
+	}
+}
+
+func TestLineItemIndex_ReturnsCopies(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+
+	item := indexTestLineItem("homepage", nil, nil)
+	require.NoError(t, repo.Create(t.Context(), item))
+	require.NoError(t, index.Refresh(t.Context()))
+
+	// Bid estimation adjusts the returned line items in place
+	items, _ := index.FindMatchingLineItems("homepage", "", "")
+	require.Len(t, items, 1)
+	items[0].Bid = 0
+
+	items, _ = index.FindMatchingLineItems("homepage", "", "")
+	require.Len(t, items, 1)
+	assert.Equal(t, item.Bid, items[0].Bid)
+}
+
+func TestLineItemIndex_AddSpendingExhaustsBudget(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	lineItemService := NewLineItemService(repo, testutil.GetTestLogger(), WithLineItemIndex(index))
+
+	item := indexTestLineItem("homepage", nil, nil)
+	item.Budget = 10
+	require.NoError(t, repo.Create(t.Context(), item))
+	require.NoError(t, index.Refresh(t.Context()))
+
+	lineItemService.RecordSpending(map[string]float64{item.ID: 4})
+	items, err := lineItemService.FindMatchingLineItems(t.Context(), "homepage", "", "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, 4.0, items[0].DailySpending)
+
+	lineItemService.RecordSpending(map[string]float64{item.ID: 6})
+	items, err = lineItemService.FindMatchingLineItems(t.Context(), "homepage", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+// blockingLineItemRepository calls during in GetAll after reading the line items
+type blockingLineItemRepository struct {
+	*memory.LineItemRepository
+	during func()
+}
+
+func (r *blockingLineItemRepository) GetAll(ctx context.Context, advertiserID, placement string) ([]*model.LineItemEntity, error) {
+	items, err := r.LineItemRepository.GetAll(ctx, advertiserID, placement)
+	if r.during != nil {
+		r.during()
+	}
+	return items, err
+}
+
+func TestLineItemIndex_AddSpendingDuringRefresh(t *testing.T) {
+	repo := &blockingLineItemRepository{LineItemRepository: memory.NewLineItemRepository()}
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+
+	item := indexTestLineItem("homepage", nil, nil)
+	item.Budget = 10
+	require.NoError(t, repo.Create(t.Context(), item))
+	require.NoError(t, index.Refresh(t.Context()))
+
+	// Spend charged after the refresh read the line items is kept in the snapshot it builds
+	repo.during = func() { index.AddSpending(item.ID, 4) }
+	require.NoError(t, index.Refresh(t.Context()))
+
+	items, ok := index.FindMatchingLineItems("homepage", "", "")
+	require.True(t, ok)
+	require.Len(t, items, 1)
+	assert.Equal(t, 4.0, items[0].DailySpending)
+
+	// Spend charged outside a refresh is left to the repository
+	repo.during = nil
+	index.AddSpending(item.ID, 1)
+	require.NoError(t, index.Refresh(t.Context()))
+	items, _ = index.FindMatchingLineItems("homepage", "", "")
+	require.Len(t, items, 1)
+	assert.Equal(t, 0.0, items[0].DailySpending, "the memory repository was never charged")
+}
+
+// countingLineItemRepository counts GetByIDs queries
+type countingLineItemRepository struct {
+	*memory.LineItemRepository
+	getByIDs int
+}
+
+func (r *countingLineItemRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.LineItemEntity, error) {
+	r.getByIDs++
+	return r.LineItemRepository.GetByIDs(ctx, ids)
+}
+
+func TestLineItemService_Exists(t *testing.T) {
+	repo := &countingLineItemRepository{LineItemRepository: memory.NewLineItemRepository()}
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	lineItemService := NewLineItemService(repo, testutil.GetTestLogger(), WithLineItemIndex(index))
+
+	active := indexTestLineItem("homepage", nil, nil)
+	paused := indexTestLineItem("homepage", nil, nil)
+	paused.Status = model.LineItemStatusPaused
+	require.NoError(t, repo.Create(t.Context(), active))
+	require.NoError(t, repo.Create(t.Context(), paused))
+	require.NoError(t, index.Refresh(t.Context()))
+
+	exists, err := lineItemService.Exists(t.Context(), []string{active.ID})
+	require.NoError(t, err)
+	assert.True(t, exists[active.ID])
+	assert.Zero(t, repo.getByIDs, "indexed line items are answered from the index")
+
+	// Paused line items are not indexed but still exist
+	exists, err = lineItemService.Exists(t.Context(), []string{active.ID, paused.ID, "li_unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{active.ID: true, paused.ID: true}, exists)
+	assert.Equal(t, 1, repo.getByIDs)
+}
+
+func TestLineItemIndex_RefreshesAfterCreate(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	lineItemService := NewLineItemService(repo, testutil.GetTestLogger(), WithLineItemIndex(index))
+	require.NoError(t, index.Refresh(t.Context()))
+	index.Start()
+	defer index.Stop(t.Context())
+
+	created, err := lineItemService.Create(t.Context(), testutil.CreateTestLineItemCreate())
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		items, _ := index.FindMatchingLineItems(created.Placement, "", "")
+		return len(items) == 1 && items[0].ID == created.ID
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestLineItemIndex_ConcurrentReadsDuringRefresh(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	for i := 0; i < 50; i++ {
+		require.NoError(t, repo.Create(t.Context(), indexTestLineItem("homepage", []string{"fashion"}, nil)))
+	}
+	require.NoError(t, index.Refresh(t.Context()))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				items, ok := index.FindMatchingLineItems("homepage", "fashion", "")
+				assert.True(t, ok)
+				assert.GreaterOrEqual(t, len(items), 50)
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, repo.Create(t.Context(), indexTestLineItem("homepage", []string{"fashion"}, nil)))
+		require.NoError(t, index.Refresh(t.Context()))
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// seedBenchmarkLineItems creates n line items spread over 10 placements, 20 categories and 50 keywords
+func seedBenchmarkLineItems(b *testing.B, repo repository.LineItemRepository, n int) {
+	b.Helper()
+	for i := 0; i < n; i++ {
+		item := testutil.CreateTestLineItemEntity()
+		item.Placement = fmt.Sprintf("placement_%d", i%10)
+		item.Categories = []string{fmt.Sprintf("category_%d", i%20)}
+		item.Keywords = []string{fmt.Sprintf("keyword_%d", i%50), fmt.Sprintf("keyword_%d", (i+1)%50)}
+		if err := repo.Create(b.Context(), item); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkFindMatching(b *testing.B, find func(ctx context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error)) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := find(b.Context(), "placement_3", "category_13", "keyword_3"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLineItemIndex_FindMatchingLineItems(b *testing.B) {
+	repo := memory.NewLineItemRepository()
+	seedBenchmarkLineItems(b, repo, 10000)
+
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	if err := index.Refresh(b.Context()); err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkFindMatching(b, func(_ context.Context, placement, category, keyword string) ([]*model.LineItemEntity, error) {
+		items, _ := index.FindMatchingLineItems(placement, category, keyword)
+		return items, nil
+	})
+}
+
+// BenchmarkPostgres_FindMatchingLineItems measures the query the index replaces. It needs a
+// database configured through the APP_DATABASE_* variables and is skipped otherwise.
+func BenchmarkPostgres_FindMatchingLineItems(b *testing.B) {
+	if os.Getenv("APP_DATABASE_HOST") == "" {
+		b.Skip("APP_DATABASE_HOST not set")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		b.Fatal(err)
+	}
+	database, err := db.ConnectPostgres(cfg.Database)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := db.RunMigrations(database, "postgres", testutil.GetTestLogger()); err != nil {
+		b.Fatal(err)
+	}
+
+	// Line items are seeded into a transaction that is rolled back afterwards
+	tx := database.Begin()
+	defer tx.Rollback()
+
+	repo := postgres.NewLineItemPostgresRepository(tx, testutil.GetTestLogger())
+	seedBenchmarkLineItems(b, repo, 10000)
+
+	benchmarkFindMatching(b, repo.FindMatchingLineItems)
+}
+
+func TestLineItemIndex_HandleChange(t *testing.T) {
+	repo := memory.NewLineItemRepository()
+	index := NewLineItemIndex(repo, time.Hour, testutil.GetTestLogger())
+	feed := changefeed.NewMemory()
+	feed.Subscribe(index.HandleChange)
+
+	item := indexTestLineItem("homepage", nil, nil)
+	require.NoError(t, repo.Create(t.Context(), item))
+	require.NoError(t, index.Refresh(t.Context()))
+	index.Start()
+	defer index.Stop(t.Context())
+
+	// Spending changes from other instances apply without a refresh
+	feed.Publish(changefeed.Event{Type: changefeed.LineItemSpending, LineItemID: item.ID, DailySpending: item.Budget})
+	items, _ := index.FindMatchingLineItems("homepage", "", "")
+	assert.Empty(t, items)
+
+	// Line items created elsewhere trigger a refresh
+	other := indexTestLineItem("homepage", nil, nil)
+	require.NoError(t, repo.Create(t.Context(), other))
+	feed.Publish(changefeed.Event{Type: changefeed.LineItemCreated, LineItemID: other.ID})
+	assert.Eventually(t, func() bool {
+		items, _ := index.FindMatchingLineItems("homepage", "", "")
+		return len(items) == 2
+	}, time.Second, 5*time.Millisecond)
+}
@@ -1,54 +1,543 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	//"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"sweng-task/internal/cache"
+	"sweng-task/internal/ivt"
+	"sweng-task/internal/logging"
+	"sweng-task/internal/metrics"
 	"sweng-task/internal/model"
 	"sweng-task/internal/repository"
+	"sweng-task/internal/sink"
+	"sweng-task/internal/tracing"
+	"sweng-task/internal/utils"
+)
+
+const (
+	defaultDedupeWindow   = 10 * time.Minute
+	defaultDedupeCapacity = 100000
+	defaultMaxBatchSize   = 500
+	defaultStatsRetention = 30 * 24 * time.Hour
 )
 
 type TrackingService struct {
 	repo            repository.TrackingRepository
 	lineItemService *LineItemService
 	logger          *zap.SugaredLogger
+	dedupe          *cache.TTLCache[string, model.TrackingResult]
+	maxBatchSize    int
+	sink            sink.EventSink
+	counters        *EventCounterCache
+	statsRetention  time.Duration
+	metrics         *metrics.Metrics
+	ivt             *ivt.Filter
 }
 
-func NewTrackingService(repo repository.TrackingRepository, lineItemService *LineItemService, logger *zap.SugaredLogger) *TrackingService {
-	return &TrackingService{repo: repo, lineItemService: lineItemService, logger: logger}
+// BatchItemResult is the outcome of a single event within a batch. Exactly one of Result and Err is set.
+type BatchItemResult struct {
+	Result *model.TrackingResult
+	Err    error
 }
 
-func (s *TrackingService) Track(event model.TrackingEvent) error {
-	s.logger.Infow("Tracking event", "event_type", event.EventType, "line_item_id", event.LineItemID)
+// TrackingOption configures optional TrackingService behaviour
+type TrackingOption func(*TrackingService)
 
-	// 1. Check if LineItem exists
-	lineItem, err := s.lineItemService.GetByID(event.LineItemID)
-	if err != nil {
-		return ErrLineItemNotFound
+// WithDedupeWindow sets how long and how many recently tracked event IDs are remembered in memory
+func WithDedupeWindow(window time.Duration, capacity int) TrackingOption {
+	return func(s *TrackingService) {
+		s.dedupe = cache.NewTTLCache[string, model.TrackingResult](window, capacity)
 	}
+}
 
-	// 2. Increase daily spending if relevant event
-	switch event.EventType {
-	case model.TrackingEventTypeImpression,
-		model.TrackingEventTypeClick,
-		model.TrackingEventTypeConversion:
-		costPerEvent := lineItem.Bid / 1000
+// WithMaxBatchSize sets the maximum number of events accepted by TrackBatch
+func WithMaxBatchSize(size int) TrackingOption {
+	return func(s *TrackingService) {
+		s.maxBatchSize = size
+	}
+}
 
-		if err := s.lineItemService.IncreaseDailySpending(lineItem.ID, costPerEvent); err != nil {
-			s.logger.Errorw("Failed to increase daily spending", "line_item_id", lineItem.ID, "error", err)
-			return err
+// WithEventSink publishes every newly accepted event to sink
+func WithEventSink(eventSink sink.EventSink) TrackingOption {
+	return func(s *TrackingService) {
+		s.sink = eventSink
+	}
+}
+
+// WithEventCounterCache serves GetEventCounts from counters instead of querying the repository
+func WithEventCounterCache(counters *EventCounterCache) TrackingOption {
+	return func(s *TrackingService) {
+		s.counters = counters
+	}
+}
+
+// WithStatsRetention sets how long hourly counter buckets are kept for windowed event counts
+func WithStatsRetention(retention time.Duration) TrackingOption {
+	return func(s *TrackingService) {
+		s.statsRetention = retention
+	}
+}
+
+// WithTrackingMetrics records tracked events by type and result in m
+func WithTrackingMetrics(m *metrics.Metrics) TrackingOption {
+	return func(s *TrackingService) {
+		s.metrics = m
+	}
+}
+
+// WithIVTFilter marks events flagged by filter as invalid traffic, which is stored but neither
+// charged nor counted
+func WithIVTFilter(filter *ivt.Filter) TrackingOption {
+	return func(s *TrackingService) {
+		s.ivt = filter
+	}
+}
+
+func NewTrackingService(repo repository.TrackingRepository, lineItemService *LineItemService, logger *zap.SugaredLogger, opts ...TrackingOption) *TrackingService {
+	s := &TrackingService{
+		repo:            repo,
+		lineItemService: lineItemService,
+		logger:          logger,
+		dedupe:          cache.NewTTLCache[string, model.TrackingResult](defaultDedupeWindow, defaultDedupeCapacity),
+		maxBatchSize:    defaultMaxBatchSize,
+		statsRetention:  defaultStatsRetention,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Track records the event and charges its line item. Events are idempotent on EventID:
+// a redelivered event returns the original result without being stored or charged again.
+func (s *TrackingService) Track(ctx context.Context, event model.TrackingEvent) (*model.TrackingResult, error) {
+	log := logging.FromContext(ctx, s.logger)
+	log.Infow("Tracking event", "event_id", event.EventID, "event_type", event.EventType, "line_item_id", event.LineItemID)
+
+	if event.EventID == "" {
+		event.EventID = NewEventID()
+	}
+
+	// 1. Short-circuit recently seen events without touching the database
+	if result, ok := s.dedupe.Get(event.EventID); ok {
+		log.Infow("Duplicate tracking event ignored", "event_id", event.EventID, "source", "cache")
+		s.metrics.TrackingEvent(string(event.EventType), metrics.TrackingDuplicate)
+		result.Duplicate = true
+		return &result, nil
+	}
+
+	// 2. Check if LineItem exists
+	lineItem, err := s.lineItemService.GetByID(ctx, event.LineItemID)
+	if err != nil {
+		if errors.Is(err, ErrLineItemNotFound) {
+			s.metrics.TrackingEvent(string(event.EventType), metrics.TrackingRejected)
 		}
+		return nil, err
 	}
 
-	// 3. Store the tracking event
+	// 3. Store the tracking event, which claims its event ID and charges its cost to the line
+	// item in the same transaction
 	eventEntity := model.ToEntityTrackingEvent(event)
-	if err := s.repo.Store(&eventEntity); err != nil {
-		s.logger.Errorw("Failed to store tracking event", "error", err)
+	eventEntity.Cost = s.costPerEvent(event.EventType, lineItem.Bid)
+	s.filterInvalid(ctx, []model.TrackingEvent{event}, []*model.TrackingEventEntity{&eventEntity})
+
+	ctx, err = s.writeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Store(ctx, &eventEntity); err != nil {
+		if errors.Is(err, repository.ErrDuplicateEvent) {
+			s.metrics.TrackingEvent(string(event.EventType), metrics.TrackingDuplicate)
+			return s.originalResult(ctx, event.EventID)
+		}
+		log.Errorw("Failed to store tracking event", "error", err)
+		return nil, err
+	}
+
+	// 4. Account the charge of the newly stored event in the index and metrics
+	if eventEntity.Cost > 0 {
+		s.lineItemService.RecordSpending(map[string]float64{lineItem.ID: eventEntity.Cost})
+	}
+
+	s.recordTraffic(ctx, event, &eventEntity)
+	result := model.ToTrackingResult(eventEntity, false)
+	s.dedupe.Set(event.EventID, result)
+	s.metrics.TrackingEvent(string(event.EventType), trackingOutcome(result))
+	s.publish(ctx, []*model.TrackingEventEntity{&eventEntity})
+	return &result, nil
+}
+
+// TrackBatch tracks events with a single bulk insert that charges their spend per batch.
+// Events that fail individually are reported in their result without failing the batch;
+// the returned error is only set when the batch as a whole could not be processed.
+func (s *TrackingService) TrackBatch(ctx context.Context, events []model.TrackingEvent) ([]BatchItemResult, error) {
+	if len(events) > s.maxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	log := logging.FromContext(ctx, s.logger)
+	log.Infow("Tracking event batch", "size", len(events))
+
+	events = append([]model.TrackingEvent(nil), events...)
+	results := make([]BatchItemResult, len(events))
+
+	// 1. Assign event IDs and drop events already seen in this batch or the dedupe window
+	firstByID := make(map[string]int, len(events))
+	var pending []int
+	var lineItemIDs []string
+	for i := range events {
+		if events[i].EventID == "" {
+			events[i].EventID = NewEventID()
+		}
+		if _, seen := firstByID[events[i].EventID]; seen {
+			continue
+		}
+		firstByID[events[i].EventID] = i
+
+		if result, ok := s.dedupe.Get(events[i].EventID); ok {
+			result.Duplicate = true
+			results[i].Result = &result
+			continue
+		}
+		pending = append(pending, i)
+		lineItemIDs = append(lineItemIDs, events[i].LineItemID)
+	}
+
+	// 2. Resolve all referenced line items in one lookup
+	lineItems, err := s.lineItemService.GetByIDs(ctx, lineItemIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make([]*model.TrackingEventEntity, 0, len(pending))
+	checked := make([]model.TrackingEvent, 0, len(pending))
+	for _, i := range pending {
+		lineItem, ok := lineItems[events[i].LineItemID]
+		if !ok {
+			results[i].Err = ErrLineItemNotFound
+			continue
+		}
+		entity := model.ToEntityTrackingEvent(events[i])
+		entity.Cost = s.costPerEvent(entity.EventType, lineItem.Bid)
+		entities = append(entities, &entity)
+		checked = append(checked, events[i])
+	}
+	s.filterInvalid(ctx, checked, entities)
+
+	ctx, err = s.writeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Bulk insert, charging newly stored events in the same transaction, then look up the
+	// original result of events stored by earlier requests
+	duplicates, err := s.repo.StoreBatch(ctx, entities)
+	if err != nil {
+		log.Errorw("Failed to store tracking event batch", "error", err)
+		return nil, err
+	}
+
+	originals := make(map[string]model.TrackingResult, len(duplicates))
+	if len(duplicates) > 0 {
+		stored, err := s.repo.FindByEventIDs(ctx, duplicates)
+		if err != nil {
+			log.Errorw("Failed to load original tracking events", "error", err)
+			return nil, err
+		}
+		for _, e := range stored {
+			originals[e.EventID] = model.ToTrackingResult(*e, false)
+		}
+	}
+
+	// 4. Aggregate spend per line item over newly stored events
+	spend := make(map[string]float64)
+	for _, entity := range entities {
+		i := firstByID[entity.EventID]
+		if original, ok := originals[entity.EventID]; ok {
+			s.dedupe.Set(entity.EventID, original)
+			original.Duplicate = true
+			results[i].Result = &original
+			continue
+		}
+		if entity.ID == 0 {
+			results[i].Err = repository.ErrEventNotFound
+			continue
+		}
+		spend[entity.LineItemID] += entity.Cost
+		result := model.ToTrackingResult(*entity, false)
+		results[i].Result = &result
+	}
+
+	s.lineItemService.RecordSpending(spend)
+
+	accepted := make([]*model.TrackingEventEntity, 0, len(entities))
+	for _, entity := range entities {
+		if result := results[firstByID[entity.EventID]].Result; result != nil && !result.Duplicate {
+			s.dedupe.Set(entity.EventID, *result)
+			s.recordTraffic(ctx, events[firstByID[entity.EventID]], entity)
+			accepted = append(accepted, entity)
+		}
+	}
+	s.publish(ctx, accepted)
+
+	// 5. Repeats of an event ID within the batch share the outcome of its first occurrence
+	for i := range events {
+		first := firstByID[events[i].EventID]
+		if first == i {
+			continue
+		}
+		results[i] = results[first]
+		if results[i].Result != nil {
+			duplicate := *results[i].Result
+			duplicate.Duplicate = true
+			results[i].Result = &duplicate
+		}
+	}
+
+	s.recordBatch(events, results)
+	return results, nil
+}
+
+// writeContext returns the context to store events with once the lookups preceding the write
+// are done. The request deadline only applies up to the write: an event that starts to be
+// stored is stored and charged in full rather than cut short in between.
+func (s *TrackingService) writeContext(ctx context.Context) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return context.WithoutCancel(ctx), nil
+}
+
+func (s *TrackingService) recordBatch(events []model.TrackingEvent, results []BatchItemResult) {
+	for i, result := range results {
+		switch {
+		case result.Err != nil:
+			s.metrics.TrackingEvent(string(events[i].EventType), metrics.TrackingRejected)
+		case result.Result.Duplicate:
+			s.metrics.TrackingEvent(string(events[i].EventType), metrics.TrackingDuplicate)
+		default:
+			s.metrics.TrackingEvent(string(events[i].EventType), trackingOutcome(*result.Result))
+		}
+	}
+}
+
+// trackingOutcome is the metrics result of a newly tracked event
+func trackingOutcome(result model.TrackingResult) string {
+	if result.InvalidReason != "" {
+		return metrics.TrackingInvalid
+	}
+	return metrics.TrackingAccepted
+}
+
+// filterInvalid marks each of entities as invalid traffic when the IVT filter flags the event
+// it was built from, waiving its cost. Events the filter fails to check are treated as valid,
+// so that an outage of the impression lookup doesn't stop billing.
+func (s *TrackingService) filterInvalid(ctx context.Context, events []model.TrackingEvent, entities []*model.TrackingEventEntity) {
+	if s.ivt == nil || len(events) == 0 {
+		return
+	}
+
+	log := logging.FromContext(ctx, s.logger)
+	reasons, err := s.ivt.CheckBatch(ctx, events)
+	if err != nil {
+		log.Errorw("Failed to check tracking events for invalid traffic", "error", err)
+	}
+	for i, reason := range reasons {
+		if reason == "" {
+			continue
+		}
+		log.Infow("Invalid traffic detected", "event_id", events[i].EventID, "line_item_id", events[i].LineItemID, "reason", reason)
+		entities[i].InvalidReason = string(reason)
+		entities[i].Cost = 0
+	}
+}
+
+// recordTraffic hands a newly stored event to the IVT filter, so that it counts towards the
+// rules on its user and client IP. Duplicates are not recorded, so that retries and replays
+// of an event are not counted again.
+func (s *TrackingService) recordTraffic(ctx context.Context, event model.TrackingEvent, entity *model.TrackingEventEntity) {
+	if s.ivt == nil {
+		return
+	}
+	if err := s.ivt.Record(ctx, event, ivt.Reason(entity.InvalidReason)); err != nil {
+		logging.FromContext(ctx, s.logger).Errorw("Failed to record tracking event for invalid traffic", "event_id", event.EventID, "error", err)
+	}
+}
+
+// RecentResult returns the result of an event tracked within the dedupe window, if any
+func (s *TrackingService) RecentResult(eventID string) (*model.TrackingResult, bool) {
+	result, ok := s.dedupe.Get(eventID)
+	if !ok {
+		return nil, false
+	}
+	result.Duplicate = true
+	return &result, true
+}
+
+// MaxBatchSize returns the maximum number of events accepted by TrackBatch
+func (s *TrackingService) MaxBatchSize() int {
+	return s.maxBatchSize
+}
+
+// GetEventCounts returns the event counts for a line item and placement, either of which may
+// be empty to count across all of them. Counts come from the counter cache once it has loaded.
+func (s *TrackingService) GetEventCounts(ctx context.Context, lineItemID string, placement string) (_ model.EventCounts, err error) {
+	ctx, span := tracing.Start(ctx, "TrackingService.GetEventCounts",
+		attribute.String("line_item.id", lineItemID),
+		attribute.String("ad.placement", placement),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if s.counters != nil {
+		if counts, ok := s.counters.Get(lineItemID, placement); ok {
+			span.SetAttributes(attribute.String("event_counts.source", "cache"))
+			return counts, nil
+		}
+	}
+	span.SetAttributes(attribute.String("event_counts.source", "repository"))
+	return s.repo.CountEvents(ctx, lineItemID, placement)
+}
+
+// GetEventCountsBatch returns, keyed by line item ID, the counts of each line item overall and
+// within placement weighted by window. An empty ID counts across all line items. Until the
+// counter cache has loaded, lifetime counts are read from the repository in a single query.
+func (s *TrackingService) GetEventCountsBatch(ctx context.Context, lineItemIDs []string, placement string, window utils.StatsWindow) (_ map[string]model.LineItemEventCounts, err error) {
+	ctx, span := tracing.Start(ctx, "TrackingService.GetEventCountsBatch",
+		attribute.Int("line_items", len(lineItemIDs)),
+		attribute.String("ad.placement", placement),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if s.counters != nil {
+		if counts, ok := s.cachedEventCounts(lineItemIDs, placement, window); ok {
+			span.SetAttributes(attribute.String("event_counts.source", "cache"))
+			return counts, nil
+		}
+	}
+	span.SetAttributes(attribute.String("event_counts.source", "repository"))
+	return s.repo.CountEventsBatch(ctx, lineItemIDs, placement)
+}
+
+// FallbackEventCounts returns counts for bid estimation when GetEventCountsBatch fails: the
+// lifetime counts last loaded by the counter cache, or zero counts, which strategies price
+// at BidFallbackMultiplier, for line items the cache does not hold
+func (s *TrackingService) FallbackEventCounts(lineItemIDs []string, placement string) map[string]model.LineItemEventCounts {
+	counts := make(map[string]model.LineItemEventCounts, len(lineItemIDs))
+	for _, id := range lineItemIDs {
+		if s.counters == nil {
+			counts[id] = model.LineItemEventCounts{}
+			continue
+		}
+		total, _ := s.counters.Get(id, "")
+		inPlacement, _ := s.counters.Get(id, placement)
+		counts[id] = model.LineItemEventCounts{Total: total, Placement: inPlacement}
+	}
+	return counts
+}
+
+func (s *TrackingService) cachedEventCounts(lineItemIDs []string, placement string, window utils.StatsWindow) (map[string]model.LineItemEventCounts, bool) {
+	now := time.Now()
+	counts := make(map[string]model.LineItemEventCounts, len(lineItemIDs))
+	for _, id := range lineItemIDs {
+		total, ok := s.counters.GetWindow(id, "", window, now)
+		if !ok {
+			return nil, false
+		}
+		inPlacement, _ := s.counters.GetWindow(id, placement, window, now)
+		counts[id] = model.LineItemEventCounts{Total: total, Placement: inPlacement}
+	}
+	return counts, true
+}
+
+// InvalidTrafficReport returns the valid and invalid event counts of a line item
+func (s *TrackingService) InvalidTrafficReport(ctx context.Context, lineItemID string) (*model.InvalidTrafficReport, error) {
+	lineItem, err := s.lineItemService.GetByID(ctx, lineItemID)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := s.repo.CountEvents(ctx, lineItemID, "")
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := s.repo.CountInvalidEvents(ctx, lineItemID)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Errorw("Failed to count invalid events", "line_item_id", lineItemID, "error", err)
+		return nil, err
+	}
+
+	report := model.ToInvalidTrafficReport(*lineItem, valid, invalid)
+	return &report, nil
+}
+
+// PruneEventStats deletes hourly counter buckets older than the stats retention period
+func (s *TrackingService) PruneEventStats(ctx context.Context) error {
+	deleted, err := s.repo.DeleteEventCounterBuckets(ctx, time.Now().Add(-s.statsRetention))
+	if err != nil {
 		return err
 	}
 
+	logging.FromContext(ctx, s.logger).Infow("Pruned event counter buckets", "deleted", deleted, "retention", s.statsRetention)
 	return nil
 }
 
-func (s *TrackingService) GetEventCounts(lineItemID string, placement string) (model.EventCounts, error) {
-	return s.repo.CountEvents(lineItemID, placement)
+// LineItemsExist reports which of ids belong to an existing line item, so that events
+// for unknown line items can be rejected before they are queued
+func (s *TrackingService) LineItemsExist(ctx context.Context, ids []string) (map[string]bool, error) {
+	return s.lineItemService.Exists(ctx, ids)
+}
+
+// NewEventID generates an event ID for events submitted without one
+func NewEventID() string {
+	return "evt_" + uuid.New().String()
+}
+
+func (s *TrackingService) costPerEvent(eventType model.TrackingEventType, bid float64) float64 {
+	switch eventType {
+	case model.TrackingEventTypeImpression,
+		model.TrackingEventTypeClick,
+		model.TrackingEventTypeConversion:
+		return bid / 1000
+	}
+	return 0
+}
+
+// publish hands newly accepted events to the event sink. The events are already stored
+// and charged, so a sink failure is logged rather than failing the request.
+func (s *TrackingService) publish(ctx context.Context, entities []*model.TrackingEventEntity) {
+	if s.sink == nil || len(entities) == 0 {
+		return
+	}
+
+	now := time.Now()
+	events := make([]model.PublishedEvent, len(entities))
+	for i, e := range entities {
+		events[i] = model.ToPublishedEvent(*e, now)
+	}
+
+	if err := s.sink.Publish(events); err != nil {
+		logging.FromContext(ctx, s.logger).Errorw("Failed to publish tracking events", "count", len(events), "error", err)
+	}
+}
+
+func (s *TrackingService) originalResult(ctx context.Context, eventID string) (*model.TrackingResult, error) {
+	original, err := s.repo.FindByEventID(ctx, eventID)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Errorw("Failed to load original tracking event", "event_id", eventID, "error", err)
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).Infow("Duplicate tracking event ignored", "event_id", eventID, "source", "database")
+
+	result := model.ToTrackingResult(*original, false)
+	s.dedupe.Set(eventID, result)
+
+	result.Duplicate = true
+	return &result, nil
 }
@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"sweng-task/internal/model"
+)
+
+// FileSink appends events to a file as newline-delimited JSON
+type FileSink struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: f, writer: bufio.NewWriter(f)}, nil
+}
+
+func (s *FileSink) Publish(events []model.PublishedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(s.writer)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	return s.writer.Flush()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
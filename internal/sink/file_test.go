@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+)
+
+func TestFileSink_AppendsNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+
+	s, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Publish(testPublishedEvents("li_1", "li_2")))
+	require.NoError(t, s.Close())
+
+	// Reopening appends rather than truncating
+	s, err = NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Publish(testPublishedEvents("li_3")))
+	require.NoError(t, s.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lineItems []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event model.PublishedEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		assert.NotEmpty(t, event.EventID)
+		assert.Equal(t, 0.0025, event.Cost)
+		lineItems = append(lineItems, event.LineItemID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"li_1", "li_2", "li_3"}, lineItems)
+}
@@ -0,0 +1,155 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/kafka"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+)
+
+const (
+	// DefaultKafkaBuffer is the number of events buffered for the producer by default
+	DefaultKafkaBuffer = 10000
+	// maxKafkaBatch bounds the records sent in one produce request
+	maxKafkaBatch = 500
+)
+
+// ErrBufferFull is returned by KafkaSink.Publish for events dropped because the producer
+// has fallen too far behind
+var ErrBufferFull = errors.New("sink: kafka buffer full")
+
+// KafkaSink produces events as JSON to a Kafka topic, keyed by line item ID so that
+// all events of a line item land on the same partition in order.
+//
+// Publish only buffers the events; a background goroutine produces them in batches, so
+// a slow or unavailable broker never holds up tracking requests. Events that do not fit
+// in the buffer are dropped, and events the producer fails to write are logged; both
+// are counted in the sink_events_total metric.
+type KafkaSink struct {
+	producer *kafka.Producer
+	topic    string
+	log      *zap.SugaredLogger
+	metrics  *metrics.Metrics
+	buffer   int
+
+	mu      sync.RWMutex
+	closed  bool
+	records chan kafka.Record
+	done    chan struct{}
+}
+
+// KafkaSinkOption configures optional KafkaSink behaviour
+type KafkaSinkOption func(*KafkaSink)
+
+// WithKafkaBuffer buffers up to size events for the producer
+func WithKafkaBuffer(size int) KafkaSinkOption {
+	return func(s *KafkaSink) {
+		s.buffer = size
+	}
+}
+
+// WithKafkaMetrics counts published, dropped and failed events in m
+func WithKafkaMetrics(m *metrics.Metrics) KafkaSinkOption {
+	return func(s *KafkaSink) {
+		s.metrics = m
+	}
+}
+
+// NewKafkaSink creates a KafkaSink and starts producing in the background until Close
+func NewKafkaSink(cfg kafka.ProducerConfig, topic string, log *zap.SugaredLogger, opts ...KafkaSinkOption) *KafkaSink {
+	s := &KafkaSink{
+		producer: kafka.NewProducer(cfg),
+		topic:    topic,
+		log:      log,
+		buffer:   DefaultKafkaBuffer,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.records = make(chan kafka.Record, s.buffer)
+	go s.run()
+	return s
+}
+
+// Publish buffers events for the producer. It never blocks; events that do not fit are
+// dropped and reported with ErrBufferFull.
+func (s *KafkaSink) Publish(events []model.PublishedEvent) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return kafka.ErrClosed
+	}
+	for i, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		record := kafka.Record{
+			Key:       []byte(e.LineItemID),
+			Value:     value,
+			Timestamp: e.Timestamp.UnixMilli(),
+		}
+
+		select {
+		case s.records <- record:
+		default:
+			dropped := len(events) - i
+			s.metrics.SinkEvents("kafka", metrics.SinkDropped, dropped)
+			return fmt.Errorf("%w: dropped %d of %d events", ErrBufferFull, dropped, len(events))
+		}
+	}
+	return nil
+}
+
+// Close produces the events still buffered and releases the producer
+func (s *KafkaSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+
+	<-s.done
+	return s.producer.Close()
+}
+
+func (s *KafkaSink) run() {
+	defer close(s.done)
+
+	batch := make([]kafka.Record, 0, maxKafkaBatch)
+	for record := range s.records {
+		batch = s.fill(append(batch[:0], record))
+		if err := s.producer.Produce(s.topic, batch); err != nil {
+			s.log.Errorw("Failed to produce tracking events", "topic", s.topic, "count", len(batch), "error", err)
+			s.metrics.SinkEvents("kafka", metrics.SinkFailed, len(batch))
+			continue
+		}
+		s.metrics.SinkEvents("kafka", metrics.SinkPublished, len(batch))
+	}
+}
+
+// fill adds the records already buffered to batch, up to maxKafkaBatch
+func (s *KafkaSink) fill(batch []kafka.Record) []kafka.Record {
+	for len(batch) < maxKafkaBatch {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, record)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
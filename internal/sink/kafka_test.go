@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/kafka"
+	"sweng-task/internal/kafka/kafkatest"
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+	"sweng-task/internal/testutil"
+)
+
+func testPublishedEvents(lineItemIDs ...string) []model.PublishedEvent {
+	events := make([]model.PublishedEvent, len(lineItemIDs))
+	for i, id := range lineItemIDs {
+		event := testutil.CreateTestTrackingEvent(id)
+		event.EventID = "evt_" + id + "_" + string(rune('a'+i))
+		events[i] = model.PublishedEvent{TrackingEvent: event, Cost: 0.0025, AcceptedAt: time.Now().UTC()}
+	}
+	return events
+}
+
+func TestKafkaSink_PublishKeysByLineItem(t *testing.T) {
+	broker, err := kafkatest.NewBroker(map[string]int32{"tracking-events": 3})
+	require.NoError(t, err)
+	defer broker.Close()
+
+	s := NewKafkaSink(kafka.ProducerConfig{Brokers: []string{broker.Addr()}, ClientID: "test", Acks: 1}, "tracking-events", testutil.GetTestLogger())
+
+	events := testPublishedEvents("li_1", "li_2", "li_1", "li_3")
+	require.NoError(t, s.Publish(events))
+	// Close waits for the buffered events to be produced
+	require.NoError(t, s.Close())
+
+	messages := broker.Messages()
+	require.Len(t, messages, len(events))
+
+	partitions := make(map[string]int32)
+	for _, msg := range messages {
+		assert.Equal(t, "tracking-events", msg.Topic)
+
+		var got model.PublishedEvent
+		require.NoError(t, json.Unmarshal(msg.Value, &got))
+		assert.Equal(t, string(msg.Key), got.LineItemID)
+		assert.Equal(t, int32(kafka.Partition(msg.Key, 3)), msg.Partition)
+
+		if p, ok := partitions[got.LineItemID]; ok {
+			assert.Equal(t, p, msg.Partition, "events of a line item must share a partition")
+		}
+		partitions[got.LineItemID] = msg.Partition
+	}
+}
+
+func TestKafkaSink_UnknownTopic(t *testing.T) {
+	broker, err := kafkatest.NewBroker(map[string]int32{"tracking-events": 1})
+	require.NoError(t, err)
+	defer broker.Close()
+
+	m := metrics.New()
+	s := NewKafkaSink(kafka.ProducerConfig{Brokers: []string{broker.Addr()}, Acks: 1}, "missing", testutil.GetTestLogger(), WithKafkaMetrics(m))
+
+	// Produce failures happen in the background and are counted rather than returned
+	assert.NoError(t, s.Publish(testPublishedEvents("li_1")))
+	require.NoError(t, s.Close())
+	assert.Empty(t, broker.Messages())
+
+	expected := `
+# HELP adserver_sink_events_total Accepted events handed to event sinks, by sink and result.
+# TYPE adserver_sink_events_total counter
+adserver_sink_events_total{result="failed",sink="kafka"} 1
+`
+	assert.NoError(t, promtestutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "adserver_sink_events_total"))
+}
+
+func TestKafkaSink_PublishesAcrossCalls(t *testing.T) {
+	broker, err := kafkatest.NewBroker(map[string]int32{"tracking-events": 1})
+	require.NoError(t, err)
+	defer broker.Close()
+
+	s := NewKafkaSink(kafka.ProducerConfig{Brokers: []string{broker.Addr()}, Acks: -1}, "tracking-events", testutil.GetTestLogger())
+	defer s.Close()
+
+	require.NoError(t, s.Publish(testPublishedEvents("li_1")))
+	require.NoError(t, s.Publish(testPublishedEvents("li_2", "li_3")))
+	assert.Eventually(t, func() bool { return len(broker.Messages()) == 3 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestKafkaSink_DropsWhenBufferFull(t *testing.T) {
+	// A broker that accepts connections but never answers stalls the producer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	m := metrics.New()
+	s := NewKafkaSink(kafka.ProducerConfig{Brokers: []string{listener.Addr().String()}, Acks: 1, Timeout: 100 * time.Millisecond},
+		"tracking-events", testutil.GetTestLogger(), WithKafkaBuffer(2), WithKafkaMetrics(m))
+
+	start := time.Now()
+	err = s.Publish(testPublishedEvents("li_1", "li_2", "li_3", "li_4", "li_5", "li_6"))
+	assert.ErrorIs(t, err, ErrBufferFull)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "publishing does not wait for the broker")
+	require.NoError(t, s.Close())
+
+	// Every event is either dropped or fails once the stalled producer times out
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "adserver_sink_events_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "result" {
+					counts[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	assert.Positive(t, counts[metrics.SinkDropped])
+	assert.Equal(t, 6.0, counts[metrics.SinkDropped]+counts[metrics.SinkFailed])
+
+	assert.ErrorIs(t, s.Publish(testPublishedEvents("li_1")), kafka.ErrClosed)
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"errors"
+
+	"sweng-task/internal/model"
+)
+
+// EventSink receives tracking events once they have been accepted and charged
+type EventSink interface {
+	Publish(events []model.PublishedEvent) error
+	Close() error
+}
+
+// Multi fans events out to several sinks. Every sink is attempted; their errors are joined.
+type Multi []EventSink
+
+func (m Multi) Publish(events []model.PublishedEvent) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Publish(events); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
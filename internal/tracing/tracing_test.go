@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"sweng-task/internal/config"
+)
+
+// recordSpans installs a tracer provider that records every span until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	_, err := Setup(config.TracingConfig{}, "test", "0.0.0")
+	require.NoError(t, err)
+	recorder := recordSpans(t)
+
+	app := fiber.New()
+	app.Use(Middleware())
+	var handlerSpan trace.SpanContext
+	app.Get("/lineitems/:id", func(c *fiber.Ctx) error {
+		handlerSpan = trace.SpanContextFromContext(c.UserContext())
+		return c.SendStatus(fiber.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/lineitems/li_1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /lineitems/:id", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	assert.Equal(t, span.SpanContext(), handlerSpan, "handlers see the request span in their user context")
+}
+
+func TestSetup_FileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	path := filepath.Join(t.TempDir(), "traces", "spans.ndjson")
+	shutdown, err := Setup(config.TracingConfig{Exporter: ExporterFile, FilePath: path, SampleRatio: 1}, "test", "0.0.0")
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "AdService.GetWinningAds")
+	span.End()
+	require.NoError(t, shutdown(t.Context()))
+
+	exported, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"Name":"AdService.GetWinningAds"`)
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := Setup(config.TracingConfig{Exporter: "zipkin"}, "test", "0.0.0")
+	assert.ErrorContains(t, err, `unknown tracing exporter "zipkin"`)
+}
@@ -3,12 +3,13 @@ package utils
 import (
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
+	"sweng-task/internal/logging"
 )
 
 func ErrorHandler(log *zap.SugaredLogger) fiber.ErrorHandler {
 	return func(c *fiber.Ctx, err error) error {
 		// Log the error
-		log.Errorw("Unhandled error", "path", c.Path(), "error", err)
+		logging.FromContext(c.UserContext(), log).Errorw("Unhandled error", "path", c.Path(), "error", err)
 
 		// Determine status code
 		code := fiber.StatusInternalServerError
@@ -18,9 +19,10 @@ func ErrorHandler(log *zap.SugaredLogger) fiber.ErrorHandler {
 
 		// Send consistent JSON error
 		return c.Status(code).JSON(ErrorResponse{
-			Code:    code,
-			Message: "An unexpected error occurred",
-			Details: err.Error(),
+			Code:      code,
+			Message:   "An unexpected error occurred",
+			Details:   err.Error(),
+			RequestID: logging.RequestID(c),
 		})
 	}
 }
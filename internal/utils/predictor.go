@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"math"
+	"time"
+
 	"sweng-task/internal/model"
 )
 
@@ -14,13 +17,47 @@ const (
 
 type BidStrategy interface {
 	Calculate(maxBid float64, global, placement, item, itemPlacement model.EventCounts) float64
+	// StatsWindow selects the events the counts passed to Calculate are drawn from
+	StatsWindow() StatsWindow
+}
+
+// StatsWindow weights tracked events by age. The zero value counts every event ever tracked
+// with equal weight; otherwise only events within the stats retention period are counted.
+type StatsWindow struct {
+	// Window ignores events older than this; zero keeps every retained event
+	Window time.Duration
+	// HalfLife halves the weight of an event for every HalfLife of its age; zero disables decay
+	HalfLife time.Duration
+}
+
+// AllTime reports whether the window counts every event ever tracked
+func (w StatsWindow) AllTime() bool {
+	return w.Window == 0 && w.HalfLife == 0
 }
 
-type AvgConversionRateStrategy struct{}
+// Weight returns how much an event of the given age counts towards the stats
+func (w StatsWindow) Weight(age time.Duration) float64 {
+	age = max(age, 0)
+	if w.Window > 0 && age > w.Window {
+		return 0
+	}
+	if w.HalfLife <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(w.HalfLife))
+}
+
+type AvgConversionRateStrategy struct {
+	Stats StatsWindow
+}
+
+func (s AvgConversionRateStrategy) StatsWindow() StatsWindow {
+	return s.Stats
+}
 
 func (s AvgConversionRateStrategy) Calculate(maxBid float64, global, placement, item, itemPlacement model.EventCounts) float64 {
-	cvr := calculateRateWithFallbacks([]model.EventCounts{itemPlacement, item, placement, global}, MinImpressionThreshold, func(e model.EventCounts) int { return e.Conversions })
-	avgCVR := calculateRateWithFallbacks([]model.EventCounts{placement, global}, MinImpressionThreshold, func(e model.EventCounts) int { return e.Conversions })
+	cvr := calculateRateWithFallbacks([]model.EventCounts{itemPlacement, item, placement, global}, MinImpressionThreshold, func(e model.EventCounts) float64 { return e.Conversions })
+	avgCVR := calculateRateWithFallbacks([]model.EventCounts{placement, global}, MinImpressionThreshold, func(e model.EventCounts) float64 { return e.Conversions })
 
 	if avgCVR == 0 {
 		return maxBid * BidFallbackMultiplier
@@ -38,11 +75,17 @@ func (s AvgConversionRateStrategy) Calculate(maxBid float64, global, placement,
 	}
 }
 
-type AvgClickThroughRateStrategy struct{}
+type AvgClickThroughRateStrategy struct {
+	Stats StatsWindow
+}
+
+func (s AvgClickThroughRateStrategy) StatsWindow() StatsWindow {
+	return s.Stats
+}
 
 func (s AvgClickThroughRateStrategy) Calculate(maxBid float64, global, placement, item, itemPlacement model.EventCounts) float64 {
-	ctr := calculateRateWithFallbacks([]model.EventCounts{itemPlacement, item, placement, global}, MinImpressionThreshold, func(e model.EventCounts) int { return e.Clicks })
-	avgCTR := calculateRateWithFallbacks([]model.EventCounts{placement, global}, MinImpressionThreshold, func(e model.EventCounts) int { return e.Clicks })
+	ctr := calculateRateWithFallbacks([]model.EventCounts{itemPlacement, item, placement, global}, MinImpressionThreshold, func(e model.EventCounts) float64 { return e.Clicks })
+	avgCTR := calculateRateWithFallbacks([]model.EventCounts{placement, global}, MinImpressionThreshold, func(e model.EventCounts) float64 { return e.Clicks })
 
 	if avgCTR == 0 {
 		return maxBid * BidFallbackMultiplier
@@ -60,15 +103,15 @@ func (s AvgClickThroughRateStrategy) Calculate(maxBid float64, global, placement
 	}
 }
 
-func calculateRateWithFallbacks(fallbacks []model.EventCounts, threshold int, extract func(model.EventCounts) int) float64 {
+func calculateRateWithFallbacks(fallbacks []model.EventCounts, threshold float64, extract func(model.EventCounts) float64) float64 {
 	for _, data := range fallbacks {
 		if data.Impressions >= threshold {
-			return float64(extract(data)) / float64(max(1, data.Impressions))
+			return extract(data) / data.Impressions
 		}
 	}
 	for _, data := range fallbacks {
 		if data.Impressions > 0 {
-			return float64(extract(data)) / float64(max(1, data.Impressions))
+			return extract(data) / data.Impressions
 		}
 	}
 	return 0
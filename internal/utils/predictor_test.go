@@ -3,6 +3,7 @@ package utils
 import (
 	"sweng-task/internal/model"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -63,3 +64,37 @@ func TestAvgConversionRateStrategy_Calculate(t *testing.T) {
 		})
 	}
 }
+
+func TestStatsWindow_Weight(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   StatsWindow
+		age      time.Duration
+		expected float64
+	}{
+		{name: "All time", window: StatsWindow{}, age: 365 * 24 * time.Hour, expected: 1},
+		{name: "Inside window", window: StatsWindow{Window: 24 * time.Hour}, age: 23 * time.Hour, expected: 1},
+		{name: "Outside window", window: StatsWindow{Window: 24 * time.Hour}, age: 25 * time.Hour, expected: 0},
+		{name: "One half-life", window: StatsWindow{HalfLife: 24 * time.Hour}, age: 24 * time.Hour, expected: 0.5},
+		{name: "Two half-lives", window: StatsWindow{HalfLife: time.Hour}, age: 2 * time.Hour, expected: 0.25},
+		{name: "Future event", window: StatsWindow{HalfLife: time.Hour}, age: -time.Hour, expected: 1},
+		{name: "Decay outside window", window: StatsWindow{Window: time.Hour, HalfLife: time.Hour}, age: 2 * time.Hour, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, tt.window.Weight(tt.age), 1e-9)
+		})
+	}
+}
+
+func TestAvgClickThroughRateStrategy_DecayedCounts(t *testing.T) {
+	strategy := AvgClickThroughRateStrategy{Stats: StatsWindow{HalfLife: 24 * time.Hour}}
+	assert.Equal(t, StatsWindow{HalfLife: 24 * time.Hour}, strategy.StatsWindow())
+
+	// Fractional counts below the impression threshold still yield a rate
+	global := model.EventCounts{Impressions: 0.5, Clicks: 0.05}
+	item := model.EventCounts{Impressions: 0.25, Clicks: 0.1}
+	bid := strategy.Calculate(2.0, global, global, item, item)
+	assert.Equal(t, 2.0, bid)
+}
@@ -9,4 +9,6 @@ type ErrorResponse struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
 	Details interface{} `json:"details,omitempty"`
+	// RequestID is the X-Request-ID of the request, to find its log lines
+	RequestID string `json:"request_id,omitempty"`
 }
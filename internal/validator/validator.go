@@ -20,6 +20,10 @@ func ValidateStruct(s interface{}) (*utils.FieldError, error) {
 		switch ve.Tag() {
 		case "required":
 			reason = "is required"
+		case "required_if":
+			reason = "is required when " + ve.Param()
+		case "excluded_unless":
+			reason = "is only allowed when " + ve.Param()
 		case "oneof":
 			reason = "must be one of: " + ve.Param()
 		case "min":
@@ -54,3 +58,12 @@ type LineItemQueryParams struct {
 	AdvertiserID string `query:"advertiser_id"`
 	Placement    string `query:"placement"`
 }
+
+type AnomalyAlertQueryParams struct {
+	AdvertiserID string `query:"advertiser_id"`
+	LineItemID   string `query:"line_item_id"`
+	Metric       string `query:"metric" validate:"omitempty,oneof=ctr cvr spend"`
+	// Since is an RFC 3339 timestamp
+	Since string `query:"since" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Limit int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+}
@@ -0,0 +1,499 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"sweng-task/internal/model"
+)
+
+// SyncPolicy controls when appended records are fsynced to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs before every Append returns
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs in the background every Options.SyncInterval
+	SyncInterval SyncPolicy = "interval"
+	// SyncNone leaves flushing to the operating system
+	SyncNone SyncPolicy = "none"
+)
+
+const (
+	segmentExt     = ".wal"
+	checkpointFile = "checkpoint"
+	headerSize     = 16 // seq (8) + payload length (4) + crc32 (4)
+	maxRecordSize  = 16 << 20
+)
+
+var (
+	ErrClosed        = errors.New("write-ahead log closed")
+	errCorruptRecord = errors.New("corrupt write-ahead log record")
+)
+
+// Options configures a Log
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	SegmentSize  int64
+}
+
+type segment struct {
+	firstSeq uint64
+	path     string
+}
+
+// segmentFile is the open file of the active segment, an *os.File outside of tests
+type segmentFile interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// Log is an append-only, segmented log of tracking events. Every record carries a
+// sequence number; once all records up to a sequence number have been acknowledged
+// the segments holding them are deleted.
+type Log struct {
+	dir  string
+	opts Options
+	log  *zap.SugaredLogger
+
+	mu        sync.Mutex
+	segments  []segment
+	active    segmentFile
+	size      int64
+	nextSeq   uint64
+	committed uint64
+	acked     map[uint64]struct{}
+	dirty     bool
+	closed    bool
+	// broken is set once a failed append could not be rolled back, leaving the active
+	// segment with a torn record that later appends must not follow
+	broken error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens or creates the log in dir, truncating a partially written record left at
+// the end of the last segment by a crash
+func Open(dir string, opts Options, log *zap.SugaredLogger) (*Log, error) {
+	switch opts.Sync {
+	case SyncAlways, SyncInterval, SyncNone:
+	default:
+		return nil, fmt.Errorf("unknown write-ahead log sync policy %q", opts.Sync)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:     dir,
+		opts:    opts,
+		log:     log,
+		nextSeq: 1,
+		acked:   make(map[uint64]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	committed, err := l.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	l.committed = committed
+	l.nextSeq = committed + 1
+
+	if err := l.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	if l.opts.Sync == SyncInterval && l.opts.SyncInterval > 0 {
+		go l.syncLoop()
+	} else {
+		close(l.done)
+	}
+
+	log.Infow("Write-ahead log opened",
+		"dir", dir,
+		"segments", len(l.segments),
+		"committed_seq", l.committed,
+		"next_seq", l.nextSeq,
+	)
+	return l, nil
+}
+
+// Append durably records events according to the sync policy and returns the
+// sequence number assigned to the first one; the rest follow consecutively
+func (l *Log) Append(events []model.TrackingEvent) (uint64, error) {
+	var buf []byte
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.broken != nil {
+		return 0, l.broken
+	}
+	if l.size >= l.opts.SegmentSize && l.opts.SegmentSize > 0 {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	first := l.nextSeq
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return 0, err
+		}
+		buf = appendRecord(buf, first+uint64(i), payload)
+	}
+
+	if _, err := l.active.Write(buf); err != nil {
+		return 0, l.rollback(err)
+	}
+	if l.opts.Sync == SyncAlways {
+		if err := l.active.Sync(); err != nil {
+			return 0, l.rollback(err)
+		}
+	} else {
+		l.dirty = true
+	}
+
+	l.size += int64(len(buf))
+	l.nextSeq += uint64(len(events))
+	return first, nil
+}
+
+// rollback truncates the active segment back to the size it had before a failed append, so
+// that a partially written record neither hides the records appended after it from Replay
+// nor leaves records that were reported as not appended. Callers must hold l.mu.
+func (l *Log) rollback(cause error) error {
+	if err := l.active.Truncate(l.size); err != nil {
+		l.broken = fmt.Errorf("write-ahead log segment left with a torn record: %w", err)
+		l.log.Errorw("Failed to roll back write-ahead log append", "size", l.size, "error", err)
+		return errors.Join(cause, l.broken)
+	}
+	if _, err := l.active.Seek(l.size, io.SeekStart); err != nil {
+		l.broken = fmt.Errorf("write-ahead log segment left at an unknown offset: %w", err)
+		return errors.Join(cause, l.broken)
+	}
+	return cause
+}
+
+// Ack marks records as persisted downstream. Segments are compacted once every
+// record they hold has been acknowledged.
+func (l *Log) Ack(seqs ...uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Records acknowledged too late are replayed on the next start and stored only once
+	if l.closed {
+		return ErrClosed
+	}
+	for _, seq := range seqs {
+		if seq > l.committed {
+			l.acked[seq] = struct{}{}
+		}
+	}
+
+	advanced := false
+	for {
+		if _, ok := l.acked[l.committed+1]; !ok {
+			break
+		}
+		delete(l.acked, l.committed+1)
+		l.committed++
+		advanced = true
+	}
+
+	if advanced && len(l.segments) > 1 && l.segments[1].firstSeq <= l.committed+1 {
+		return l.compact()
+	}
+	return nil
+}
+
+// Replay calls fn, in order, for every record that has not been acknowledged
+func (l *Log) Replay(fn func(seq uint64, event model.TrackingEvent) error) error {
+	l.mu.Lock()
+	segments := append([]segment(nil), l.segments...)
+	committed := l.committed
+	l.mu.Unlock()
+
+	for _, seg := range segments {
+		_, err := readSegment(seg.path, func(seq uint64, payload []byte) error {
+			if seq <= committed {
+				return nil
+			}
+			var event model.TrackingEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return fmt.Errorf("decode record %d: %w", seq, err)
+			}
+			return fn(seq, event)
+		})
+		if err != nil && !errors.Is(err, errCorruptRecord) {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastSeq returns the sequence number of the last record appended, or 0 if there is none
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.nextSeq - 1
+}
+
+// Backlog returns the number of appended records not yet acknowledged
+func (l *Log) Backlog() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.nextSeq - 1 - l.committed
+}
+
+// Close syncs the active segment, records the checkpoint and releases the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	l.mu.Unlock()
+
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.writeCheckpoint(); err != nil {
+		return err
+	}
+	l.log.Infow("Write-ahead log closed", "committed_seq", l.committed, "backlog", l.nextSeq-1-l.committed)
+	return l.active.Close()
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty {
+				if err := l.active.Sync(); err != nil {
+					l.log.Errorw("Failed to sync write-ahead log", "error", err)
+				} else {
+					l.dirty = false
+				}
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Log) loadSegments() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, segment{firstSeq: firstSeq, path: filepath.Join(l.dir, name)})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].firstSeq < l.segments[j].firstSeq })
+
+	if len(l.segments) == 0 {
+		return l.createSegment(l.nextSeq)
+	}
+
+	// Find the end of the last segment, dropping any torn write at its tail
+	last := l.segments[len(l.segments)-1]
+	lastSeq := last.firstSeq - 1
+	validSize, err := readSegment(last.path, func(seq uint64, _ []byte) error {
+		lastSeq = seq
+		return nil
+	})
+	if errors.Is(err, errCorruptRecord) {
+		l.log.Warnw("Truncating corrupt write-ahead log tail", "segment", last.path, "size", validSize)
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(last.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(validSize); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.active = f
+	l.size = validSize
+	if lastSeq+1 > l.nextSeq {
+		l.nextSeq = lastSeq + 1
+	}
+	return nil
+}
+
+func (l *Log) createSegment(firstSeq uint64) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", firstSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.segments = append(l.segments, segment{firstSeq: firstSeq, path: path})
+	l.active = f
+	l.size = 0
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	l.dirty = false
+
+	if err := l.createSegment(l.nextSeq); err != nil {
+		return err
+	}
+	return l.compact()
+}
+
+// compact deletes every closed segment whose records have all been acknowledged
+func (l *Log) compact() error {
+	if err := l.writeCheckpoint(); err != nil {
+		return err
+	}
+
+	removed := 0
+	for len(l.segments) > 1 && l.segments[1].firstSeq <= l.committed+1 {
+		if err := os.Remove(l.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.segments = l.segments[1:]
+		removed++
+	}
+
+	if removed > 0 {
+		l.log.Infow("Write-ahead log compacted", "removed_segments", removed, "committed_seq", l.committed)
+	}
+	return nil
+}
+
+func (l *Log) readCheckpoint() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (l *Log) writeCheckpoint() error {
+	tmp := filepath.Join(l.dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(l.committed, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, checkpointFile))
+}
+
+func appendRecord(buf []byte, seq uint64, payload []byte) []byte {
+	var header [headerSize]byte
+	binary.BigEndian.PutUint64(header[0:8], seq)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[12:16], crc32.ChecksumIEEE(payload))
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// readSegment calls fn for every valid record in the segment and returns the size of
+// the valid prefix. A truncated or corrupt record ends the scan with errCorruptRecord.
+func readSegment(path string, fn func(seq uint64, payload []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, errCorruptRecord
+		}
+
+		seq := binary.BigEndian.Uint64(header[0:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		checksum := binary.BigEndian.Uint32(header[12:16])
+		if length > maxRecordSize {
+			return offset, errCorruptRecord
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, errCorruptRecord
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return offset, errCorruptRecord
+		}
+
+		if err := fn(seq, payload); err != nil {
+			return offset, err
+		}
+		offset += int64(headerSize) + int64(length)
+	}
+}
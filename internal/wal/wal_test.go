@@ -0,0 +1,175 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sweng-task/internal/model"
+	"sweng-task/internal/testutil"
+)
+
+func openTestLog(t *testing.T, dir string, segmentSize int64) *Log {
+	t.Helper()
+	l, err := Open(dir, Options{Sync: SyncAlways, SegmentSize: segmentSize}, testutil.GetTestLogger())
+	require.NoError(t, err)
+	return l
+}
+
+func replayAll(t *testing.T, l *Log) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	require.NoError(t, l.Replay(func(seq uint64, _ model.TrackingEvent) error {
+		seqs = append(seqs, seq)
+		return nil
+	}))
+	return seqs
+}
+
+func testEvents(n int) []model.TrackingEvent {
+	events := make([]model.TrackingEvent, n)
+	for i := range events {
+		events[i] = testutil.CreateTestTrackingEvent("li_1")
+	}
+	return events
+}
+
+func TestLog_ReplaysUnacknowledgedAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+
+	first, err := l.Append(testEvents(3))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	require.NoError(t, l.Ack(1))
+	require.NoError(t, l.Close())
+
+	reopened := openTestLog(t, dir, 0)
+	defer reopened.Close()
+
+	assert.Equal(t, []uint64{2, 3}, replayAll(t, reopened))
+	assert.Equal(t, uint64(2), reopened.Backlog())
+
+	next, err := reopened.Append(testEvents(1))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), next)
+}
+
+func TestLog_AckOutOfOrderAndCompact(t *testing.T) {
+	dir := t.TempDir()
+	// A tiny segment size rotates on every append
+	l := openTestLog(t, dir, 1)
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(testEvents(2))
+		require.NoError(t, err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, segments, 3)
+
+	require.NoError(t, l.Ack(3, 4))
+	assert.Equal(t, uint64(6), l.Backlog())
+
+	require.NoError(t, l.Ack(1, 2))
+	assert.Equal(t, uint64(2), l.Backlog())
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, segments, 1)
+	assert.Equal(t, []uint64{5, 6}, replayAll(t, l))
+}
+
+func TestLog_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+	_, err := l.Append(testEvents(2))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.Len(t, segments, 1)
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 3, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened := openTestLog(t, dir, 0)
+	defer reopened.Close()
+
+	assert.Equal(t, []uint64{1, 2}, replayAll(t, reopened))
+	next, err := reopened.Append(testEvents(1))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), next)
+	assert.Equal(t, []uint64{1, 2, 3}, replayAll(t, reopened))
+}
+
+// shortWriteFile writes only the first n bytes of the next write before failing, like a
+// full disk
+type shortWriteFile struct {
+	segmentFile
+	n int
+}
+
+func (f *shortWriteFile) Write(p []byte) (int, error) {
+	n, err := f.segmentFile.Write(p[:min(f.n, len(p))])
+	if err != nil {
+		return n, err
+	}
+	return n, errors.New("no space left on device")
+}
+
+func TestLog_RollsBackFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+	_, err := l.Append(testEvents(1))
+	require.NoError(t, err)
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.Len(t, segments, 1)
+	before, err := os.Stat(segments[0])
+	require.NoError(t, err)
+
+	active := l.active
+	l.active = &shortWriteFile{segmentFile: active, n: 20}
+	_, err = l.Append(testEvents(2))
+	require.Error(t, err)
+	l.active = active
+
+	after, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	assert.Equal(t, before.Size(), after.Size(), "the partial record is truncated")
+
+	next, err := l.Append(testEvents(1))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), next, "the failed append assigned no sequence numbers")
+	require.NoError(t, l.Close())
+
+	reopened := openTestLog(t, dir, 0)
+	defer reopened.Close()
+	assert.Equal(t, []uint64{1, 2}, replayAll(t, reopened), "records appended after the failure are replayed")
+}
+
+func TestOpen_RejectsUnknownSyncPolicy(t *testing.T) {
+	_, err := Open(t.TempDir(), Options{Sync: "sometimes"}, testutil.GetTestLogger())
+	assert.Error(t, err)
+}
+
+func TestLog_AckAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+
+	_, err := l.Append(testEvents(2))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), l.LastSeq())
+	require.NoError(t, l.Close())
+
+	// Records acknowledged after the checkpoint was written are replayed on reopen
+	assert.ErrorIs(t, l.Ack(1, 2), ErrClosed)
+	reopened := openTestLog(t, dir, 0)
+	defer reopened.Close()
+	assert.Equal(t, []uint64{1, 2}, replayAll(t, reopened))
+}